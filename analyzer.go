@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// analyzerVersion identifies the rules that turn text into tokens,
+// keywords and normalized keys: tokenize, analyzeText, normalizeQuestion,
+// defaultStopWords and stemWord. Bump it whenever they change, so entry vectors and
+// interaction keywords derived under the old rules are found and
+// re-derived rather than silently mismatching new queries.
+//
+// Learned entries are re-keyed with normalizeQuestion on every restore and
+// are never stale. Data stamped 0 predates versioning.
+const analyzerVersion = 3
+
+// staleCount reports how much derived data was built by another analyzer
+// version.
+type staleCount struct {
+	Entries      int `json:"entries"`
+	Interactions int `json:"interactions"`
+}
+
+func (c staleCount) total() int {
+	return c.Entries + c.Interactions
+}
+
+func (ai *AIEngine) staleDerived() staleCount {
+	var count staleCount
+	for _, entry := range ai.KB.view().entries {
+		if entry.Analyzer != analyzerVersion {
+			count.Entries++
+		}
+	}
+	count.Interactions = len(ai.staleInteractions())
+	return count
+}
+
+// RederiveStatus is the pollable progress of re-deriving stale data.
+type RederiveStatus struct {
+	State     string     `json:"state"`
+	Stale     staleCount `json:"stale"`
+	Done      int        `json:"done"`
+	Total     int        `json:"total"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+}
+
+// Rederiver re-derives entry vectors and interaction keywords built by an
+// older analyzer. It works one item at a time and never holds a lock while
+// analyzing, so queries keep being answered from the stale data until
+// each item is replaced.
+type Rederiver struct {
+	ai *AIEngine
+
+	mu     sync.Mutex
+	status RederiveStatus
+}
+
+func NewRederiver(ai *AIEngine) *Rederiver {
+	return &Rederiver{ai: ai, status: RederiveStatus{State: "idle"}}
+}
+
+// Start re-derives stale data in the background unless a run is already
+// going, and returns a channel closed when it is finished.
+func (r *Rederiver) Start() (<-chan struct{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status.State == "running" {
+		return nil, newError(ErrConflict, "re-derivation is already running")
+	}
+	stale := r.ai.staleDerived()
+	now := time.Now()
+	r.status = RederiveStatus{State: "running", Stale: stale, Total: stale.total(), StartedAt: &now}
+	done := make(chan struct{})
+	go func() {
+		r.run()
+		close(done)
+	}()
+	return done, nil
+}
+
+// Refresh re-derives stale data, waiting at most budget before leaving the
+// rest to finish in the background. It reports whether everything was
+// done within the budget.
+func (r *Rederiver) Refresh(budget time.Duration) bool {
+	done, err := r.Start()
+	if err != nil {
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-time.After(budget):
+		return false
+	}
+}
+
+func (r *Rederiver) Status() RederiveStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+func (r *Rederiver) step() {
+	r.mu.Lock()
+	r.status.Done++
+	r.mu.Unlock()
+}
+
+func (r *Rederiver) run() {
+	started := time.Now()
+	ai := r.ai
+	embeddings := ai.embeddings()
+	for _, entry := range ai.KB.view().entries {
+		if entry.Analyzer == analyzerVersion {
+			continue
+		}
+		vector := getSentenceVector(entry.Question, embeddings)
+		ai.KB.updateEntries("rederive", func(entries []KnowledgeEntry) []KnowledgeEntry {
+			for i := range entries {
+				if entries[i].ID == entry.ID && entries[i].Analyzer != analyzerVersion {
+					entries[i].Vector = vector
+					entries[i].Analyzer = analyzerVersion
+				}
+			}
+			return entries
+		})
+		r.step()
+	}
+
+	for _, ref := range ai.staleInteractions() {
+		interaction, ok := ai.interaction(ref)
+		if !ok || interaction.Analyzer == analyzerVersion {
+			r.step()
+			continue
+		}
+		keywords, _, err := analyzeText(interaction.Question)
+		if err != nil {
+			log.Printf("Re-deriving keywords of %q: %v", interaction.Question, err)
+		} else {
+			keywords, _ = ai.Limits.capKeywords(ai.KB.view().stopWords.filter(keywords))
+			ai.replaceKeywords(ref, interaction, keywords)
+		}
+		r.step()
+	}
+
+	r.mu.Lock()
+	r.status.State = "done"
+	r.mu.Unlock()
+	log.Printf("Re-derived %d stale items in %s", r.Status().Total, time.Since(started).Round(time.Millisecond))
+}
+
+// interactionRef locates an interaction in a session's history.
+type interactionRef struct {
+	session string
+	index   int
+}
+
+// staleInteractions lists the interactions whose keywords were derived by
+// another analyzer version.
+func (ai *AIEngine) staleInteractions() []interactionRef {
+	ai.mu.RLock()
+	defer ai.mu.RUnlock()
+	var refs []interactionRef
+	for id, s := range ai.sessions {
+		for i, interaction := range s.history {
+			if interaction.Analyzer != analyzerVersion {
+				refs = append(refs, interactionRef{id, i})
+			}
+		}
+	}
+	return refs
+}
+
+func (ai *AIEngine) interaction(ref interactionRef) (Interaction, bool) {
+	ai.mu.RLock()
+	defer ai.mu.RUnlock()
+	s, ok := ai.sessions[ref.session]
+	if !ok || ref.index >= len(s.history) {
+		return Interaction{}, false
+	}
+	return s.history[ref.index], true
+}
+
+// replaceKeywords installs re-derived keywords on an interaction, moving
+// the pattern weight it contributed to its session from the old keywords
+// to the new ones. It does nothing if the interaction changed meanwhile,
+// e.g. because the session ended or its history was trimmed.
+func (ai *AIEngine) replaceKeywords(ref interactionRef, old Interaction, keywords []string) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	s, ok := ai.sessions[ref.session]
+	if !ok || ref.index >= len(s.history) {
+		return
+	}
+	current := s.history[ref.index]
+	if current.Question != old.Question || current.Analyzer != old.Analyzer {
+		return
+	}
+	// Analyzers before 3 keyed the weights by the keywords themselves.
+	weight := patternReinforcement * current.Score
+	for _, keyword := range current.Keywords {
+		s.weights[keyword] -= weight
+		if s.weights[keyword] <= 1e-9 {
+			delete(s.weights, keyword)
+		}
+	}
+	for _, keyword := range keywords {
+		s.weights[stemWord(keyword)] += weight
+	}
+	current.Keywords = keywords
+	current.Analyzer = analyzerVersion
+	s.history[ref.index] = current
+}
+
+// checkAnalyzer reports stale derived data at startup. With a budget it is
+// re-derived, waiting at most that long; without one it is only logged,
+// and /admin/rederive starts the work.
+func (ai *AIEngine) checkAnalyzer(budget time.Duration) {
+	stale := ai.staleDerived()
+	if stale.total() == 0 {
+		return
+	}
+	log.Printf("WARNING: %d entries and %d interactions were derived by another analyzer version (current %d)",
+		stale.Entries, stale.Interactions, analyzerVersion)
+	if budget <= 0 {
+		log.Printf("WARNING: stale data will mismatch new queries until POST /admin/rederive")
+		return
+	}
+	if !ai.Rederiver.Refresh(budget) {
+		log.Printf("Re-deriving stale data continues in the background")
+	}
+}
+
+// handleRederive serves /admin/rederive: GET reports stale data and
+// progress, POST starts re-deriving it.
+func handleRederive(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			if _, err := ai.Rederiver.Start(); err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(ai.Rederiver.Status())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"analyzer": analyzerVersion,
+			"stale":    ai.staleDerived(),
+			"status":   ai.Rederiver.Status(),
+		})
+	}
+}
+
+// analyzerWarning describes stale derived data for /healthz, or "".
+func (ai *AIEngine) analyzerWarning() string {
+	stale := ai.staleDerived()
+	if stale.total() == 0 {
+		return ""
+	}
+	warning := fmt.Sprintf("%d entries and %d interactions derived by another analyzer version", stale.Entries, stale.Interactions)
+	if ai.Rederiver.Status().State == "running" {
+		warning += "; re-deriving"
+	}
+	return warning
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// staleSnapshot takes a snapshot of ai after each of sessions sessions
+// was given a learned answer, the interactions sessions remember, then
+// stamps it and every interaction as derived by analyzer 0, with
+// keywords and pattern weights the current analyzer would never make.
+func staleSnapshot(t *testing.T, ai *AIEngine, sessions int) Snapshot {
+	t.Helper()
+	ai.KB.Learn("How do I close a channel?", "The sender calls close.")
+	for i := 0; i < sessions; i++ {
+		answer := ask(t, ai, "How do I close a channel?", AskOptions{SessionID: fmt.Sprintf("stale-%d", i)})
+		if answer.Source != SourceLearned {
+			t.Fatalf("answered %q from %s", answer.Text, answer.Source)
+		}
+	}
+	s := ai.snapshot()
+	s.Analyzer = 0
+	for i := range s.Sessions {
+		weights := make(map[string]float64)
+		history := s.Sessions[i].History
+		for j := range history {
+			history[j].Analyzer = 0
+			history[j].Keywords = []string{"OLDRULES"}
+			weights["OLDRULES"] += patternReinforcement * history[j].Score
+		}
+		s.Sessions[i].Weights = weights
+	}
+	return s
+}
+
+// TestStaleSnapshotRederivesInBackground restores a snapshot from an
+// older analyzer over entries stamped older too, and keeps asking while
+// the background refresh replaces them, and after.
+func TestStaleSnapshotRederivesInBackground(t *testing.T) {
+	ai := newTestEngine(t)
+	snap := staleSnapshot(t, ai, 400)
+	ai.KB.updateEntries("test", func(entries []KnowledgeEntry) []KnowledgeEntry {
+		for i := range entries {
+			entries[i].Analyzer = 0
+		}
+		return entries
+	})
+	ai.restore(snap)
+	if stale := ai.staleDerived(); stale.Entries != len(testEntries) || stale.Interactions != 400 {
+		t.Fatalf("stale %+v before the refresh", stale)
+	}
+	if ai.analyzerWarning() == "" {
+		t.Error("/healthz doesn't warn about stale data")
+	}
+
+	check := func(when string) {
+		answer := ask(t, ai, "How do channels work?", AskOptions{})
+		if answer.Entry == nil || answer.Entry.ID != "channels" {
+			t.Errorf("%s the refresh, answered %q from %s", when, answer.Text, answer.Source)
+		}
+	}
+	check("before")
+	done, err := ai.Rederiver.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var asked int32
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				check("during")
+				atomic.AddInt32(&asked, 1)
+			}
+		}()
+	}
+	<-done
+	wg.Wait()
+	if atomic.LoadInt32(&asked) == 0 {
+		t.Error("nothing was asked during the refresh")
+	}
+	check("after")
+
+	if stale := ai.staleDerived(); stale.total() != 0 {
+		t.Errorf("stale %+v after the refresh", stale)
+	}
+	status := ai.Rederiver.Status()
+	if status.State != "done" || status.Done != status.Total || status.Total != len(testEntries)+400 {
+		t.Errorf("status %+v", status)
+	}
+	ai.mu.RLock()
+	for id, s := range ai.sessions {
+		if _, ok := s.weights["OLDRULES"]; ok {
+			t.Errorf("session %s keeps the old keyword's weight", id)
+		}
+		for _, interaction := range s.history {
+			if interaction.Analyzer != analyzerVersion || len(interaction.Keywords) == 0 || interaction.Keywords[0] == "OLDRULES" {
+				t.Errorf("session %s interaction %+v not re-derived", id, interaction)
+			}
+		}
+	}
+	ai.mu.RUnlock()
+	if warning := ai.analyzerWarning(); warning != "" {
+		t.Errorf("/healthz still warns %q", warning)
+	}
+}
+
+// TestRederiveEndpoint reports stale data, starts one run at a time and
+// reports it done.
+func TestRederiveEndpoint(t *testing.T) {
+	ai := newTestEngine(t)
+	ai.restore(staleSnapshot(t, ai, 1))
+	handler := handleRederive(ai)
+	get := func() (stale staleCount, status RederiveStatus) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/admin/rederive", nil))
+		var body struct {
+			Analyzer int
+			Stale    staleCount
+			Status   RederiveStatus
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Analyzer != analyzerVersion {
+			t.Errorf("analyzer %d", body.Analyzer)
+		}
+		return body.Stale, body.Status
+	}
+	if stale, status := get(); stale.Interactions != 1 || status.State != "idle" {
+		t.Fatalf("before: stale %+v, status %+v", stale, status)
+	}
+	if !ai.Rederiver.Refresh(time.Minute) {
+		t.Fatal("refresh didn't finish")
+	}
+	if stale, status := get(); stale.total() != 0 || status.State != "done" {
+		t.Errorf("after: stale %+v, status %+v", stale, status)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("POST", "/admin/rederive", nil))
+	if rec.Code != 202 {
+		t.Errorf("POST status %d: %s", rec.Code, rec.Body)
+	}
+}
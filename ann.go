@@ -0,0 +1,275 @@
+package main
+
+import (
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Bounds of approximate nearest-neighbor ranking.
+const (
+	// annMinEntries is the number of entries, or of learned entries, from
+	// which they are ranked through an annIndex instead of by scanning
+	// them all.
+	annMinEntries = 5000
+	// annTables is the number of hash tables, and annBits the number of
+	// hyperplanes each hashes a vector with.
+	annTables = 8
+	annBits   = 14
+	// annExactBelow is the score the best entry the index finds must
+	// reach; below it, every entry is scored instead, so questions the
+	// index can't place are answered as well as without it.
+	annExactBelow = 0.5
+)
+
+// annIndex finds the vectors likely closest to a query by locality
+// sensitive hashing: each table hashes a vector to the side of each of its
+// random hyperplanes it falls on, so vectors at a small angle tend to
+// share buckets. A query is looked up in its own bucket and in those one
+// hyperplane away. Items are ids chosen by the caller.
+//
+// An index is immutable once published; with returns an updated copy that
+// shares the buckets it leaves alone, so inserts cost a few buckets, not
+// a rebuild.
+type annIndex struct {
+	dim int
+	// planes holds annTables*annBits hyperplanes of dim, table by table.
+	planes []float32
+	// buckets[t][key] lists the ids table t hashed to key.
+	buckets [][][]int32
+}
+
+// annUpdate moves the item id from the buckets of old to those of vec. A
+// nil old inserts it, a nil vec removes it.
+type annUpdate struct {
+	id       int32
+	old, vec []float32
+}
+
+// newANNIndex returns an empty index of dim-dimensional vectors. The
+// hyperplanes are drawn from a fixed seed, so rebuilding an index over the
+// same vectors gives the same buckets.
+func newANNIndex(dim int) *annIndex {
+	rng := rand.New(rand.NewSource(1))
+	x := &annIndex{dim: dim, planes: make([]float32, annTables*annBits*dim), buckets: make([][][]int32, annTables)}
+	for i := range x.planes {
+		x.planes[i] = float32(rng.NormFloat64())
+	}
+	for t := range x.buckets {
+		x.buckets[t] = make([][]int32, 1<<annBits)
+	}
+	return x
+}
+
+// hash returns the bucket key of vec in table t.
+func (x *annIndex) hash(t int, vec []float32) uint32 {
+	var key uint32
+	for b := 0; b < annBits; b++ {
+		plane := x.planes[(t*annBits+b)*x.dim:][:x.dim]
+		var dot float32
+		for i, v := range vec {
+			dot += plane[i] * v
+		}
+		if dot > 0 {
+			key |= 1 << uint(b)
+		}
+	}
+	return key
+}
+
+// addAll inserts each of vectors in place, with its position as id. It is
+// only for building an index nothing reads yet. Hashing, which is most of
+// the work, is spread over the CPUs.
+func (x *annIndex) addAll(vectors [][]float32) {
+	keys := make([]uint32, len(vectors)*annTables)
+	workers := runtime.GOMAXPROCS(0)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(vectors); i += workers {
+				if len(vectors[i]) == x.dim {
+					for t := range x.buckets {
+						keys[i*annTables+t] = x.hash(t, vectors[i])
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	for i, vec := range vectors {
+		if len(vec) != x.dim {
+			continue
+		}
+		for t := range x.buckets {
+			key := keys[i*annTables+t]
+			x.buckets[t][key] = append(x.buckets[t][key], int32(i))
+		}
+	}
+}
+
+// with returns x with updates applied. Vectors of another dimension than
+// the index's are left out. x itself is unchanged.
+func (x *annIndex) with(updates []annUpdate) *annIndex {
+	y := &annIndex{dim: x.dim, planes: x.planes, buckets: make([][][]int32, annTables)}
+	for t := range y.buckets {
+		y.buckets[t] = append([][]int32(nil), x.buckets[t]...)
+		copied := make(map[uint32]bool)
+		bucket := func(key uint32) []int32 {
+			if !copied[key] {
+				copied[key] = true
+				y.buckets[t][key] = append([]int32(nil), y.buckets[t][key]...)
+			}
+			return y.buckets[t][key]
+		}
+		for _, u := range updates {
+			if len(u.old) == x.dim {
+				key := x.hash(t, u.old)
+				ids := bucket(key)
+				for i, id := range ids {
+					if id == u.id {
+						y.buckets[t][key] = append(ids[:i], ids[i+1:]...)
+						break
+					}
+				}
+			}
+			if len(u.vec) == x.dim {
+				key := x.hash(t, u.vec)
+				y.buckets[t][key] = append(bucket(key), u.id)
+			}
+		}
+	}
+	return y
+}
+
+// candidates returns, in ascending order, the ids sharing a bucket with
+// vec, or one hyperplane away from it, in any table.
+func (x *annIndex) candidates(vec []float32) []int32 {
+	if len(vec) != x.dim {
+		return nil
+	}
+	var ids []int32
+	for t, buckets := range x.buckets {
+		key := x.hash(t, vec)
+		ids = append(ids, buckets[key]...)
+		for b := uint(0); b < annBits; b++ {
+			ids = append(ids, buckets[key^1<<b]...)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	distinct := ids[:0]
+	for i, id := range ids {
+		if i == 0 || id != ids[i-1] {
+			distinct = append(distinct, id)
+		}
+	}
+	return distinct
+}
+
+// buildEntryIndex indexes the entry vectors by position, or returns nil
+// below annMinEntries entries.
+func buildEntryIndex(entries []KnowledgeEntry) *annIndex {
+	if len(entries) < annMinEntries {
+		return nil
+	}
+	vectors := make([][]float32, len(entries))
+	for i, entry := range entries {
+		vectors[i] = entry.Vector
+	}
+	x := newANNIndex(vectorDimension(entries))
+	x.addAll(vectors)
+	return x
+}
+
+// entryIndex returns the index of next's entries: current's updated with
+// the entries next appends or re-vectorizes, or one built afresh when
+// entries were removed or reordered, or most of them changed.
+func entryIndex(current, next *kbState) *annIndex {
+	if current.ann == nil || len(next.entries) < len(current.entries) {
+		return buildEntryIndex(next.entries)
+	}
+	var updates []annUpdate
+	for i, entry := range next.entries {
+		var old []float32
+		if i < len(current.entries) {
+			if current.entries[i].ID != entry.ID {
+				return buildEntryIndex(next.entries)
+			}
+			old = current.entries[i].Vector
+			if sameVector(old, entry.Vector) || len(old)+len(entry.Vector) == 0 {
+				continue
+			}
+		}
+		if updates = append(updates, annUpdate{int32(i), old, entry.Vector}); len(updates) > len(next.entries)/4 {
+			return buildEntryIndex(next.entries)
+		}
+	}
+	if len(updates) == 0 {
+		return current.ann
+	}
+	return current.ann.with(updates)
+}
+
+// indexLearned sets the learned index of next, with learnedKeys and
+// learnedIDs to map its ids to keys and back: current's updated with the
+// learned vectors added, changed or removed since, or built afresh when
+// most of them changed or removed keys hold half the ids.
+func indexLearned(current, next *kbState) {
+	if len(next.learned) < annMinEntries {
+		return
+	}
+	if current.learnedANN == nil || len(current.learnedKeys) > 2*len(next.learned) {
+		buildLearnedIndex(next)
+		return
+	}
+	ids := make(map[string]int32, len(current.learnedIDs)+1)
+	for key, id := range current.learnedIDs {
+		ids[key] = id
+	}
+	keys := current.learnedKeys[:len(current.learnedKeys):len(current.learnedKeys)]
+	var updates []annUpdate
+	for key, vec := range next.learnedVectors {
+		id, ok := ids[key]
+		if !ok {
+			id = int32(len(keys))
+			keys = append(keys, key)
+			ids[key] = id
+		}
+		old := current.learnedVectors[key]
+		if !sameVector(old, vec) && len(old)+len(vec) > 0 {
+			updates = append(updates, annUpdate{id, old, vec})
+		}
+	}
+	for key, id := range current.learnedIDs {
+		if _, ok := next.learnedVectors[key]; !ok {
+			updates = append(updates, annUpdate{id: id, old: current.learnedVectors[key]})
+			delete(ids, key)
+		}
+	}
+	if len(updates) > len(next.learned)/4 {
+		buildLearnedIndex(next)
+		return
+	}
+	next.learnedANN, next.learnedKeys, next.learnedIDs = current.learnedANN, keys, ids
+	if len(updates) > 0 {
+		next.learnedANN = current.learnedANN.with(updates)
+	}
+}
+
+func buildLearnedIndex(next *kbState) {
+	dim := 0
+	vectors := make([][]float32, 0, len(next.learnedVectors))
+	next.learnedIDs = make(map[string]int32, len(next.learnedVectors))
+	for key, vec := range next.learnedVectors {
+		if dim == 0 {
+			dim = len(vec)
+		}
+		next.learnedIDs[key] = int32(len(next.learnedKeys))
+		next.learnedKeys = append(next.learnedKeys, key)
+		vectors = append(vectors, vec)
+	}
+	next.learnedANN = newANNIndex(dim)
+	next.learnedANN.addAll(vectors)
+}
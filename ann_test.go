@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// annKB builds a knowledge base of n synthetic entries of dim dimensions
+// and returns it with probes near random entries.
+func annKB(tb testing.TB, n, dim, probes int) (*KnowledgeBase, [][]float32) {
+	tb.Helper()
+	rng := rand.New(rand.NewSource(int64(n)))
+	entries := benchEntries(rng, n, dim)
+	kb := NewKnowledgeBase()
+	kb.updateEntries("test", func([]KnowledgeEntry) []KnowledgeEntry { return entries })
+	if kb.view().ann == nil {
+		tb.Fatalf("%d entries aren't indexed", n)
+	}
+	vectors := make([][]float32, probes)
+	for i := range vectors {
+		vectors[i] = noisyVector(rng, entries[rng.Intn(n)].Vector, dim, 0.4)
+	}
+	return kb, vectors
+}
+
+// TestANNAgreesWithExactScan ranks probes through the index and by
+// scoring every entry, and expects the same best entry nearly always, at
+// the same score, and always for a probe far from every entry.
+func TestANNAgreesWithExactScan(t *testing.T) {
+	kb, probes := annKB(t, 10000, 64, 100)
+	state := kb.view()
+	agree := 0
+	for _, probe := range probes {
+		want := state.rankEntries(probe, nil)
+		got := state.rankVector(probe)
+		if len(got) == 0 {
+			t.Fatal("the index found nothing")
+		}
+		if got[0].Entry.ID == want[0].Entry.ID {
+			agree++
+		}
+		if exact := cosineSimilarity(probe, got[0].Entry.Vector); math.Abs(got[0].Score-exact) > 1e-6 {
+			t.Errorf("%s scored %v through the index, %v exactly", got[0].Entry.ID, got[0].Score, exact)
+		}
+	}
+	if recall := float64(agree) / float64(len(probes)); recall < 0.95 {
+		t.Errorf("index agreed with the exact scan on %.1f%% of probes, want 95%%", 100*recall)
+	}
+
+	// A random direction lands near no entry, so every entry is scored.
+	far := noisyVector(rand.New(rand.NewSource(7)), nil, 64, 1)
+	want, got := state.rankEntries(far, nil), state.rankVector(far)
+	if want[0].Score >= annExactBelow {
+		t.Fatalf("far probe scored %v", want[0].Score)
+	}
+	if got[0].Entry.ID != want[0].Entry.ID {
+		t.Errorf("far probe: index ranked %s first, exact scan %s", got[0].Entry.ID, want[0].Entry.ID)
+	}
+}
+
+// bucketContents lists the sorted ids of every bucket of x.
+func bucketContents(x *annIndex) [][][]int32 {
+	contents := make([][][]int32, len(x.buckets))
+	for t, buckets := range x.buckets {
+		contents[t] = make([][]int32, len(buckets))
+		for key, ids := range buckets {
+			sorted := append([]int32{}, ids...)
+			sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+			contents[t][key] = sorted
+		}
+	}
+	return contents
+}
+
+// TestANNIncrementalInsert appends and re-vectorizes entries and checks
+// the index is updated in place of a rebuild: it shares every bucket it
+// didn't touch, leaves the published index alone, and holds what a
+// rebuild would.
+func TestANNIncrementalInsert(t *testing.T) {
+	kb, probes := annKB(t, 6000, 64, 2)
+	before := kb.view().ann
+	beforeContents := bucketContents(before)
+	kb.updateEntries("test", func(entries []KnowledgeEntry) []KnowledgeEntry {
+		entries[10].Vector = probes[0]
+		return append(entries, KnowledgeEntry{ID: "new", Vector: probes[1]})
+	})
+	state := kb.view()
+	after := state.ann
+	if after == before {
+		t.Fatal("the index wasn't updated")
+	}
+	if !reflect.DeepEqual(bucketContents(before), beforeContents) {
+		t.Error("updating changed the published index")
+	}
+	if want := bucketContents(buildEntryIndex(state.entries)); !reflect.DeepEqual(bucketContents(after), want) {
+		t.Error("the updated index differs from a rebuilt one")
+	}
+	copied := 0
+	for tbl := range after.buckets {
+		for key, ids := range after.buckets[tbl] {
+			old := before.buckets[tbl][key]
+			if len(old) > 0 && len(ids) > 0 && &old[0] != &ids[0] {
+				copied++
+			}
+		}
+	}
+	// An insert touches one bucket per table, a move two.
+	if copied > 3*annTables {
+		t.Errorf("%d buckets copied for one insert and one move", copied)
+	}
+	for i, id := range map[int]string{0: "bench-10", 1: "new"} {
+		matches := state.rankVector(probes[i])
+		if len(matches) == 0 || matches[0].Entry.ID != id {
+			t.Errorf("probe %d found %d matches, not %s first", i, len(matches), id)
+		}
+	}
+}
+
+// BenchmarkRank compares ranking by scoring every entry with ranking
+// through the index, at 10k and 100k entries of 300 dimensions.
+func BenchmarkRank(b *testing.B) {
+	for _, n := range []int{10000, 100000} {
+		kb, probes := annKB(b, n, 300, 500)
+		state := kb.view()
+		b.Run(fmt.Sprintf("exact/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				state.rankEntries(probes[i%len(probes)], nil)
+			}
+		})
+		b.Run(fmt.Sprintf("ann/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				state.rankVector(probes[i%len(probes)])
+			}
+		})
+		next := &kbState{entries: append(state.entries[:len(state.entries):len(state.entries)], KnowledgeEntry{ID: "new", Vector: probes[0]})}
+		b.Run(fmt.Sprintf("insert/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				entryIndex(state, next)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Answer sources, reported to clients so they can tell a real match from a
+// canned fallback.
+const (
+	SourceContext        = "context"
+	SourceLearned        = "learned"
+	SourceGreeting       = "greeting"
+	SourceCommonQuestion = "common_question"
+	SourceKnowledgeBase  = "knowledge_base"
+	SourceDefault        = "default"
+	// SourceLLM is an answer of the LLM fallback to a question nothing
+	// else answered.
+	SourceLLM = "llm"
+)
+
+// Stages that modify an answer after a source has been chosen.
+const (
+	StageAdapt        = "adapt"
+	StageVerification = "verification"
+	StageAttribution  = "attribution"
+	// StageSupersede marks an answer served in place of the superseded
+	// entry that matched; its entry ID is the superseded one.
+	StageSupersede = "supersede"
+	// StageCache marks an answer served from the answer cache; the other
+	// parts are those of the answer as first computed.
+	StageCache = "cache"
+)
+
+// maxCandidates bounds the near misses kept on a fallback answer.
+const maxCandidates = 3
+
+// Answer is the outcome of answering one question.
+type Answer struct {
+	Text   string
+	Source string
+	// Score is the similarity that drove the decision; for fallbacks it is
+	// the best score that fell short.
+	Score float64
+	// Confidence is Score calibrated for the source; zero for fallbacks.
+	Confidence float64
+	// Entry is the knowledge base entry served, if any.
+	Entry *KnowledgeEntry
+	// EntryID and SourceURL identify the KB or learned entry served.
+	EntryID   string
+	SourceURL string
+	// SupersededID is the entry that matched when Entry, which supersedes
+	// it, was served in its place.
+	SupersededID string
+	// Variant is the index of the experiment variant served when Entry
+	// runs an experiment.
+	Variant int
+	// Candidates holds the closest entries when nothing matched well enough.
+	Candidates []Match
+	// Retrieved is the best candidate of each retriever that had one.
+	Retrieved []Candidate
+	// Keywords are the keywords extracted from the question.
+	Keywords []string
+	// Vocabulary counts the question's tokens missing from the embeddings.
+	Vocabulary VocabularyCoverage
+	// Truncated reports that the analysis limits cut the question short.
+	Truncated bool
+	// Operators are the inline operators parsed from the question.
+	Operators Operators
+	// Corrected maps the misspelled words of the question to their
+	// corrections.
+	Corrected map[string]string
+	// Language is the language the question was answered in.
+	Language string
+	// Related lists similar questions, for detailed answers.
+	Related []string
+	// Sources breaks the final text down by the stage that produced each
+	// part. The first element is the primary source.
+	Sources []SourcePart
+	// Warnings are the soft problems met while answering.
+	Warnings []Warning
+	// Duration is how long Ask took, for the observers.
+	Duration time.Duration
+}
+
+// SourcePart attributes a range of the answer text to one stage.
+type SourcePart struct {
+	Stage   string  `json:"stage"`
+	EntryID string  `json:"entry_id,omitempty"`
+	Score   float64 `json:"score"`
+	Range   Span    `json:"range"`
+	text    string
+}
+
+// addSource records that text, somewhere in the final answer, came from
+// stage. Ranges are resolved by locateSources once the text is final.
+func (a *Answer) addSource(stage, entryID string, score float64, text string) {
+	a.Sources = append(a.Sources, SourcePart{Stage: stage, EntryID: entryID, Score: score, text: text})
+}
+
+// locateSources resolves the range of every source part against the
+// current answer text. If the primary part no longer appears verbatim, for
+// example after an output processor rewrote it, it is attributed the whole
+// answer; other parts that can't be found are dropped.
+func (a *Answer) locateSources() {
+	located := a.Sources[:0]
+	for i, part := range a.Sources {
+		start := -1
+		if part.text != "" {
+			start = strings.Index(a.Text, part.text)
+		}
+		switch {
+		case start >= 0:
+			part.Range = Span{start, start + len(part.text)}
+		case i == 0:
+			part.Range = Span{0, len(a.Text)}
+		default:
+			continue
+		}
+		located = append(located, part)
+	}
+	a.Sources = located
+}
+
+// LowConfidence reports whether the answer is a fallback, canned or the
+// LLM's, rather than a match.
+func (a Answer) LowConfidence() bool {
+	return a.Source == SourceDefault || a.Source == SourceLLM
+}
+
+// cached reports whether the answer was served from a cache, the answer
+// cache or the LLM fallback's.
+func (a Answer) cached() bool {
+	for _, part := range a.Sources {
+		if part.Stage == StageCache {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEveryBranchIsAttributed takes every branch of answering a question
+// and checks its sources: the primary one names the answer's source, each
+// range lies within the text, and the stages each branch adds are there.
+func TestEveryBranchIsAttributed(t *testing.T) {
+	model := newLLMServer(t)
+	defer model.Close()
+	entries := append([]KnowledgeEntry{
+		{ID: "maps-old", Question: "How are maps iterated?", Answer: "In insertion order."},
+		{ID: "maps", Question: "In what order does range visit a map?", Answer: "In no specified order.", Supersedes: []string{"maps-old"}},
+	}, testEntries...)
+	tests := []struct {
+		name  string
+		setup func(ai *AIEngine)
+		// asks are asked in turn; the last answer is checked.
+		asks   []string
+		source string
+		stages []string
+	}{
+		{name: "greeting", asks: []string{"hello"}, source: SourceGreeting},
+		{name: "common question", asks: []string{"who are you"}, source: SourceCommonQuestion},
+		{name: "knowledge base", asks: []string{"How do channels work?"}, source: SourceKnowledgeBase},
+		{name: "superseded entry", asks: []string{"How are maps iterated?"}, source: SourceKnowledgeBase, stages: []string{StageSupersede}},
+		{
+			name:   "learned, adapted",
+			setup:  func(ai *AIEngine) { ai.KB.Learn("How do I close a channel?", "The sender calls close.") },
+			asks:   []string{"How do I close a channel?"},
+			source: SourceLearned,
+			stages: []string{StageAdapt},
+		},
+		{
+			name:   "context",
+			setup:  func(ai *AIEngine) { ai.KB.Learn("How do I close a channel?", "The sender calls close.") },
+			asks:   []string{"How do I close a channel?", "How do I close a channel?"},
+			source: SourceContext,
+		},
+		{name: "answer cache", asks: []string{"How do channels work?", "How do channels work?"}, source: SourceKnowledgeBase, stages: []string{StageCache}},
+		{
+			name: "stale entry",
+			setup: func(ai *AIEngine) {
+				ai.Verification = VerificationPolicy{MaxAge: time.Nanosecond, Disclaimer: "This answer may be out of date."}
+			},
+			asks:   []string{"How do channels work?"},
+			source: SourceKnowledgeBase,
+			stages: []string{StageVerification},
+		},
+		{name: "keywords default", asks: []string{"zebra quantum marmalade"}, source: SourceDefault},
+		{name: "default response", asks: []string{"what about it"}, source: SourceDefault},
+		{name: "empty question", asks: []string{"   "}, source: SourceDefault},
+		{name: "question too large", asks: []string{strings.Repeat("go ", 10000)}, source: SourceDefault},
+		{
+			name:   "LLM fallback",
+			setup:  func(ai *AIEngine) { ai.LLM = newTestLLM(t, model.URL) },
+			asks:   []string{"How do I vendor dependencies?"},
+			source: SourceLLM,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ai := newTestEngine(t, withEntries(entries...))
+			ai.AdaptResponses = true
+			if tt.setup != nil {
+				tt.setup(ai)
+			}
+			var answer Answer
+			for _, question := range tt.asks {
+				answer, _ = ai.Ask(question, AskOptions{SessionID: "attribution"})
+			}
+			if answer.Source != tt.source {
+				t.Fatalf("answered %q from %s, want %s", answer.Text, answer.Source, tt.source)
+			}
+			if len(answer.Sources) == 0 {
+				t.Fatalf("answer %q has no sources", answer.Text)
+			}
+			if answer.Sources[0].Stage != answer.Source {
+				t.Errorf("primary source %s, answer source %s", answer.Sources[0].Stage, answer.Source)
+			}
+			stages := make(map[string]bool)
+			for _, part := range answer.Sources {
+				stages[part.Stage] = true
+				if part.Range.Start < 0 || part.Range.End > len(answer.Text) || part.Range.Start > part.Range.End {
+					t.Errorf("%s ranges over %v of a %d-byte answer", part.Stage, part.Range, len(answer.Text))
+				}
+			}
+			for _, stage := range tt.stages {
+				if !stages[stage] {
+					t.Errorf("sources %+v lack %s", answer.Sources, stage)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of the answer cache.
+const (
+	defaultAnswerCacheSize = 1000
+	defaultAnswerCacheTTL  = 5 * time.Minute
+)
+
+// answerCache keeps recent answers by normalized question and the options
+// that shape them, so the same question asked again skips analysis and
+// retrieval. An answer is served from it only while the knowledge base is
+// at the version it was computed against: adding, learning, importing,
+// superseding or re-vectorizing anything invalidates every answer, and
+// the TTL bounds how stale anything else an answer depends on, such as
+// verification dates, can get.
+//
+// Only answers that don't depend on the session are kept: none from the
+// context retriever or of entries running an experiment, and none served
+// to a session with history, whose context could have answered instead.
+// Failed answers (no match, no coverage) aren't kept either. A nil cache
+// keeps nothing.
+type answerCache struct {
+	// The counts come first, where atomic keeps them 64-bit aligned.
+	hits, misses uint64
+
+	size int
+	ttl  time.Duration
+
+	mu sync.Mutex
+	// order lists the cached answers, most recently used first; items
+	// finds their elements by key.
+	order *list.List
+	items map[string]*list.Element
+}
+
+// cachedAnswer is an answer as ask returned it, with what is needed to
+// replay its effects.
+type cachedAnswer struct {
+	key     string
+	version uint64
+	expires time.Time
+	answer  Answer
+	// warnings were raised while answering; keywords were extracted from
+	// the question.
+	warnings []Warning
+	keywords []string
+	// remembered is the text recorded as an interaction of the session
+	// for a learned answer, which a hit records again.
+	remembered string
+}
+
+// newAnswerCache returns a cache of up to size answers, each kept for ttl,
+// or nil if either is 0.
+func newAnswerCache(size int, ttl time.Duration) *answerCache {
+	if size <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &answerCache{size: size, ttl: ttl, order: list.New(), items: make(map[string]*list.Element, size)}
+}
+
+// answerCacheKey is the key the answer to question under opts is cached
+// under: the normalized question and the scope, style, tags and tag
+// filter, which are all that change the answer for a session without
+// history.
+func answerCacheKey(question string, opts AskOptions) string {
+	return strings.Join([]string{normalizeQuestion(question), opts.Scope, opts.Language, opts.Style, tagKey(opts.RequireTags), tagKey(opts.AnyTags)}, "\xff")
+}
+
+// tagKey lists tags in lower case and sorted, so the same set always
+// gives the same key.
+func tagKey(tags []string) string {
+	lower := make([]string, len(tags))
+	for i, tag := range tags {
+		lower[i] = strings.ToLower(tag)
+	}
+	sort.Strings(lower)
+	return strings.Join(lower, ",")
+}
+
+// get returns the answer cached for key at version, unless it expired.
+func (c *answerCache) get(key string, version uint64, now time.Time) (cachedAnswer, bool) {
+	if c == nil {
+		return cachedAnswer{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if ok {
+		cached := e.Value.(*cachedAnswer)
+		if cached.version == version && now.Before(cached.expires) {
+			c.order.MoveToFront(e)
+			atomic.AddUint64(&c.hits, 1)
+			return *cached, true
+		}
+		c.order.Remove(e)
+		delete(c.items, key)
+	}
+	atomic.AddUint64(&c.misses, 1)
+	return cachedAnswer{}, false
+}
+
+// put caches answer for key, evicting the least recently used answer when
+// the cache is full.
+func (c *answerCache) put(key string, version uint64, now time.Time, answer cachedAnswer) {
+	if c == nil {
+		return
+	}
+	answer.key, answer.version, answer.expires = key, version, now.Add(c.ttl)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value = &answer
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.order.PushFront(&answer)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cachedAnswer).key)
+	}
+}
+
+// clear drops every answer, for changes the knowledge base version
+// doesn't track.
+func (c *answerCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.items = make(map[string]*list.Element, c.size)
+}
+
+// Len returns the number of answers cached, expired or not.
+func (c *answerCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// registerMetrics exposes the hit and miss counts of c on m. A nil cache
+// has none to expose.
+func (c *answerCache) registerMetrics(m *Metrics) {
+	if c == nil {
+		return
+	}
+	m.Counter("askgo_answer_cache_hits_total", "Questions answered from the answer cache.", func() float64 { return float64(atomic.LoadUint64(&c.hits)) })
+	m.Counter("askgo_answer_cache_misses_total", "Questions the answer cache had no answer for.", func() float64 { return float64(atomic.LoadUint64(&c.misses)) })
+	m.Gauge("askgo_answer_cache_entries", "Answers in the answer cache.", func() float64 { return float64(c.Len()) })
+}
+
+// cacheable reports whether answer, which ask returned with err, may be
+// served to other askers.
+func cacheable(answer Answer, err error) bool {
+	return err == nil && answer.Source != SourceContext && (answer.Entry == nil || len(answer.Entry.Variants) == 0)
+}
+
+// sessionHasHistory reports whether the session id has interactions its
+// questions may be answered from.
+func (ai *AIEngine) sessionHasHistory(id string) bool {
+	ai.mu.RLock()
+	defer ai.mu.RUnlock()
+	s, ok := ai.activeSession(id, time.Now())
+	return ok && len(s.history) > 0
+}
+
+// answerFromCache answers question from the answer cache if it has an
+// answer computed in state. Like a fresh answer, a learned one is
+// remembered in the session. The caller checks the session has no history.
+func (ai *AIEngine) answerFromCache(state *kbState, question, key string, ops Operators, opts AskOptions) (*Query, Answer, bool) {
+	cached, ok := ai.AnswerCache.get(key, state.version, time.Now())
+	if !ok {
+		return nil, Answer{}, false
+	}
+	for _, warning := range cached.warnings {
+		opts.Warnings.Add(warning.Code, warning.Message)
+	}
+	if cached.remembered != "" {
+		ai.rememberLearned(question, cached.remembered, cached.answer.EntryID, cached.keywords, opts)
+	}
+	answer := cached.answer
+	answer.Operators = ops
+	answer.Sources = append([]SourcePart(nil), answer.Sources...)
+	answer.addSource(StageCache, answer.EntryID, answer.Score, answer.Text)
+	answer.locateSources()
+	q := &Query{
+		Raw:        question,
+		Normalized: strings.ToLower(question),
+		Key:        normalizeQuestion(question),
+		Keywords:   cached.keywords,
+		Truncated:  answer.Truncated,
+		embeddings: ai.embeddings(),
+		kb:         state,
+	}
+	return q, answer, true
+}
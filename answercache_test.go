@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestLearnInvalidatesCachedAnswer caches the answer to a question,
+// teaches a new answer to it through /learn, and gets the new answer on
+// the next /ai, which is then cached in turn.
+func TestLearnInvalidatesCachedAnswer(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	ask := func() (string, []string) {
+		t.Helper()
+		resp, body := f.do(t, http.MethodPost, "/ai", `{"text": "How do channels work?", "verbose": true}`, "", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("/ai status %d: %s", resp.StatusCode, body)
+		}
+		var reply struct {
+			Answer  string       `json:"answer"`
+			Sources []SourcePart `json:"sources"`
+		}
+		if err := json.Unmarshal([]byte(body), &reply); err != nil {
+			t.Fatal(err)
+		}
+		var stages []string
+		for _, part := range reply.Sources {
+			stages = append(stages, part.Stage)
+		}
+		return reply.Answer, stages
+	}
+
+	first, stages := ask()
+	if contains(stages, StageCache) {
+		t.Errorf("first answer came from the cache: %q", stages)
+	}
+	if again, stages := ask(); again != first || !contains(stages, StageCache) {
+		t.Fatalf("repeated question answered %q from %q, want %q from the cache", again, stages, first)
+	}
+
+	learned := "Channels are typed pipes; the sender closes them."
+	body, _ := json.Marshal(LearnRequest{Question: "How do channels work?", Answer: learned})
+	if resp, reply := f.do(t, http.MethodPost, "/learn", string(body), testAdminKey, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("/learn status %d: %s", resp.StatusCode, reply)
+	}
+	answer, stages := ask()
+	if !strings.Contains(answer, learned) || contains(stages, StageCache) {
+		t.Errorf("after /learn, answered %q from %q, want the learned answer afresh", answer, stages)
+	}
+	if again, stages := ask(); again != answer || !contains(stages, StageCache) {
+		t.Errorf("the learned answer wasn't cached: %q from %q", again, stages)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// FeedbackTuning sets how far POST /feedback moves the session's pattern
+// weights and interaction scores.
+type FeedbackTuning struct {
+	// Boost is added to the session's pattern weight of each keyword of a
+	// helpful answer, up to MaxWeight and within the session's
+	// PatternBudget cap.
+	Boost     float64
+	MaxWeight float64
+	// Decay is the share of its session pattern weight each keyword of an
+	// unhelpful answer keeps.
+	Decay float64
+	// ScoreStep moves the score of the interaction answered, up for
+	// helpful answers and down for unhelpful ones, within [0, 1].
+	ScoreStep float64
+	// Quarantine withdraws a learned entry once QuarantineVotes sessions
+	// have said its answer didn't help.
+	Quarantine      bool
+	QuarantineVotes int
+}
+
+var defaultFeedbackTuning = FeedbackTuning{Boost: 0.1, MaxWeight: 1, Decay: 0.5, ScoreStep: 0.1, QuarantineVotes: 3}
+
+// raise adds step to x up to most. Values already at or past most, such
+// as weights folded in before, are left alone rather than lowered.
+func raise(x, step, most float64) float64 {
+	if x >= most {
+		return x
+	}
+	return math.Min(most, x+step)
+}
+
+// AnswerFeedback says whether the answer to a question helped.
+type AnswerFeedback struct {
+	Question string `json:"question" schema:"required"`
+	// Answer is the text served, which picks the interaction when the
+	// question was asked more than once.
+	Answer  string `json:"answer"`
+	Helpful *bool  `json:"helpful" schema:"required"`
+	// SessionID is the conversation the question was asked in, the
+	// caller's own; without it the session cookie is used. Feedback only
+	// ever changes that session.
+	SessionID string `json:"session_id"`
+}
+
+// FeedbackResult reports what an AnswerFeedback changed.
+type FeedbackResult struct {
+	Keywords []string `json:"keywords"`
+	// Weights are the session's pattern weights of the keywords' stems
+	// afterwards.
+	Weights map[string]float64 `json:"weights"`
+	// Score is the interaction's score afterwards.
+	Score float64 `json:"score"`
+	// Quarantined is the learned entry withdrawn.
+	Quarantined string `json:"quarantined,omitempty"`
+}
+
+// findInteraction returns the latest interaction of s asking question. An
+// interaction whose answer is part of answer is preferred. The caller
+// holds ai.mu.
+func (s *session) findInteraction(question, answer string) (*Interaction, bool) {
+	key := normalizeQuestion(question)
+	var found *Interaction
+	for i := len(s.history) - 1; i >= 0; i-- {
+		interaction := &s.history[i]
+		if normalizeQuestion(interaction.Question) != key {
+			continue
+		}
+		if answer != "" && strings.Contains(answer, interaction.Answer) {
+			return interaction, true
+		}
+		if found == nil {
+			found = interaction
+		}
+	}
+	return found, found != nil
+}
+
+// voteUnhelpful records that session sessionID found the answer of the
+// learned entry id unhelpful and returns the number of sessions that
+// have. Each session counts once. The caller holds ai.mu for writing.
+func (ai *AIEngine) voteUnhelpful(id, sessionID string) int {
+	if ai.unhelpfulVotes == nil {
+		ai.unhelpfulVotes = make(map[string]map[string]bool)
+	}
+	voters := ai.unhelpfulVotes[id]
+	if voters == nil {
+		voters = make(map[string]bool)
+		ai.unhelpfulVotes[id] = voters
+	}
+	voters[sessionID] = true
+	return len(voters)
+}
+
+// applyFeedback adjusts the pattern weights of the keywords of the
+// interaction feedback is about, in the caller's session, and that
+// interaction's score: up by the tuning's Boost and ScoreStep when the
+// answer helped, the weights decayed and the score lowered when it
+// didn't. Weights only reach the global Patterns when the session is
+// folded, so feedback is bound by the session's PatternBudget like any
+// other reinforcement. Nothing changes when learning is switched off for
+// tenant. An unhelpful answer from a learned entry counts as a vote
+// against it, and the entry is quarantined, if the tuning says so, once
+// QuarantineVotes sessions have voted.
+func (ai *AIEngine) applyFeedback(feedback AnswerFeedback, tenant string) (FeedbackResult, error) {
+	tuning := ai.FeedbackTuning
+	helpful := feedback.Helpful != nil && *feedback.Helpful
+	learning := ai.Switches.Enabled(SwitchLearning, tenant)
+
+	ai.mu.Lock()
+	s, ok := ai.activeSession(feedback.SessionID, time.Now())
+	var interaction *Interaction
+	if ok {
+		interaction, ok = s.findInteraction(feedback.Question, feedback.Answer)
+	}
+	if !ok {
+		ai.mu.Unlock()
+		return FeedbackResult{}, newError(ErrNotFound, "this session was not answered the question from a learned entry; rate knowledge base answers on /entries/{id}/feedback")
+	}
+	result := FeedbackResult{Keywords: interaction.Keywords, Weights: make(map[string]float64)}
+	if result.Keywords == nil {
+		result.Keywords = []string{}
+	}
+	for _, keyword := range interaction.Keywords {
+		stem := stemWord(keyword)
+		if _, seen := result.Weights[stem]; seen {
+			continue
+		}
+		weight, ok := s.weights[stem]
+		switch {
+		case !learning:
+		case helpful:
+			s.offered += tuning.Boost
+			room := ai.PatternBudget.Cap - s.kept
+			if next := raise(math.Max(0, weight), math.Min(tuning.Boost, room), tuning.MaxWeight); next > weight {
+				s.kept += next - weight
+				weight = next
+				s.weights[stem] = weight
+			}
+		case ok:
+			weight = math.Max(0, weight*tuning.Decay)
+			s.weights[stem] = weight
+		}
+		result.Weights[stem] = weight
+	}
+	switch {
+	case !learning:
+	case helpful:
+		interaction.Score = raise(interaction.Score, tuning.ScoreStep, 1)
+	default:
+		interaction.Score = math.Max(0, interaction.Score-tuning.ScoreStep)
+	}
+	result.Score = interaction.Score
+	entryID := interaction.EntryID
+	votes := 0
+	if learning && !helpful && tuning.Quarantine && entryID != "" {
+		votes = ai.voteUnhelpful(entryID, feedback.SessionID)
+	}
+	ai.mu.Unlock()
+
+	if votes == 0 || votes < tuning.QuarantineVotes {
+		return result, nil
+	}
+	quarantined, err := ai.KB.quarantineLearned(entryID)
+	if err != nil {
+		return result, err
+	}
+	if quarantined {
+		ai.forgetInteractions(entryID)
+		result.Quarantined = entryID
+	}
+	ai.mu.Lock()
+	delete(ai.unhelpfulVotes, entryID)
+	ai.mu.Unlock()
+	return result, nil
+}
+
+// handleFeedback serves POST /feedback, on which users say whether an
+// answer they were given in their session helped, naming the question
+// and, ideally, the answer served.
+func handleFeedback(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req AnswerFeedback
+		if err := decodeRequest(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		if req.SessionID == "" {
+			if cookie, err := r.Cookie(sessionCookie); err == nil {
+				req.SessionID = cookie.Value
+			}
+		}
+		if req.SessionID == "" {
+			writeError(w, newError(ErrInvalidInput, "session_id is required: feedback is given on an answer in your session"))
+			return
+		}
+		if !validSessionID(req.SessionID) {
+			writeError(w, newError(ErrInvalidInput, "session_id must be 1 to %d letters, digits or -_.: characters", maxRequestIDLength))
+			return
+		}
+		if len(req.Question) > ai.Limits.maxQuestionBytes() {
+			writeError(w, newError(ErrTooLarge, "question is %d bytes, the limit is %d", len(req.Question), ai.Limits.maxQuestionBytes()))
+			return
+		}
+		result, err := ai.applyFeedback(req, requestTenant(r))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
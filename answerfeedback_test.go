@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// learnSlice teaches ai the learned entry the feedback tests rate.
+func learnSlice(t *testing.T, ai *AIEngine) LearnedEntry {
+	t.Helper()
+	learned, err := ai.KB.learnChecked(LearnedEntry{Question: "How do I reverse a slice?", Answer: "Swap elements from both ends."}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return learned
+}
+
+func feedback(question string, helpful bool, sessionID string) AnswerFeedback {
+	return AnswerFeedback{Question: question, Helpful: &helpful, SessionID: sessionID}
+}
+
+// sessionWeights copies the pattern weights of session id.
+func sessionWeights(ai *AIEngine, id string) map[string]float64 {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	weights := make(map[string]float64)
+	for stem, weight := range ai.sessions[id].weights {
+		weights[stem] = weight
+	}
+	return weights
+}
+
+func TestFeedbackChangesOnlyTheCallersSession(t *testing.T) {
+	ai := newTestEngine(t)
+	learnSlice(t, ai)
+	ai.FeedbackTuning = FeedbackTuning{Boost: 0.2, MaxWeight: 1, Decay: 0.5, ScoreStep: 0.1}
+	ask(t, ai, "How do I reverse a slice?", AskOptions{SessionID: "alice"})
+	ask(t, ai, "How do I reverse a slice?", AskOptions{SessionID: "bob"})
+	before := sessionWeights(ai, "bob")
+
+	result, err := ai.applyFeedback(feedback("How do I reverse a slice?", true, "alice"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Keywords) == 0 {
+		t.Fatal("no keywords were adjusted")
+	}
+	after := sessionWeights(ai, "alice")
+	for stem, weight := range result.Weights {
+		if after[stem] != weight || weight <= 0 {
+			t.Errorf("alice's weight of %q is %g, reported %g", stem, after[stem], weight)
+		}
+	}
+	for stem, weight := range sessionWeights(ai, "bob") {
+		if before[stem] != weight {
+			t.Errorf("bob's weight of %q moved from %g to %g", stem, before[stem], weight)
+		}
+	}
+	if len(ai.Patterns) != 0 {
+		t.Errorf("global patterns changed before the session ended: %v", ai.Patterns)
+	}
+
+	if _, err := ai.applyFeedback(feedback("How do I reverse a slice?", true, "mallory"), ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("feedback from a session that never asked: err = %v, want ErrNotFound", err)
+	}
+	ask(t, ai, "How do channels work?", AskOptions{SessionID: "alice"})
+	if _, err := ai.applyFeedback(feedback("How do channels work?", true, "alice"), ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("feedback on a knowledge base answer: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestFeedbackStaysWithinTheSessionBudget(t *testing.T) {
+	ai := newTestEngine(t)
+	learnSlice(t, ai)
+	ai.FeedbackTuning = FeedbackTuning{Boost: 0.5, MaxWeight: 10, Decay: 0.5, ScoreStep: 0.1}
+	ai.PatternBudget.Cap = 1
+	ask(t, ai, "How do I reverse a slice?", AskOptions{SessionID: "alice"})
+	for i := 0; i < 20; i++ {
+		if _, err := ai.applyFeedback(feedback("How do I reverse a slice?", true, "alice"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ai.mu.Lock()
+	kept := ai.sessions["alice"].kept
+	ai.mu.Unlock()
+	if kept > ai.PatternBudget.Cap+1e-9 {
+		t.Errorf("session kept %g, over the cap of %g", kept, ai.PatternBudget.Cap)
+	}
+	total := 0.0
+	for _, weight := range sessionWeights(ai, "alice") {
+		total += weight
+	}
+	if total > ai.PatternBudget.Cap+1e-9 {
+		t.Errorf("session weights total %g, over the cap of %g", total, ai.PatternBudget.Cap)
+	}
+
+	result, err := ai.applyFeedback(feedback("How do I reverse a slice?", false, "alice"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for stem, weight := range result.Weights {
+		if weight < 0 {
+			t.Errorf("weight of %q decayed below zero: %g", stem, weight)
+		}
+	}
+	if result.Score < 0 || result.Score > 1 {
+		t.Errorf("score %g is outside [0, 1]", result.Score)
+	}
+}
+
+func TestFeedbackRespectsTheLearningSwitch(t *testing.T) {
+	ai := newTestEngine(t)
+	ai.FeedbackTuning.Quarantine, ai.FeedbackTuning.QuarantineVotes = true, 1
+	learnSlice(t, ai)
+	assertAnswerSource(t, ask(t, ai, "How do I reverse a slice?", AskOptions{SessionID: "alice"}), SourceLearned)
+	before := sessionWeights(ai, "alice")
+
+	dir, err := ioutil.TempDir("", "askgo-switches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if ai.Switches, err = LoadSwitches(filepath.Join(dir, "switches.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ai.Switches.Set(SwitchLearning, "", false, "test"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := ai.applyFeedback(feedback("How do I reverse a slice?", false, "alice"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Quarantined != "" {
+		t.Errorf("quarantined %s with learning switched off", result.Quarantined)
+	}
+	for stem, weight := range sessionWeights(ai, "alice") {
+		if before[stem] != weight {
+			t.Errorf("weight of %q moved with learning switched off", stem)
+		}
+	}
+}
+
+func TestFeedbackQuarantinesAfterEnoughSessions(t *testing.T) {
+	ai := newTestEngine(t)
+	ai.FeedbackTuning.Quarantine, ai.FeedbackTuning.QuarantineVotes = true, 2
+	learned := learnSlice(t, ai)
+	for _, id := range []string{"alice", "bob"} {
+		assertAnswerSource(t, ask(t, ai, "How do I reverse a slice?", AskOptions{SessionID: id}), SourceLearned)
+	}
+
+	// One session voting again and again is still one vote.
+	for i := 0; i < 3; i++ {
+		result, err := ai.applyFeedback(feedback("How do I reverse a slice?", false, "alice"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Quarantined != "" {
+			t.Fatalf("vote %d of one session quarantined %s", i+1, result.Quarantined)
+		}
+	}
+	result, err := ai.applyFeedback(feedback("How do I reverse a slice?", false, "bob"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Quarantined != learned.ID {
+		t.Fatalf("quarantined %q after two sessions voted, want %s", result.Quarantined, learned.ID)
+	}
+	if answer, _ := ai.Ask("How do I reverse a slice?", AskOptions{}); answer.Source == SourceLearned {
+		t.Error("the quarantined entry still answers")
+	}
+}
+
+func TestHandleFeedbackNeedsASession(t *testing.T) {
+	ai := newTestEngine(t)
+	learnSlice(t, ai)
+	ask(t, ai, "How do I reverse a slice?", AskOptions{SessionID: "alice"})
+	tests := []struct {
+		name   string
+		body   string
+		cookie string
+		want   int
+	}{
+		{"no session", `{"question": "How do I reverse a slice?", "helpful": true}`, "", http.StatusBadRequest},
+		{"invalid session", `{"question": "How do I reverse a slice?", "helpful": true, "session_id": "a b"}`, "", http.StatusBadRequest},
+		{"another session", `{"question": "How do I reverse a slice?", "helpful": true, "session_id": "bob"}`, "", http.StatusNotFound},
+		{"session in the body", `{"question": "How do I reverse a slice?", "helpful": true, "session_id": "alice"}`, "", http.StatusOK},
+		{"session cookie", `{"question": "How do I reverse a slice?", "helpful": true}`, "alice", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/feedback", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: sessionCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			handleFeedback(ai)(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package askgopb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// AssistantClient is the client API for Assistant service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AssistantClient interface {
+	// Ask answers a question as POST /ai does.
+	Ask(ctx context.Context, in *Question, opts ...grpc.CallOption) (*Answer, error)
+	// AskStream answers a question word by word, as POST /ai does for
+	// clients accepting text/event-stream. The last chunk carries the whole
+	// answer.
+	AskStream(ctx context.Context, in *Question, opts ...grpc.CallOption) (Assistant_AskStreamClient, error)
+	// Learn teaches an answer as POST /learn does. It needs an admin key as
+	// "authorization: Bearer <key>" metadata.
+	Learn(ctx context.Context, in *QA, opts ...grpc.CallOption) (*Learned, error)
+}
+
+type assistantClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAssistantClient(cc grpc.ClientConnInterface) AssistantClient {
+	return &assistantClient{cc}
+}
+
+func (c *assistantClient) Ask(ctx context.Context, in *Question, opts ...grpc.CallOption) (*Answer, error) {
+	out := new(Answer)
+	err := c.cc.Invoke(ctx, "/askgo.Assistant/Ask", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *assistantClient) AskStream(ctx context.Context, in *Question, opts ...grpc.CallOption) (Assistant_AskStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Assistant_ServiceDesc.Streams[0], "/askgo.Assistant/AskStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &assistantAskStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Assistant_AskStreamClient interface {
+	Recv() (*AnswerChunk, error)
+	grpc.ClientStream
+}
+
+type assistantAskStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *assistantAskStreamClient) Recv() (*AnswerChunk, error) {
+	m := new(AnswerChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *assistantClient) Learn(ctx context.Context, in *QA, opts ...grpc.CallOption) (*Learned, error) {
+	out := new(Learned)
+	err := c.cc.Invoke(ctx, "/askgo.Assistant/Learn", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AssistantServer is the server API for Assistant service.
+// All implementations must embed UnimplementedAssistantServer
+// for forward compatibility
+type AssistantServer interface {
+	// Ask answers a question as POST /ai does.
+	Ask(context.Context, *Question) (*Answer, error)
+	// AskStream answers a question word by word, as POST /ai does for
+	// clients accepting text/event-stream. The last chunk carries the whole
+	// answer.
+	AskStream(*Question, Assistant_AskStreamServer) error
+	// Learn teaches an answer as POST /learn does. It needs an admin key as
+	// "authorization: Bearer <key>" metadata.
+	Learn(context.Context, *QA) (*Learned, error)
+	mustEmbedUnimplementedAssistantServer()
+}
+
+// UnimplementedAssistantServer must be embedded to have forward compatible implementations.
+type UnimplementedAssistantServer struct {
+}
+
+func (UnimplementedAssistantServer) Ask(context.Context, *Question) (*Answer, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ask not implemented")
+}
+func (UnimplementedAssistantServer) AskStream(*Question, Assistant_AskStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method AskStream not implemented")
+}
+func (UnimplementedAssistantServer) Learn(context.Context, *QA) (*Learned, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Learn not implemented")
+}
+func (UnimplementedAssistantServer) mustEmbedUnimplementedAssistantServer() {}
+
+// UnsafeAssistantServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AssistantServer will
+// result in compilation errors.
+type UnsafeAssistantServer interface {
+	mustEmbedUnimplementedAssistantServer()
+}
+
+func RegisterAssistantServer(s grpc.ServiceRegistrar, srv AssistantServer) {
+	s.RegisterService(&Assistant_ServiceDesc, srv)
+}
+
+func _Assistant_Ask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Question)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AssistantServer).Ask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/askgo.Assistant/Ask",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AssistantServer).Ask(ctx, req.(*Question))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Assistant_AskStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Question)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AssistantServer).AskStream(m, &assistantAskStreamServer{stream})
+}
+
+type Assistant_AskStreamServer interface {
+	Send(*AnswerChunk) error
+	grpc.ServerStream
+}
+
+type assistantAskStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *assistantAskStreamServer) Send(m *AnswerChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Assistant_Learn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QA)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AssistantServer).Learn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/askgo.Assistant/Learn",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AssistantServer).Learn(ctx, req.(*QA))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Assistant_ServiceDesc is the grpc.ServiceDesc for Assistant service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Assistant_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "askgo.Assistant",
+	HandlerType: (*AssistantServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Ask",
+			Handler:    _Assistant_Ask_Handler,
+		},
+		{
+			MethodName: "Learn",
+			Handler:    _Assistant_Learn_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "AskStream",
+			Handler:       _Assistant_AskStream_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "askgopb/askgo.proto",
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"text/template"
+)
+
+const defaultAttributionTemplate = ` [source: {{.ID}}{{if .SourceURL}} {{.SourceURL}}{{end}}]`
+
+// AttributionConfig is the "attribution" section of prompt.json.
+type AttributionConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Template string `json:"template"`
+}
+
+// Attribution appends a footer naming the entry an answer came from.
+type Attribution struct {
+	Enabled bool
+	tmpl    *template.Template
+}
+
+func (c AttributionConfig) build() (*Attribution, error) {
+	text := c.Template
+	if text == "" {
+		text = defaultAttributionTemplate
+	}
+	tmpl, err := template.New("attribution").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("attribution.template: %v", err)
+	}
+	return &Attribution{Enabled: c.Enabled, tmpl: tmpl}, nil
+}
+
+// enabled applies a per-request override to the configured default.
+func (a *Attribution) enabled(override *bool) bool {
+	if a == nil {
+		return false
+	}
+	if override != nil {
+		return *override
+	}
+	return a.Enabled
+}
+
+// apply returns the answer text with the footer appended. Only answers
+// served from the knowledge base or learned entries are attributed.
+func (a *Attribution) apply(answer Answer) string {
+	if answer.EntryID == "" {
+		return answer.Text
+	}
+	var buf bytes.Buffer
+	data := struct {
+		ID        string
+		SourceURL string
+	}{answer.EntryID, answer.SourceURL}
+	if err := a.tmpl.Execute(&buf, data); err != nil {
+		log.Printf("Attribution footer failed: %v", err)
+		return answer.Text
+	}
+	return answer.Text + buf.String()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// adminKeysEnv lists admin keys, comma-separated, in addition to
+// -admin-token and -admin-key-file.
+const adminKeysEnv = "ASKGO_ADMIN_KEYS"
+
+// loadAdminKeys gathers the admin keys from token, the key file, which
+// holds one key per line with blank lines and # comments skipped, and
+// $ASKGO_ADMIN_KEYS.
+func loadAdminKeys(token, file string) ([]string, error) {
+	var keys []string
+	if token != "" {
+		keys = append(keys, token)
+	}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				keys = append(keys, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range strings.Split(os.Getenv(adminKeysEnv), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// keyID names a key in logs and persisted state without revealing it: a
+// prefix of its SHA-256 sum.
+func keyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key-" + hex.EncodeToString(sum[:8])
+}
+
+// AdminAuth checks the bearer key of requests to admin routes. With no
+// keys every request is refused, unless Open is set for local
+// development.
+type AdminAuth struct {
+	Open bool
+	// digests are SHA-256 sums of the keys, so comparisons take the same
+	// time whatever the key lengths.
+	digests [][sha256.Size]byte
+}
+
+func NewAdminAuth(keys []string, open bool) AdminAuth {
+	auth := AdminAuth{Open: open}
+	for _, key := range keys {
+		auth.digests = append(auth.digests, sha256.Sum256([]byte(key)))
+	}
+	return auth
+}
+
+// check returns ErrUnauthorized when r carries no bearer key and
+// ErrForbidden when the key is not one of the admin keys. Every key is
+// compared in constant time, whichever matches.
+func (a AdminAuth) check(r *http.Request) error {
+	_, err := a.identify(r.Header.Get("Authorization"))
+	return err
+}
+
+// checkAuthorization checks an Authorization value, however it was sent.
+func (a AdminAuth) checkAuthorization(header string) error {
+	_, err := a.identify(header)
+	return err
+}
+
+// identify checks an Authorization value as checkAuthorization does and
+// returns the keyID of the admin key it carries, "" when a is Open.
+func (a AdminAuth) identify(header string) (string, error) {
+	if a.Open {
+		return "", nil
+	}
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", newError(ErrUnauthorized, "admin key required as an Authorization: Bearer header")
+	}
+	if len(a.digests) == 0 {
+		return "", newError(ErrForbidden, "no admin keys are configured on this server")
+	}
+	key := strings.TrimPrefix(header, "Bearer ")
+	digest := sha256.Sum256([]byte(key))
+	match := 0
+	for _, want := range a.digests {
+		match |= subtle.ConstantTimeCompare(digest[:], want[:])
+	}
+	if match != 1 {
+		return "", newError(ErrForbidden, "admin key not recognized")
+	}
+	return keyID(key), nil
+}
+
+type adminIdentityKey struct{}
+
+// adminIdentity returns the keyID of the admin key r was authenticated
+// with, or "" on routes that are not admin or servers run with -no-auth.
+func adminIdentity(r *http.Request) string {
+	id, _ := r.Context().Value(adminIdentityKey{}).(string)
+	return id
+}
+
+// TenantKeys maps the API keys callers send as X-API-Key to the tenants
+// they belong to. Keys it doesn't know belong to no tenant, so only
+// global kill switches apply to them.
+type TenantKeys struct {
+	tenants map[[sha256.Size]byte]string
+}
+
+// loadTenantKeys reads a tenant key file: one "tenant key" pair per line,
+// with blank lines and # comments skipped. An empty file name means no
+// tenants.
+func loadTenantKeys(file string) (TenantKeys, error) {
+	keys := TenantKeys{tenants: make(map[[sha256.Size]byte]string)}
+	if file == "" {
+		return keys, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return keys, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return keys, fmt.Errorf("%s:%d: want a tenant and a key", file, n)
+		}
+		digest := sha256.Sum256([]byte(fields[1]))
+		if _, ok := keys.tenants[digest]; ok {
+			return keys, fmt.Errorf("%s:%d: key listed twice", file, n)
+		}
+		keys.tenants[digest] = fields[0]
+	}
+	return keys, scanner.Err()
+}
+
+// tenant returns the tenant of an API key, or "" for keys not listed.
+func (t TenantKeys) tenant(key string) string {
+	if key == "" {
+		return ""
+	}
+	return t.tenants[sha256.Sum256([]byte(key))]
+}
+
+type tenantKey struct{}
+
+// requestTenant returns the tenant of the X-API-Key r was routed with.
+func requestTenant(r *http.Request) string {
+	tenant, _ := r.Context().Value(tenantKey{}).(string)
+	return tenant
+}
+
+// withTenant resolves the X-API-Key of r to its tenant for requestTenant.
+func withTenant(r *http.Request, tenants TenantKeys) *http.Request {
+	tenant := tenants.tenant(r.Header.Get("X-API-Key"))
+	if tenant == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant))
+}
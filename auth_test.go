@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminAuthCheckAuthorization(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   AdminAuth
+		header string
+		want   error
+	}{
+		{"missing", NewAdminAuth([]string{"k1", "k2"}, false), "", ErrUnauthorized},
+		{"not bearer", NewAdminAuth([]string{"k1"}, false), "Basic azE=", ErrUnauthorized},
+		{"wrong", NewAdminAuth([]string{"k1", "k2"}, false), "Bearer k3", ErrForbidden},
+		{"prefix of a key", NewAdminAuth([]string{"k1"}, false), "Bearer k", ErrForbidden},
+		{"no keys configured", NewAdminAuth(nil, false), "Bearer k1", ErrForbidden},
+		{"first key", NewAdminAuth([]string{"k1", "k2"}, false), "Bearer k1", nil},
+		{"second key", NewAdminAuth([]string{"k1", "k2"}, false), "Bearer k2", nil},
+		{"open", NewAdminAuth(nil, true), "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.auth.checkAuthorization(tt.header)
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("checkAuthorization(%q) = %v, want %v", tt.header, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadAdminKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "askgo-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "keys")
+	if err := ioutil.WriteFile(file, []byte("# ops\nk2\n\n  k3  \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv(adminKeysEnv, os.Getenv(adminKeysEnv))
+	os.Setenv(adminKeysEnv, "k4, ,k5")
+
+	keys, err := loadAdminKeys("k1", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"k1", "k2", "k3", "k4", "k5"}
+	if len(keys) != len(want) {
+		t.Fatalf("keys = %q, want %q", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("keys = %q, want %q", keys, want)
+		}
+	}
+}
+
+func TestLoadTenantKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "askgo-tenants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "tenants")
+	if err := ioutil.WriteFile(file, []byte("# customers\nacme secret-a\n\n  globex   secret-g\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tenants, err := loadTenantKeys(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"secret-a": "acme", "secret-g": "globex", "acme": "", "": ""} {
+		if got := tenants.tenant(key); got != want {
+			t.Errorf("tenant(%q) = %q, want %q", key, got, want)
+		}
+	}
+
+	for _, bad := range []string{"acme\n", "acme k1\nglobex k1\n"} {
+		if err := ioutil.WriteFile(file, []byte(bad), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadTenantKeys(file); err == nil {
+			t.Errorf("loadTenantKeys accepted %q", bad)
+		}
+	}
+}
+
+func TestSwitchesAuditAndTenantsHoldNoSecrets(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	router, err := NewRouter(appRoutes(f.deps), NewAdminAuth([]string{testAdminKey}, false), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "askgo-tenants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "tenants")
+	if err := ioutil.WriteFile(file, []byte("acme tenant-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if router.tenants, err = loadTenantKeys(file); err != nil {
+		t.Fatal(err)
+	}
+	f.server.Config.Handler = router
+
+	resp, body := f.do(t, http.MethodPost, "/admin/switches", `{"switch": "learning_enabled", "tenant": "acme", "enabled": false}`, testAdminKey,
+		map[string]string{"X-Admin-User": "mallory"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(f.dir, "switches.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{testAdminKey, "tenant-secret", "mallory"} {
+		if strings.Contains(string(data), leak) {
+			t.Errorf("switches file holds %q:\n%s", leak, data)
+		}
+	}
+	if !strings.Contains(string(data), keyID(testAdminKey)) {
+		t.Errorf("switches file does not name the admin key %s:\n%s", keyID(testAdminKey), data)
+	}
+
+	// The tenant's callers are refused learning; other keys are not.
+	learn := `{"question": "What is a map?", "answer": "A map is a hash table."}`
+	for key, want := range map[string]int{"tenant-secret": http.StatusServiceUnavailable, "other-key": http.StatusOK} {
+		resp, body := f.do(t, http.MethodPost, "/learn", learn, testAdminKey, map[string]string{"X-API-Key": key})
+		if resp.StatusCode != want {
+			t.Errorf("X-API-Key %s: status %d, want %d: %s", key, resp.StatusCode, want, body)
+		}
+	}
+}
+
+func TestRekeyTenantsDropsStoredKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "askgo-switches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "switches.json")
+	switches, err := LoadSwitches(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := switches.Set(SwitchLearning, "tenant-secret", false, "ops"); err != nil {
+		t.Fatal(err)
+	}
+	tenants := TenantKeys{tenants: map[[32]byte]string{sha256.Sum256([]byte("tenant-secret")): "acme"}}
+	if err := switches.rekeyTenants(tenants); err != nil {
+		t.Fatal(err)
+	}
+	if switches.Enabled(SwitchLearning, "acme") {
+		t.Error("acme lost its switch setting")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "tenant-secret") {
+		t.Errorf("switches file still holds the key:\n%s", data)
+	}
+}
+
+// TestAnonymousCandidatesAreCapped lets only callers with a tenant key ask
+// /ai/candidates and /search for more than publicCandidatesK matches.
+func TestAnonymousCandidatesAreCapped(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	router, err := NewRouter(appRoutes(f.deps), NewAdminAuth([]string{testAdminKey}, false), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.tenants = TenantKeys{tenants: map[[32]byte]string{sha256.Sum256([]byte("tenant-secret")): "acme"}}
+	f.server.Config.Handler = router
+
+	tenant := map[string]string{"X-API-Key": "tenant-secret"}
+	stranger := map[string]string{"X-API-Key": "made-up"}
+	for _, path := range []string{"/ai/candidates", "/search"} {
+		tests := []struct {
+			k      int
+			header map[string]string
+			status int
+		}{
+			{publicCandidatesK, nil, http.StatusOK},
+			{publicCandidatesK + 1, nil, http.StatusBadRequest},
+			{publicCandidatesK + 1, stranger, http.StatusBadRequest},
+			{publicCandidatesK + 1, tenant, http.StatusOK},
+			{maxCandidatesK, tenant, http.StatusOK},
+			{maxCandidatesK + 1, tenant, http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			body := fmt.Sprintf(`{"text": "What is a goroutine?", "k": %d}`, tt.k)
+			if resp, got := f.do(t, http.MethodPost, path, body, "", tt.header); resp.StatusCode != tt.status {
+				t.Errorf("%s k=%d with %v: status %d, want %d: %s", path, tt.k, tt.header, resp.StatusCode, tt.status, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runBenchANN is the bench-ann subcommand. It builds knowledge bases of
+// synthetic entries, clustered the way questions on a few topics are, and
+// compares ranking through the index with the exact scan: query latency,
+// how often both agree on the best entry, and what inserting one entry
+// costs.
+func runBenchANN(args []string) {
+	fs := flag.NewFlagSet("bench-ann", flag.ExitOnError)
+	sizes := fs.String("entries", "10000,100000", "comma-separated knowledge base sizes to measure")
+	dim := fs.Int("dim", 300, "vector dimension")
+	queries := fs.Int("queries", 500, "queries per size")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: askgo bench-ann [-entries n,n] [-dim n] [-queries n]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dim <= 0 || *queries <= 0 {
+		log.Fatal("-dim and -queries must be positive")
+	}
+	fmt.Printf("%9s %10s %10s %8s %8s %10s %10s\n", "entries", "exact", "ann", "speedup", "recall", "build", "insert")
+	for _, field := range strings.Split(*sizes, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n <= 0 {
+			log.Fatalf("-entries: %q is not a size", field)
+		}
+		benchANN(n, *dim, *queries)
+	}
+}
+
+func benchANN(n, dim, queries int) {
+	rng := rand.New(rand.NewSource(int64(n)))
+	entries := benchEntries(rng, n, dim)
+	kb := NewKnowledgeBase()
+	start := time.Now()
+	kb.updateEntries("bench", func([]KnowledgeEntry) []KnowledgeEntry { return entries })
+	build := time.Since(start)
+	if kb.view().ann == nil {
+		fmt.Printf("%9d: below %d entries every entry is scored\n", n, annMinEntries)
+		return
+	}
+
+	probes := make([][]float32, queries)
+	for i := range probes {
+		probes[i] = noisyVector(rng, entries[rng.Intn(n)].Vector, dim, 0.4)
+	}
+	state := kb.view()
+	var exact, approximate time.Duration
+	agree := 0
+	for _, probe := range probes {
+		start := time.Now()
+		want := state.rankEntries(probe, nil)
+		exact += time.Since(start)
+		start = time.Now()
+		got := state.rankVector(probe)
+		approximate += time.Since(start)
+		if len(got) > 0 && len(want) > 0 && got[0].Entry.ID == want[0].Entry.ID {
+			agree++
+		}
+	}
+
+	start = time.Now()
+	kb.updateEntries("bench", func(entries []KnowledgeEntry) []KnowledgeEntry {
+		return append(entries, KnowledgeEntry{ID: "bench-new", Vector: probes[0]})
+	})
+	insert := time.Since(start)
+
+	perQuery := func(d time.Duration) time.Duration { return (d / time.Duration(queries)).Round(time.Microsecond) }
+	fmt.Printf("%9d %10s %10s %7.1fx %7.1f%% %10s %10s\n", n, perQuery(exact), perQuery(approximate),
+		float64(exact)/float64(approximate), 100*float64(agree)/float64(queries),
+		build.Round(time.Millisecond), insert.Round(time.Microsecond))
+}
+
+// benchEntries returns n synthetic entries of dim dimensions, around one
+// topic per 50 entries.
+func benchEntries(rng *rand.Rand, n, dim int) []KnowledgeEntry {
+	centers := make([][]float32, n/50+1)
+	for i := range centers {
+		centers[i] = noisyVector(rng, nil, dim, 1)
+	}
+	entries := make([]KnowledgeEntry, n)
+	for i := range entries {
+		entries[i] = KnowledgeEntry{
+			ID:     fmt.Sprintf("bench-%d", i),
+			Vector: noisyVector(rng, centers[rng.Intn(len(centers))], dim, 0.6),
+		}
+	}
+	return entries
+}
+
+// noisyVector returns center plus gaussian noise of the given scale,
+// normalized; a nil center gives a random direction.
+func noisyVector(rng *rand.Rand, center []float32, dim int, noise float64) []float32 {
+	vec := make([]float32, dim)
+	var norm float64
+	for i := range vec {
+		x := rng.NormFloat64() * noise / math.Sqrt(float64(dim))
+		if center != nil {
+			x += float64(center[i])
+		}
+		vec[i] = float32(x)
+		norm += x * x
+	}
+	for i := range vec {
+		vec[i] /= float32(math.Sqrt(norm))
+	}
+	return vec
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runBenchScan is the bench-scan subcommand. It scores every entry of a
+// synthetic knowledge base against a set of questions, the scan questions
+// the index can't place fall back to, split across each number of workers
+// asked for, and reports how much faster each split is than one goroutine.
+func runBenchScan(args []string) {
+	fs := flag.NewFlagSet("bench-scan", flag.ExitOnError)
+	n := fs.Int("entries", 50000, "knowledge base size")
+	dim := fs.Int("dim", 300, "vector dimension")
+	queries := fs.Int("queries", 200, "queries per worker count")
+	workerList := fs.String("workers", "1,"+strconv.Itoa(runtime.GOMAXPROCS(0)), "comma-separated worker counts to measure")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: askgo bench-scan [-entries n] [-dim n] [-queries n] [-workers n,n]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *n <= 0 || *dim <= 0 || *queries <= 0 {
+		log.Fatal("-entries, -dim and -queries must be positive")
+	}
+	var workers []int
+	for _, field := range strings.Split(*workerList, ",") {
+		w, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || w <= 0 {
+			log.Fatalf("-workers: %q is not a worker count", field)
+		}
+		workers = append(workers, w)
+	}
+
+	rng := rand.New(rand.NewSource(int64(*n)))
+	entries := benchEntries(rng, *n, *dim)
+	probes := make([][]float32, *queries)
+	for i := range probes {
+		probes[i] = noisyVector(rng, entries[rng.Intn(*n)].Vector, *dim, 0.4)
+	}
+	serial := &kbState{entries: entries, scan: scanConfig{workers: 1}}
+	want := make([]string, len(probes))
+	for i, probe := range probes {
+		if matches := serial.rankEntries(probe, nil); len(matches) > 0 {
+			want[i] = matches[0].Entry.ID
+		}
+	}
+
+	fmt.Printf("%d entries of dimension %d, GOMAXPROCS %d\n", *n, *dim, runtime.GOMAXPROCS(0))
+	fmt.Printf("%8s %10s %8s\n", "workers", "per query", "speedup")
+	var base time.Duration
+	for _, w := range workers {
+		state := &kbState{entries: entries, scan: scanConfig{minEntries: 1, workers: w}}
+		start := time.Now()
+		for i, probe := range probes {
+			matches := state.rankEntries(probe, nil)
+			if len(matches) > 0 && matches[0].Entry.ID != want[i] {
+				log.Fatalf("%d workers ranked %s first for query %d, one goroutine %s", w, matches[0].Entry.ID, i, want[i])
+			}
+		}
+		perQuery := time.Since(start) / time.Duration(*queries)
+		if base == 0 {
+			base = perQuery
+		}
+		fmt.Printf("%8d %10s %7.1fx\n", w, perQuery.Round(time.Microsecond), float64(base)/float64(perQuery))
+	}
+}
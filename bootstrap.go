@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// toyEmbeddingsMarker is a key written into toy embeddings so the server
+// can tell them apart from real ones. Its vector is all zeros, so it
+// never moves a sentence vector.
+const toyEmbeddingsMarker = "_askgo_toy_embeddings"
+
+// toyDimension is the dimension of toy embeddings.
+const toyDimension = 32
+
+// toyStopWords are scaled down in toy embeddings, so questions that share
+// only "what is a" don't look alike.
+var toyStopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "is": true, "are": true, "do": true,
+	"does": true, "i": true, "in": true, "of": true, "to": true, "and": true,
+	"or": true, "what": true, "how": true, "why": true, "when": true,
+	"between": true, "it": true, "for": true, "with": true, "go": true,
+}
+
+// starterPrompt is the prompt file askgo init writes. JSON has no
+// comments, so the notes live under "_comment" keys, which the loader
+// ignores.
+const starterPrompt = `{
+  "_comment": [
+    "Starter prompt file written by askgo init. Edit it freely; the schema is served at /schema/prompt.json.",
+    "greetings: exact questions answered with a fixed reply.",
+    "common_questions: replies for questions containing the key; keep keys specific.",
+    "knowledge_base: questions matched by meaning. id is optional and derived from the question if left out.",
+    "default_responses: fallbacks when nothing matches; keywords must contain exactly one %s.",
+    "The embeddings written next to this file are toy vectors: fine for trying the server, useless in production."
+  ],
+  "greetings": {
+    "hi": "Hello! Ask me anything about Go.",
+    "hello": "Hi there! What would you like to know about Go?"
+  },
+  "common_questions": {
+    "who are you": "I'm AskGo, a small assistant that answers questions about the Go programming language."
+  },
+  "knowledge_base": [
+    {
+      "question": "What is a goroutine?",
+      "answer": "A goroutine is a function running concurrently with other goroutines in the same address space. Start one with the go keyword: go work()."
+    },
+    {
+      "question": "How do channels work?",
+      "answer": "Channels are typed conduits between goroutines. Send with ch <- v and receive with v := <-ch; both block until the other side is ready unless the channel is buffered."
+    },
+    {
+      "question": "What is the difference between buffered and unbuffered channels?",
+      "answer": "An unbuffered channel hands each value directly from sender to receiver. A buffered channel, made with make(chan T, n), accepts up to n values before sends block."
+    },
+    {
+      "question": "How do I handle errors in Go?",
+      "answer": "Functions return an error as their last result. Check it right away with if err != nil, and wrap it with fmt.Errorf and %w to add context."
+    },
+    {
+      "question": "What is an interface in Go?",
+      "answer": "An interface is a set of method signatures. Any type with those methods satisfies it implicitly; there is no implements keyword."
+    },
+    {
+      "question": "How do I create a slice?",
+      "answer": "Use a literal such as []int{1, 2, 3}, make([]int, length, capacity), or slice an existing array or slice with s[low:high]."
+    },
+    {
+      "question": "What is the difference between a slice and an array?",
+      "answer": "An array has a fixed length that is part of its type. A slice is a view of an underlying array with a length and capacity, and append can grow it."
+    },
+    {
+      "question": "How do maps work in Go?",
+      "answer": "A map is a hash table from keys to values, created with make(map[K]V) or a literal. Look up with v, ok := m[k] and remove with delete(m, k)."
+    },
+    {
+      "question": "What does defer do?",
+      "answer": "defer schedules a call to run when the surrounding function returns, in last-in first-out order. It is the usual way to close files and unlock mutexes."
+    },
+    {
+      "question": "How do I use the select statement?",
+      "answer": "select waits on several channel operations and runs the first one that is ready. A default case makes it non-blocking."
+    },
+    {
+      "question": "What is a Go module?",
+      "answer": "A module is a collection of packages versioned together, described by a go.mod file. Create one with go mod init and add dependencies with go get."
+    },
+    {
+      "question": "How do I write a test in Go?",
+      "answer": "Put functions named TestXxx(t *testing.T) in a file ending in _test.go and run go test. Report failures with t.Errorf or t.Fatalf."
+    }
+  ],
+  "default_responses": {
+    "keywords": "I don't know much about %s yet. Try asking about goroutines, channels, slices or errors.",
+    "default": "Ask me a question about Go, for example: what is a goroutine?"
+  }
+}
+`
+
+// toyEmbeddings derives deterministic pseudo-vectors for the words of the
+// knowledge base. Each word gets a unit vector seeded from the hash of its
+// stem, so sentences are similar only when they share word stems: enough
+// for a demo, with none of the meaning real embeddings carry.
+func toyEmbeddings(entries []KnowledgeEntry) map[string][]float64 {
+	embeddings := map[string][]float64{toyEmbeddingsMarker: make([]float64, toyDimension)}
+	for _, entry := range entries {
+		for _, token := range tokenize(entry.Question+" "+entry.Answer, nil) {
+			word := strings.TrimFunc(token, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+			if word == "" || strings.IndexFunc(word, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) >= 0 {
+				continue
+			}
+			vec := toyVector(word)
+			embeddings[word] = vec
+			embeddings[token] = vec
+		}
+	}
+	return embeddings
+}
+
+func toyVector(word string) []float64 {
+	sum := sha1.Sum([]byte(stemWord(word)))
+	rng := rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(sum[:8]))))
+	vec := make([]float64, toyDimension)
+	var norm float64
+	for i := range vec {
+		vec[i] = rng.NormFloat64()
+		norm += vec[i] * vec[i]
+	}
+	scale := 1 / math.Sqrt(norm)
+	if toyStopWords[word] {
+		scale *= 0.1
+	}
+	for i := range vec {
+		vec[i] = math.Round(vec[i]*scale*1e6) / 1e6
+	}
+	return vec
+}
+
+// isToyEmbeddings reports whether embeddings were generated by
+// toyEmbeddings.
+func isToyEmbeddings(embeddings *EmbeddingStore) bool {
+	return embeddings.Has(toyEmbeddingsMarker)
+}
+
+// writeStarter writes the starter prompt file and toy embeddings for it to
+// the configured paths. Existing files are kept unless overwrite is set;
+// toy embeddings are derived from whatever prompt file ends up in place.
+// It returns the paths written.
+func writeStarter(config Config, overwrite bool) ([]string, error) {
+	var written []string
+	missing := func(path string) bool {
+		_, err := os.Stat(path)
+		return overwrite || os.IsNotExist(err)
+	}
+	if missing(config.Prompts) {
+		if err := ioutil.WriteFile(config.Prompts, []byte(starterPrompt), 0644); err != nil {
+			return written, err
+		}
+		written = append(written, config.Prompts)
+	}
+	if missing(config.Embeddings) {
+		prompts, err := loadPrompts(config.Prompts)
+		if err != nil {
+			return written, err
+		}
+		data, err := json.Marshal(toyEmbeddings(prompts.KnowledgeBase))
+		if err != nil {
+			return written, err
+		}
+		if err := ioutil.WriteFile(config.Embeddings, data, 0644); err != nil {
+			return written, err
+		}
+		written = append(written, config.Embeddings)
+	}
+	return written, nil
+}
+
+// runInit is the init subcommand: it writes a starter prompt file and toy
+// embeddings so a fresh checkout can serve answers.
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	force := fs.Bool("force", false, "overwrite existing files")
+	configFlags := registerConfigFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: askgo init [-force] [-prompts file] [-embeddings file]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	config, err := configFlags.load(fs)
+	if err != nil {
+		log.Fatal("Error loading configuration: ", err)
+	}
+	written, err := writeStarter(config, *force)
+	if err != nil {
+		log.Fatal("Error writing starter files: ", err)
+	}
+	if len(written) == 0 {
+		fmt.Printf("%s and %s already exist; use -force to overwrite them\n", config.Prompts, config.Embeddings)
+		return
+	}
+	for _, path := range written {
+		fmt.Println("Wrote", path)
+	}
+	fmt.Println("The embeddings are toy vectors for trying the server; replace them before production.")
+}
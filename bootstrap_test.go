@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestBootstrapFromCleanDir builds the server and starts it with
+// -bootstrap in an empty directory, with only the shipped templates and
+// static files, then asks it a starter question and reads /healthz.
+func TestBootstrapFromCleanDir(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the server")
+	}
+	dir, err := ioutil.TempDir("", "askgo-bootstrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	binary := filepath.Join(dir, "askgo")
+	if out, err := exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"), "build", "-o", binary, ".").CombinedOutput(); err != nil {
+		t.Fatalf("building: %v\n%s", err, out)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	work := filepath.Join(dir, "work")
+	if err := os.Mkdir(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	server := exec.Command(binary, "-bootstrap", "-addr", addr,
+		"-templates", filepath.Join(cwd, "templates"), "-static", filepath.Join(cwd, "static"))
+	server.Dir = work
+	var output strings.Builder
+	server.Stdout, server.Stderr = &output, &output
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Process.Kill()
+
+	url := "http://" + addr
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		resp, err := http.Get(url + "/healthz")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server didn't come up: %v\n%s", err, output.String())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	resp, err := http.Post(url+"/ai", "application/json", strings.NewReader(`{"text": "What is a goroutine?", "verbose": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var answer struct {
+		Text   string `json:"answer"`
+		Source string `json:"source"`
+	}
+	json.NewDecoder(resp.Body).Decode(&answer)
+	resp.Body.Close()
+	if answer.Source != SourceKnowledgeBase {
+		t.Errorf("starter question answered %q from %q", answer.Text, answer.Source)
+	}
+
+	resp, err = http.Get(url + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var health struct {
+		Toy      bool     `json:"toy_embeddings"`
+		Warnings []string `json:"warnings"`
+	}
+	json.NewDecoder(resp.Body).Decode(&health)
+	resp.Body.Close()
+	if !health.Toy || !strings.Contains(strings.Join(health.Warnings, "\n"), "not for production") {
+		t.Errorf("/healthz doesn't flag the toy embeddings: %+v", health)
+	}
+
+	server.Process.Signal(syscall.SIGTERM)
+	if err := server.Wait(); err != nil {
+		t.Errorf("server exited with %v\n%s", err, output.String())
+	}
+	for _, file := range []string{"prompt.json", "embeddings.json"} {
+		if !strings.Contains(output.String(), "Bootstrap: wrote "+file) {
+			t.Errorf("output doesn't report writing %s:\n%s", file, output.String())
+		}
+	}
+}
+
+// TestStarterFilesPassValidation writes the starter files and checks the
+// knowledge base validator finds no problem with them, and that they are
+// kept unless overwriting.
+func TestStarterFilesPassValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "askgo-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := defaultConfig()
+	config.Prompts, config.Embeddings = filepath.Join(dir, "prompt.json"), filepath.Join(dir, "embeddings.json")
+	written, err := writeStarter(config, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 2 {
+		t.Fatalf("wrote %q", written)
+	}
+	embeddings, err := loadEmbeddings(config.Embeddings, formatAuto, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isToyEmbeddings(embeddings) {
+		t.Error("the toy embeddings aren't marked")
+	}
+	ai, err := NewAIEngine(config, embeddings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ai.KB.view().entries); n < 12 {
+		t.Errorf("%d starter entries", n)
+	}
+	if report := ai.ValidateKB(false); report.problems() != 0 {
+		t.Errorf("starter files fail validation: %s", report.summary())
+	}
+
+	before, _ := ioutil.ReadFile(config.Embeddings)
+	if written, err := writeStarter(config, false); err != nil || len(written) != 0 {
+		t.Errorf("second init wrote %q, %v", written, err)
+	}
+	if written, err := writeStarter(config, true); err != nil || len(written) != 2 {
+		t.Errorf("forced init wrote %q, %v", written, err)
+	}
+	if after, _ := ioutil.ReadFile(config.Embeddings); string(after) != string(before) {
+		t.Errorf("forced init wrote different embeddings (%d bytes, was %d)", len(after), len(before))
+	}
+}
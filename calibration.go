@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// minConfidence is the calibrated confidence a candidate must exceed to be
+// served; below it the answer is a fallback.
+const minConfidence = 0.5
+
+// Calibration maps one retriever's raw scores onto a common 0–1
+// confidence, so candidates from different retrievers can be compared.
+type Calibration struct {
+	// Method is "minmax", linear from Min (0) to Max (1) and clamped, or
+	// "logistic", a sigmoid centred on Midpoint with slope Steepness.
+	Method    string  `json:"method" schema:"enum=minmax|logistic"`
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Midpoint  float64 `json:"midpoint"`
+	Steepness float64 `json:"steepness"`
+}
+
+// defaultCalibrations put each retriever's former fixed threshold at
+// minConfidence and its perfect score at 1: context matches served above
+// 0.8 and knowledge base matches above 0.7; exact lookups always score 1.
+// Learned entries matched by similarity share the knowledge base's scale.
+var defaultCalibrations = map[string]Calibration{
+	SourceContext:        {Method: "minmax", Min: 0.6, Max: 1},
+	SourceLearned:        {Method: "minmax", Min: 0.4, Max: 1},
+	SourceGreeting:       {Method: "minmax", Min: 0, Max: 1},
+	SourceCommonQuestion: {Method: "minmax", Min: 0, Max: 1},
+	SourceKnowledgeBase:  {Method: "minmax", Min: 0.4, Max: 1},
+}
+
+// normalize returns the confidence of a raw score. It is rounded to nine
+// places so a raw score exactly at a threshold doesn't pass on rounding
+// error.
+func (c Calibration) normalize(raw float64) float64 {
+	var confidence float64
+	if c.Method == "logistic" {
+		confidence = 1 / (1 + math.Exp(-c.Steepness*(raw-c.Midpoint)))
+	} else {
+		confidence = math.Max(0, math.Min(1, (raw-c.Min)/(c.Max-c.Min)))
+	}
+	return math.Round(confidence*1e9) / 1e9
+}
+
+func (c Calibration) validate() error {
+	switch c.Method {
+	case "minmax":
+		if c.Max <= c.Min {
+			return fmt.Errorf("max must be greater than min")
+		}
+	case "logistic":
+		if c.Steepness <= 0 {
+			return fmt.Errorf("steepness must be positive")
+		}
+	default:
+		return fmt.Errorf(`method must be "minmax" or "logistic"`)
+	}
+	return nil
+}
+
+// Calibrations holds the calibration of every retriever, by answer source.
+type Calibrations map[string]Calibration
+
+// buildCalibrations overlays the "calibration" section of prompt.json on
+// the defaults.
+func buildCalibrations(config map[string]Calibration) (Calibrations, error) {
+	calibrations := make(Calibrations, len(defaultCalibrations))
+	for source, c := range defaultCalibrations {
+		calibrations[source] = c
+	}
+	names := make([]string, 0, len(config))
+	for source := range config {
+		names = append(names, source)
+	}
+	sort.Strings(names)
+	for _, source := range names {
+		if _, ok := defaultCalibrations[source]; !ok {
+			return nil, fmt.Errorf("calibration.%s: unknown retriever", source)
+		}
+		if err := config[source].validate(); err != nil {
+			return nil, fmt.Errorf("calibration.%s: %v", source, err)
+		}
+		calibrations[source] = config[source]
+	}
+	return calibrations, nil
+}
+
+// normalize returns the confidence of a raw score from source. Sources
+// without a calibration use their raw score.
+func (c Calibrations) normalize(source string, raw float64) float64 {
+	if calibration, ok := c[source]; ok {
+		return calibration.normalize(raw)
+	}
+	return raw
+}
+
+// Candidate is the best answer one retriever offered for a question, with
+// its raw score and calibrated confidence.
+type Candidate struct {
+	Source     string  `json:"source"`
+	EntryID    string  `json:"entry_id,omitempty"`
+	Score      float64 `json:"score"`
+	Confidence float64 `json:"confidence"`
+	Chosen     bool    `json:"chosen,omitempty"`
+}
+
+// retrieval is a candidate and how to build its answer if it is chosen.
+type retrieval struct {
+	Candidate
+	answer func() Answer
+}
+
+// choose picks the most confident candidate above minConfidence, earlier
+// candidates winning ties, and marks it chosen. It returns -1 if none
+// qualifies.
+func choose(retrievals []retrieval) int {
+	best := -1
+	for i, r := range retrievals {
+		if r.Confidence > minConfidence && (best < 0 || r.Confidence > retrievals[best].Confidence) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		retrievals[best].Chosen = true
+	}
+	return best
+}
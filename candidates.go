@@ -0,0 +1,142 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Bounds on k for /ai/candidates and /search. Callers without a tenant
+// key get at most publicCandidatesK, so anonymous clients can't page
+// through the knowledge base a large k at a time.
+const (
+	defaultCandidatesK = 5
+	publicCandidatesK  = 10
+	maxCandidatesK     = 50
+)
+
+// candidatesLimit is the largest k r may ask for.
+func candidatesLimit(r *http.Request) int {
+	if requestTenant(r) == "" {
+		return publicCandidatesK
+	}
+	return maxCandidatesK
+}
+
+// matchHeap is a min-heap of matches, the weakest on top, so the best k
+// are kept by popping whenever it grows past k.
+type matchHeap []Match
+
+// weaker orders matches by score, breaking ties by ID so results are
+// stable.
+func weaker(a, b Match) bool {
+	if a.Score != b.Score {
+		return a.Score < b.Score
+	}
+	return a.Entry.ID > b.Entry.ID
+}
+
+func (h matchHeap) Len() int            { return len(h) }
+func (h matchHeap) Less(i, j int) bool  { return weaker(h[i], h[j]) }
+func (h matchHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *matchHeap) Push(x interface{}) { *h = append(*h, x.(Match)) }
+func (h *matchHeap) Pop() interface{} {
+	old := *h
+	m := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return m
+}
+
+// FindTopK returns the k knowledge base and learned entries closest to the
+// question, best first, like the head of RankMatches without sorting
+// every entry. Equal scores are ordered by ID. Fewer than k come back when
+// fewer score above zero.
+func (kb *KnowledgeBase) FindTopK(question string, embeddings *EmbeddingStore, k int) []Match {
+	if k <= 0 {
+		return nil
+	}
+	state := kb.view()
+	vec := state.vector(question, embeddings)
+	top := make(matchHeap, 0, k+1)
+	offer := func(m Match) {
+		if m.Score <= 0 || (len(top) == k && !weaker(top[0], m)) {
+			return
+		}
+		heap.Push(&top, m)
+		if len(top) > k {
+			heap.Pop(&top)
+		}
+	}
+	for _, entry := range state.entries {
+		if !entry.Quarantined {
+			raw := cosineSimilarity(vec, entry.Vector)
+			offer(Match{Entry: entry, Score: weightedScore(raw, entry.weight(), state.maxWeightBoost), RawScore: raw, Source: SourceKnowledgeBase})
+		}
+	}
+	for key, entry := range state.learned {
+		if !entry.Quarantined {
+			offer(learnedMatch(entry, cosineSimilarity(vec, state.learnedVectors[key])))
+		}
+	}
+	matches := make([]Match, len(top))
+	for i := len(matches) - 1; i >= 0; i-- {
+		matches[i] = heap.Pop(&top).(Match)
+	}
+	return matches
+}
+
+type candidatesRequest struct {
+	Text string `json:"text" schema:"required"`
+	// K is the number of candidates wanted, at most candidatesLimit; 0
+	// means defaultCandidatesK.
+	K int `json:"k"`
+}
+
+// CandidateAnswer is one entry in the /ai/candidates response.
+type CandidateAnswer struct {
+	ID       string  `json:"id"`
+	Source   string  `json:"source"`
+	Question string  `json:"question"`
+	Answer   string  `json:"answer"`
+	Score    float64 `json:"score"`
+}
+
+// handleCandidates serves POST /ai/candidates: the closest knowledge base
+// and learned entries to a question with their raw cosine scores, for
+// review tools that need more than the answer served.
+func handleCandidates(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req candidatesRequest
+		if err := decodeRequest(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		chargeStage(r, StageExplain)
+		switch {
+		case strings.TrimSpace(req.Text) == "":
+			writeError(w, newError(ErrInvalidInput, "text is empty"))
+			return
+		case len(req.Text) > ai.Limits.maxQuestionBytes():
+			writeError(w, newError(ErrTooLarge, "text is %d bytes, the limit is %d", len(req.Text), ai.Limits.maxQuestionBytes()))
+			return
+		case req.K < 0 || req.K > candidatesLimit(r):
+			writeError(w, newError(ErrInvalidInput, "k must be between 1 and %d", candidatesLimit(r)))
+			return
+		case req.K == 0:
+			req.K = defaultCandidatesK
+		}
+		candidates := []CandidateAnswer{}
+		for _, m := range ai.KB.FindTopK(req.Text, ai.embeddings(), req.K) {
+			candidates = append(candidates, CandidateAnswer{
+				ID:       m.Entry.ID,
+				Source:   m.Source,
+				Question: m.Entry.Question,
+				Answer:   m.Entry.Answer,
+				Score:    m.Score,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"candidates": candidates})
+	}
+}
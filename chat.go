@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Defaults of the /ws message rate cap: a connection may send a few
+// messages at once, then defaultChatRate a minute.
+const (
+	defaultChatRate = 30
+	chatBurst       = 5
+)
+
+// chatMessage is a question sent over /ws.
+type chatMessage struct {
+	Text  string `json:"text"`
+	Scope string `json:"scope"`
+	// Style is "concise", "normal" (the default) or "detailed".
+	Style string `json:"style"`
+}
+
+// chatReply answers a chatMessage.
+type chatReply struct {
+	Answer     string  `json:"answer"`
+	Confidence float64 `json:"confidence"`
+	Source     string  `json:"source"`
+}
+
+// chatTyping announces, with pacing on, that a reply is coming.
+type chatTyping struct {
+	Typing bool `json:"typing"`
+}
+
+// chatError refuses a chatMessage in the envelope HTTP errors use.
+type chatError struct {
+	Error      string `json:"error"`
+	Code       string `json:"code"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// newChatError returns the envelope of err, hiding the message of errors
+// without a kind as writeError does.
+func newChatError(err error) chatError {
+	status, code := errorResponse(err)
+	if status == http.StatusInternalServerError {
+		return chatError{Error: "internal error", Code: code}
+	}
+	return chatError{Error: err.Error(), Code: code}
+}
+
+// handleChat upgrades GET /ws to a WebSocket over which questions are sent
+// as {"text": ...} messages and answered in order. Each connection is a
+// session of its own, so earlier answers on it are its conversation
+// context; the session ends, folding its pattern weight, when the
+// connection closes. A connection may send ratePerMinute messages a minute
+// after a burst of chatBurst; messages beyond that are refused, not
+// queued. Browsers may connect from their own origin or, when cors is
+// set, one it allows, since WebSockets aren't subject to CORS. Messages
+// are limited to maxMessage bytes. With pacing on, each answer is preceded
+// by {"typing": true} and held back for its pacing delay.
+func handleChat(ai *AIEngine, cors *CORS, ratePerMinute float64, maxMessage int64, pacing Pacing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(r, origin) && (cors == nil || !cors.allowed(origin)) {
+			writeError(w, newError(ErrForbidden, "origin %s may not connect", origin))
+			return
+		}
+		conn, err := upgradeWebSocket(w, r, maxMessage)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		sessionID := "ws-" + newRequestID() + newRequestID()
+		done := make(chan struct{})
+		go conn.keepalive(done)
+		defer ai.endSession(sessionID)
+		defer close(done)
+
+		// Messages are read ahead of answering, so a client going away is
+		// noticed, and ctx cancelled, while an answer is being paced.
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		type read struct {
+			data []byte
+			err  error
+		}
+		reads := make(chan read)
+		go func() {
+			for {
+				data, err := conn.readMessage()
+				if err != nil {
+					cancel()
+				}
+				select {
+				case reads <- read{data, err}:
+				case <-done:
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+
+		limit := bucket{rateLimit: rateLimit{capacity: chatBurst, refill: ratePerMinute / 60}, tokens: chatBurst, updated: time.Now()}
+		for {
+			next := <-reads
+			data, err := next.data, next.err
+			if err != nil {
+				conn.close(err)
+				return
+			}
+			var reply interface{}
+			var message chatMessage
+			now := time.Now()
+			limit.tokens, limit.updated = limit.level(now), now
+			switch {
+			case json.Unmarshal(data, &message) != nil:
+				reply = chatError{Error: `messages must be JSON objects such as {"text": "..."}`, Code: "invalid_input"}
+			case !validStyle(message.Style):
+				reply = chatError{Error: `style must be "concise", "normal" or "detailed"`, Code: "invalid_input"}
+			case ratePerMinute > 0 && limit.tokens < 1:
+				wait := (1 - limit.tokens) / limit.refill
+				reply = chatError{Error: "rate limit exceeded", Code: "rate_limited", RetryAfter: int(math.Ceil(wait))}
+			default:
+				limit.tokens--
+				result, err := ai.AskContext(ctx, message.Text, AskOptions{
+					SessionID: sessionID,
+					Scope:     message.Scope,
+					Style:     message.Style,
+					Tenant:    requestTenant(r),
+					// The handshake carries the browser's languages.
+					LanguageHints: acceptLanguages(r),
+				})
+				// As on /ai, a fallback is still an answer.
+				if errors.Is(err, ErrInvalidInput) || errors.Is(err, ErrTooLarge) {
+					reply = newChatError(err)
+					break
+				}
+				reply = chatReply{Answer: result.Text, Confidence: result.Confidence, Source: result.Source}
+				if pacing.delay(result.Text) > 0 {
+					if err := conn.writeJSON(chatTyping{Typing: true}); err != nil {
+						conn.close(err)
+						return
+					}
+					if !pacing.wait(ctx, result.Text) {
+						conn.close(ctx.Err())
+						return
+					}
+				}
+			}
+			if err := conn.writeJSON(reply); err != nil {
+				conn.close(err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// cliSession is the conversation the questions typed into -cli belong to,
+// so follow-ups are answered as they are for a browser.
+const cliSession = "cli"
+
+const cliHelp = `Type a question to see its answer, source and score, or:
+  :learn <question> | <answer>   teach an answer, replacing any learned before
+  :reload                        read the prompt file again, forgetting what was learned
+  :quit                          leave`
+
+// runCLI answers the questions read from in, one a line, on out, with the
+// engine the server would build from config and flags, without serving
+// HTTP. It is for trying out prompt file changes, typed or piped in:
+//
+//	echo "what is a channel" | askgo -cli
+//
+// Lines starting with ':' are commands; see cliHelp. Answers learned are
+// kept in memory only. Problems go to errOut. runCLI returns the exit
+// status: 1 when the embeddings or prompts fail to load, else 0.
+func runCLI(config Config, flags EngineConfig, in io.Reader, out, errOut io.Writer) int {
+	embeddings, err := loadEmbeddings(config.Embeddings, config.EmbeddingsFormat, config.embeddingsLimit())
+	if err == nil {
+		err = config.check("embeddings")
+	}
+	if err == nil && embeddings.Len() == 0 {
+		err = fmt.Errorf("embeddings: %s holds no vectors", config.Embeddings)
+	}
+	if err != nil {
+		fmt.Fprintln(errOut, "Error loading embeddings:", err)
+		return 1
+	}
+	load := func() (*AIEngine, error) {
+		if err := config.check("prompts"); err != nil {
+			return nil, err
+		}
+		ai, err := NewAIEngine(config, embeddings)
+		if err != nil {
+			return nil, err
+		}
+		ai.Configure(flags)
+		return ai, nil
+	}
+	ai, err := load()
+	if err != nil {
+		fmt.Fprintln(errOut, "Error loading prompts:", err)
+		return 1
+	}
+
+	// The prompt is only shown to someone typing.
+	prompt := ""
+	if stat, err := os.Stdin.Stat(); err == nil && in == os.Stdin && stat.Mode()&os.ModeCharDevice != 0 {
+		prompt = "> "
+		fmt.Fprintln(out, "Type :help for commands.")
+	}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for fmt.Fprint(out, prompt); scanner.Scan(); fmt.Fprint(out, prompt) {
+		line := strings.TrimSpace(scanner.Text())
+		command, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			command, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		switch {
+		case line == "":
+		case command == ":quit" || command == ":q" || command == ":exit":
+			return 0
+		case command == ":help":
+			fmt.Fprintln(out, cliHelp)
+		case command == ":reload":
+			next, err := load()
+			if err != nil {
+				fmt.Fprintln(errOut, "Error reloading prompts, keeping the previous ones:", err)
+				break
+			}
+			ai = next
+			fmt.Fprintf(out, "Reloaded %s: %d entries\n", config.Prompts, len(ai.KB.view().entries))
+		case command == ":learn":
+			parts := strings.SplitN(arg, "|", 2)
+			if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+				fmt.Fprintln(errOut, "usage: :learn <question> | <answer>")
+				break
+			}
+			question := strings.TrimSpace(parts[0])
+			if err := ai.Limits.checkLearnQuestion(question); err != nil {
+				fmt.Fprintln(errOut, "Not learned:", err)
+				break
+			}
+			if _, err := ai.KB.learnChecked(LearnedEntry{Question: question, Answer: strings.TrimSpace(parts[1])}, true); err != nil {
+				fmt.Fprintln(errOut, "Not learned:", err)
+				break
+			}
+			fmt.Fprintln(out, "Learned.")
+		case strings.HasPrefix(command, ":"):
+			fmt.Fprintf(errOut, "Unknown command %s; type :help for the commands\n", command)
+		default:
+			answer, err := ai.Ask(line, AskOptions{SessionID: cliSession})
+			fmt.Fprintln(out, answer.Text)
+			fmt.Fprintf(out, "  [source %s, score %.3f, confidence %.3f", answer.Source, answer.Score, answer.Confidence)
+			if answer.EntryID != "" {
+				fmt.Fprintf(out, ", entry %s", answer.EntryID)
+			}
+			if err != nil {
+				fmt.Fprintf(out, ", %v", err)
+			}
+			fmt.Fprintln(out, "]")
+		}
+	}
+	if prompt != "" {
+		fmt.Fprintln(out)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(errOut, "Error reading input:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The fixtures in testdata/compat are documents as each format version
+// wrote them: format 1 predates versioning, format 2 is the current one,
+// and later formats stand in for what a newer binary could write during a
+// rolling deploy.
+
+func TestImportFormatCompatibility(t *testing.T) {
+	tests := []struct {
+		file string
+		// refused is set for formats this binary is too old to import.
+		refused bool
+	}{
+		{"export-format1.json", false},
+		{"export-format2.json", false},
+		// Newer, but declares format 2 readers compatible.
+		{"export-format3.json", false},
+		{"export-format4.json", true},
+		// A format without min_compatible needs itself.
+		{"export-format5.json", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", "compat", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ai := newTestEngine(t)
+			before := len(ai.KB.view().entries)
+			rec := httptest.NewRecorder()
+			handleKBImport(ai)(rec, httptest.NewRequest("POST", "/kb/import", strings.NewReader(string(data))))
+			if tt.refused {
+				if rec.Code != 400 || !strings.Contains(rec.Body.String(), "this server reads format 2") {
+					t.Fatalf("import answered %d: %s", rec.Code, rec.Body)
+				}
+				if n := ai.ImportsRefused(); n != 1 {
+					t.Errorf("%d imports counted as refused, want 1", n)
+				}
+				if after := len(ai.KB.view().entries); after != before {
+					t.Errorf("a refused import changed the knowledge base")
+				}
+				return
+			}
+			if rec.Code != 200 {
+				t.Fatalf("import answered %d: %s", rec.Code, rec.Body)
+			}
+			if _, ok := ai.KB.entryByID("maps"); !ok {
+				t.Error("entry maps not imported")
+			}
+			if _, ok := ai.KB.view().lookupLearned(normalizeQuestion("What is a slice?")); !ok {
+				t.Error("learned entry not imported")
+			}
+			if n := ai.ImportsRefused(); n != 0 {
+				t.Errorf("%d imports counted as refused", n)
+			}
+		})
+	}
+}
+
+func TestSnapshotFormatCompatibility(t *testing.T) {
+	tests := []struct {
+		file    string
+		refused bool
+	}{
+		{"snapshot-format1.json", false},
+		{"snapshot-format2.json", false},
+		{"snapshot-format3.json", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			snap, err := loadSnapshotFile(filepath.Join("testdata", "compat", tt.file))
+			if tt.refused {
+				if !errors.Is(err, errSnapshotFormat) {
+					t.Fatalf("err = %v, want errSnapshotFormat", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ai := newTestEngine(t)
+			if err := ai.restoreSnapshot(snap, false); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := ai.KB.view().lookupLearned(normalizeQuestion("What is a slice?")); !ok {
+				t.Error("learned entry not restored")
+			}
+			if ai.Patterns["slice"] != 0.5 {
+				t.Errorf("patterns %v not restored", ai.Patterns)
+			}
+		})
+	}
+}
+
+// TestLoadLatestSkipsNewerFormats falls back past a snapshot a newer
+// binary wrote to the newest one this binary reads, and counts the skip.
+func TestLoadLatestSkipsNewerFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "askgo-snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, fixture := range map[string]string{
+		"snapshot-20261001T030405.000Z.json": "snapshot-format2.json",
+		"snapshot-20270101T030405.000Z.json": "snapshot-format3.json",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "compat", fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ai := newTestEngine(t)
+	snapshots := NewSnapshotter(ai, dir, 0, 0, 0)
+	path, err := snapshots.LoadLatest(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "snapshot-20261001T030405.000Z.json" {
+		t.Errorf("restored %s, want the format 2 snapshot", path)
+	}
+	if n := snapshots.Refused(); n != 1 {
+		t.Errorf("%d snapshots refused, want 1", n)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Config is where the server listens and where it finds its files. Each
+// setting comes from its flag, else its environment variable, else the
+// JSON file named by -config, else the default.
+type Config struct {
+	Addr       string `json:"addr"`
+	Prompts    string `json:"prompts"`
+	Embeddings string `json:"embeddings"`
+	// EmbeddingsFormat is auto, json, text or binary; see
+	// readEmbeddings.
+	EmbeddingsFormat string `json:"embeddings-format"`
+	// EmbeddingsLimit is the number of words read from a text or binary
+	// embeddings file; 0 reads them all.
+	EmbeddingsLimit string `json:"embeddings-limit"`
+	Templates       string `json:"templates"`
+	Static          string `json:"static"`
+
+	// origin records where each setting came from, for error messages.
+	origin map[string]string
+}
+
+func defaultConfig() Config {
+	return Config{
+		Addr:             "0.0.0.0:8080",
+		Prompts:          "prompt.json",
+		Embeddings:       "embeddings.json",
+		EmbeddingsFormat: formatAuto,
+		EmbeddingsLimit:  "0",
+		Templates:        "templates",
+		Static:           "static",
+	}
+}
+
+// configFileEnv names the config file when -config is not given.
+const configFileEnv = "ASKGO_CONFIG"
+
+// configSetting is one setting: its flag name, environment variable and
+// field of Config.
+type configSetting struct {
+	name  string
+	env   string
+	usage string
+	field func(*Config) *string
+}
+
+var configSettings = []configSetting{
+	{"addr", "ASKGO_ADDR", "address the server listens on", func(c *Config) *string { return &c.Addr }},
+	{"prompts", "ASKGO_PROMPTS", "prompt file; its includes are relative to it", func(c *Config) *string { return &c.Prompts }},
+	{"embeddings", "ASKGO_EMBEDDINGS", "embeddings file; per-language files such as embeddings.zh.json are read from beside it", func(c *Config) *string { return &c.Embeddings }},
+	{"embeddings-format", "ASKGO_EMBEDDINGS_FORMAT", "format of the embeddings file, optionally gzipped: json, text (GloVe or word2vec), binary (word2vec) or auto to go by its extension", func(c *Config) *string { return &c.EmbeddingsFormat }},
+	{"embeddings-limit", "ASKGO_EMBEDDINGS_LIMIT", "load only the first N words of an embeddings file other than JSON, the most frequent, to bound memory (0 = all)", func(c *Config) *string { return &c.EmbeddingsLimit }},
+	{"templates", "ASKGO_TEMPLATES", "directory of HTML templates", func(c *Config) *string { return &c.Templates }},
+	{"static", "ASKGO_STATIC", "directory served under /static/", func(c *Config) *string { return &c.Static }},
+}
+
+// configFlags are the flags of the settings in Config, registered on a
+// flag set by registerConfigFlags.
+type configFlags struct {
+	file   *string
+	values map[string]*string
+}
+
+func registerConfigFlags(fs *flag.FlagSet) configFlags {
+	defaults := defaultConfig()
+	flags := configFlags{
+		file:   fs.String("config", "", "JSON file of the settings below, keyed by flag name (falls back to $"+configFileEnv+")"),
+		values: make(map[string]*string, len(configSettings)),
+	}
+	for _, s := range configSettings {
+		flags.values[s.name] = fs.String(s.name, *s.field(&defaults), fmt.Sprintf("%s (falls back to $%s)", s.usage, s.env))
+	}
+	return flags
+}
+
+// load resolves the settings once fs has been parsed.
+func (f configFlags) load(fs *flag.FlagSet) (Config, error) {
+	given := make(map[string]bool)
+	fs.Visit(func(fl *flag.Flag) { given[fl.Name] = true })
+
+	config := defaultConfig()
+	config.origin = make(map[string]string, len(configSettings))
+	var file Config
+	path := *f.file
+	if !given["config"] && os.Getenv(configFileEnv) != "" {
+		path = os.Getenv(configFileEnv)
+	}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return config, fmt.Errorf("reading config file: %v", err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&file); err != nil {
+			return config, fmt.Errorf("parsing config file %s: %v", path, err)
+		}
+	}
+	for _, s := range configSettings {
+		value := s.field(&config)
+		if v := *s.field(&file); v != "" {
+			*value, config.origin[s.name] = v, "config file "+path
+		}
+		if v := os.Getenv(s.env); v != "" {
+			*value, config.origin[s.name] = v, "$"+s.env
+		}
+		if given[s.name] {
+			*value, config.origin[s.name] = *f.values[s.name], "-"+s.name
+		}
+	}
+	if err := checkEmbeddingsFormat(config.EmbeddingsFormat); err != nil {
+		return config, fmt.Errorf("embeddings-format: %v", err)
+	}
+	if n, err := strconv.Atoi(config.EmbeddingsLimit); err != nil || n < 0 {
+		return config, fmt.Errorf("embeddings-limit: %q is not a word count", config.EmbeddingsLimit)
+	}
+	return config, nil
+}
+
+// embeddingsLimit is EmbeddingsLimit as a number, 0 if unset.
+func (c Config) embeddingsLimit() int {
+	n, _ := strconv.Atoi(c.EmbeddingsLimit)
+	return n
+}
+
+// describe names the path of a setting and where it came from.
+func (c Config) describe(name, path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if origin, ok := c.origin[name]; ok {
+		return fmt.Sprintf("%s (set by %s)", path, origin)
+	}
+	for _, s := range configSettings {
+		if s.name == name {
+			return fmt.Sprintf("%s (the default; set -%s or $%s to change it)", path, name, s.env)
+		}
+	}
+	return path
+}
+
+// check reports the first path of the named settings, or of all of them,
+// that doesn't exist. A missing embeddings file is only an error if it
+// was asked for; by default the server runs without embeddings.
+func (c Config) check(names ...string) error {
+	paths := []struct {
+		name, path string
+		dir        bool
+	}{
+		{"prompts", c.Prompts, false},
+		{"embeddings", c.Embeddings, false},
+		{"templates", c.Templates, true},
+		{"static", c.Static, true},
+	}
+	for _, p := range paths {
+		if len(names) > 0 && !containsString(names, p.name) {
+			continue
+		}
+		info, err := os.Stat(p.path)
+		switch {
+		case os.IsNotExist(err):
+			if _, ok := c.origin[p.name]; p.name == "embeddings" && !ok {
+				continue
+			}
+			return fmt.Errorf("%s: %s does not exist", p.name, c.describe(p.name, p.path))
+		case err != nil:
+			return fmt.Errorf("%s: %v", p.name, err)
+		case p.dir && !info.IsDir():
+			return fmt.Errorf("%s: %s is not a directory", p.name, c.describe(p.name, p.path))
+		case !p.dir && info.IsDir():
+			return fmt.Errorf("%s: %s is a directory", p.name, c.describe(p.name, p.path))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsRequestHeaders are the request headers cross-origin callers may send.
+const corsRequestHeaders = "Content-Type, X-API-Key, X-Request-ID"
+
+// corsExposedHeaders are the response headers cross-origin callers may
+// read.
+const corsExposedHeaders = "X-Request-ID, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, X-RateLimit-Warning, Retry-After"
+
+// CORS lets pages on other origins call routes marked CORS. Requests from
+// origins not on the list are refused rather than answered without
+// headers, so a misconfigured frontend fails loudly. The zero CORS allows
+// no other origin.
+type CORS struct {
+	origins map[string]bool
+	any     bool
+	maxAge  time.Duration
+}
+
+// NewCORS parses a comma-separated list of origins such as
+// https://docs.example.com; "*" allows every origin. An empty list allows
+// none but the server's own.
+func NewCORS(list string, maxAge time.Duration) (*CORS, error) {
+	c := &CORS{origins: make(map[string]bool), maxAge: maxAge}
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.TrimSpace(origin)
+		switch {
+		case origin == "":
+			continue
+		case origin == "*":
+			c.any = true
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return nil, fmt.Errorf("%q is not an origin; use scheme://host[:port]", origin)
+		}
+		c.origins[u.Scheme+"://"+u.Host] = true
+	}
+	return c, nil
+}
+
+func (c *CORS) allowed(origin string) bool {
+	return c.any || c.origins[origin]
+}
+
+// sameOrigin reports whether origin is the server itself, which browsers
+// also name in the Origin header of POST requests. Scheme and host must
+// both match: a page served over plain HTTP is another origin.
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Scheme == requestScheme(r) && strings.EqualFold(u.Host, r.Host)
+}
+
+// requestScheme is the scheme the client used: https over TLS, or behind
+// a trusted proxy the X-Forwarded-Proto it set, and http otherwise.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if isTrustedProxy(host) {
+		if proto := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); proto == "https" || proto == "http" {
+			return proto
+		}
+	}
+	return "http"
+}
+
+// check sets the CORS headers for a request from another origin, or
+// reports an error if the origin is not allowed. Same-origin requests and
+// requests without an Origin header pass untouched.
+func (c *CORS) check(w http.ResponseWriter, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" || sameOrigin(r, origin) {
+		return nil
+	}
+	w.Header().Add("Vary", "Origin")
+	if !c.allowed(origin) {
+		return newError(ErrForbidden, "origin %s is not allowed", origin)
+	}
+	if c.any {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+	return nil
+}
+
+// wrap applies the origin check to a route's handler.
+func (c *CORS) wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := c.check(w, r); err != nil {
+			writeError(w, err)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// preflight answers an OPTIONS preflight for a route allowing methods.
+func (c *CORS) preflight(w http.ResponseWriter, r *http.Request, methods string) {
+	if err := c.check(w, r); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	w.Header().Set("Access-Control-Allow-Headers", corsRequestHeaders)
+	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.maxAge.Seconds())))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// corsMethods lists the methods of a route group open to cross-origin
+// callers, for Access-Control-Allow-Methods.
+func corsMethods(methods map[string]bool) string {
+	list := make([]string, 0, len(methods))
+	for method := range methods {
+		list = append(list, method)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewCORS(t *testing.T) {
+	tests := []struct {
+		list string
+		// wantNone wants no other origin allowed.
+		wantNone bool
+		wantErr  bool
+	}{
+		{list: "", wantNone: true},
+		{list: " , ", wantNone: true},
+		{list: "*"},
+		{list: "https://docs.example.com, http://localhost:3000"},
+		{list: "https://docs.example.com/"},
+		{list: "docs.example.com", wantErr: true},
+		{list: "ftp://docs.example.com", wantErr: true},
+		{list: "https://docs.example.com/widget", wantErr: true},
+		{list: "https://docs.example.com?x=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		c, err := NewCORS(tt.list, time.Hour)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewCORS(%q) error = %v, want error %v", tt.list, err, tt.wantErr)
+			continue
+		}
+		if err == nil && c.allowed("https://docs.example.com") == tt.wantNone {
+			t.Errorf("NewCORS(%q) allows https://docs.example.com: %v, want %v", tt.list, !tt.wantNone, !tt.wantNone)
+		}
+	}
+}
+
+// corsRouter routes POST /ai, open to cross-origin callers, and POST
+// /learn, which is not, through c.
+func corsRouter(t *testing.T, c *CORS) *Router {
+	t.Helper()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	router, err := NewRouter([]Route{
+		{Pattern: "/ai", Method: http.MethodPost, Handler: ok, CORS: true},
+		{Pattern: "/learn", Method: http.MethodPost, Handler: ok},
+	}, NewAdminAuth(nil, true), nil, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+func TestCORS(t *testing.T) {
+	allowList, err := NewCORS("https://docs.example.com", 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anyOrigin, err := NewCORS("*", 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		cors       *CORS
+		method     string
+		path       string
+		origin     string
+		preflight  string
+		wantStatus int
+		// wantOrigin is Access-Control-Allow-Origin; "" wants none.
+		wantOrigin string
+		wantAllow  string
+	}{
+		{name: "preflight", cors: allowList, method: http.MethodOptions, path: "/ai", origin: "https://docs.example.com", preflight: http.MethodPost,
+			wantStatus: http.StatusNoContent, wantOrigin: "https://docs.example.com"},
+		{name: "preflight from another origin", cors: allowList, method: http.MethodOptions, path: "/ai", origin: "https://evil.example", preflight: http.MethodPost,
+			wantStatus: http.StatusForbidden},
+		{name: "preflight of a same-origin route", cors: allowList, method: http.MethodOptions, path: "/learn", origin: "https://docs.example.com", preflight: http.MethodPost,
+			wantStatus: http.StatusNoContent, wantAllow: "OPTIONS, POST"},
+		{name: "request", cors: allowList, method: http.MethodPost, path: "/ai", origin: "https://docs.example.com",
+			wantStatus: http.StatusOK, wantOrigin: "https://docs.example.com"},
+		{name: "request from another origin", cors: allowList, method: http.MethodPost, path: "/ai", origin: "https://evil.example",
+			wantStatus: http.StatusForbidden},
+		{name: "request without origin", cors: allowList, method: http.MethodPost, path: "/ai",
+			wantStatus: http.StatusOK},
+		{name: "same-origin request", cors: allowList, method: http.MethodPost, path: "/ai", origin: "http://askgo.test",
+			wantStatus: http.StatusOK},
+		{name: "any origin", cors: anyOrigin, method: http.MethodPost, path: "/ai", origin: "https://evil.example",
+			wantStatus: http.StatusOK, wantOrigin: "*"},
+		{name: "same host over another scheme", cors: allowList, method: http.MethodPost, path: "/ai", origin: "https://askgo.test",
+			wantStatus: http.StatusForbidden},
+		{name: "preflight without allowlist", cors: nil, method: http.MethodOptions, path: "/ai", origin: "https://docs.example.com", preflight: http.MethodPost,
+			wantStatus: http.StatusForbidden},
+		{name: "request without allowlist", cors: nil, method: http.MethodPost, path: "/ai", origin: "https://docs.example.com",
+			wantStatus: http.StatusForbidden},
+		{name: "same-origin request without allowlist", cors: nil, method: http.MethodPost, path: "/ai", origin: "http://askgo.test",
+			wantStatus: http.StatusOK},
+		{name: "wrong method", cors: allowList, method: http.MethodGet, path: "/ai", origin: "https://docs.example.com",
+			wantStatus: http.StatusMethodNotAllowed, wantAllow: "OPTIONS, POST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://askgo.test"+tt.path, nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight != "" {
+				r.Header.Set("Access-Control-Request-Method", tt.preflight)
+			}
+			w := httptest.NewRecorder()
+			corsRouter(t, tt.cors).ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+			if tt.preflight != "" && tt.wantOrigin != "" {
+				if got := w.Header().Get("Access-Control-Allow-Headers"); got != corsRequestHeaders {
+					t.Errorf("Access-Control-Allow-Headers = %q, want %q", got, corsRequestHeaders)
+				}
+				if got := w.Header().Get("Access-Control-Max-Age"); got != "600" {
+					t.Errorf("Access-Control-Max-Age = %q, want 600", got)
+				}
+			}
+		})
+	}
+}
+
+// TestSameOriginScheme believes the scheme a proxy reports only from
+// trusted proxies.
+func TestSameOriginScheme(t *testing.T) {
+	defer func(saved []*net.IPNet) { trustedProxies = saved }(trustedProxies)
+	var err error
+	if trustedProxies, err = parseTrustedProxies("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote string
+		proto  string
+		origin string
+		want   bool
+	}{
+		{"192.0.2.1:1234", "", "http://askgo.test", true},
+		{"192.0.2.1:1234", "", "https://askgo.test", false},
+		{"192.0.2.1:1234", "https", "https://askgo.test", false},
+		{"10.0.0.1:1234", "https", "https://askgo.test", true},
+		{"10.0.0.1:1234", "https", "http://askgo.test", false},
+		{"10.0.0.1:1234", "", "http://askgo.test", true},
+		{"192.0.2.1:1234", "", "http://askgo.test:8080", false},
+		{"192.0.2.1:1234", "", "http://evil.example", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "http://askgo.test/ai", nil)
+		r.RemoteAddr = tt.remote
+		if tt.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		if got := sameOrigin(r, tt.origin); got != tt.want {
+			t.Errorf("sameOrigin(%s from %s with X-Forwarded-Proto %q) = %v, want %v", tt.origin, tt.remote, tt.proto, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// defaultDuplicateThreshold is the similarity of two questions above
+// which adding one reports the other as a near duplicate.
+const defaultDuplicateThreshold = 0.95
+
+// NearDuplicate is an entry or learned entry whose question is close to
+// that of an entry just added or learned.
+type NearDuplicate struct {
+	ID          string  `json:"id"`
+	DuplicateOf string  `json:"duplicate_of"`
+	Source      string  `json:"source"`
+	Question    string  `json:"question"`
+	Score       float64 `json:"score"`
+}
+
+// DuplicateMerge is a duplicate entry folded into an earlier one. Score
+// is 1 for questions that normalize alike.
+type DuplicateMerge struct {
+	ID       string  `json:"id"`
+	Into     string  `json:"into"`
+	Question string  `json:"question"`
+	Score    float64 `json:"score"`
+}
+
+// duplicateOf returns the position of the entry, other than a quarantined
+// one, whose question normalizes as question does, or -1.
+func duplicateOf(entries []KnowledgeEntry, question string) int {
+	key := normalizeQuestion(question)
+	for i, entry := range entries {
+		if !entry.Quarantined && normalizeQuestion(entry.Question) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// questionIndex finds the first entry, other than a quarantined one, whose
+// question normalizes as a question does, as duplicateOf would, without
+// normalizing every question on each lookup: bulk writes such as imports
+// look up every entry they bring while holding the write lock.
+type questionIndex map[string]int
+
+func newQuestionIndex(entries []KnowledgeEntry) questionIndex {
+	x := make(questionIndex, len(entries))
+	for i, entry := range entries {
+		x.set(i, entry)
+	}
+	return x
+}
+
+// set records that entry is at position i, after it was added or
+// replaced.
+func (x questionIndex) set(i int, entry KnowledgeEntry) {
+	if entry.Quarantined {
+		return
+	}
+	key := normalizeQuestion(entry.Question)
+	if j, ok := x[key]; !ok || i < j {
+		x[key] = i
+	}
+}
+
+// find returns the position of the entry of entries asking question, or
+// -1. A position whose entry was since replaced by one asking something
+// else falls back to a scan.
+func (x questionIndex) find(entries []KnowledgeEntry, question string) int {
+	key := normalizeQuestion(question)
+	i, ok := x[key]
+	if !ok {
+		return -1
+	}
+	if entry := entries[i]; !entry.Quarantined && normalizeQuestion(entry.Question) == key {
+		return i
+	}
+	delete(x, key)
+	if i = duplicateOf(entries, question); i >= 0 {
+		x[key] = i
+	}
+	return i
+}
+
+// nearDuplicates lists the entries and learned entries other than id
+// whose questions score at least threshold against vec, the vector of
+// id's question, most similar first. Quarantined ones are left out, and a
+// threshold of 0 finds none.
+func (s *kbState) nearDuplicates(id string, vec []float32, threshold float64) []NearDuplicate {
+	if threshold <= 0 || len(vec) == 0 {
+		return nil
+	}
+	var found []NearDuplicate
+	for _, entry := range s.entries {
+		if entry.ID == id || entry.Quarantined {
+			continue
+		}
+		if score := cosineSimilarity(vec, entry.Vector); score >= threshold {
+			found = append(found, NearDuplicate{ID: id, DuplicateOf: entry.ID, Source: SourceKnowledgeBase, Question: entry.Question, Score: score})
+		}
+	}
+	for key, entry := range s.learned {
+		if entry.ID == id || entry.Quarantined {
+			continue
+		}
+		if score := cosineSimilarity(vec, s.learnedVectors[key]); score >= threshold {
+			found = append(found, NearDuplicate{ID: id, DuplicateOf: entry.ID, Source: SourceLearned, Question: entry.Question, Score: score})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Score != found[j].Score {
+			return found[i].Score > found[j].Score
+		}
+		return found[i].DuplicateOf < found[j].DuplicateOf
+	})
+	return found
+}
+
+// nearDuplicatesOf lists the near duplicates of the entries ids, just
+// added or changed, reporting each pair among them once.
+func (s *kbState) nearDuplicatesOf(ids []string, threshold float64) []NearDuplicate {
+	if threshold <= 0 || len(ids) == 0 {
+		return nil
+	}
+	checked := make(map[string]bool, len(ids))
+	var found []NearDuplicate
+	for _, id := range ids {
+		if checked[id] {
+			continue
+		}
+		checked[id] = true
+		for _, entry := range s.entries {
+			if entry.ID != id {
+				continue
+			}
+			for _, d := range s.nearDuplicates(id, entry.Vector, threshold) {
+				if !checked[d.DuplicateOf] {
+					found = append(found, d)
+				}
+			}
+			break
+		}
+	}
+	return found
+}
+
+// dedupeEntries folds every entry whose question normalizes like an
+// earlier entry's, or with threshold above 0 scores at least threshold
+// against one, into that earlier entry: the earlier entry takes its
+// answer, being the later one loaded, and its tags, and the duplicate is
+// quarantined with MergedInto set, since stores only add and replace
+// entries. Entries already quarantined or in supersedes links are left
+// alone, as the links already say how they relate.
+func dedupeEntries(entries []KnowledgeEntry, threshold float64) ([]KnowledgeEntry, []DuplicateMerge) {
+	linked := make(map[string]bool)
+	for _, entry := range entries {
+		for _, id := range entry.Supersedes {
+			linked[entry.ID], linked[id] = true, true
+		}
+	}
+	var merged []DuplicateMerge
+	var kept []int
+	byKey := make(map[string]int)
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Quarantined || linked[entry.ID] {
+			continue
+		}
+		key := normalizeQuestion(entry.Question)
+		into, score := -1, 0.0
+		if j, ok := byKey[key]; ok {
+			into, score = j, 1
+		} else if threshold > 0 {
+			for _, j := range kept {
+				if s := cosineSimilarity(entry.Vector, entries[j].Vector); s >= threshold && s > score {
+					into, score = j, s
+				}
+			}
+		}
+		if into < 0 {
+			byKey[key] = i
+			kept = append(kept, i)
+			continue
+		}
+		survivor := &entries[into]
+		survivor.Answer = entry.Answer
+		for _, tag := range entry.Tags {
+			if !allTags(survivor.Tags, []string{tag}) {
+				// The tags may be shared with the published state.
+				survivor.Tags = append(survivor.Tags[:len(survivor.Tags):len(survivor.Tags)], tag)
+			}
+		}
+		entry.Quarantined, entry.MergedInto = true, survivor.ID
+		merged = append(merged, DuplicateMerge{ID: entry.ID, Into: survivor.ID, Question: entry.Question, Score: score})
+	}
+	return entries, merged
+}
+
+// DedupeRequest is the body of POST /kb/dedupe.
+type DedupeRequest struct {
+	// Threshold, when above 0, also merges entries whose questions score
+	// at least this similar; otherwise only questions that normalize
+	// alike are merged.
+	Threshold float64 `json:"threshold" schema:"minimum=0,maximum=1"`
+	DryRun    bool    `json:"dry_run"`
+}
+
+// DedupeReport is the response of /kb/dedupe.
+type DedupeReport struct {
+	DryRun bool             `json:"dry_run"`
+	Merged []DuplicateMerge `json:"merged"`
+}
+
+// Dedupe merges the duplicate entries of the knowledge base; see
+// dedupeEntries. A dry run reports the merges without making them.
+func (ai *AIEngine) Dedupe(threshold float64, dryRun bool) (DedupeReport, error) {
+	report := DedupeReport{DryRun: dryRun, Merged: []DuplicateMerge{}}
+	plan := func(entries []KnowledgeEntry) []KnowledgeEntry {
+		entries, merged := dedupeEntries(entries, threshold)
+		if merged != nil {
+			report.Merged = merged
+		}
+		return entries
+	}
+	if dryRun {
+		plan(append([]KnowledgeEntry{}, ai.KB.view().entries...))
+		return report, nil
+	}
+	if err := ai.KB.updateEntries("Dedupe", plan); err != nil {
+		return report, err
+	}
+	for _, m := range report.Merged {
+		log.Printf("Dedupe: merged %s into %s", m.ID, m.Into)
+	}
+	return report, nil
+}
+
+// handleDedupe serves POST /kb/dedupe.
+func handleDedupe(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req DedupeRequest
+		if err := decodeRequest(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		if req.Threshold < 0 || req.Threshold > 1 {
+			writeError(w, newError(ErrInvalidInput, "threshold must be between 0 and 1"))
+			return
+		}
+		report, err := ai.Dedupe(req.Threshold, req.DryRun)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
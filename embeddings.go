@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Embeddings file formats. Text is the "word 0.1 0.2 ..." format GloVe
+// and word2vec ship in; word2vec's "count dimension" header line is
+// optional. Binary is word2vec's binary format, as in GoogleNews-vectors.
+// Packed is the format pack-embeddings writes, which is used in place.
+// Any format may be gzip-compressed, though a compressed packed file is
+// read into memory.
+const (
+	formatAuto   = "auto"
+	formatJSON   = "json"
+	formatText   = "text"
+	formatBinary = "binary"
+	formatPacked = "packed"
+)
+
+const (
+	// maxEmbeddingLine bounds a line of a text embeddings file: a
+	// 1000-word dimension at 15 bytes a number, with room to spare.
+	maxEmbeddingLine = 1 << 20
+	// maxBinaryWord bounds a word of a binary file, so a file in another
+	// format fails quickly instead of being read as one huge word.
+	maxBinaryWord = 1 << 10
+	// maxBinaryDimension bounds the dimension a binary header may claim.
+	maxBinaryDimension = 1 << 14
+)
+
+// checkEmbeddingsFormat reports a format readEmbeddings doesn't know. An
+// empty format is auto.
+func checkEmbeddingsFormat(format string) error {
+	switch format {
+	case "", formatAuto, formatJSON, formatText, formatBinary, formatPacked:
+		return nil
+	}
+	return fmt.Errorf("unknown embeddings format %q; use auto, json, text, binary or packed", format)
+}
+
+// embeddingsFormat resolves auto: files starting with packedMagic are
+// packed, else the extension of path decides, ignoring .gz: .json is JSON,
+// .txt and .vec are text and .bin is binary. Other files are JSON when
+// their first non-space byte is "{", else text.
+func embeddingsFormat(path, format string, r *bufio.Reader) string {
+	if format != "" && format != formatAuto {
+		return format
+	}
+	if magic, _ := r.Peek(len(packedMagic)); string(magic) == packedMagic {
+		return formatPacked
+	}
+	switch filepath.Ext(strings.TrimSuffix(path, ".gz")) {
+	case ".json":
+		return formatJSON
+	case ".txt", ".vec":
+		return formatText
+	case ".bin":
+		return formatBinary
+	}
+	for n := 1; ; n++ {
+		peek, err := r.Peek(n)
+		if len(peek) < n || err != nil {
+			return formatJSON
+		}
+		if c := peek[n-1]; c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			if c == '{' {
+				return formatJSON
+			}
+			return formatText
+		}
+	}
+}
+
+// readEmbeddings reads the embeddings file at path in format, gunzipping
+// it first if it is compressed, and checks that every vector has the same
+// dimension. Files other than JSON are read only up to their first limit
+// words if limit is positive; as they list words most frequent first,
+// that keeps the commonest. Uncompressed packed files are memory-mapped
+// where the platform allows.
+func readEmbeddings(path, format string, limit int) (*EmbeddingStore, error) {
+	if err := checkEmbeddingsFormat(format); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReaderSize(f, 64<<10)
+	compressed := false
+	if magic, _ := r.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		compressed = true
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		defer gz.Close()
+		r = bufio.NewReaderSize(gz, 64<<10)
+	}
+	var embeddings *EmbeddingStore
+	switch embeddingsFormat(path, format, r) {
+	case formatJSON:
+		embeddings, err = readJSONEmbeddings(r)
+	case formatPacked:
+		if !compressed {
+			embeddings, err = mapPackedEmbeddings(f, limit)
+		}
+		if compressed || err == errNoMmap {
+			embeddings, err = readPackedEmbeddings(r, limit)
+		}
+	case formatBinary:
+		embeddings, err = readBinaryEmbeddings(r, limit)
+	default:
+		embeddings, err = readTextEmbeddings(r, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return embeddings, nil
+}
+
+// readTextEmbeddings parses "word 0.1 0.2 ..." lines. The dimension is
+// set by the header line if there is one, else by the first vector. A word
+// may contain spaces, as a few in GloVe's larger files do; it is whatever
+// precedes the last dimension fields. Repeated words keep their first
+// vector.
+func readTextEmbeddings(r io.Reader, limit int) (*EmbeddingStore, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxEmbeddingLine)
+	embeddings := newEmbeddingStore(0)
+	dim := 0
+	var vec []float32
+	for line := 1; (limit <= 0 || embeddings.Len() < limit) && scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if line == 1 && len(fields) == 2 {
+			count, countErr := strconv.Atoi(fields[0])
+			d, dimErr := strconv.Atoi(fields[1])
+			if countErr == nil && dimErr == nil && count >= 0 && d > 0 {
+				dim = d
+				embeddings = newEmbeddingStore(capacity(count, limit))
+				embeddings.data = make([]float32, 0, capacity(count, limit)*dim)
+				continue
+			}
+		}
+		if dim == 0 {
+			dim = len(fields) - 1
+		}
+		if dim == 0 || len(fields) < dim+1 {
+			return nil, fmt.Errorf("line %d: %d values, want %d", line, len(fields)-1, dim)
+		}
+		split := len(fields) - dim
+		if _, err := strconv.ParseFloat(fields[split-1], 64); split > 1 && err == nil {
+			return nil, fmt.Errorf("line %d: %d values, want %d", line, len(fields)-1, dim)
+		}
+		word := strings.Join(fields[:split], " ")
+		if embeddings.Has(word) {
+			continue
+		}
+		vec = vec[:0]
+		for _, field := range fields[split:] {
+			v, err := strconv.ParseFloat(field, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			vec = append(vec, float32(v))
+		}
+		embeddings.add(word, vec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return embeddings, nil
+}
+
+// readBinaryEmbeddings parses word2vec's binary format: a "count
+// dimension" text line, then count records of a word, a space and
+// dimension little-endian float32s. Some writers end each record with a
+// newline and some don't, so newlines before a word are skipped. A file
+// that ends early or holds a word longer than maxBinaryWord is an error
+// naming the record.
+func readBinaryEmbeddings(r *bufio.Reader, limit int) (*EmbeddingStore, error) {
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("reading the header: %v", unexpected(err))
+	}
+	var count, dim int
+	if n, _ := fmt.Sscanf(strings.TrimSpace(header), "%d %d", &count, &dim); n != 2 || count < 0 || dim <= 0 || dim > maxBinaryDimension {
+		return nil, fmt.Errorf("header %q is not a word count and a dimension", strings.TrimSpace(header))
+	}
+	if limit > 0 && limit < count {
+		count = limit
+	}
+	embeddings := newEmbeddingStore(capacity(count, 0))
+	embeddings.data = make([]float32, 0, capacity(count, 0)*dim)
+	raw := make([]byte, 4*dim)
+	vec := make([]float32, dim)
+	var word []byte
+	for i := 1; i <= count; i++ {
+		word = word[:0]
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return nil, fmt.Errorf("record %d of %d: %v", i, count, unexpected(err))
+			}
+			if c == ' ' || (c == '\n' && len(word) > 0) {
+				break
+			}
+			if c == '\n' || c == '\r' {
+				continue
+			}
+			if word = append(word, c); len(word) > maxBinaryWord {
+				return nil, fmt.Errorf("record %d of %d: word longer than %d bytes; is this a binary word2vec file?", i, count, maxBinaryWord)
+			}
+		}
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, fmt.Errorf("record %d of %d (%q): %v", i, count, word, unexpected(err))
+		}
+		if embeddings.Has(string(word)) {
+			continue
+		}
+		for j := range vec {
+			vec[j] = math.Float32frombits(binary.LittleEndian.Uint32(raw[4*j:]))
+		}
+		embeddings.add(string(word), vec)
+	}
+	return embeddings, nil
+}
+
+// unexpected turns io.EOF, which mid-file means truncation, into
+// io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// capacity sizes a map for count words, or limit if that is smaller, and
+// never beyond a million, since headers can lie.
+func capacity(count, limit int) int {
+	if limit > 0 && limit < count {
+		count = limit
+	}
+	return min(count, 1<<20)
+}
+
+// readJSONEmbeddings decodes a {"word": [0.1, 0.2, ...], ...} object a
+// word at a time, so the file is never held whole as float64s.
+func readJSONEmbeddings(r io.Reader) (*EmbeddingStore, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, fmt.Errorf("want an object of word vectors, got %v", tok)
+	}
+	embeddings := newEmbeddingStore(0)
+	var vec []float32
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		word, _ := tok.(string)
+		vec = vec[:0]
+		if err := dec.Decode(&vec); err != nil {
+			return nil, fmt.Errorf("%q: %v", word, err)
+		}
+		if err := embeddings.add(word, vec); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return embeddings, nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"unsafe"
+)
+
+// EmbeddingStore holds word vectors as float32s in one contiguous slice,
+// with an index from word to position: a 300-dimensional, million-word
+// vocabulary takes 1.2 GB, not the several a map of []float64 took. A
+// store read from a packed file is memory-mapped where the platform
+// allows, so the kernel pages vectors in as they are used. A store is
+// read-only once loaded; a nil store is empty.
+type EmbeddingStore struct {
+	dim   int
+	words []string
+	index map[string]int32
+	data  []float32
+	// mapped is set when data points into a memory-mapped file. The
+	// mapping is never released: vectors Lookup returned may still be in
+	// use, and unused pages cost the kernel nothing to drop.
+	mapped bool
+}
+
+func newEmbeddingStore(capacity int) *EmbeddingStore {
+	return &EmbeddingStore{index: make(map[string]int32, capacity)}
+}
+
+// Lookup returns the vector of word, or nil if word is not in s. Callers
+// must not modify it.
+func (s *EmbeddingStore) Lookup(word string) []float32 {
+	if s == nil {
+		return nil
+	}
+	i, ok := s.index[word]
+	if !ok {
+		return nil
+	}
+	start := int(i) * s.dim
+	return s.data[start : start+s.dim : start+s.dim]
+}
+
+// Has reports whether word is in s.
+func (s *EmbeddingStore) Has(word string) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.index[word]
+	return ok
+}
+
+// Len returns the number of words in s.
+func (s *EmbeddingStore) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.words)
+}
+
+// Dimension returns the dimension of the vectors in s, 0 when it is empty.
+func (s *EmbeddingStore) Dimension() int {
+	if s == nil {
+		return 0
+	}
+	return s.dim
+}
+
+// Words returns the words of s in the order they were added. Callers must
+// not modify it.
+func (s *EmbeddingStore) Words() []string {
+	if s == nil {
+		return nil
+	}
+	return s.words
+}
+
+// Bytes estimates the heap held by s. Mapped vectors aren't counted: they
+// live in the page cache.
+func (s *EmbeddingStore) Bytes() int64 {
+	if s == nil {
+		return 0
+	}
+	var total int64
+	for _, word := range s.words {
+		total += int64(len(word)) + 48
+	}
+	if !s.mapped {
+		total += int64(len(s.data)) * 4
+	}
+	return total
+}
+
+// add appends the vector of word unless word is already in s: repeated
+// words keep their first vector. The first vector sets the dimension,
+// which later ones must match. Adding to a mapped store copies its
+// vectors off the mapping first.
+func (s *EmbeddingStore) add(word string, vec []float32) error {
+	if _, ok := s.index[word]; ok {
+		return nil
+	}
+	if len(s.words) == 0 && s.dim == 0 {
+		s.dim = len(vec)
+	}
+	if len(vec) != s.dim {
+		return fmt.Errorf("%q has %d dimensions but %q has %d", word, len(vec), s.words[0], s.dim)
+	}
+	if s.mapped {
+		s.data = append(make([]float32, 0, len(s.data)+s.dim), s.data...)
+		s.mapped = false
+	}
+	s.index[word] = int32(len(s.words))
+	s.words = append(s.words, word)
+	s.data = append(s.data, vec...)
+	return nil
+}
+
+// The packed format is what the pack-embeddings subcommand writes: a
+// header of packedMagic, a version, the dimension and the word count, all
+// little-endian; then every vector as little-endian float32s, in word
+// order; then every word as a uvarint length and its bytes. The vectors
+// start 4-byte aligned, so a mapped file is used in place.
+const (
+	packedMagic      = "ASKGOEMB"
+	packedVersion    = 1
+	packedHeaderSize = 24
+)
+
+// errNoMmap means the file can't be used in place and is to be read into
+// memory instead.
+var errNoMmap = errors.New("memory mapping not supported")
+
+// packedHeader parses the header of a packed file.
+func packedHeader(b []byte) (dim, count int, err error) {
+	if len(b) < packedHeaderSize || string(b[:len(packedMagic)]) != packedMagic {
+		return 0, 0, errors.New("not a packed embeddings file")
+	}
+	if v := binary.LittleEndian.Uint32(b[8:]); v != packedVersion {
+		return 0, 0, fmt.Errorf("packed embeddings version %d, want %d", v, packedVersion)
+	}
+	dim = int(binary.LittleEndian.Uint32(b[12:]))
+	n := binary.LittleEndian.Uint64(b[16:])
+	if dim <= 0 || dim > maxBinaryDimension || n > math.MaxInt32 {
+		return 0, 0, fmt.Errorf("packed header claims %d words of dimension %d", n, dim)
+	}
+	return dim, int(n), nil
+}
+
+// mapPackedEmbeddings uses the packed file f in place, or only its first
+// limit words if limit is positive. It returns errNoMmap where the
+// platform can't map files, or its byte order isn't the file's.
+func mapPackedEmbeddings(f *os.File, limit int) (*EmbeddingStore, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < packedHeaderSize {
+		return nil, errors.New("not a packed embeddings file")
+	}
+	if int64(int(info.Size())) != info.Size() || !littleEndian() {
+		return nil, errNoMmap
+	}
+	mapping, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	dim, count, err := packedHeader(mapping)
+	if err != nil {
+		unmapFile(mapping)
+		return nil, err
+	}
+	vectorBytes := 4 * int64(dim) * int64(count)
+	if int64(len(mapping)-packedHeaderSize) < vectorBytes {
+		unmapFile(mapping)
+		return nil, fmt.Errorf("%d words of dimension %d: %v", count, dim, io.ErrUnexpectedEOF)
+	}
+	n := count
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	s := newEmbeddingStore(n)
+	s.dim = dim
+	if err := readPackedWords(s, bytes.NewReader(mapping[packedHeaderSize+int(vectorBytes):]), n); err != nil {
+		unmapFile(mapping)
+		return nil, err
+	}
+	s.data = float32s(mapping[packedHeaderSize : packedHeaderSize+4*dim*n])
+	s.mapped = true
+	return s, nil
+}
+
+// readPackedEmbeddings reads a packed file into memory, or only its first
+// limit words if limit is positive.
+func readPackedEmbeddings(r *bufio.Reader, limit int) (*EmbeddingStore, error) {
+	header := make([]byte, packedHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("reading the header: %v", unexpected(err))
+	}
+	dim, count, err := packedHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	n := count
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	s := newEmbeddingStore(capacity(n, 0))
+	s.dim = dim
+	s.data = make([]float32, 0, capacity(n, 0)*dim)
+	raw := make([]byte, 4*dim)
+	for i := 0; i < n; i++ {
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, fmt.Errorf("vector %d of %d: %v", i+1, count, unexpected(err))
+		}
+		for j := 0; j < dim; j++ {
+			s.data = append(s.data, math.Float32frombits(binary.LittleEndian.Uint32(raw[4*j:])))
+		}
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, int64(4*dim)*int64(count-n)); err != nil {
+		return nil, fmt.Errorf("vector %d of %d: %v", n+1, count, unexpected(err))
+	}
+	if err := readPackedWords(s, r, n); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// readPackedWords reads the first n words of a packed file into the index
+// of s. A packed file holds no repeated words.
+func readPackedWords(s *EmbeddingStore, r io.ByteReader, n int) error {
+	buf := make([]byte, 0, 64)
+	for i := 0; i < n; i++ {
+		size, err := binary.ReadUvarint(r)
+		if err == nil && size > maxBinaryWord {
+			err = fmt.Errorf("word longer than %d bytes", maxBinaryWord)
+		}
+		buf = buf[:0]
+		for j := uint64(0); err == nil && j < size; j++ {
+			var c byte
+			if c, err = r.ReadByte(); err == nil {
+				buf = append(buf, c)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("word %d of %d: %v", i+1, n, unexpected(err))
+		}
+		word := string(buf)
+		if _, ok := s.index[word]; ok {
+			return fmt.Errorf("word %d of %d: %q repeated", i+1, n, word)
+		}
+		s.index[word] = int32(i)
+		s.words = append(s.words, word)
+	}
+	return nil
+}
+
+// writePackedEmbeddings writes s to w in the packed format.
+func writePackedEmbeddings(w io.Writer, s *EmbeddingStore) error {
+	bw := bufio.NewWriterSize(w, 64<<10)
+	header := make([]byte, packedHeaderSize)
+	copy(header, packedMagic)
+	binary.LittleEndian.PutUint32(header[8:], packedVersion)
+	binary.LittleEndian.PutUint32(header[12:], uint32(s.Dimension()))
+	binary.LittleEndian.PutUint64(header[16:], uint64(s.Len()))
+	bw.Write(header)
+	var buf [binary.MaxVarintLen64]byte
+	for _, x := range s.data {
+		binary.LittleEndian.PutUint32(buf[:4], math.Float32bits(x))
+		bw.Write(buf[:4])
+	}
+	for _, word := range s.Words() {
+		bw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(word)))])
+		bw.WriteString(word)
+	}
+	return bw.Flush()
+}
+
+// littleEndian reports whether the host stores numbers little-endian, as
+// packed files do.
+func littleEndian() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}
+
+// float32s views b, which must be 4-byte aligned, as float32s in host
+// byte order, without copying.
+func float32s(b []byte) []float32 {
+	if len(b) < 4 {
+		return nil
+	}
+	var data []float32
+	h := (*reflect.SliceHeader)(unsafe.Pointer(&data))
+	h.Data = uintptr(unsafe.Pointer(&b[0]))
+	h.Len = len(b) / 4
+	h.Cap = len(b) / 4
+	return data
+}
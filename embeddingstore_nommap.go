@@ -0,0 +1,13 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import "os"
+
+// mapFile returns errNoMmap: files are read into memory instead.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errNoMmap
+}
+
+func unmapFile(b []byte) {}
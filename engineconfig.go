@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+)
+
+// EngineConfig is the "engine" section of prompt.json: the knobs most
+// deployments tune, without writing calibrations by hand. The flags of the
+// same names override it.
+type EngineConfig struct {
+	// KBMatchThreshold is the cosine similarity a knowledge base or
+	// learned entry must exceed to be served. Small embedding files need
+	// a lower one than the default 0.7.
+	KBMatchThreshold *float64 `json:"kb_match_threshold" schema:"minimum=0,exclusiveMaximum=1"`
+	// ContextMatchThreshold is the keyword overlap with an earlier
+	// interaction of the session that must be exceeded to reuse its
+	// answer; 0.8 by default.
+	ContextMatchThreshold *float64 `json:"context_match_threshold" schema:"minimum=0,exclusiveMaximum=1"`
+	// AdaptResponses frames learned and context answers with the
+	// question's keywords ("Based on ..., I understand that ..."). On by
+	// default.
+	AdaptResponses *bool `json:"adapt_responses"`
+	// IDFWeighting weights each word of a sentence vector by its inverse
+	// document frequency over the questions, so common words like "how"
+	// don't dominate. On by default; off averages words equally.
+	IDFWeighting *bool `json:"idf_weighting"`
+	// FilterStopWords leaves stopwords out of sentence vectors and
+	// keywords. On by default.
+	FilterStopWords *bool `json:"filter_stopwords"`
+	// StopWords are added to the built-in English stopwords.
+	StopWords []string `json:"stopwords"`
+	// ParallelScanMinEntries is the number of entries from which a scan
+	// of them all is split across ScanWorkers goroutines; 2000 by
+	// default.
+	ParallelScanMinEntries *int `json:"parallel_scan_min_entries" schema:"minimum=1"`
+	// ScanWorkers is the number of goroutines a scan is split across;
+	// GOMAXPROCS by default, 1 never splits.
+	ScanWorkers *int `json:"scan_workers" schema:"minimum=1"`
+	// MaxWeightBoost is how far a knowledge base entry's weight may raise
+	// its score above the cosine similarity, so weights reorder close
+	// matches without serving poor ones; 0.1 by default, 0 lets weights
+	// only lower scores.
+	MaxWeightBoost *float64 `json:"max_weight_boost" schema:"minimum=0,maximum=1"`
+	// GreetingMaxEdits is the number of typos a greeting is still
+	// recognized with, "helo" for "hello"; 2 by default, fewer for short
+	// greetings, and 0 only takes greetings as written.
+	GreetingMaxEdits *int `json:"greeting_max_edits" schema:"minimum=0"`
+	// SpellCorrection corrects words of a question found neither in the
+	// embeddings nor in any question when a word that is lies a typo or
+	// two away, "gorotine" for "goroutine". On by default.
+	SpellCorrection *bool `json:"spell_correction"`
+}
+
+// thresholdCalibration is the calibration serving raw scores above
+// threshold: linear, with threshold at minConfidence and 1 at 1.
+func thresholdCalibration(threshold float64) Calibration {
+	return Calibration{Method: "minmax", Min: 2*threshold - 1, Max: 1}
+}
+
+func checkThreshold(name string, threshold float64) error {
+	if threshold < 0 || threshold >= 1 {
+		return fmt.Errorf("%s must be at least 0 and below 1", name)
+	}
+	return nil
+}
+
+// applyThresholds replaces the calibrations of the retrievers whose
+// threshold is set. The knowledge base threshold covers learned entries
+// too, which share its scale.
+func (e EngineConfig) applyThresholds(calibrations Calibrations) error {
+	if e.KBMatchThreshold != nil {
+		if err := checkThreshold("kb_match_threshold", *e.KBMatchThreshold); err != nil {
+			return err
+		}
+		calibrations[SourceKnowledgeBase] = thresholdCalibration(*e.KBMatchThreshold)
+		calibrations[SourceLearned] = thresholdCalibration(*e.KBMatchThreshold)
+	}
+	if e.ContextMatchThreshold != nil {
+		if err := checkThreshold("context_match_threshold", *e.ContextMatchThreshold); err != nil {
+			return err
+		}
+		calibrations[SourceContext] = thresholdCalibration(*e.ContextMatchThreshold)
+	}
+	return nil
+}
+
+// conflicts reports a threshold set alongside a calibration of the same
+// retriever, since only one of them can win.
+func (e EngineConfig) conflicts(calibration map[string]Calibration) error {
+	check := func(set bool, name string, sources ...string) error {
+		for _, source := range sources {
+			if _, ok := calibration[source]; ok && set {
+				return fmt.Errorf("engine.%s and calibration.%s both set the %s threshold; keep one", name, source, source)
+			}
+		}
+		return nil
+	}
+	if err := check(e.KBMatchThreshold != nil, "kb_match_threshold", SourceKnowledgeBase, SourceLearned); err != nil {
+		return err
+	}
+	return check(e.ContextMatchThreshold != nil, "context_match_threshold", SourceContext)
+}
+
+// adaptResponses reports whether answers are framed with their keywords.
+func (e EngineConfig) adaptResponses() bool {
+	return e.AdaptResponses == nil || *e.AdaptResponses
+}
+
+func (e EngineConfig) idfWeighting() bool {
+	return e.IDFWeighting == nil || *e.IDFWeighting
+}
+
+func (e EngineConfig) filterStopWords() bool {
+	return e.FilterStopWords == nil || *e.FilterStopWords
+}
+
+// scan returns base with the scan settings of e applied.
+func (e EngineConfig) scan(base scanConfig) (scanConfig, error) {
+	if e.ParallelScanMinEntries != nil {
+		if *e.ParallelScanMinEntries < 1 {
+			return base, errors.New("parallel_scan_min_entries must be at least 1")
+		}
+		base.minEntries = *e.ParallelScanMinEntries
+	}
+	if e.ScanWorkers != nil {
+		if *e.ScanWorkers < 1 {
+			return base, errors.New("scan_workers must be at least 1")
+		}
+		base.workers = *e.ScanWorkers
+	}
+	return base, nil
+}
+
+func (e EngineConfig) spellCorrection() bool {
+	return e.SpellCorrection == nil || *e.SpellCorrection
+}
+
+func (e EngineConfig) greetingMaxEdits() int {
+	if e.GreetingMaxEdits == nil {
+		return defaultGreetingMaxEdits
+	}
+	return *e.GreetingMaxEdits
+}
+
+// maxWeightBoost returns the weight boost cap of e, or the default.
+func (e EngineConfig) maxWeightBoost() (float64, error) {
+	if e.MaxWeightBoost == nil {
+		return defaultMaxWeightBoost, nil
+	}
+	if *e.MaxWeightBoost < 0 || *e.MaxWeightBoost > 1 {
+		return 0, errors.New("max_weight_boost must be between 0 and 1")
+	}
+	return *e.MaxWeightBoost, nil
+}
+
+// Configure applies an EngineConfig to a running engine, as the flags do
+// after prompt.json has been loaded. Unset fields are left alone.
+func (ai *AIEngine) Configure(e EngineConfig) error {
+	calibrations := make(Calibrations, len(ai.Calibrations))
+	for source, c := range ai.Calibrations {
+		calibrations[source] = c
+	}
+	if err := e.applyThresholds(calibrations); err != nil {
+		return err
+	}
+	scan, err := e.scan(ai.KB.view().scan)
+	if err != nil {
+		return err
+	}
+	boost, err := e.maxWeightBoost()
+	if err != nil {
+		return err
+	}
+	if e.GreetingMaxEdits != nil && *e.GreetingMaxEdits < 0 {
+		return errors.New("greeting_max_edits must be at least 0")
+	}
+	ai.Calibrations = calibrations
+	if e.AdaptResponses != nil {
+		ai.AdaptResponses = *e.AdaptResponses
+	}
+	if e.IDFWeighting != nil {
+		ai.KB.setIDFWeighting(*e.IDFWeighting)
+	}
+	if e.StopWords != nil {
+		ai.StopWords = newStopWordSet(e.StopWords)
+	}
+	if e.StopWords != nil || e.FilterStopWords != nil {
+		filter := ai.KB.view().stopWords != nil
+		if e.FilterStopWords != nil {
+			filter = *e.FilterStopWords
+		}
+		var stopWords stopWordSet
+		if filter {
+			stopWords = ai.StopWords
+		}
+		ai.KB.setStopWords(stopWords)
+	}
+	if e.ParallelScanMinEntries != nil || e.ScanWorkers != nil {
+		ai.KB.setScan(scan)
+	}
+	if e.MaxWeightBoost != nil {
+		ai.KB.setMaxWeightBoost(boost)
+	}
+	if e.GreetingMaxEdits != nil {
+		ai.GreetingMaxEdits = *e.GreetingMaxEdits
+	}
+	if e.SpellCorrection != nil {
+		ai.SpellCorrection = *e.SpellCorrection
+	}
+	ai.AnswerCache.clear()
+	return nil
+}
+
+// EffectiveConfig is the configuration a running engine answers with:
+// the engine settings in force, whether they came from prompt.json, the
+// flags or their defaults, the calibrations, the features turned on at
+// startup and the kill switches as they are now.
+type EffectiveConfig struct {
+	Engine       EngineConfig    `json:"engine"`
+	Calibrations Calibrations    `json:"calibrations"`
+	Features     map[string]bool `json:"features"`
+	Switches     SwitchSettings  `json:"switches"`
+}
+
+// effectiveConfig returns the configuration in force. The thresholds are
+// left out where a calibration other than a threshold's replaced them,
+// and the stopwords, which are the built-in ones plus those added.
+func (ai *AIEngine) effectiveConfig() EffectiveConfig {
+	state := ai.KB.view()
+	threshold := func(source string) *float64 {
+		c, ok := ai.Calibrations[source]
+		if !ok || c.Method != "minmax" || c.Max != 1 {
+			return nil
+		}
+		t := (c.Min + 1) / 2
+		return &t
+	}
+	idf, filter := state.idf != nil, state.stopWords != nil
+	minEntries, workers := state.scan.minEntries, state.scan.workers
+	if minEntries == 0 {
+		minEntries = defaultParallelScanMin
+	}
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	boost, edits, spelling, adapt := state.maxWeightBoost, ai.GreetingMaxEdits, ai.SpellCorrection, ai.AdaptResponses
+	config := EffectiveConfig{
+		Engine: EngineConfig{
+			KBMatchThreshold:       threshold(SourceKnowledgeBase),
+			ContextMatchThreshold:  threshold(SourceContext),
+			AdaptResponses:         &adapt,
+			IDFWeighting:           &idf,
+			FilterStopWords:        &filter,
+			ParallelScanMinEntries: &minEntries,
+			ScanWorkers:            &workers,
+			MaxWeightBoost:         &boost,
+			GreetingMaxEdits:       &edits,
+			SpellCorrection:        &spelling,
+		},
+		Calibrations: ai.Calibrations,
+		Features:     ai.Features,
+		Switches:     ai.Switches.Settings(),
+	}
+	if config.Features == nil {
+		config.Features = map[string]bool{}
+	}
+	return config
+}
+
+// handleEffectiveConfig serves GET /admin/config.
+func handleEffectiveConfig(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ai.effectiveConfig())
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestThresholdFlipsBorderlineMatch asks a question whose only word lies
+// at a cosine similarity of 0.707 from an entry's, and serves the entry
+// or not as the knowledge base threshold moves around that score.
+func TestThresholdFlipsBorderlineMatch(t *testing.T) {
+	zebra := make([]float64, toyDimension)
+	quagga := make([]float64, toyDimension)
+	zebra[0], quagga[0], quagga[1] = 1, 1, 1
+	ai := newTestEngine(t,
+		withEntries(append([]KnowledgeEntry{{ID: "zebra", Question: "what is a zebra", Answer: "A striped horse."}}, testEntries...)...),
+		withEmbeddings(map[string][]float64{"zebra": zebra, "quagga": quagga}))
+	if score := cosineSimilarity(getSentenceVector("quagga", ai.Embeddings), getSentenceVector("zebra", ai.Embeddings)); math.Abs(score-math.Sqrt2/2) > 1e-3 {
+		t.Fatalf("borderline score %.3f, want 0.707", score)
+	}
+	for _, tt := range []struct {
+		threshold float64
+		served    bool
+	}{
+		{0.7, true},
+		{0.75, false},
+		{0.6, true},
+	} {
+		threshold := tt.threshold
+		if err := ai.Configure(EngineConfig{KBMatchThreshold: &threshold}); err != nil {
+			t.Fatal(err)
+		}
+		answer, _ := ai.Ask("quagga", AskOptions{})
+		if served := answer.Entry != nil && answer.Entry.ID == "zebra"; served != tt.served {
+			t.Errorf("threshold %.2f: answered %q from %s, served = %v, want %v", tt.threshold, answer.Text, answer.Source, served, tt.served)
+		}
+	}
+}
+
+// TestThresholdRanges refuses thresholds outside [0, 1), both from the
+// flags and from prompt.json, and a threshold set alongside a
+// calibration of the same retriever.
+func TestThresholdRanges(t *testing.T) {
+	ai := newTestEngine(t)
+	for _, threshold := range []float64{-0.1, 1, 1.5} {
+		threshold := threshold
+		for name, config := range map[string]EngineConfig{
+			"kb":      {KBMatchThreshold: &threshold},
+			"context": {ContextMatchThreshold: &threshold},
+		} {
+			if err := ai.Configure(config); err == nil {
+				t.Errorf("%s threshold %v accepted", name, threshold)
+			}
+		}
+	}
+	for _, threshold := range []float64{0, 0.5, 0.99} {
+		threshold := threshold
+		if err := ai.Configure(EngineConfig{KBMatchThreshold: &threshold, ContextMatchThreshold: &threshold}); err != nil {
+			t.Errorf("threshold %v refused: %v", threshold, err)
+		}
+	}
+
+	// Schema violations are logged one by one and counted in the error.
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	dir, err := ioutil.TempDir("", "askgo-thresholds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, tt := range map[string]struct {
+		engine, want string
+	}{
+		"above 1":     {`"engine": {"kb_match_threshold": 1.2}`, "kb_match_threshold"},
+		"equal to 1":  {`"engine": {"context_match_threshold": 1}`, "context_match_threshold"},
+		"negative":    {`"engine": {"kb_match_threshold": -0.5}`, "kb_match_threshold"},
+		"conflicting": {`"engine": {"kb_match_threshold": 0.5}, "calibration": {"knowledge_base": {"method": "minmax", "min": 0, "max": 1}}`, "keep one"},
+	} {
+		path := filepath.Join(dir, "prompt.json")
+		prompt := `{"knowledge_base": [{"id": "maps", "question": "What is a map?", "answer": "A hash table."}], ` + tt.engine + `}`
+		if err := ioutil.WriteFile(path, []byte(prompt), 0644); err != nil {
+			t.Fatal(err)
+		}
+		logged.Reset()
+		if _, err := loadPrompts(path); err == nil || !strings.Contains(err.Error()+logged.String(), tt.want) {
+			t.Errorf("%s: loading gave %v, logging %q; want %s named", name, err, logged.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// entryListing is an entry as shown in the admin listing.
+type entryListing struct {
+	entryStatus
+	Feedback *FeedbackScore `json:"feedback,omitempty"`
+}
+
+// entryListings lists the knowledge base entries with their feedback.
+func (ai *AIEngine) entryListings() []entryListing {
+	entries := ai.KB.view().entries
+	listings := make([]entryListing, len(entries))
+	for i, entry := range entries {
+		listings[i].entryStatus = newEntryStatus(entry)
+	}
+	for i := range listings {
+		if score, ok := ai.Feedback.get(listings[i].ID); ok {
+			listings[i].Feedback = &score
+		}
+	}
+	return listings
+}
+
+// sortListings orders listings by feedback score, ascending for "score"
+// and descending for "-score". Entries without feedback come last either
+// way.
+func sortListings(listings []entryListing, order string) error {
+	var desc bool
+	switch order {
+	case "":
+		return nil
+	case "score":
+	case "-score":
+		desc = true
+	default:
+		return newError(ErrInvalidInput, `sort must be "score" or "-score"`)
+	}
+	sort.SliceStable(listings, func(i, j int) bool {
+		a, b := listings[i].Feedback, listings[j].Feedback
+		if a == nil || b == nil {
+			return a != nil
+		}
+		if desc {
+			return a.Score > b.Score
+		}
+		return a.Score < b.Score
+	})
+	return nil
+}
+
+// handleEntries serves GET /entries, optionally sorted with ?sort=score or
+// ?sort=-score.
+func handleEntries(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listings := ai.entryListings()
+		if err := sortListings(listings, r.URL.Query().Get("sort")); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listings)
+	}
+}
+
+// Paging of GET /kb/entries.
+const (
+	defaultKBPage = 50
+	maxKBPage     = 500
+)
+
+// kbEntryListing is an entry as GET /kb/entries lists it: what the engine
+// loaded, with its vector summarized unless asked for.
+type kbEntryListing struct {
+	ID        string   `json:"id"`
+	Question  string   `json:"question"`
+	Answer    string   `json:"answer"`
+	SourceURL string   `json:"source_url,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Weight    float64  `json:"weight,omitempty"`
+	// Vectorized is false for entries none of whose words are in the
+	// embeddings, which only lexical matching can find.
+	Vectorized  bool      `json:"vectorized"`
+	Dimension   int       `json:"vector_dimension"`
+	Vector      []float32 `json:"vector,omitempty"`
+	Quarantined bool      `json:"quarantined,omitempty"`
+	MergedInto  string    `json:"merged_into,omitempty"`
+}
+
+func newKBEntryListing(entry KnowledgeEntry, vector bool) kbEntryListing {
+	listing := kbEntryListing{
+		ID:          entry.ID,
+		Question:    entry.Question,
+		Answer:      entry.Answer,
+		SourceURL:   entry.SourceURL,
+		Tags:        entry.Tags,
+		Weight:      entry.Weight,
+		Vectorized:  len(entry.Vector) > 0,
+		Dimension:   len(entry.Vector),
+		Quarantined: entry.Quarantined,
+		MergedInto:  entry.MergedInto,
+	}
+	if vector {
+		listing.Vector = entry.Vector
+	}
+	return listing
+}
+
+// handleKBEntries serves GET /kb/entries: the knowledge base entries in
+// load order, those whose question contains ?q= if given, limit from
+// offset, with the number matched. ?vectors=true includes the vectors.
+// It reads one published state, which no writer changes, so it neither
+// takes a lock nor holds up answers; only the page is copied.
+func handleKBEntries(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		offset, limit, err := pageParams(r, defaultKBPage, maxKBPage)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		filter := strings.ToLower(r.URL.Query().Get("q"))
+		vectors := r.URL.Query().Get("vectors") == "true"
+
+		entries := ai.KB.view().entries
+		page := make([]kbEntryListing, 0, min(limit, len(entries)))
+		total := 0
+		for _, entry := range entries {
+			if filter != "" && !strings.Contains(strings.ToLower(entry.Question), filter) {
+				continue
+			}
+			if total >= offset && len(page) < limit {
+				page = append(page, newKBEntryListing(entry, vectors))
+			}
+			total++
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"total":   total,
+			"offset":  offset,
+			"limit":   limit,
+			"entries": page,
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Error kinds returned by the engine and stores. Test for them with
+// errors.Is; the concrete errors carry a human-readable message.
+var (
+	// ErrInvalidInput: the request or argument is malformed.
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrNotFound: the entry, cluster or report does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict: the change clashes with the current state.
+	ErrConflict = errors.New("conflict")
+	// ErrGone: the token or resource existed but is no longer usable.
+	ErrGone = errors.New("gone")
+	// ErrDisabled: an operator switch has turned the feature off.
+	ErrDisabled = errors.New("disabled")
+	// ErrNoMatch: no entry matched well enough; the answer is a fallback.
+	ErrNoMatch = errors.New("no match")
+	// ErrNoCoverage: none of the question's words have embeddings.
+	ErrNoCoverage = errors.New("no vocabulary coverage")
+	// ErrStoreUnavailable: state could not be read or persisted.
+	ErrStoreUnavailable = errors.New("store unavailable")
+	// ErrMethodNotAllowed: the route doesn't serve the request method.
+	ErrMethodNotAllowed = errors.New("method not allowed")
+	// ErrUnauthorized: the route needs credentials the request lacks.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden: the credentials given are not accepted.
+	ErrForbidden = errors.New("forbidden")
+	// ErrTooLarge: the request body or question exceeds its size limit.
+	ErrTooLarge = errors.New("too large")
+)
+
+// Error is an error of one of the kinds above.
+type Error struct {
+	Kind    error
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+func (e *Error) Unwrap() error { return e.Kind }
+
+func newError(kind error, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// errorResponses translates error kinds to HTTP statuses and envelope
+// codes. Every kind must be listed.
+var errorResponses = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{ErrInvalidInput, http.StatusBadRequest, "invalid_input"},
+	{ErrNotFound, http.StatusNotFound, "not_found"},
+	{ErrConflict, http.StatusConflict, "conflict"},
+	{ErrGone, http.StatusGone, "gone"},
+	{ErrDisabled, http.StatusServiceUnavailable, "disabled"},
+	{ErrNoMatch, http.StatusNotFound, "no_match"},
+	{ErrNoCoverage, http.StatusUnprocessableEntity, "no_coverage"},
+	{ErrStoreUnavailable, http.StatusServiceUnavailable, "store_unavailable"},
+	{ErrMethodNotAllowed, http.StatusMethodNotAllowed, "method_not_allowed"},
+	{ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ErrTooLarge, http.StatusRequestEntityTooLarge, "too_large"},
+}
+
+// errorResponse returns the status and code for err; unknown errors are
+// internal errors.
+func errorResponse(err error) (int, string) {
+	for _, r := range errorResponses {
+		if errors.Is(err, r.kind) {
+			return r.status, r.code
+		}
+	}
+	return http.StatusInternalServerError, "internal"
+}
+
+// writeError writes err as a JSON error envelope. The message of errors
+// without a kind is logged rather than sent, since it may leak internals.
+func writeError(w http.ResponseWriter, err error) {
+	status, code := errorResponse(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Printf("Internal error: %v", err)
+		message = "internal error"
+	}
+	writeErrorBody(w, status, code, message, nil)
+}
+
+// writeErrorBody writes an error envelope with extra fields.
+func writeErrorBody(w http.ResponseWriter, status int, code, message string, extra map[string]interface{}) {
+	body := map[string]interface{}{"error": message, "code": code}
+	for k, v := range extra {
+		body[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
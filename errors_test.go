@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestEveryErrorKindIsMapped reads the Err variables declared in
+// errors.go and checks each has its own status and envelope code, so a
+// new kind can't be added without a row in errorResponses.
+func TestEveryErrorKindIsMapped(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "errors.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]error{
+		"ErrInvalidInput":     ErrInvalidInput,
+		"ErrNotFound":         ErrNotFound,
+		"ErrConflict":         ErrConflict,
+		"ErrGone":             ErrGone,
+		"ErrDisabled":         ErrDisabled,
+		"ErrNoMatch":          ErrNoMatch,
+		"ErrNoCoverage":       ErrNoCoverage,
+		"ErrStoreUnavailable": ErrStoreUnavailable,
+		"ErrMethodNotAllowed": ErrMethodNotAllowed,
+		"ErrUnauthorized":     ErrUnauthorized,
+		"ErrForbidden":        ErrForbidden,
+		"ErrTooLarge":         ErrTooLarge,
+	}
+	declared := 0
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.VAR {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			for _, name := range spec.(*ast.ValueSpec).Names {
+				if !strings.HasPrefix(name.Name, "Err") {
+					continue
+				}
+				declared++
+				if _, ok := kinds[name.Name]; !ok {
+					t.Errorf("%s is declared but not listed in this test", name.Name)
+				}
+			}
+		}
+	}
+	if declared != len(kinds) {
+		t.Errorf("errors.go declares %d kinds, the test lists %d", declared, len(kinds))
+	}
+	codes := make(map[string]string)
+	for name, kind := range kinds {
+		status, code := errorResponse(newError(kind, "wrapped"))
+		if status == http.StatusInternalServerError || code == "internal" {
+			t.Errorf("%s maps to an internal error", name)
+		}
+		if other, ok := codes[code]; ok {
+			t.Errorf("%s and %s share the code %q", name, other, code)
+		}
+		codes[code] = name
+		if s, c := errorResponse(fmt.Errorf("context: %w", kind)); s != status || c != code {
+			t.Errorf("%s wrapped with %%w maps to %d %q, want %d %q", name, s, c, status, code)
+		}
+	}
+}
+
+// TestWriteErrorHidesInternals sends kinded messages as they are and
+// replaces the message of an untyped error.
+func TestWriteErrorHidesInternals(t *testing.T) {
+	tests := []struct {
+		err           error
+		status        int
+		code, message string
+	}{
+		{newError(ErrNotFound, "no entry %q", "maps"), http.StatusNotFound, "not_found", `no entry "maps"`},
+		{errors.New("open /var/lib/askgo/kb.json: permission denied"), http.StatusInternalServerError, "internal", "internal error"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		writeError(rec, tt.err)
+		var body struct {
+			Error, Code string
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tt.status || body.Code != tt.code || body.Error != tt.message {
+			t.Errorf("%v: %d %+v, want %d %q %q", tt.err, rec.Code, body, tt.status, tt.code, tt.message)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Bounds of the escalation store. Past maxPendingEscalations unredeemed
+// tokens the one expiring soonest is dropped, and past maxEscalations the
+// oldest escalation.
+const (
+	maxPendingEscalations = 10000
+	maxEscalations        = 1000
+	// maxEscalationHistory is how many of the session's latest turns are
+	// attached to an escalation.
+	maxEscalationHistory = 10
+)
+
+// EscalationTurn is an earlier question of the session and its answer,
+// attached to an escalation with personal data redacted.
+type EscalationTurn struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// EscalationCandidate is a near-miss entry captured with an escalation.
+type EscalationCandidate struct {
+	ID       string  `json:"id"`
+	Question string  `json:"question"`
+	Score    float64 `json:"score"`
+}
+
+// Escalation is an unanswered question handed over to a human.
+type Escalation struct {
+	ID         string                `json:"id"`
+	Question   string                `json:"question"`
+	Candidates []EscalationCandidate `json:"candidates"`
+	// History is the conversation leading up to the question, oldest
+	// first.
+	History   []EscalationTurn `json:"history"`
+	Email     string           `json:"email,omitempty"`
+	Comment   string           `json:"comment,omitempty"`
+	AskedAt   time.Time        `json:"asked_at"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+type pendingEscalation struct {
+	escalation Escalation
+	expires    time.Time
+}
+
+// EscalationStore issues single-use tokens for low-confidence answers and
+// keeps the escalations redeemed with them.
+type EscalationStore struct {
+	ttl     time.Duration
+	webhook string
+	client  *http.Client
+	// switches can turn webhook forwarding off at runtime.
+	switches *Switches
+
+	mu          sync.Mutex
+	pending     map[string]pendingEscalation
+	escalations []Escalation
+}
+
+func NewEscalationStore(ttl time.Duration, webhook string, client *http.Client, switches *Switches) *EscalationStore {
+	return &EscalationStore{
+		ttl:      ttl,
+		webhook:  webhook,
+		client:   client,
+		switches: switches,
+		pending:  make(map[string]pendingEscalation),
+	}
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Issue records the context of a low-confidence answer, with the latest
+// turns of history, and returns the token the client can redeem at
+// /escalate. The question and history are redacted as mirrored questions
+// are, since they reach people and webhooks.
+func (s *EscalationStore) Issue(question string, answer Answer, history []Interaction) string {
+	now := time.Now()
+	escalation := Escalation{Question: redact(question), AskedAt: now, Candidates: []EscalationCandidate{}, History: []EscalationTurn{}}
+	if len(history) > maxEscalationHistory {
+		history = history[len(history)-maxEscalationHistory:]
+	}
+	for _, turn := range history {
+		escalation.History = append(escalation.History, EscalationTurn{Question: redact(turn.Question), Answer: redact(turn.Answer)})
+	}
+	for _, match := range answer.Candidates {
+		escalation.Candidates = append(escalation.Candidates, EscalationCandidate{
+			ID:       match.Entry.ID,
+			Question: match.Entry.Question,
+			Score:    match.Score,
+		})
+	}
+	token := newToken()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t, p := range s.pending {
+		if now.After(p.expires) {
+			delete(s.pending, t)
+		}
+	}
+	if len(s.pending) >= maxPendingEscalations {
+		var soonest string
+		for t, p := range s.pending {
+			if soonest == "" || p.expires.Before(s.pending[soonest].expires) {
+				soonest = t
+			}
+		}
+		delete(s.pending, soonest)
+	}
+	s.pending[token] = pendingEscalation{escalation: escalation, expires: now.Add(s.ttl)}
+	return token
+}
+
+// Redeem consumes a token. Unknown, used or expired tokens give ErrGone.
+func (s *EscalationStore) Redeem(token, email, comment string) (Escalation, error) {
+	now := time.Now()
+	s.mu.Lock()
+	p, ok := s.pending[token]
+	delete(s.pending, token)
+	if !ok || now.After(p.expires) {
+		s.mu.Unlock()
+		return Escalation{}, newError(ErrGone, "escalation token is invalid, used or expired")
+	}
+	escalation := p.escalation
+	escalation.ID = newToken()[:12]
+	escalation.Email = email
+	escalation.Comment = comment
+	escalation.CreatedAt = now
+	s.escalations = append(s.escalations, escalation)
+	if extra := len(s.escalations) - maxEscalations; extra > 0 {
+		s.escalations = append(s.escalations[:0:0], s.escalations[extra:]...)
+	}
+	s.mu.Unlock()
+
+	if s.webhook != "" && s.switches.Enabled(SwitchWebhooks, "") {
+		go s.forward(escalation)
+	}
+	return escalation, nil
+}
+
+func (s *EscalationStore) forward(escalation Escalation) {
+	body, _ := json.Marshal(escalation)
+	resp, err := s.client.Post(s.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Escalation webhook failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Escalation webhook returned %s", resp.Status)
+	}
+}
+
+func (s *EscalationStore) List() []Escalation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Escalation, len(s.escalations))
+	copy(list, s.escalations)
+	return list
+}
+
+type EscalateRequest struct {
+	Token   string `json:"token" schema:"required"`
+	Email   string `json:"email"`
+	Comment string `json:"comment"`
+}
+
+func handleEscalate(s *EscalationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req EscalateRequest
+		if err := decodeRequest(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		escalation, err := s.Redeem(req.Token, req.Email, req.Comment)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(escalation)
+	}
+}
+
+func handleEscalations(s *EscalationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.List())
+	}
+}
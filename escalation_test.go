@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEscalationCarriesRedactedHistory(t *testing.T) {
+	s := NewEscalationStore(time.Hour, "", nil, nil)
+	var history []Interaction
+	for i := 0; i < maxEscalationHistory+2; i++ {
+		history = append(history, Interaction{Question: "How do I reach ops?", Answer: "Mail ops@example.com."})
+	}
+	history[len(history)-1] = Interaction{Question: "My number is +1 555 123 4567", Answer: "Noted."}
+
+	token := s.Issue("Email me at jo@example.com", Answer{}, history)
+	escalation, err := s.Redeem(token, "jo@example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(escalation.History) != maxEscalationHistory {
+		t.Fatalf("history has %d turns, want the latest %d", len(escalation.History), maxEscalationHistory)
+	}
+	if last := escalation.History[maxEscalationHistory-1]; last.Question != "My number is <number>" {
+		t.Errorf("last turn %q, want the latest, redacted", last.Question)
+	}
+	for _, turn := range append(escalation.History, EscalationTurn{Question: escalation.Question}) {
+		if strings.Contains(turn.Question+turn.Answer, "@example.com") || strings.Contains(turn.Question, "555") {
+			t.Errorf("turn %+v was not redacted", turn)
+		}
+	}
+	// The email the user gave to be contacted on is kept.
+	if escalation.Email != "jo@example.com" {
+		t.Errorf("email %q", escalation.Email)
+	}
+}
+
+func TestEscalationStoreIsBounded(t *testing.T) {
+	s := NewEscalationStore(time.Hour, "", nil, nil)
+	first := s.Issue("first", Answer{}, nil)
+	// So first is strictly the soonest to expire.
+	time.Sleep(time.Millisecond)
+	for i := 0; i < maxPendingEscalations; i++ {
+		s.Issue("q", Answer{}, nil)
+	}
+	if len(s.pending) != maxPendingEscalations {
+		t.Fatalf("%d pending, want %d", len(s.pending), maxPendingEscalations)
+	}
+	if _, err := s.Redeem(first, "", ""); err == nil {
+		t.Error("the token expiring soonest was kept past the cap")
+	}
+
+	for i := 0; i < maxEscalations+5; i++ {
+		if _, err := s.Redeem(s.Issue("q", Answer{}, nil), "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(s.List()); n != maxEscalations {
+		t.Errorf("%d escalations kept, want %d", n, maxEscalations)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxExampleBytes caps each captured request and response body.
+const maxExampleBytes = 4096
+
+// Patterns redacted from captured payloads.
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	ipPattern    = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`)
+	digitPattern = regexp.MustCompile(`\+?\d[\d -]{7,}\d`)
+)
+
+func redact(s string) string {
+	s = emailPattern.ReplaceAllString(s, "<email>")
+	s = ipPattern.ReplaceAllString(s, "<ip>")
+	return digitPattern.ReplaceAllString(s, "<number>")
+}
+
+// Example is one captured request/response pair. ContentType is the media
+// type of the response.
+type Example struct {
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	Status      int       `json:"status"`
+	Request     string    `json:"request,omitempty"`
+	Response    string    `json:"response,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Truncated   bool      `json:"truncated,omitempty"`
+	CapturedAt  time.Time `json:"captured_at"`
+}
+
+// ExampleCapture records sampled traffic as examples, keeping the latest
+// one per endpoint, the method and route pattern, and status code so they
+// follow schema changes. The OpenAPI document embeds them.
+type ExampleCapture struct {
+	mu         sync.Mutex
+	enabled    bool
+	sampleRate float64
+	examples   map[string]map[int]Example
+}
+
+func NewExampleCapture() *ExampleCapture {
+	return &ExampleCapture{sampleRate: 0.1, examples: make(map[string]map[int]Example)}
+}
+
+func (c *ExampleCapture) sample() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enabled && rand.Float64() < c.sampleRate
+}
+
+type capturingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *capturingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes flushes on, for streamed responses.
+func (w *capturingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes hijacking on, for WebSocket upgrades.
+func (w *capturingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer can't be hijacked")
+	}
+	return h.Hijack()
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	if room := maxExampleBytes + 1 - w.body.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		w.body.Write(b[:room])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Middleware captures examples of requests served by router, keyed by the
+// method and route pattern that handled them.
+func (c *ExampleCapture) Middleware(router *Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.sample() {
+			router.ServeHTTP(w, r)
+			return
+		}
+		endpoint := r.Method + " " + router.Pattern(r)
+		var request []byte
+		if r.Body != nil {
+			// Only what an example keeps is read ahead; the route's body
+			// limit still applies to the rest.
+			request, _ = ioutil.ReadAll(io.LimitReader(r.Body, maxExampleBytes+1))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(request), r.Body), r.Body}
+		}
+		cw := &capturingWriter{ResponseWriter: w, status: http.StatusOK}
+		router.ServeHTTP(cw, r)
+		c.record(endpoint, r, request, cw)
+	})
+}
+
+func (c *ExampleCapture) record(endpoint string, r *http.Request, request []byte, w *capturingWriter) {
+	example := Example{
+		Method:     r.Method,
+		Path:       redact(r.URL.Path),
+		Status:     w.status,
+		CapturedAt: time.Now(),
+	}
+	if mediaType, _, err := mime.ParseMediaType(w.Header().Get("Content-Type")); err == nil {
+		example.ContentType = mediaType
+	}
+	example.Request, example.Truncated = capBody(request)
+	var truncated bool
+	example.Response, truncated = capBody(w.body.Bytes())
+	example.Truncated = example.Truncated || truncated
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.examples[endpoint] == nil {
+		c.examples[endpoint] = make(map[int]Example)
+	}
+	c.examples[endpoint][w.status] = example
+}
+
+func capBody(b []byte) (string, bool) {
+	if len(b) > maxExampleBytes {
+		return redact(string(b[:maxExampleBytes])), true
+	}
+	return redact(string(b)), false
+}
+
+// List returns the captured examples by endpoint and status code.
+func (c *ExampleCapture) List() map[string]map[string]Example {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]map[string]Example, len(c.examples))
+	for endpoint, byStatus := range c.examples {
+		out[endpoint] = make(map[string]Example, len(byStatus))
+		for status, example := range byStatus {
+			out[endpoint][strconv.Itoa(status)] = example
+		}
+	}
+	return out
+}
+
+// ExampleSettings turns capture on or off and sets the sampled share of
+// requests.
+type ExampleSettings struct {
+	Enabled    *bool    `json:"enabled"`
+	SampleRate *float64 `json:"sample_rate"`
+}
+
+// handleExamples serves /admin/examples: GET lists the examples and the
+// capture settings, POST changes the settings.
+func handleExamples(c *ExampleCapture) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var req ExampleSettings
+			if err := decodeRequest(r, &req); err != nil {
+				writeError(w, err)
+				return
+			}
+			if req.SampleRate != nil && (*req.SampleRate <= 0 || *req.SampleRate > 1) {
+				writeError(w, newError(ErrInvalidInput, "sample_rate must be in (0, 1]"))
+				return
+			}
+			c.mu.Lock()
+			if req.Enabled != nil {
+				c.enabled = *req.Enabled
+			}
+			if req.SampleRate != nil {
+				c.sampleRate = *req.SampleRate
+			}
+			c.mu.Unlock()
+		}
+		examples := c.List()
+		endpoints := make([]string, 0, len(examples))
+		for endpoint := range examples {
+			endpoints = append(endpoints, endpoint)
+		}
+		sort.Strings(endpoints)
+		c.mu.Lock()
+		enabled, rate := c.enabled, c.sampleRate
+		c.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"enabled":     enabled,
+			"sample_rate": rate,
+			"endpoints":   endpoints,
+			"examples":    examples,
+		})
+	}
+}
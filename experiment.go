@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// AnswerVariant is one phrasing of an entry's answer under experiment.
+type AnswerVariant struct {
+	Answer string  `json:"answer"`
+	Weight float64 `json:"weight"`
+}
+
+// pickVariant chooses a variant by weight. With a session ID the choice is a
+// hash of session and entry, so a user keeps seeing the same phrasing.
+func pickVariant(entry *KnowledgeEntry, sessionID string) int {
+	var total float64
+	for _, v := range entry.Variants {
+		total += v.Weight
+	}
+	var u float64
+	if sessionID != "" {
+		sum := sha1.Sum([]byte(sessionID + "/" + entry.ID))
+		u = float64(binary.BigEndian.Uint64(sum[:8])) / float64(math.MaxUint64)
+	} else {
+		u = rand.Float64()
+	}
+	target := u * total
+	for i, v := range entry.Variants {
+		if target < v.Weight {
+			return i
+		}
+		target -= v.Weight
+	}
+	return len(entry.Variants) - 1
+}
+
+// VariantStats counts serves and feedback for one variant.
+type VariantStats struct {
+	Serves    int `json:"serves"`
+	Helpful   int `json:"helpful"`
+	Unhelpful int `json:"unhelpful"`
+}
+
+// HelpfulRate is the share of feedback that was positive.
+func (s VariantStats) HelpfulRate() float64 {
+	if s.Helpful+s.Unhelpful == 0 {
+		return 0
+	}
+	return float64(s.Helpful) / float64(s.Helpful+s.Unhelpful)
+}
+
+// maxServedVariants bounds the serves remembered for outcomes; the oldest
+// are forgotten first, and their outcomes refused.
+const maxServedVariants = 10000
+
+// servedVariant is the variant one session was served of an entry, and
+// whether the session has reported how it did.
+type servedVariant struct {
+	variant int
+	rated   bool
+}
+
+// ExperimentTracker counts serves and feedback per entry and variant.
+// Feedback is only taken on a variant a session was served, once.
+type ExperimentTracker struct {
+	mu    sync.Mutex
+	stats map[string]map[int]*VariantStats
+	// served holds, by session and entry, the variants served to
+	// sessions; servedOrder lists its keys oldest first.
+	served      map[string]*servedVariant
+	servedOrder []string
+}
+
+func NewExperimentTracker() *ExperimentTracker {
+	return &ExperimentTracker{stats: make(map[string]map[int]*VariantStats), served: make(map[string]*servedVariant)}
+}
+
+func (t *ExperimentTracker) variant(entryID string, variant int) *VariantStats {
+	variants, ok := t.stats[entryID]
+	if !ok {
+		variants = make(map[int]*VariantStats)
+		t.stats[entryID] = variants
+	}
+	stats, ok := variants[variant]
+	if !ok {
+		stats = &VariantStats{}
+		variants[variant] = stats
+	}
+	return stats
+}
+
+// recordServe counts a serve of variant, remembering it for the outcome
+// of sessionID if set.
+func (t *ExperimentTracker) recordServe(entryID string, variant int, sessionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.variant(entryID, variant).Serves++
+	if sessionID == "" {
+		return
+	}
+	key := sessionID + "/" + entryID
+	if served, ok := t.served[key]; ok {
+		served.variant = variant
+		return
+	}
+	if len(t.servedOrder) >= maxServedVariants {
+		delete(t.served, t.servedOrder[0])
+		t.servedOrder = t.servedOrder[1:]
+	}
+	t.served[key] = &servedVariant{variant: variant}
+	t.servedOrder = append(t.servedOrder, key)
+}
+
+// servedVariant returns the variant of entryID served to sessionID.
+func (t *ExperimentTracker) servedVariant(entryID, sessionID string) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	served, ok := t.served[sessionID+"/"+entryID]
+	if !ok {
+		return 0, false
+	}
+	return served.variant, true
+}
+
+// recordFeedback counts the outcome sessionID reports for the variant of
+// entryID it was served, and returns that variant. It refuses sessions
+// that were not served one, or have reported already.
+func (t *ExperimentTracker) recordFeedback(entryID, sessionID string, helpful bool) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	served, ok := t.served[sessionID+"/"+entryID]
+	if !ok {
+		return 0, newError(ErrNotFound, "this session was not served a variant of entry %s", entryID)
+	}
+	if served.rated {
+		return 0, newError(ErrConflict, "this session has already reported on entry %s", entryID)
+	}
+	served.rated = true
+	stats := t.variant(entryID, served.variant)
+	if helpful {
+		stats.Helpful++
+	} else {
+		stats.Unhelpful++
+	}
+	return served.variant, nil
+}
+
+func (t *ExperimentTracker) snapshot(entryID string, variants int) []VariantStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]VariantStats, variants)
+	for i := range out {
+		if stats, ok := t.stats[entryID][i]; ok {
+			out[i] = *stats
+		}
+	}
+	return out
+}
+
+// reset forgets the stats of entryID. Sessions served one of its
+// variants keep them, and when the entry runs another experiment their
+// outcomes count for the variant at the same position.
+func (t *ExperimentTracker) reset(entryID string) {
+	t.mu.Lock()
+	delete(t.stats, entryID)
+	t.mu.Unlock()
+}
+
+// twoProportionZ compares the helpful rates of two variants. |z| > 1.96 is
+// a difference at roughly 95% confidence.
+func twoProportionZ(a, b VariantStats) float64 {
+	na, nb := float64(a.Helpful+a.Unhelpful), float64(b.Helpful+b.Unhelpful)
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	pooled := float64(a.Helpful+b.Helpful) / (na + nb)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/na + 1/nb))
+	if se == 0 {
+		return 0
+	}
+	return (a.HelpfulRate() - b.HelpfulRate()) / se
+}
+
+// entryByID returns a copy of the entry with the given ID.
+func (kb *KnowledgeBase) entryByID(id string) (KnowledgeEntry, bool) {
+	return kb.view().entryByID(id)
+}
+
+// entryByID is KnowledgeBase.entryByID in s.
+func (s *kbState) entryByID(id string) (KnowledgeEntry, bool) {
+	for _, entry := range s.entries {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+	return KnowledgeEntry{}, false
+}
+
+// endExperiment makes the winning variant the entry's only answer.
+func (kb *KnowledgeBase) endExperiment(id string, winner int) error {
+	err := newError(ErrNotFound, "entry %s not found", id)
+	kb.updateEntries("endExperiment", func(entries []KnowledgeEntry) []KnowledgeEntry {
+		for i := range entries {
+			entry := &entries[i]
+			if entry.ID != id {
+				continue
+			}
+			if winner < 0 || winner >= len(entry.Variants) {
+				err = newError(ErrInvalidInput, "winner is not a variant of this entry")
+				break
+			}
+			entry.Answer = entry.Variants[winner].Answer
+			entry.Variants = nil
+			err = nil
+			break
+		}
+		return entries
+	})
+	return err
+}
+
+type variantReport struct {
+	Answer      string  `json:"answer"`
+	Weight      float64 `json:"weight"`
+	HelpfulRate float64 `json:"helpful_rate"`
+	VariantStats
+}
+
+// ExperimentFeedback reports whether the variant of an entry served to a
+// session helped. The variant is the one served; Variant, if given, must
+// name it.
+type ExperimentFeedback struct {
+	Variant   *int   `json:"variant"`
+	Helpful   bool   `json:"helpful"`
+	SessionID string `json:"session_id"`
+}
+
+type EndExperimentRequest struct {
+	Winner int `json:"winner"`
+}
+
+// handleExperiment serves /entries/{id}/experiment: GET reports the
+// experiment, POST records the outcome of the variant served to the
+// caller's session and reports it.
+func handleExperiment(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := pathParam(r, "id")
+		entry, ok := ai.KB.entryByID(id)
+		if !ok || len(entry.Variants) == 0 {
+			writeError(w, newError(ErrNotFound, "no experiment running for this entry"))
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			var req ExperimentFeedback
+			if err := decodeRequest(r, &req); err != nil {
+				writeError(w, err)
+				return
+			}
+			voter, err := feedbackVoter(r, req.SessionID)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			if !strings.HasPrefix(voter, "session:") {
+				writeError(w, newError(ErrInvalidInput, "session_id is required: outcomes are reported on the variant served to a session"))
+				return
+			}
+			sessionID := strings.TrimPrefix(voter, "session:")
+			if served, ok := ai.Experiments.servedVariant(id, sessionID); ok && req.Variant != nil && *req.Variant != served {
+				writeError(w, newError(ErrInvalidInput, "this session was served variant %d, not %d", served, *req.Variant))
+				return
+			}
+			if _, err := ai.Experiments.recordFeedback(id, sessionID, req.Helpful); err != nil {
+				writeError(w, err)
+				return
+			}
+			ai.Feedback.record(id, voter, req.Helpful)
+		}
+
+		stats := ai.Experiments.snapshot(id, len(entry.Variants))
+		variants := make([]variantReport, len(stats))
+		for i, s := range stats {
+			variants[i] = variantReport{
+				Answer:       entry.Variants[i].Answer,
+				Weight:       entry.Variants[i].Weight,
+				HelpfulRate:  s.HelpfulRate(),
+				VariantStats: s,
+			}
+		}
+		report := map[string]interface{}{"id": id, "variants": variants}
+		if len(stats) >= 2 {
+			report["z_score"] = twoProportionZ(stats[0], stats[1])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// handleEndExperiment serves POST /entries/{id}/experiment/end, which
+// makes the winning variant the entry's answer.
+func handleEndExperiment(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := pathParam(r, "id")
+		if entry, ok := ai.KB.entryByID(id); !ok || len(entry.Variants) == 0 {
+			writeError(w, newError(ErrNotFound, "no experiment running for this entry"))
+			return
+		}
+		var req EndExperimentRequest
+		if err := decodeRequest(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := ai.KB.endExperiment(id, req.Winner); err != nil {
+			writeError(w, err)
+			return
+		}
+		ai.Experiments.reset(id)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// all copies the stats of every experiment, for snapshots.
+func (t *ExperimentTracker) all() map[string]map[int]VariantStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]map[int]VariantStats, len(t.stats))
+	for entryID, variants := range t.stats {
+		out[entryID] = make(map[int]VariantStats, len(variants))
+		for i, stats := range variants {
+			out[entryID][i] = *stats
+		}
+	}
+	return out
+}
+
+// restore replaces every experiment's stats.
+func (t *ExperimentTracker) restore(stats map[string]map[int]VariantStats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats = make(map[string]map[int]*VariantStats, len(stats))
+	for entryID, variants := range stats {
+		t.stats[entryID] = make(map[int]*VariantStats, len(variants))
+		for i, s := range variants {
+			s := s
+			t.stats[entryID][i] = &s
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestExperimentFeedbackNeedsAServedVariant(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	answer := ask(t, f.deps.ai, "What is a closure?", AskOptions{SessionID: "served"})
+	served := strconv.Itoa(answer.Variant)
+	other := strconv.Itoa(1 - answer.Variant)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"no session", `{"helpful": true}`, http.StatusBadRequest},
+		{"not served", `{"helpful": true, "session_id": "stranger"}`, http.StatusNotFound},
+		{"other variant", `{"variant": ` + other + `, "helpful": true, "session_id": "served"}`, http.StatusBadRequest},
+		{"served variant", `{"variant": ` + served + `, "helpful": true, "session_id": "served"}`, http.StatusOK},
+		{"rated again", `{"helpful": false, "session_id": "served"}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		resp, body := f.do(t, http.MethodPost, "/entries/closures/experiment", tt.body, "", nil)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, resp.StatusCode, tt.status, body)
+		}
+	}
+
+	stats := f.deps.ai.Experiments.snapshot("closures", 2)
+	if stats[answer.Variant].Helpful != 1 || stats[1-answer.Variant].Helpful != 0 {
+		t.Errorf("stats = %+v, want one helpful outcome on variant %d", stats, answer.Variant)
+	}
+}
+
+func TestExperimentTrackerForgetsOldestServes(t *testing.T) {
+	tracker := NewExperimentTracker()
+	for i := 0; i <= maxServedVariants; i++ {
+		tracker.recordServe("closures", 0, "s"+strconv.Itoa(i))
+	}
+	if _, err := tracker.recordFeedback("closures", "s0", true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("oldest serve: err = %v, want ErrNotFound", err)
+	}
+	if _, err := tracker.recordFeedback("closures", "s"+strconv.Itoa(maxServedVariants), true); err != nil {
+		t.Errorf("newest serve: %v", err)
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// faqIDPrefix marks the entries owned by the FAQ importer, so re-imports
+// can tell which entries they may tombstone.
+const faqIDPrefix = "faq-"
+
+// faqItem is one H2 question of a Markdown FAQ file.
+type faqItem struct {
+	File     string
+	Line     int
+	Question string
+	Answer   string
+}
+
+// faqID derives the identifier of a FAQ entry from its file and heading,
+// so re-importing the same heading updates the entry instead of adding
+// another.
+func faqID(file, question string) string {
+	sum := sha1.Sum([]byte(file + "\n" + strings.ToLower(strings.TrimSpace(question))))
+	return faqIDPrefix + hex.EncodeToString(sum[:])[:10]
+}
+
+// parseFAQ splits a Markdown file into questions and answers: every H2
+// heading is a question and everything up to the next H1 or H2 heading is
+// its answer, verbatim. Headings inside code fences are part of the
+// answer. Text before the first H2, such as the page title, is ignored.
+func parseFAQ(file string, data []byte) []faqItem {
+	var items []faqItem
+	var current *faqItem
+	var body []string
+	flush := func() {
+		if current != nil {
+			current.Answer = trimBlankLines(body)
+			items = append(items, *current)
+		}
+		current, body = nil, nil
+	}
+	var fence string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimLeft(text, " ")
+		indented := len(text)-len(trimmed) > 3
+		switch {
+		case fence != "":
+			if !indented && strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]+" \t") == "" {
+				fence = ""
+			}
+		case indented:
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1]))]
+		case strings.HasPrefix(trimmed, "## ") || trimmed == "##":
+			flush()
+			current = &faqItem{File: file, Line: line, Question: headingText(trimmed[2:])}
+			continue
+		case strings.HasPrefix(trimmed, "# ") || trimmed == "#":
+			flush()
+			continue
+		}
+		if current != nil {
+			body = append(body, text)
+		}
+	}
+	flush()
+	return items
+}
+
+// headingText strips the spaces and optional closing hashes of an ATX
+// heading.
+func headingText(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, " #"); i >= 0 && strings.Trim(s[i+1:], "#") == "" {
+		s = strings.TrimSpace(s[:i])
+	} else if strings.Trim(s, "#") == "" {
+		s = ""
+	}
+	return s
+}
+
+func trimBlankLines(lines []string) string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// readFAQDir parses every *.md file under dir, skipping hidden
+// directories such as .git. File paths are relative to dir, with forward
+// slashes.
+func readFAQDir(dir string) ([]faqItem, int, error) {
+	var items []faqItem
+	var files int
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.EqualFold(filepath.Ext(path), ".md") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files++
+		items = append(items, parseFAQ(filepath.ToSlash(rel), data)...)
+		return nil
+	})
+	return items, files, err
+}
+
+// FAQImportOptions controls ImportFAQ.
+type FAQImportOptions struct {
+	// DryRun reports the changes without applying them.
+	DryRun bool
+	// Tombstone quarantines FAQ entries whose heading is gone from the
+	// directory. Without it they are left as they are.
+	Tombstone bool
+}
+
+// FAQ import actions.
+const (
+	FAQAdd       = "add"
+	FAQUpdate    = "update"
+	FAQTombstone = "tombstone"
+)
+
+// FAQChange is one change an import makes, or would make on a dry run.
+type FAQChange struct {
+	Action   string `json:"action"`
+	ID       string `json:"id"`
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Question string `json:"question"`
+}
+
+// FAQImportReport is the response of /kb/import-faq.
+type FAQImportReport struct {
+	DryRun     bool `json:"dry_run"`
+	Files      int  `json:"files"`
+	Added      int  `json:"added"`
+	Updated    int  `json:"updated"`
+	Unchanged  int  `json:"unchanged"`
+	Tombstoned int  `json:"tombstoned"`
+	// Empty lists the headings skipped for having no answer, as
+	// file:line.
+	Empty   []string    `json:"empty,omitempty"`
+	Changes []FAQChange `json:"changes"`
+	// NearDuplicates pairs the entries added or updated with the entries
+	// and learned entries whose questions are nearly the same; on a dry
+	// run, only those already in the knowledge base.
+	NearDuplicates []NearDuplicate `json:"near_duplicates,omitempty"`
+}
+
+// ImportFAQ upserts the questions of a directory of Markdown FAQ files
+// into the knowledge base. Each entry is tagged with its file and keyed
+// by file and heading; an updated entry keeps its creation time, minimum
+// score, source URL and supersedes links. Tombstoned entries are quarantined rather than
+// deleted, since stores only add and replace entries, and come back if
+// their heading reappears.
+func (ai *AIEngine) ImportFAQ(dir string, opts FAQImportOptions) (FAQImportReport, error) {
+	report := FAQImportReport{DryRun: opts.DryRun, Changes: []FAQChange{}}
+	items, files, err := readFAQDir(dir)
+	if err != nil {
+		return report, newError(ErrInvalidInput, "reading %s: %v", dir, err)
+	}
+	report.Files = files
+	if files == 0 {
+		return report, newError(ErrInvalidInput, "no Markdown files under %s", dir)
+	}
+
+	now := time.Now()
+	embeddings := ai.embeddings()
+	var imported []KnowledgeEntry
+	seen := make(map[string]faqItem, len(items))
+	for _, item := range items {
+		if item.Question == "" || item.Answer == "" {
+			report.Empty = append(report.Empty, fmt.Sprintf("%s:%d", item.File, item.Line))
+			continue
+		}
+		id := faqID(item.File, item.Question)
+		if first, ok := seen[id]; ok {
+			return report, newError(ErrInvalidInput, "%s:%d: question %q repeats line %d", item.File, item.Line, item.Question, first.Line)
+		}
+		seen[id] = item
+		imported = append(imported, KnowledgeEntry{
+			ID:         id,
+			Question:   item.Question,
+			Answer:     item.Answer,
+			Tags:       []string{item.File},
+			CreatedAt:  now,
+			VerifiedAt: now,
+		})
+	}
+
+	plan := func(entries []KnowledgeEntry) []KnowledgeEntry {
+		report.Changes = report.Changes[:0]
+		report.Added, report.Updated, report.Unchanged, report.Tombstoned = 0, 0, 0, 0
+		byID := make(map[string]int, len(entries))
+		for i, entry := range entries {
+			byID[entry.ID] = i
+		}
+		for _, entry := range imported {
+			item := seen[entry.ID]
+			change := FAQChange{ID: entry.ID, File: item.File, Line: item.Line, Question: entry.Question}
+			i, ok := byID[entry.ID]
+			switch {
+			case !ok:
+				change.Action = FAQAdd
+				report.Added++
+				if !opts.DryRun {
+					entry.Vector = getSentenceVector(entry.Question, embeddings)
+					entry.Analyzer = analyzerVersion
+				}
+				entries = append(entries, entry)
+			case sameFAQEntry(entries[i], entry):
+				report.Unchanged++
+				continue
+			default:
+				change.Action = FAQUpdate
+				report.Updated++
+				if !opts.DryRun {
+					existing := entries[i]
+					entry.CreatedAt = existing.CreatedAt
+					entry.MinScore = existing.MinScore
+					entry.Weight = existing.Weight
+					entry.SourceURL = existing.SourceURL
+					entry.Supersedes = existing.Supersedes
+					entry.Vector, entry.Analyzer = existing.Vector, existing.Analyzer
+					if existing.Question != entry.Question || existing.Analyzer != analyzerVersion {
+						entry.Vector = getSentenceVector(entry.Question, embeddings)
+						entry.Analyzer = analyzerVersion
+					}
+				}
+				entries[i] = entry
+			}
+			report.Changes = append(report.Changes, change)
+		}
+		if opts.Tombstone {
+			for i, entry := range entries {
+				if !strings.HasPrefix(entry.ID, faqIDPrefix) || entry.Tombstoned {
+					continue
+				}
+				if _, ok := seen[entry.ID]; ok {
+					continue
+				}
+				var file string
+				if len(entry.Tags) > 0 {
+					file = entry.Tags[0]
+				}
+				report.Changes = append(report.Changes, FAQChange{Action: FAQTombstone, ID: entry.ID, File: file, Question: entry.Question})
+				report.Tombstoned++
+				entries[i].Tombstoned = true
+				entries[i].Quarantined = true
+			}
+		}
+		return entries
+	}
+
+	if opts.DryRun {
+		state := ai.KB.view()
+		plan(append([]KnowledgeEntry{}, state.entries...))
+		for _, change := range report.Changes {
+			if change.Action != FAQTombstone {
+				vec := state.vector(change.Question, embeddings)
+				report.NearDuplicates = append(report.NearDuplicates, state.nearDuplicates(change.ID, vec, ai.DuplicateThreshold)...)
+			}
+		}
+	} else {
+		if err := ai.KB.updateEntries("ImportFAQ", plan); err != nil {
+			return report, err
+		}
+		var changed []string
+		for _, change := range report.Changes {
+			if change.Action != FAQTombstone {
+				changed = append(changed, change.ID)
+			}
+		}
+		report.NearDuplicates = ai.KB.view().nearDuplicatesOf(changed, ai.DuplicateThreshold)
+	}
+	sort.SliceStable(report.Changes, func(i, j int) bool { return report.Changes[i].File < report.Changes[j].File })
+	return report, nil
+}
+
+// sameFAQEntry reports whether an import would leave existing as it is.
+func sameFAQEntry(existing, imported KnowledgeEntry) bool {
+	return !existing.Tombstoned && existing.Question == imported.Question &&
+		existing.Answer == imported.Answer && len(existing.Variants) == 0 &&
+		len(existing.Tags) == 1 && existing.Tags[0] == imported.Tags[0]
+}
+
+// FAQImportRequest is the body of POST /kb/import-faq. Path is a
+// directory on the server, typically a checkout of the FAQ repository.
+type FAQImportRequest struct {
+	Path      string `json:"path"`
+	DryRun    bool   `json:"dry_run"`
+	Tombstone bool   `json:"tombstone"`
+}
+
+// handleFAQImport serves POST /kb/import-faq.
+func handleFAQImport(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req FAQImportRequest
+		if err := decodeRequest(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		if req.Path == "" {
+			writeError(w, newError(ErrInvalidInput, "path is required"))
+			return
+		}
+		report, err := ai.ImportFAQ(req.Path, FAQImportOptions{DryRun: req.DryRun, Tombstone: req.Tombstone})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// runImportFAQ is the import-faq subcommand. It imports into the SQLite
+// database directly, so it should run while no server has the database
+// open, or the server's next write of those entries undoes the import.
+func runImportFAQ(args []string) {
+	fs := flag.NewFlagSet("import-faq", flag.ExitOnError)
+	dbPath := fs.String("db", "askgo.db", "SQLite database to import into")
+	dryRun := fs.Bool("dry-run", false, "report the changes without applying them")
+	tombstone := fs.Bool("tombstone", false, "quarantine FAQ entries whose heading is gone from the directory")
+	configFlags := registerConfigFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: askgo import-faq [-db file] [-dry-run] [-tombstone] [-prompts file] [-embeddings file] dir")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	config, err := configFlags.load(fs)
+	if err != nil {
+		log.Fatal("Error loading configuration: ", err)
+	}
+	embeddings, _ := loadEmbeddings(config.Embeddings, config.EmbeddingsFormat, config.embeddingsLimit())
+	ai, err := NewAIEngine(config, embeddings)
+	if err != nil {
+		log.Fatal("Error loading prompts: ", err)
+	}
+	store, err := openSQLiteStore(*dbPath)
+	if err != nil {
+		log.Fatal("Error opening database:", err)
+	}
+	defer store.Close()
+	if _, err := ai.openStore(store); err != nil {
+		log.Fatal("Error loading the knowledge store:", err)
+	}
+	report, err := ai.ImportFAQ(fs.Arg(0), FAQImportOptions{DryRun: *dryRun, Tombstone: *tombstone})
+	if err != nil {
+		log.Fatal("Error importing FAQ:", err)
+	}
+	for _, change := range report.Changes {
+		fmt.Printf("%-9s %s %s: %s\n", change.Action, change.ID, change.File, change.Question)
+	}
+	for _, location := range report.Empty {
+		fmt.Printf("skipped   %s: heading without an answer\n", location)
+	}
+	for _, d := range report.NearDuplicates {
+		fmt.Printf("near-dup  %s is %.2f similar to %s: %s\n", d.ID, d.Score, d.DuplicateOf, d.Question)
+	}
+	verb := "Imported"
+	if report.DryRun {
+		verb = "Dry run"
+	}
+	fmt.Printf("%s %d files: %d added, %d updated, %d unchanged, %d tombstoned\n",
+		verb, report.Files, report.Added, report.Updated, report.Unchanged, report.Tombstoned)
+}
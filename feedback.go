@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// feedbackZ is the z-score of the Wilson interval behind feedback scores,
+// roughly 95% confidence.
+const feedbackZ = 1.96
+
+// FeedbackScore aggregates the helpful/unhelpful votes on one entry. Score
+// is the Wilson lower bound of the helpful rate, so a few lucky votes don't
+// outrank a long good record.
+type FeedbackScore struct {
+	Helpful   int     `json:"helpful"`
+	Unhelpful int     `json:"unhelpful"`
+	Score     float64 `json:"score"`
+}
+
+// Votes is the number of votes cast.
+func (s FeedbackScore) Votes() int {
+	return s.Helpful + s.Unhelpful
+}
+
+func wilsonLowerBound(helpful, total int) float64 {
+	if helpful == 0 {
+		return 0
+	}
+	n := float64(total)
+	p := float64(helpful) / n
+	z2 := feedbackZ * feedbackZ
+	centre := p + z2/(2*n)
+	margin := feedbackZ * math.Sqrt(p*(1-p)/n+z2/(4*n*n))
+	return (centre - margin) / (1 + z2/n)
+}
+
+// maxEntryVoters bounds the voters remembered per entry. Past it an
+// arbitrary voter is forgotten for each new one, and could vote again.
+const maxEntryVoters = 10000
+
+// FeedbackScores holds the feedback aggregate of every entry that has
+// received votes. Each vote updates its entry's counts and score in place;
+// history is never replayed.
+type FeedbackScores struct {
+	mu     sync.Mutex
+	scores map[string]*FeedbackScore
+	// votes holds each voter's vote on each entry, so a voter voting again
+	// changes their vote instead of adding one. Votes are not snapshotted;
+	// after a restore every voter may vote once more.
+	votes map[string]map[string]bool
+}
+
+func NewFeedbackScores() *FeedbackScores {
+	return &FeedbackScores{scores: make(map[string]*FeedbackScore), votes: make(map[string]map[string]bool)}
+}
+
+// record counts voter's vote on entryID, replacing the voter's earlier
+// vote on it if any.
+func (f *FeedbackScores) record(entryID, voter string, helpful bool) FeedbackScore {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	score, ok := f.scores[entryID]
+	if !ok {
+		score = &FeedbackScore{}
+		f.scores[entryID] = score
+	}
+	voters := f.votes[entryID]
+	if voters == nil {
+		voters = make(map[string]bool)
+		f.votes[entryID] = voters
+	}
+	if previous, ok := voters[voter]; ok {
+		if previous == helpful {
+			return *score
+		}
+		if previous {
+			score.Helpful--
+		} else {
+			score.Unhelpful--
+		}
+	} else if len(voters) >= maxEntryVoters {
+		for other := range voters {
+			delete(voters, other)
+			break
+		}
+	}
+	voters[voter] = helpful
+	if helpful {
+		score.Helpful++
+	} else {
+		score.Unhelpful++
+	}
+	score.Score = wilsonLowerBound(score.Helpful, score.Votes())
+	return *score
+}
+
+func (f *FeedbackScores) get(entryID string) (FeedbackScore, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	score, ok := f.scores[entryID]
+	if !ok {
+		return FeedbackScore{}, false
+	}
+	return *score, true
+}
+
+// all copies the aggregates for a snapshot.
+func (f *FeedbackScores) all() map[string]FeedbackScore {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]FeedbackScore, len(f.scores))
+	for id, score := range f.scores {
+		out[id] = *score
+	}
+	return out
+}
+
+// restore replaces the aggregates with those of a snapshot, dropping
+// entries that no longer exist.
+func (f *FeedbackScores) restore(scores map[string]FeedbackScore, exists func(string) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scores = make(map[string]*FeedbackScore, len(scores))
+	f.votes = make(map[string]map[string]bool)
+	for id, score := range scores {
+		if exists(id) {
+			score := score
+			f.scores[id] = &score
+		}
+	}
+}
+
+// FeedbackPolicy decides which entries /entries/problem reports: those
+// with at least MinVotes votes scoring below Floor.
+type FeedbackPolicy struct {
+	Floor    float64
+	MinVotes int
+}
+
+func (p FeedbackPolicy) problem(score FeedbackScore) bool {
+	return score.Votes() >= p.MinVotes && score.Score < p.Floor
+}
+
+// EntryFeedback is a vote on whether an entry's answer helped.
+type EntryFeedback struct {
+	Helpful bool `json:"helpful"`
+	// SessionID identifies the voter; without it the session cookie does,
+	// and failing that the client's address.
+	SessionID string `json:"session_id"`
+}
+
+// feedbackVoter identifies who cast a vote, so each voter counts once:
+// the caller's session if sessionID or the session cookie names one,
+// else the client's address.
+func feedbackVoter(r *http.Request, sessionID string) (string, error) {
+	if sessionID == "" {
+		if cookie, err := r.Cookie(sessionCookie); err == nil && validSessionID(cookie.Value) {
+			sessionID = cookie.Value
+		}
+	}
+	switch {
+	case sessionID == "":
+		return "ip:" + clientIP(r), nil
+	case !validSessionID(sessionID):
+		return "", newError(ErrInvalidInput, "session_id must be 1 to %d letters, digits or -_.: characters", maxRequestIDLength)
+	}
+	return "session:" + sessionID, nil
+}
+
+// handleEntryFeedback serves POST /entries/{id}/feedback, one vote per
+// voter and entry; see feedbackVoter.
+func handleEntryFeedback(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := pathParam(r, "id")
+		if _, ok := ai.KB.entryByID(id); !ok {
+			writeError(w, newError(ErrNotFound, "entry %s not found", id))
+			return
+		}
+		var req EntryFeedback
+		if err := decodeRequest(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		voter, err := feedbackVoter(r, req.SessionID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		score := ai.Feedback.record(id, voter, req.Helpful)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(score)
+	}
+}
+
+// handleProblemEntries serves GET /entries/problem: the entries whose
+// feedback score is below the policy floor, worst first.
+func handleProblemEntries(ai *AIEngine, policy FeedbackPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		problems := []entryListing{}
+		for _, listing := range ai.entryListings() {
+			if listing.Feedback != nil && policy.problem(*listing.Feedback) {
+				problems = append(problems, listing)
+			}
+		}
+		sort.SliceStable(problems, func(i, j int) bool {
+			return problems[i].Feedback.Score < problems[j].Feedback.Score
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"floor":     policy.Floor,
+			"min_votes": policy.MinVotes,
+			"entries":   problems,
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFeedbackScoresCountEachVoterOnce(t *testing.T) {
+	f := NewFeedbackScores()
+	f.record("e1", "session:alice", true)
+	f.record("e1", "session:alice", true)
+	f.record("e1", "session:bob", false)
+	score := f.record("e1", "ip:192.0.2.1", true)
+	if score.Helpful != 2 || score.Unhelpful != 1 {
+		t.Fatalf("votes %+v, want 2 helpful and 1 unhelpful", score)
+	}
+
+	// A voter changing their mind moves their vote.
+	score = f.record("e1", "session:alice", false)
+	if score.Helpful != 1 || score.Unhelpful != 2 {
+		t.Fatalf("after alice changed her vote: %+v, want 1 helpful and 2 unhelpful", score)
+	}
+	if want := wilsonLowerBound(1, 3); score.Score != want {
+		t.Errorf("score %g, want %g", score.Score, want)
+	}
+	if other := f.record("e2", "session:alice", true); other.Votes() != 1 {
+		t.Errorf("alice's vote on another entry counted %d times", other.Votes())
+	}
+}
+
+func TestFeedbackScoresBoundVoters(t *testing.T) {
+	f := NewFeedbackScores()
+	for i := 0; i < maxEntryVoters+10; i++ {
+		f.record("e1", "ip:"+string(rune('a'+i%26))+strings.Repeat("x", i/26), true)
+	}
+	if n := len(f.votes["e1"]); n > maxEntryVoters {
+		t.Fatalf("%d voters remembered, want at most %d", n, maxEntryVoters)
+	}
+}
+
+func TestHandleEntryFeedbackDedupesVoters(t *testing.T) {
+	ai := newTestEngine(t)
+	vote := func(body, cookie, addr string) FeedbackScore {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/entries/channels/feedback", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r = r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, map[string]string{"id": "channels"}))
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: cookie})
+		}
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		handleEntryFeedback(ai)(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		var score FeedbackScore
+		if err := json.NewDecoder(w.Body).Decode(&score); err != nil {
+			t.Fatal(err)
+		}
+		return score
+	}
+	vote(`{"helpful": false, "session_id": "alice"}`, "", "192.0.2.1:1000")
+	vote(`{"helpful": false}`, "alice", "192.0.2.2:1000")
+	vote(`{"helpful": false}`, "", "192.0.2.3:1000")
+	score := vote(`{"helpful": false}`, "", "192.0.2.3:2000")
+	if score.Unhelpful != 2 {
+		t.Fatalf("unhelpful votes %d, want 2: one for alice's session and one for 192.0.2.3", score.Unhelpful)
+	}
+}
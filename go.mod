@@ -1,6 +1,10 @@
-
-module main
+module askgo
 
 go 1.13
 
-require github.com/jdkato/prose/v2 v2.0.0
+require (
+	github.com/jdkato/prose/v2 v2.0.0
+	github.com/mattn/go-sqlite3 v1.14.16
+	google.golang.org/grpc v1.40.1
+	google.golang.org/protobuf v1.27.1
+)
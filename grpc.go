@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"askgo/askgopb"
+)
+
+// grpcCodes translates error kinds to gRPC status codes, as errorResponses
+// does to HTTP statuses. Unlisted errors are internal.
+var grpcCodes = []struct {
+	kind error
+	code codes.Code
+}{
+	{ErrInvalidInput, codes.InvalidArgument},
+	{ErrNotFound, codes.NotFound},
+	{ErrConflict, codes.AlreadyExists},
+	{ErrGone, codes.NotFound},
+	{ErrDisabled, codes.Unavailable},
+	{ErrNoMatch, codes.NotFound},
+	{ErrNoCoverage, codes.FailedPrecondition},
+	{ErrStoreUnavailable, codes.Unavailable},
+	{ErrMethodNotAllowed, codes.Unimplemented},
+	{ErrUnauthorized, codes.Unauthenticated},
+	{ErrForbidden, codes.PermissionDenied},
+	{ErrTooLarge, codes.InvalidArgument},
+}
+
+// grpcError returns err as a gRPC status. The message of errors without a
+// kind is logged rather than sent, as writeError does.
+func grpcError(err error) error {
+	for _, c := range grpcCodes {
+		if errors.Is(err, c.kind) {
+			return status.Error(c.code, err.Error())
+		}
+	}
+	log.Printf("Internal error: %v", err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+// grpcAssistant serves the Assistant service of askgopb from the engine
+// the HTTP handlers use. Like them, it only translates: answering and
+// learning are the engine's.
+type grpcAssistant struct {
+	askgopb.UnimplementedAssistantServer
+	ai             *AIEngine
+	admin          AdminAuth
+	tenants        TenantKeys
+	streamInterval time.Duration
+}
+
+// metadataValue returns the first value of key in the metadata of ctx.
+func metadataValue(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// ask answers question as handleAI does, footer included.
+func (s *grpcAssistant) ask(ctx context.Context, question *askgopb.Question) (*askgopb.Answer, error) {
+	if !validStyle(question.Style) {
+		return nil, status.Error(codes.InvalidArgument, `style must be "concise", "normal" or "detailed"`)
+	}
+	if question.SessionId != "" && !validSessionID(question.SessionId) {
+		return nil, status.Errorf(codes.InvalidArgument, "session_id must be 1 to %d letters, digits or -_.: characters", maxRequestIDLength)
+	}
+	result, err := s.ai.AskContext(ctx, question.Text, AskOptions{
+		SessionID: question.SessionId,
+		Scope:     question.Scope,
+		Style:     question.Style,
+		Tenant:    s.tenants.tenant(metadataValue(ctx, "x-api-key")),
+	})
+	// A fallback is still an answer; only refuse malformed questions.
+	if errors.Is(err, ErrInvalidInput) || errors.Is(err, ErrTooLarge) {
+		return nil, grpcError(err)
+	}
+	text := result.Text
+	if s.ai.Attribution.enabled(nil) {
+		text = s.ai.Attribution.apply(result)
+	}
+	return &askgopb.Answer{Text: text, Confidence: result.Confidence, Source: result.Source}, nil
+}
+
+func (s *grpcAssistant) Ask(ctx context.Context, question *askgopb.Question) (*askgopb.Answer, error) {
+	return s.ask(ctx, question)
+}
+
+// AskStream sends the answer a word at a time, streamInterval apart, as
+// streamAnswer does for server-sent events.
+func (s *grpcAssistant) AskStream(question *askgopb.Question, stream askgopb.Assistant_AskStreamServer) error {
+	ctx := stream.Context()
+	answer, err := s.ask(ctx, question)
+	if err != nil {
+		return err
+	}
+	var timer *time.Timer
+	for i, chunk := range answerChunks(answer.Text) {
+		if i > 0 && s.streamInterval > 0 {
+			if timer == nil {
+				timer = time.NewTimer(s.streamInterval)
+				defer timer.Stop()
+			} else {
+				timer.Reset(s.streamInterval)
+			}
+			select {
+			case <-ctx.Done():
+				return status.FromContextError(ctx.Err()).Err()
+			case <-timer.C:
+			}
+		}
+		if err := stream.Send(&askgopb.AnswerChunk{Text: chunk}); err != nil {
+			return err
+		}
+	}
+	return stream.Send(&askgopb.AnswerChunk{Answer: answer})
+}
+
+// Learn teaches an answer as handleLearn does, behind the same admin keys
+// and kill switch.
+func (s *grpcAssistant) Learn(ctx context.Context, qa *askgopb.QA) (*askgopb.Learned, error) {
+	if err := s.admin.checkAuthorization(metadataValue(ctx, "authorization")); err != nil {
+		return nil, grpcError(err)
+	}
+	if !s.ai.Switches.Enabled(SwitchLearning, s.tenants.tenant(metadataValue(ctx, "x-api-key"))) {
+		return nil, status.Error(codes.Unavailable, "disabled by an operator kill switch")
+	}
+	if qa.Question == "" || qa.Answer == "" {
+		return nil, status.Error(codes.InvalidArgument, "question and answer are required")
+	}
+	if err := s.ai.Limits.checkLearnQuestion(qa.Question); err != nil {
+		return nil, grpcError(err)
+	}
+	entry := LearnedEntry{Question: qa.Question, Answer: qa.Answer, SourceURL: qa.SourceUrl}
+	if existing, err := s.ai.KB.learnChecked(entry, qa.Overwrite); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return nil, status.Errorf(codes.AlreadyExists, "%v (existing answer to %q: %q)", err, existing.Question, existing.Answer)
+		}
+		return nil, grpcError(err)
+	}
+	return &askgopb.Learned{}, nil
+}
+
+// newGRPCServer returns a server of the Assistant service, with reflection
+// so tools such as grpcurl can list and call it. With certs set it serves
+// TLS with the HTTP server's certificate.
+func newGRPCServer(assistant *grpcAssistant, certs *certReloader) *grpc.Server {
+	var opts []grpc.ServerOption
+	if certs != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{GetCertificate: certs.GetCertificate})))
+	}
+	server := grpc.NewServer(opts...)
+	askgopb.RegisterAssistantServer(server, assistant)
+	reflection.Register(server)
+	return server
+}
+
+// serveGRPC returns a listener for serveUntilSignal that serves server on
+// addr. Stopping server ends it as closing an HTTP server would.
+func serveGRPC(server *grpc.Server, addr string) func() error {
+	return func() error {
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		if err := server.Serve(lis); err != nil {
+			return err
+		}
+		return http.ErrServerClosed
+	}
+}
+
+// drainGRPC stops server, waiting at most timeout for the calls in flight,
+// streams included, to finish before cutting them off.
+func drainGRPC(server *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		log.Printf("gRPC calls still running when the shutdown deadline passed")
+		server.Stop()
+	}
+}
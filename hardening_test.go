@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// emptyEngine builds an engine from a prompt.json of "{}" and no
+// embeddings.
+func emptyEngine(t *testing.T) *AIEngine {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "askgo-empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prompt.json")
+	if err := ioutil.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ai, err := NewAIEngine(Config{Prompts: path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ai
+}
+
+// sensible reports whether an answer is fit to send: non-empty, valid
+// UTF-8, and free of unfilled format verbs.
+func sensible(answer string) bool {
+	return strings.TrimSpace(answer) != "" && utf8.ValidString(answer) && !strings.Contains(answer, "%!")
+}
+
+// TestEmptyConfigAnswersEveryBranch asks an engine built from nothing
+// down every branch and expects a sensible answer from each.
+func TestEmptyConfigAnswersEveryBranch(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(ai *AIEngine)
+		asks  []string
+	}{
+		{name: "greeting", asks: []string{"hello"}},
+		{name: "common question", asks: []string{"who are you"}},
+		{name: "keywords default", asks: []string{"How do channels work?"}},
+		{name: "default response", asks: []string{"what about it"}},
+		{name: "empty question", asks: []string{"  "}},
+		{name: "question too large", asks: []string{strings.Repeat("go ", 10000)}},
+		{
+			name:  "learned",
+			setup: func(ai *AIEngine) { ai.KB.Learn("How do I close a channel?", "The sender calls close.") },
+			asks:  []string{"How do I close a channel?"},
+		},
+		{
+			name:  "context",
+			setup: func(ai *AIEngine) { ai.KB.Learn("How do I close a channel?", "The sender calls close.") },
+			asks:  []string{"How do I close a channel?", "How do I close a channel?"},
+		},
+		{
+			name: "stale learned",
+			setup: func(ai *AIEngine) {
+				ai.KB.Learn("How do I close a channel?", "The sender calls close.")
+				ai.Verification.MaxAge = time.Nanosecond
+			},
+			asks: []string{"How do I close a channel?"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ai := emptyEngine(t)
+			if tt.setup != nil {
+				tt.setup(ai)
+			}
+			for _, question := range tt.asks {
+				if answer := ai.GenerateAnswer(question, "empty"); !sensible(answer) {
+					t.Errorf("%.30q answered %q", question, answer)
+				}
+				answer, _ := ai.Ask(question, AskOptions{SessionID: "empty-ask", Scope: "nowhere", Language: "xx"})
+				if !sensible(answer.Text) {
+					t.Errorf("%.30q in an unknown scope answered %q", question, answer.Text)
+				}
+			}
+		})
+	}
+	// A zero PromptConfig built in code, not loaded, answers too.
+	ai := newAIEngine(&PromptConfig{}, nil)
+	if answer := ai.GenerateAnswer("How do channels work?", ""); !sensible(answer) {
+		t.Errorf("zero config answered %q", answer)
+	}
+}
+
+// TestRandomUnicodeAnswers asks random strings of runes from across the
+// Unicode range, both of an empty engine and a configured one.
+func TestRandomUnicodeAnswers(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	engines := map[string]*AIEngine{"empty": emptyEngine(t), "configured": newTestEngine(t)}
+	for round := 0; round < 200; round++ {
+		runes := make([]rune, rng.Intn(64))
+		for i := range runes {
+			switch rng.Intn(4) {
+			case 0:
+				runes[i] = rune(' ' + rng.Intn(95))
+			case 1:
+				runes[i] = rune(rng.Intn(0x3000))
+			default:
+				runes[i] = rune(rng.Intn(utf8.MaxRune + 1))
+			}
+		}
+		question := string(runes)
+		for name, ai := range engines {
+			if answer := ai.GenerateAnswer(question, "fuzz"); !sensible(answer) {
+				t.Errorf("%s engine answered %+q with %q", name, question, answer)
+			}
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testEntries is the knowledge base tests run against unless they bring
+// their own.
+var testEntries = []KnowledgeEntry{
+	{ID: "goroutines", Question: "What is a goroutine?", Answer: "A goroutine is a lightweight thread managed by the Go runtime."},
+	{ID: "channels", Question: "How do channels work?", Answer: "Channels connect goroutines so they can send and receive values."},
+	{ID: "errors", Question: "How are errors handled in Go?", Answer: "Functions return an error value that callers check explicitly."},
+}
+
+// testSetup is what newTestEngine builds an engine from.
+type testSetup struct {
+	prompts    PromptConfig
+	embeddings map[string][]float64
+}
+
+type testOption func(*testSetup)
+
+// withEntries replaces the knowledge base.
+func withEntries(entries ...KnowledgeEntry) testOption {
+	return func(s *testSetup) { s.prompts.KnowledgeBase = append([]KnowledgeEntry(nil), entries...) }
+}
+
+// withEmbeddings adds to, or overrides, the toy embeddings derived from
+// the knowledge base.
+func withEmbeddings(embeddings map[string][]float64) testOption {
+	return func(s *testSetup) {
+		if s.embeddings == nil {
+			s.embeddings = make(map[string][]float64)
+		}
+		for word, vec := range embeddings {
+			s.embeddings[word] = vec
+		}
+	}
+}
+
+// withPrompts edits the prompt configuration before the engine is built.
+func withPrompts(edit func(*PromptConfig)) testOption {
+	return func(s *testSetup) { edit(&s.prompts) }
+}
+
+// newTestEngine builds an engine over testEntries, or the entries of
+// opts, with the toy embeddings askgo init derives from them, so answers
+// are deterministic and no files are read.
+func newTestEngine(t testing.TB, opts ...testOption) *AIEngine {
+	t.Helper()
+	s := &testSetup{prompts: PromptConfig{
+		Greetings:       map[string]string{"hello": "Hi! Ask me about Go."},
+		CommonQuestions: map[string]string{"who are you": "I answer questions about Go."},
+		KnowledgeBase:   append([]KnowledgeEntry(nil), testEntries...),
+		DefaultResponses: map[string]string{
+			"unknown": "I don't know that yet.",
+		},
+	}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	// Backfill timestamps as loadPrompts does.
+	now := time.Now()
+	for i := range s.prompts.KnowledgeBase {
+		if s.prompts.KnowledgeBase[i].CreatedAt.IsZero() {
+			s.prompts.KnowledgeBase[i].CreatedAt, s.prompts.KnowledgeBase[i].VerifiedAt = now, now
+		}
+	}
+	vectors := toyEmbeddings(s.prompts.KnowledgeBase)
+	for word, vec := range s.embeddings {
+		vectors[word] = vec
+	}
+	store := newEmbeddingStore(len(vectors))
+	for word, vec := range vectors {
+		v := make([]float32, len(vec))
+		for i, x := range vec {
+			v[i] = float32(x)
+		}
+		if err := store.add(word, v); err != nil {
+			t.Fatalf("embedding %q: %v", word, err)
+		}
+	}
+	ai := newAIEngine(&s.prompts, store)
+	ai.rng = rand.New(rand.NewSource(1))
+	return ai
+}
+
+// ask asks ai question with opts, failing the test on error.
+func ask(t testing.TB, ai *AIEngine, question string, opts AskOptions) Answer {
+	t.Helper()
+	answer, err := ai.Ask(question, opts)
+	if err != nil {
+		t.Fatalf("Ask(%q): %v", question, err)
+	}
+	return answer
+}
+
+// assertAnswerSource fails the test unless answer came from source.
+func assertAnswerSource(t testing.TB, answer Answer, source string) {
+	t.Helper()
+	if answer.Source != source {
+		t.Fatalf("answer %q came from %s, want %s", answer.Text, answer.Source, source)
+	}
+}
+
+func TestEngineAnswersFromEachSource(t *testing.T) {
+	ai := newTestEngine(t)
+	tests := []struct {
+		question string
+		source   string
+	}{
+		{"hello", SourceGreeting},
+		{"who are you", SourceCommonQuestion},
+		{"What is a goroutine?", SourceKnowledgeBase},
+		{"how do channels work", SourceKnowledgeBase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.question, func(t *testing.T) {
+			assertAnswerSource(t, ask(t, ai, tt.question, AskOptions{}), tt.source)
+		})
+	}
+}
+
+func TestEngineRejectsUnknownWords(t *testing.T) {
+	ai := newTestEngine(t)
+	answer, err := ai.Ask("zebra quantum marmalade", AskOptions{})
+	if !errors.Is(err, ErrNoCoverage) {
+		t.Fatalf("err = %v, want ErrNoCoverage", err)
+	}
+	assertAnswerSource(t, answer, SourceDefault)
+}
+
+// TestConcurrentAsk answers from many goroutines at once, sharing
+// sessions, while the pattern report and session context are read. Every
+// learned answer reinforces Patterns and is appended to a session's history,
+// which concurrent requests used to write without a lock, crashing with
+// a concurrent map write. Run it with -race.
+func TestConcurrentAsk(t *testing.T) {
+	ai := newTestEngine(t)
+	ai.MaxSessions = 4
+	// Analyzing each question once keeps the test quick under -race.
+	ai.QueryCache = newQueryCache(16)
+	ai.KB.Learn("How do I stop a goroutine?", "Cancel its context.")
+	questions := []string{"How do I stop a goroutine?", "What is a goroutine?", "hello", "How do channels work?"}
+	for _, question := range questions {
+		ai.GenerateAnswer(question, "")
+	}
+	const goroutines, rounds = 8, 12
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				// Some questions come without a session, which folds
+				// their reinforcement into Patterns at once.
+				session := ""
+				if g%4 != 0 {
+					session = fmt.Sprint("session-", (g+i)%6)
+				}
+				if text := ai.GenerateAnswer(questions[(g+i)%len(questions)], session); text == "" {
+					t.Errorf("empty answer in round %d", i)
+					return
+				}
+				if i%4 == 0 {
+					ai.patternReport(time.Now())
+					ai.evaluateContext([]string{"goroutine"}, session)
+					ai.findSimilarInteraction([]string{"goroutine"}, session)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	report := ai.patternReport(time.Now())
+	if report.Active > ai.MaxSessions {
+		t.Errorf("%d sessions active, want at most %d", report.Active, ai.MaxSessions)
+	}
+	remembered := 0
+	for _, s := range report.Sessions {
+		remembered += s.History
+	}
+	if remembered == 0 || report.Stats.Folded == 0 {
+		t.Errorf("no learned answer was remembered: %+v", report)
+	}
+}
+
+// evalSet is the fixture question set of the regression eval: a knowledge
+// base, what was learned on top of it, and questions with the source and
+// entry they must be answered from.
+type evalSet struct {
+	Entries []KnowledgeEntry `json:"entries"`
+	Learned []LearnedEntry   `json:"learned"`
+	// Baseline is the share of cases answered right when the set was
+	// recorded; the eval fails below it.
+	Baseline float64 `json:"baseline"`
+	Cases    []struct {
+		Question string `json:"question"`
+		// Session, if set, is the session the question is asked in.
+		// Cases are asked in order, so earlier ones in the session are
+		// its history.
+		Session string `json:"session"`
+		Source  string `json:"source"`
+		EntryID string `json:"entry_id"`
+	} `json:"cases"`
+}
+
+// TestRegressionEval asks every question of testdata/eval/questions.json
+// with the default calibration and fails if fewer are answered from the
+// right source and entry than the recorded baseline. Run it with -v for
+// the misses.
+func TestRegressionEval(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "eval", "questions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var set evalSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		t.Fatal(err)
+	}
+	ai := newTestEngine(t, withEntries(set.Entries...))
+	for _, entry := range set.Learned {
+		ai.KB.Learn(entry.Question, entry.Answer)
+	}
+	right := 0
+	for _, c := range set.Cases {
+		answer, err := ai.Ask(c.Question, AskOptions{SessionID: c.Session})
+		if err != nil && !errors.Is(err, ErrNoCoverage) {
+			t.Fatalf("Ask(%q): %v", c.Question, err)
+		}
+		if answer.Source != c.Source || c.EntryID != "" && answer.EntryID != c.EntryID {
+			t.Logf("miss: %q answered from %s %s, want %s %s", c.Question, answer.Source, answer.EntryID, c.Source, c.EntryID)
+			continue
+		}
+		right++
+	}
+	accuracy := float64(right) / float64(len(set.Cases))
+	t.Logf("%d of %d answered right (%.2f); baseline %.2f", right, len(set.Cases), accuracy, set.Baseline)
+	if accuracy < set.Baseline {
+		t.Errorf("accuracy %.2f fell below the baseline %.2f", accuracy, set.Baseline)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Span is a byte range [Start, End) into an answer string.
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type wordToken struct {
+	Text  string
+	Start int
+	End   int
+	// Stem is the stemWord of Text, what words are matched on.
+	Stem string
+	// Vector is the word vector of Text or its stem, if any; see
+	// withVectors.
+	Vector []float32
+}
+
+// synonymSimilarity is how close the word vectors of two words must be for
+// one to highlight the other, as "coroutine" may "goroutine".
+const synonymSimilarity = 0.8
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// splitWords returns the words of s together with their byte offsets, so
+// matches found on the words map back onto the original string. Han and
+// Kana, which carry no spaces, are one word per character, so a CJK
+// keyword matches as a run of them wherever tokenize segmented it.
+func splitWords(s string) []wordToken {
+	var words []wordToken
+	start := -1
+	add := func(start, end int) {
+		words = append(words, wordToken{Text: s[start:end], Start: start, End: end, Stem: stemWord(s[start:end])})
+	}
+	for i, r := range s {
+		if isCJK(r) {
+			if start >= 0 {
+				add(start, i)
+				start = -1
+			}
+			add(i, i+utf8.RuneLen(r))
+			continue
+		}
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			add(start, i)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		add(start, len(s))
+	}
+	return words
+}
+
+// withVectors looks up the word vectors of words as questions are
+// vectorized for matching: the word's own, else that of its stem or of a
+// vocabulary word sharing it. Vectors synthesized from subwords are left
+// out; they make words alike in spelling, not meaning.
+func withVectors(words []wordToken, embeddings *EmbeddingStore, vocab *vocabIndex) []wordToken {
+	if embeddings.Len() == 0 {
+		return words
+	}
+	for i := range words {
+		if v, found := vocab.lookup(strings.ToLower(words[i].Text), embeddings); found == vocabExact || found == vocabStem {
+			words[i].Vector = v
+		}
+	}
+	return words
+}
+
+// sameWord reports whether two words match: they share a stem, as
+// "channel" and "channels" do, are equal but for case, or are synonyms,
+// their word vectors at least synonymSimilarity alike.
+func sameWord(a, b wordToken) bool {
+	if a.Stem == b.Stem || strings.EqualFold(a.Text, b.Text) {
+		return true
+	}
+	return a.Vector != nil && b.Vector != nil && cosineSimilarity(a.Vector, b.Vector) >= synonymSimilarity
+}
+
+// findHighlights locates occurrences of the keywords in the answer,
+// ignoring case and inflection as the analyzer does, and synonyms by the
+// word vectors of embeddings, which may be nil, and vocab. It returns
+// them as merged, sorted byte spans.
+func findHighlights(answer string, keywords []string, embeddings *EmbeddingStore, vocab *vocabIndex) []Span {
+	words := withVectors(splitWords(answer), embeddings, vocab)
+	var spans []Span
+	for _, keyword := range keywords {
+		parts := withVectors(splitWords(keyword), embeddings, vocab)
+		if len(parts) == 0 {
+			continue
+		}
+		for i := 0; i+len(parts) <= len(words); i++ {
+			matched := true
+			for j, part := range parts {
+				if !sameWord(words[i+j], part) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				spans = append(spans, Span{Start: words[i].Start, End: words[i+len(parts)-1].End})
+			}
+		}
+	}
+	return mergeSpans(spans)
+}
+
+func mergeSpans(spans []Span) []Span {
+	if len(spans) == 0 {
+		return nil
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	merged := []Span{spans[0]}
+	for _, span := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if span.Start <= last.End {
+			if span.End > last.End {
+				last.End = span.End
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestFindHighlights(t *testing.T) {
+	// Synonyms are words whose vectors are alike; "thread" is not one of
+	// "goroutine".
+	vectors := map[string][]float32{
+		"goroutine": {1, 0, 0},
+		"coroutine": {0.95, 0.1, 0},
+		"thread":    {0, 1, 0},
+		"kanäle":    {0, 0, 1},
+		"röhren":    {0, 0.1, 0.95},
+	}
+	embeddings := newEmbeddingStore(len(vectors))
+	for word, vec := range vectors {
+		if err := embeddings.add(word, vec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vocab := buildVocabIndex(embeddings)
+	tests := []struct {
+		name     string
+		answer   string
+		keywords []string
+		want     []string
+	}{
+		{"case", "Channels connect goroutines.", []string{"channels"}, []string{"Channels"}},
+		{"plural keyword", "A channel connects goroutines.", []string{"channels", "goroutine"}, []string{"channel", "goroutines"}},
+		{"inflected verb", "Closing a closed channel panics.", []string{"close"}, []string{"Closing", "closed"}},
+		{"phrase", "Use a buffered channel here.", []string{"buffered channels"}, []string{"buffered channel"}},
+		{"no partial words", "Channelling is not a channel.", []string{"chan"}, nil},
+		{"multibyte", "Über die Kanäle: ÜBER Goroutinen.", []string{"über", "kanäle"}, []string{"Über", "Kanäle", "ÜBER"}},
+		{"after multibyte", "«Héllo» naïve channels", []string{"channel"}, []string{"channels"}},
+		{"cjk", "使用通道在协程之间通信", []string{"通道"}, []string{"通道"}},
+		{"synonym", "A coroutine is like a goroutine.", []string{"goroutine"}, []string{"coroutine", "goroutine"}},
+		{"synonym by stem", "Coroutines resemble goroutines.", []string{"goroutines"}, []string{"Coroutines", "goroutines"}},
+		{"not a synonym", "A thread is not a goroutine.", []string{"goroutine"}, []string{"goroutine"}},
+		{"multibyte synonym", "Röhren, also Kanäle.", []string{"kanäle"}, []string{"Röhren", "Kanäle"}},
+		{"synonym in phrase", "Start a new coroutine now.", []string{"new goroutine"}, []string{"new coroutine"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spans := findHighlights(tt.answer, tt.keywords, embeddings, vocab)
+			var got []string
+			for _, span := range spans {
+				got = append(got, tt.answer[span.Start:span.End])
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("highlights %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("highlights %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import "math"
+
+// idfTable weights words by their inverse document frequency over the
+// knowledge base and learned questions, so words most questions share,
+// like "how" or "do", count for less in sentence vectors than the words
+// that tell questions apart. A nil table weights every word 1.
+//
+// Each write that changes questions updates the table, so the words of
+// new questions are weighted by counts that include them. Re-weighting
+// every other vector along would make each write re-vectorize every
+// question, so vectors computed with an earlier table are kept until
+// more than one question in idfRebuildShare has changed since.
+type idfTable struct {
+	docs int
+	// df counts, by word stem, the questions containing it.
+	df map[string]int
+}
+
+// idfRebuildShare is the inverse of the share of questions that may
+// change before every vector is re-weighted with the current table.
+const idfRebuildShare = 10
+
+// with returns t counting the added questions too, and no longer the
+// removed ones, reading only those. t, which may be nil for a table of
+// no questions, is not modified.
+func (t *idfTable) with(added, removed []string, embeddings *EmbeddingStore) *idfTable {
+	if t != nil && len(added)+len(removed) == 0 {
+		return t
+	}
+	next := &idfTable{df: make(map[string]int)}
+	if t != nil {
+		next.docs = t.docs
+		for stem, n := range t.df {
+			next.df[stem] = n
+		}
+	}
+	for _, question := range removed {
+		next.docs--
+		for stem := range questionStems(question, embeddings) {
+			if next.df[stem]--; next.df[stem] <= 0 {
+				delete(next.df, stem)
+			}
+		}
+	}
+	for _, question := range added {
+		next.docs++
+		for stem := range questionStems(question, embeddings) {
+			next.df[stem]++
+		}
+	}
+	return next
+}
+
+// questionStems returns the distinct word stems of question.
+func questionStems(question string, embeddings *EmbeddingStore) map[string]bool {
+	stems := make(map[string]bool)
+	for _, word := range tokenize(question, embeddings) {
+		stems[stemWord(word)] = true
+	}
+	return stems
+}
+
+// weight returns the weight of word's stem, smoothed as
+// ln((1+N)/(1+df)) + 1 so that a word in every question still counts
+// once. Words no question contains get the weight of the rarest possible
+// word.
+func (t *idfTable) weight(word string) float64 {
+	if t == nil {
+		return 1
+	}
+	return math.Log(float64(1+t.docs)/float64(1+t.df[stemWord(word)])) + 1
+}
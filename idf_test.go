@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestIDFFavorsContentWords(t *testing.T) {
+	// Most questions share their words with the query; the one sharing
+	// its content word shares few others.
+	entries := []KnowledgeEntry{{ID: "channels", Question: "How do channels work?", Answer: "Channels pass values."}}
+	verbs := []string{"install", "test", "format", "vet", "build", "run", "profile", "debug", "deploy", "document"}
+	for _, verb := range verbs {
+		entries = append(entries, KnowledgeEntry{ID: verb, Question: "How do I use it to " + verb + " in Go?", Answer: "Use the go tool."})
+	}
+	// Orthogonal word vectors keep chance similarities between toy
+	// vectors out of the scores.
+	oneHot := make(map[string][]float64)
+	words := append([]string{"how", "do", "i", "use", "it", "to", "in", "go", "channels", "work"}, verbs...)
+	for i, word := range words {
+		vec := make([]float64, toyDimension)
+		vec[i] = 1
+		oneHot[word], oneHot[word+"?"] = vec, vec
+	}
+	ai := newTestEngine(t, withEntries(entries...), withEmbeddings(oneHot))
+	ai.KB.setStopWords(nil)
+	ai.KB.setIDFWeighting(false)
+	query := "how do i use channels in go"
+	if best := ai.KB.FindBestMatch(query, ai.Embeddings); best.Entry.ID == "channels" {
+		t.Fatalf("unweighted, %q already matched channels; the test shows nothing", query)
+	}
+	ai.KB.setIDFWeighting(true)
+	if best := ai.KB.FindBestMatch(query, ai.Embeddings); best.Entry.ID != "channels" {
+		t.Errorf("weighted, %q matched %s, want channels", query, best.Entry.ID)
+	}
+}
+
+// TestIDFCountsAddedEntries weights the words of an added entry by a
+// table that counts it.
+func TestIDFCountsAddedEntries(t *testing.T) {
+	// Enough questions that one more doesn't re-weight every vector.
+	entries := topicEntries(50)
+	ai := newTestEngine(t, withEntries(entries...), withEmbeddings(map[string][]float64{"mutex": toyVector("mutex")}))
+	ai.KB.setIDFWeighting(true)
+	docs := len(entries)
+	rarest := math.Log(float64(1+docs)) + 1
+	if w := ai.KB.view().idf.weight("mutex"); w != rarest {
+		t.Fatalf("weight of an unseen word %v, want %v", w, rarest)
+	}
+	ai.KB.AddEntry("When is a mutex needed?", "When goroutines share memory.", ai.Embeddings)
+	idf := ai.KB.view().idf
+	if idf.docs != docs+1 {
+		t.Errorf("table counts %d questions, want %d", idf.docs, docs+1)
+	}
+	if w, want := idf.weight("mutex"), math.Log(float64(2+docs)/2)+1; math.Abs(w-want) > 1e-12 {
+		t.Errorf("weight of mutex %v, want %v", w, want)
+	}
+	ai.KB.Learn("Is a mutex fair?", "Mostly.")
+	if w, want := ai.KB.view().idf.weight("mutex"), math.Log(float64(3+docs)/3)+1; math.Abs(w-want) > 1e-12 {
+		t.Errorf("after learning, weight of mutex %v, want %v", w, want)
+	}
+	ai.KB.forgetLearned(normalizeQuestion("Is a mutex fair?"))
+	if w, want := ai.KB.view().idf.weight("mutex"), math.Log(float64(2+docs)/2)+1; math.Abs(w-want) > 1e-12 {
+		t.Errorf("after forgetting, weight of mutex %v, want %v", w, want)
+	}
+}
+
+// TestIDFReweighsWhenStale updates the table on every write but keeps the
+// vectors of unchanged entries until a tenth of the questions changed.
+func TestIDFReweighsWhenStale(t *testing.T) {
+	entries := topicEntries(50)
+	ai := newTestEngine(t, withEntries(entries...))
+	ai.KB.setIDFWeighting(true)
+	first := ai.KB.view()
+	for i := 0; i < first.idf.docs/idfRebuildShare; i++ {
+		ai.KB.AddEntry(fmt.Sprintf("Is topic %d new?", i), "Yes.", ai.Embeddings)
+		state := ai.KB.view()
+		if state.idf.docs != first.idf.docs+i+1 {
+			t.Fatalf("table counts %d questions after %d were added to %d", state.idf.docs, i+1, first.idf.docs)
+		}
+		if !sameVector(state.entries[0].Vector, first.entries[0].Vector) {
+			t.Fatalf("unchanged entry re-weighted after %d changes", i+1)
+		}
+	}
+	ai.KB.AddEntry("Is the table stale?", "Now it is.", ai.Embeddings)
+	if state := ai.KB.view(); sameVector(state.entries[0].Vector, first.entries[0].Vector) {
+		t.Errorf("entries not re-weighted after %d of %d questions changed", len(state.entries)-len(entries), len(entries))
+	}
+}
+
+// topicEntries returns n entries asking about numbered topics.
+func topicEntries(n int) []KnowledgeEntry {
+	var entries []KnowledgeEntry
+	for i := 0; i < n; i++ {
+		entries = append(entries, KnowledgeEntry{ID: fmt.Sprint("topic-", i), Question: fmt.Sprintf("What is topic %d?", i), Answer: "A topic."})
+	}
+	return entries
+}
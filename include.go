@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// promptInclude is the layout of a file listed under "include" in
+// prompt.json. Included files contribute content only; their own includes
+// are not followed.
+type promptInclude struct {
+	Greetings        map[string]string `json:"greetings"`
+	CommonQuestions  map[string]string `json:"common_questions"`
+	KnowledgeBase    []promptEntry     `json:"knowledge_base"`
+	DefaultResponses map[string]string `json:"default_responses"`
+}
+
+var includeSchema = buildSchema(reflect.TypeOf(promptInclude{}))
+
+// includePaths expands the include list. Entries are paths or globs,
+// relative to dir unless absolute; globs expand in lexical order, while a
+// plain path must exist.
+func includePaths(dir string, patterns []string) ([]string, error) {
+	var paths []string
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include %q: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			if !strings.ContainsAny(pattern, "*?[") {
+				return nil, fmt.Errorf("include %q: no such file", pattern)
+			}
+			log.Printf("prompt.json: include %q matches no files", pattern)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// loadInclude reads and validates one included file, writing its content
+// to digest.
+func loadInclude(path string, digest io.Writer) (promptInclude, error) {
+	var include promptInclude
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return include, err
+	}
+	digest.Write(data)
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return include, fmt.Errorf("%s: %v", path, err)
+	}
+	if errs := validateSchema(raw, includeSchema, ""); len(errs) > 0 {
+		for _, err := range errs {
+			log.Printf("%s: %v", path, err)
+		}
+		return include, fmt.Errorf("%s: %d schema violations", path, len(errs))
+	}
+	if err := json.Unmarshal(data, &include); err != nil {
+		return include, fmt.Errorf("%s: %v", path, err)
+	}
+	return include, nil
+}
+
+// PromptFile is what one file of the prompt configuration contributed to
+// the merged view: the IDs of its knowledge base entries and the keys of
+// its other sections that no later file overrode.
+type PromptFile struct {
+	Path             string   `json:"path"`
+	Entries          []string `json:"entries"`
+	Greetings        []string `json:"greetings,omitempty"`
+	CommonQuestions  []string `json:"common_questions,omitempty"`
+	DefaultResponses []string `json:"default_responses,omitempty"`
+}
+
+// promptMerge merges included files into prompt.json content. Files are
+// applied in order and later files win on conflicting keys; every
+// override is logged with the file it replaced.
+type promptMerge struct {
+	config *promptFile
+	origin map[string]string
+}
+
+// mergeIncludes merges the files included by the prompt file at path and
+// returns what each file, the prompt file first, contributed.
+func mergeIncludes(config *promptFile, path string, digest io.Writer) ([]PromptFile, error) {
+	paths, err := includePaths(filepath.Dir(path), config.Include)
+	if err != nil {
+		return nil, err
+	}
+	own := promptInclude{
+		Greetings:        config.Greetings,
+		CommonQuestions:  config.CommonQuestions,
+		KnowledgeBase:    config.KnowledgeBase,
+		DefaultResponses: config.DefaultResponses,
+	}
+	config.Greetings, config.CommonQuestions, config.KnowledgeBase, config.DefaultResponses = nil, nil, nil, nil
+	m := promptMerge{config: config, origin: make(map[string]string)}
+	m.add(path, own)
+	for _, path := range paths {
+		include, err := loadInclude(path, digest)
+		if err != nil {
+			return nil, err
+		}
+		m.add(path, include)
+	}
+	return m.files(append([]string{path}, paths...)), nil
+}
+
+// files breaks the merged view down by the file each key came from.
+func (m *promptMerge) files(paths []string) []PromptFile {
+	files := make([]PromptFile, len(paths))
+	index := make(map[string]int, len(paths))
+	for i, path := range paths {
+		files[i] = PromptFile{Path: path, Entries: []string{}}
+		index[path] = i
+	}
+	keys := make([]string, 0, len(m.origin))
+	for key := range m.origin {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f := &files[index[m.origin[key]]]
+		parts := strings.SplitN(key, "/", 2)
+		switch parts[0] {
+		case "knowledge_base":
+			f.Entries = append(f.Entries, parts[1])
+		case "greetings":
+			f.Greetings = append(f.Greetings, parts[1])
+		case "common_questions":
+			f.CommonQuestions = append(f.CommonQuestions, parts[1])
+		case "default_responses":
+			f.DefaultResponses = append(f.DefaultResponses, parts[1])
+		}
+	}
+	return files
+}
+
+func (m *promptMerge) add(file string, include promptInclude) {
+	c := m.config
+	c.Greetings = m.mergeMap(file, "greetings", c.Greetings, include.Greetings)
+	c.CommonQuestions = m.mergeMap(file, "common_questions", c.CommonQuestions, include.CommonQuestions)
+	c.DefaultResponses = m.mergeMap(file, "default_responses", c.DefaultResponses, include.DefaultResponses)
+	for _, entry := range include.KnowledgeBase {
+		id := promptEntryID(entry)
+		key := "knowledge_base/" + id
+		if prev, ok := m.origin[key]; ok {
+			log.Printf("prompt.json: %s overrides knowledge base entry %s from %s", file, id, prev)
+			for i := range c.KnowledgeBase {
+				if promptEntryID(c.KnowledgeBase[i]) == id {
+					c.KnowledgeBase[i] = entry
+				}
+			}
+		} else {
+			c.KnowledgeBase = append(c.KnowledgeBase, entry)
+		}
+		m.origin[key] = file
+	}
+}
+
+func (m *promptMerge) mergeMap(file, section string, dst, src map[string]string) map[string]string {
+	if len(src) > 0 && dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+	for key, value := range src {
+		origin := section + "/" + key
+		if prev, ok := m.origin[origin]; ok {
+			log.Printf("prompt.json: %s overrides %s[%q] from %s", file, section, key, prev)
+		}
+		dst[key] = value
+		m.origin[origin] = file
+	}
+	return dst
+}
+
+func promptEntryID(entry promptEntry) string {
+	if entry.ID != "" {
+		return entry.ID
+	}
+	return entryID(entry.Question)
+}
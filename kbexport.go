@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Export format versions. As with snapshots, each export records the
+// format it was written in and the oldest format able to import it, so
+// either side of a rolling deploy can tell whether a document is safe to
+// import. Exports without a version predate versioning and are format 1;
+// format 2 added the version itself and the per-file breakdown.
+const (
+	kbExportFormat        = 2
+	kbExportMinCompatible = 1
+)
+
+// KBExport is the document /kb/export writes and /kb/import reads: every
+// knowledge entry and learned entry of an instance.
+type KBExport struct {
+	Format        int       `json:"format"`
+	MinCompatible int       `json:"min_compatible"`
+	ExportedAt    time.Time `json:"exported_at"`
+	// Analyzer and Embeddings describe how the vectors were derived. An
+	// importer with other embeddings re-derives them.
+	Analyzer   int              `json:"analyzer"`
+	Embeddings EmbeddingInfo    `json:"embeddings"`
+	Entries    []KnowledgeEntry `json:"entries"`
+	Learned    []LearnedEntry   `json:"learned"`
+	// Provenance describes the exporting server; imports ignore it.
+	Provenance *Provenance `json:"provenance,omitempty"`
+	// Files, with ?view=files, breaks the entries down by the prompt file
+	// they were loaded from; entries in none were added at run time.
+	// Imports ignore it.
+	Files []PromptFile `json:"files,omitempty"`
+}
+
+// ImportCounts counts what an import did with one kind of entry. Skipped
+// entries were identical to ones already present.
+type ImportCounts struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+// ImportReport is the response of /kb/import.
+type ImportReport struct {
+	Entries ImportCounts `json:"entries"`
+	Learned ImportCounts `json:"learned"`
+	// Merged lists the imported entries that asked the question of an
+	// existing entry, which took their answer instead of being added.
+	Merged []DuplicateMerge `json:"merged,omitempty"`
+	// NearDuplicates pairs the entries added or updated with the entries
+	// and learned entries whose questions are nearly the same.
+	NearDuplicates []NearDuplicate `json:"near_duplicates,omitempty"`
+}
+
+// ExportKB copies the knowledge base as of one consistent view.
+func (ai *AIEngine) ExportKB() KBExport {
+	state := ai.KB.view()
+	export := KBExport{
+		Format:        kbExportFormat,
+		MinCompatible: kbExportMinCompatible,
+		ExportedAt:    time.Now().UTC(),
+		Analyzer:      analyzerVersion,
+		Embeddings:    ai.embeddingInfo(),
+		Entries:       append([]KnowledgeEntry{}, state.entries...),
+		Learned:       make([]LearnedEntry, 0, len(state.learned)),
+	}
+	provenance := ai.provenance()
+	export.Provenance = &provenance
+	for _, entry := range state.learned {
+		export.Learned = append(export.Learned, entry)
+	}
+	sort.Slice(export.Learned, func(i, j int) bool { return export.Learned[i].ID < export.Learned[j].ID })
+	return export
+}
+
+// ImportKB merges an export into the knowledge base. Entries are matched
+// by ID and learned entries by normalized question; imported ones replace
+// what they match. An entry whose ID is new but whose question, once
+// normalized, an existing entry asks updates that entry's answer instead
+// of being added. Vectors are re-derived for entries that don't carry
+// one, or whose vector was built by another analyzer or other embeddings.
+// The whole import is validated first and published in a single update,
+// so readers see the knowledge base before or after it, never in between;
+// an import whose supersedes links would conflict or form a cycle is
+// rejected as a whole, as is one in a format this server can't read.
+func (ai *AIEngine) ImportKB(doc KBExport) (ImportReport, error) {
+	var report ImportReport
+	if err := ai.checkExportFormat(doc.Format, doc.MinCompatible); err != nil {
+		return report, err
+	}
+	embeddings := ai.embeddings()
+	info := ai.embeddingInfo()
+	sameVectors := doc.Analyzer == analyzerVersion && doc.Embeddings == info
+	now := time.Now()
+
+	entries := make([]KnowledgeEntry, len(doc.Entries))
+	for i, entry := range doc.Entries {
+		if entry.Question == "" || (entry.Answer == "" && len(entry.Variants) == 0) {
+			return report, newError(ErrInvalidInput, "entry %d needs a question and an answer", i)
+		}
+		if entry.Weight < 0 {
+			return report, newError(ErrInvalidInput, "entry %d has a negative weight", i)
+		}
+		if err := checkMinScore(fmt.Sprintf("min_score of entry %d", i), entry.MinScore); err != nil {
+			return report, err
+		}
+		if entry.ID == "" {
+			entry.ID = entryID(entry.Question)
+		}
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = now
+		}
+		if entry.VerifiedAt.IsZero() {
+			entry.VerifiedAt = entry.CreatedAt
+		}
+		if !sameVectors || entry.Analyzer != analyzerVersion || len(entry.Vector) != info.Dimension {
+			entry.Vector = getSentenceVector(entry.Question, embeddings)
+			entry.Analyzer = analyzerVersion
+		}
+		entries[i] = entry
+	}
+	learned := make([]LearnedEntry, len(doc.Learned))
+	for i, entry := range doc.Learned {
+		if entry.Question == "" || entry.Answer == "" {
+			return report, newError(ErrInvalidInput, "learned entry %d needs a question and an answer", i)
+		}
+		if err := checkMinScore(fmt.Sprintf("MinScore of learned entry %d", i), entry.MinScore); err != nil {
+			return report, err
+		}
+		entry.ID = learnedID(normalizeQuestion(entry.Question))
+		learned[i] = entry
+	}
+
+	var changed []string
+	err := ai.KB.update("ImportKB", func(current []KnowledgeEntry, currentLearned map[string]LearnedEntry) []KnowledgeEntry {
+		byID := make(map[string]int, len(current))
+		for i, entry := range current {
+			byID[entry.ID] = i
+		}
+		questions := newQuestionIndex(current)
+		for _, entry := range entries {
+			i, ok := byID[entry.ID]
+			if !ok {
+				if j := questions.find(current, entry.Question); j >= 0 {
+					report.Merged = append(report.Merged, DuplicateMerge{ID: entry.ID, Into: current[j].ID, Question: entry.Question, Score: 1})
+					byID[entry.ID] = j
+					if current[j].Answer == entry.Answer {
+						report.Entries.Skipped++
+						continue
+					}
+					current[j].Answer = entry.Answer
+					report.Entries.Updated++
+					changed = append(changed, current[j].ID)
+					continue
+				}
+			}
+			switch {
+			case !ok:
+				byID[entry.ID] = len(current)
+				questions.set(len(current), entry)
+				current = append(current, entry)
+				report.Entries.Added++
+			case sameEntry(current[i], entry):
+				report.Entries.Skipped++
+				continue
+			default:
+				current[i] = entry
+				questions.set(i, entry)
+				report.Entries.Updated++
+			}
+			changed = append(changed, entry.ID)
+		}
+		for _, entry := range learned {
+			key := normalizeQuestion(entry.Question)
+			existing, ok := currentLearned[key]
+			switch {
+			case !ok:
+				report.Learned.Added++
+			case sameLearned(existing, entry):
+				report.Learned.Skipped++
+				continue
+			default:
+				report.Learned.Updated++
+			}
+			currentLearned[key] = entry
+		}
+		return current
+	})
+	if err != nil {
+		return ImportReport{}, err
+	}
+	report.NearDuplicates = ai.KB.view().nearDuplicatesOf(changed, ai.DuplicateThreshold)
+	return report, nil
+}
+
+// checkExportFormat refuses, logs and counts an export this server is too
+// old to import: one whose min_compatible is newer than kbExportFormat.
+// An export with a format but no min_compatible needs its own format.
+func (ai *AIEngine) checkExportFormat(format, minCompatible int) error {
+	if format == 0 {
+		format, minCompatible = 1, 1
+	}
+	if minCompatible == 0 {
+		minCompatible = format
+	}
+	if minCompatible <= kbExportFormat {
+		return nil
+	}
+	ai.mu.Lock()
+	ai.importsRefused++
+	ai.mu.Unlock()
+	err := newError(ErrInvalidInput, "export written in format %d needs format %d or newer; this server reads format %d",
+		format, minCompatible, kbExportFormat)
+	log.Printf("Refusing KB import: %v", err)
+	return err
+}
+
+// ImportsRefused returns how many imports were refused for an
+// incompatible format.
+func (ai *AIEngine) ImportsRefused() int {
+	ai.mu.RLock()
+	defer ai.mu.RUnlock()
+	return ai.importsRefused
+}
+
+// sameEntry reports whether two entries are identical as exported. Times
+// are compared as JSON, since a round trip drops their location and
+// monotonic reading.
+func sameEntry(a, b KnowledgeEntry) bool {
+	x, errX := json.Marshal(a)
+	y, errY := json.Marshal(b)
+	return errX == nil && errY == nil && bytes.Equal(x, y)
+}
+
+// handleKBExport serves GET /kb/export, the merged view of the knowledge
+// base, broken down by prompt file with ?view=files.
+func handleKBExport(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		export := ai.ExportKB()
+		switch r.URL.Query().Get("view") {
+		case "", "merged":
+		case "files":
+			export.Files = ai.promptFiles
+		default:
+			writeError(w, newError(ErrInvalidInput, `view must be "merged" or "files"`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="kb-export.json"`)
+		json.NewEncoder(w).Encode(export)
+	}
+}
+
+// handleKBImport serves POST /kb/import, which merges an export into the
+// knowledge base.
+func handleKBImport(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		// The version is read first: a format this server can't import is
+		// refused as such, not for fields it doesn't know.
+		var version struct {
+			Format        int `json:"format"`
+			MinCompatible int `json:"min_compatible"`
+		}
+		json.Unmarshal(data, &version)
+		if err := ai.checkExportFormat(version.Format, version.MinCompatible); err != nil {
+			writeError(w, err)
+			return
+		}
+		var doc KBExport
+		if version.Format > kbExportFormat {
+			// A newer format this one can import only added fields.
+			if err := json.Unmarshal(data, &doc); err != nil {
+				writeError(w, newError(ErrInvalidInput, "%v", err))
+				return
+			}
+		} else {
+			r.Body = ioutil.NopCloser(bytes.NewReader(data))
+			if err := decodeRequest(r, &doc); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		report, err := ai.ImportKB(doc)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTrip exports ai as /kb/export would send it and decodes it as
+// /kb/import would read it.
+func roundTrip(t *testing.T, ai *AIEngine) KBExport {
+	t.Helper()
+	data, err := json.Marshal(ai.ExportKB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc KBExport
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestKBExportImportRoundTrip(t *testing.T) {
+	source := newTestEngine(t)
+	source.KB.learn(LearnedEntry{Question: "What is a map?", Answer: "A map is a hash table."})
+	doc := roundTrip(t, source)
+	if len(doc.Entries) != len(testEntries) || len(doc.Learned) != 1 {
+		t.Fatalf("exported %d entries and %d learned, want %d and 1", len(doc.Entries), len(doc.Learned), len(testEntries))
+	}
+
+	target := newTestEngine(t, withEntries(), withEmbeddings(toyEmbeddings(testEntries)))
+	report, err := target.ImportKB(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ImportCounts{Added: len(testEntries)}); report.Entries != want {
+		t.Errorf("entries %+v, want %+v", report.Entries, want)
+	}
+	if want := (ImportCounts{Added: 1}); report.Learned != want {
+		t.Errorf("learned %+v, want %+v", report.Learned, want)
+	}
+	assertAnswerSource(t, ask(t, target, "What is a goroutine?", AskOptions{}), SourceKnowledgeBase)
+
+	// Importing the same document again changes nothing.
+	report, err = target.ImportKB(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ImportCounts{Skipped: len(testEntries)}); report.Entries != want {
+		t.Errorf("re-import entries %+v, want %+v", report.Entries, want)
+	}
+	if want := (ImportCounts{Skipped: 1}); report.Learned != want {
+		t.Errorf("re-import learned %+v, want %+v", report.Learned, want)
+	}
+
+	// An export of the target imports into the source as a no-op, so the
+	// two now agree.
+	report, err = source.ImportKB(roundTrip(t, target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Entries.Added+report.Entries.Updated+report.Learned.Added+report.Learned.Updated != 0 {
+		t.Errorf("importing the copy back changed the source: %+v", report)
+	}
+}
+
+func TestKBImportUpdatesAndMerges(t *testing.T) {
+	ai := newTestEngine(t)
+	doc := roundTrip(t, ai)
+	doc.Entries = []KnowledgeEntry{doc.Entries[0], {ID: "channels-faq", Question: "how do channels work", Answer: "Channels pass values between goroutines."}}
+	doc.Entries[0].Answer = "Goroutines are functions running concurrently."
+	doc.Learned = nil
+
+	report, err := ai.ImportKB(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ImportCounts{Updated: 2}); report.Entries != want {
+		t.Errorf("entries %+v, want %+v", report.Entries, want)
+	}
+	if len(report.Merged) != 1 || report.Merged[0].ID != "channels-faq" || report.Merged[0].Into != "channels" {
+		t.Errorf("merged %+v, want channels-faq into channels", report.Merged)
+	}
+	entry, _ := ai.KB.entryByID("channels")
+	if entry.Answer != "Channels pass values between goroutines." {
+		t.Errorf("channels answer %q was not updated", entry.Answer)
+	}
+}
+
+// TestKBImportMergesWithinDocument merges entries of the document into
+// ones it added or re-asked earlier, not into what an entry asked before
+// the document changed its question.
+func TestKBImportMergesWithinDocument(t *testing.T) {
+	ai := newTestEngine(t)
+	doc := KBExport{Entries: []KnowledgeEntry{
+		{ID: "channels", Question: "How are channels closed?", Answer: "With close."},
+		{ID: "channels-faq", Question: "How do channels work?", Answer: "Channels pass values between goroutines."},
+		{ID: "maps", Question: "How are maps iterated?", Answer: "With range."},
+		{ID: "maps-faq", Question: "how are maps iterated", Answer: "With range."},
+	}}
+	report, err := ai.ImportKB(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ImportCounts{Added: 2, Updated: 1, Skipped: 1}); report.Entries != want {
+		t.Errorf("entries %+v, want %+v", report.Entries, want)
+	}
+	if len(report.Merged) != 1 || report.Merged[0].ID != "maps-faq" || report.Merged[0].Into != "maps" {
+		t.Errorf("merged %+v, want maps-faq into maps", report.Merged)
+	}
+	if entry, ok := ai.KB.entryByID("channels-faq"); !ok || entry.Question != "How do channels work?" {
+		t.Errorf("channels-faq %+v merged into the entry that asked its question before", entry)
+	}
+}
+
+func TestKBImportRejectsInvalidDocuments(t *testing.T) {
+	ai := newTestEngine(t)
+	before := roundTrip(t, ai)
+	tests := map[string]KBExport{
+		"entry without answer":     {Entries: []KnowledgeEntry{{ID: "a", Question: "What is a map?"}, {ID: "b", Question: "q", Answer: "a"}}},
+		"negative weight":          {Entries: []KnowledgeEntry{{ID: "a", Question: "What is a map?", Answer: "a", Weight: -1}}},
+		"learned without question": {Learned: []LearnedEntry{{Answer: "a"}}},
+		"min score above 1":        {Entries: []KnowledgeEntry{{ID: "a", Question: "What is a map?", Answer: "a", MinScore: 1.5}}},
+		"negative min score":       {Entries: []KnowledgeEntry{{ID: "a", Question: "What is a map?", Answer: "a", MinScore: -0.1}}},
+		"NaN min score":            {Entries: []KnowledgeEntry{{ID: "a", Question: "What is a map?", Answer: "a", MinScore: math.NaN()}}},
+		"learned min score":        {Learned: []LearnedEntry{{Question: "What is a map?", Answer: "a", MinScore: 2}}},
+	}
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ai.ImportKB(doc); !errors.Is(err, ErrInvalidInput) {
+				t.Fatalf("err = %v, want ErrInvalidInput", err)
+			}
+			if after := roundTrip(t, ai); len(after.Entries) != len(before.Entries) || len(after.Learned) != len(before.Learned) {
+				t.Fatalf("a rejected import changed the knowledge base")
+			}
+		})
+	}
+}
+
+func TestKnowledgeEntryJSONNames(t *testing.T) {
+	entry := KnowledgeEntry{ID: "maps", Question: "What is a map?", Answer: "A hash table.", MinScore: 0.8, SourceURL: "https://go.dev/", NeedsRewrite: true}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{`"min_score":0.8`, `"source_url":`, `"needs_rewrite":true`, `"created_at":`} {
+		if !strings.Contains(string(data), name) {
+			t.Errorf("%s lacks %s", data, name)
+		}
+	}
+
+	// Exports, snapshots and SQLite rows written before the entry had
+	// JSON tags use the Go field names.
+	legacy := `{"ID": "maps", "Question": "What is a map?", "Answer": "A hash table.", "MinScore": 0.8,
+		"SourceURL": "https://go.dev/", "CreatedAt": "2024-01-02T03:04:05Z", "NeedsRewrite": true, "MergedInto": "dicts",
+		"Variants": [{"Answer": "A map.", "Weight": 2}]}`
+	var decoded KnowledgeEntry
+	if err := json.Unmarshal([]byte(legacy), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if decoded.ID != "maps" || decoded.MinScore != 0.8 || decoded.SourceURL != "https://go.dev/" || !decoded.CreatedAt.Equal(created) ||
+		!decoded.NeedsRewrite || decoded.MergedInto != "dicts" || len(decoded.Variants) != 1 || decoded.Variants[0].Weight != 2 {
+		t.Errorf("legacy entry decoded as %+v", decoded)
+	}
+
+	var roundTripped KnowledgeEntry
+	if err := json.Unmarshal(data, &roundTripped); err != nil {
+		t.Fatal(err)
+	}
+	if roundTripped.MinScore != 0.8 || roundTripped.SourceURL != entry.SourceURL || !roundTripped.NeedsRewrite {
+		t.Errorf("entry decoded as %+v", roundTripped)
+	}
+}
+
+// TestKBExportFileBreakdown exports, with ?view=files, what prompt.json and
+// each file it includes contributed once later files won their conflicts.
+func TestKBExportFileBreakdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "askgo-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"prompt.json": `{"greetings": {"hi": "Hi!"}, "default_responses": {"unknown": "No idea."},
+			"knowledge_base": [{"id": "maps", "question": "What is a map?", "answer": "A hash table."}],
+			"include": ["teams/*.json"]}`,
+		"teams/a.json": `{"knowledge_base": [{"id": "maps", "question": "What is a map?", "answer": "A hash map."},
+			{"id": "slices", "question": "What is a slice?", "answer": "A view of an array."}]}`,
+		"teams/b.json": `{"greetings": {"hi": "Hello!", "hey": "Hey!"}}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prompts, err := loadPrompts(filepath.Join(dir, "prompt.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ai := newTestEngine(t, withPrompts(func(p *PromptConfig) { *p = *prompts }))
+	ai.KB.AddEntry("What is a channel?", "A pipe between goroutines.", ai.Embeddings)
+
+	export := func(view string) (KBExport, int) {
+		rec := httptest.NewRecorder()
+		handleKBExport(ai)(rec, httptest.NewRequest("GET", "/kb/export?view="+view, nil))
+		var doc KBExport
+		json.NewDecoder(rec.Body).Decode(&doc)
+		return doc, rec.Code
+	}
+	if doc, _ := export("merged"); doc.Files != nil || len(doc.Entries) != 3 {
+		t.Errorf("merged view: %d entries, files %+v", len(doc.Entries), doc.Files)
+	}
+	doc, _ := export("files")
+	want := []PromptFile{
+		{Path: filepath.Join(dir, "prompt.json"), Entries: []string{}, DefaultResponses: []string{"unknown"}},
+		{Path: filepath.Join(dir, "teams", "a.json"), Entries: []string{"maps", "slices"}},
+		{Path: filepath.Join(dir, "teams", "b.json"), Entries: []string{}, Greetings: []string{"hey", "hi"}},
+	}
+	if !reflect.DeepEqual(doc.Files, want) {
+		t.Errorf("files %+v, want %+v", doc.Files, want)
+	}
+	if len(doc.Entries) != 3 {
+		t.Errorf("breakdown dropped the merged entries: %d", len(doc.Entries))
+	}
+	if _, code := export("tree"); code != 400 {
+		t.Errorf("unknown view answered %d, want 400", code)
+	}
+}
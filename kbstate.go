@@ -0,0 +1,282 @@
+package main
+
+import "sync/atomic"
+
+// kbState is an immutable view of the knowledge base. Readers load the
+// current one without locking; writers copy what they change, build a new
+// state and publish it, so a published state is never modified.
+type kbState struct {
+	entries []KnowledgeEntry
+	// learned is keyed by normalizeQuestion of the taught question.
+	learned map[string]LearnedEntry
+	// learnedVectors holds the sentence vector of each learned question,
+	// by the same key.
+	learnedVectors map[string][]float32
+	// successors maps each superseded entry ID to the position in entries
+	// of the entry superseding it.
+	successors map[string]int
+	// idf weights the words of every vector in the state; nil unless IDF
+	// weighting is on.
+	idf *idfTable
+	// idfChanges counts the questions added or removed since every vector
+	// was last weighted with the table then current.
+	idfChanges int
+	// stopWords are left out of every vector in the state; nil unless
+	// stopword filtering is on.
+	stopWords stopWordSet
+	// vocab finds embeddings for words missing from the vocabulary.
+	vocab *vocabIndex
+	// ann indexes the entry vectors by position, and learnedANN the
+	// learned vectors by id; both are nil below annMinEntries.
+	ann        *annIndex
+	learnedANN *annIndex
+	// learnedKeys maps the ids of learnedANN to learned keys, and
+	// learnedIDs back. Removed keys keep their ids until it is rebuilt.
+	learnedKeys []string
+	learnedIDs  map[string]int32
+	// scan is how rankEntries splits a scan of every entry.
+	scan scanConfig
+	// spelling counts the words of the questions, which questions asked
+	// are spelled against.
+	spelling *spellDictionary
+	// maxWeightBoost caps how far entry weights raise scores.
+	maxWeightBoost float64
+	// vectorEpoch identifies how questions are vectorized in the state:
+	// states sharing it weight, filter and look up words alike, so a
+	// question vector computed in one holds in the others.
+	vectorEpoch uint64
+	// version changes with every publish, so answers computed against one
+	// state are known to be stale in the next.
+	version uint64
+}
+
+// vectorEpochs numbers the vector epochs and kbVersions the published
+// states.
+var vectorEpochs, kbVersions uint64
+
+// view returns the current state. Callers must not modify it.
+func (kb *KnowledgeBase) view() *kbState {
+	return kb.state.Load().(*kbState)
+}
+
+// updateEntries replaces the entries with what fn makes of a private copy
+// of them. Writers are serialized by the write lock; readers keep the
+// state they loaded until they are done with it. Changed entries are
+// written through to the store, if there is one. If the result has
+// conflicting or cyclic supersedes links, nothing changes and the error
+// is returned.
+func (kb *KnowledgeBase) updateEntries(op string, fn func(entries []KnowledgeEntry) []KnowledgeEntry) error {
+	defer kb.writeLock(op)()
+	current := kb.view()
+	entries := make([]KnowledgeEntry, len(current.entries), len(current.entries)+1)
+	copy(entries, current.entries)
+	entries = fn(entries)
+	successors, err := supersessions(entries)
+	if err != nil {
+		return err
+	}
+	if kb.store != nil {
+		kb.persistEntries(op, current.entries, entries)
+	}
+	kb.publish(current, entries, current.learned, successors)
+	return nil
+}
+
+// updateLearned is updateEntries for the learned entries.
+func (kb *KnowledgeBase) updateLearned(op string, fn func(learned map[string]LearnedEntry)) {
+	defer kb.writeLock(op)()
+	current := kb.view()
+	learned := make(map[string]LearnedEntry, len(current.learned)+1)
+	for key, entry := range current.learned {
+		learned[key] = entry
+	}
+	fn(learned)
+	kb.publish(current, current.entries, learned, current.successors)
+}
+
+// replaceLearned installs learned, which the caller must not keep using, as
+// the learned entries.
+func (kb *KnowledgeBase) replaceLearned(op string, learned map[string]LearnedEntry) {
+	defer kb.writeLock(op)()
+	current := kb.view()
+	kb.publish(current, current.entries, learned, current.successors)
+}
+
+// update is updateEntries for changes to both the entries and the learned
+// entries, which readers see together or not at all. fn may modify the
+// learned map it is given.
+func (kb *KnowledgeBase) update(op string, fn func(entries []KnowledgeEntry, learned map[string]LearnedEntry) []KnowledgeEntry) error {
+	defer kb.writeLock(op)()
+	current := kb.view()
+	entries := make([]KnowledgeEntry, len(current.entries), len(current.entries)+1)
+	copy(entries, current.entries)
+	learned := make(map[string]LearnedEntry, len(current.learned)+1)
+	for key, entry := range current.learned {
+		learned[key] = entry
+	}
+	entries = fn(entries, learned)
+	successors, err := supersessions(entries)
+	if err != nil {
+		return err
+	}
+	if kb.store != nil {
+		kb.persistEntries(op, current.entries, entries)
+		kb.persistLearned(op, current.learned, learned)
+	}
+	kb.publish(current, entries, learned, successors)
+	return nil
+}
+
+// publish stores the state made of entries, learned and successors. The
+// entries are vectorized with the state's stopwords and, with IDF
+// weighting on, current's table updated with the questions changed: all
+// of them when weighting was turned on or the table drifted too far from
+// the one their vectors were weighted with (see idfTable), else those
+// whose vector the caller replaced, which it computed plainly. Learned
+// vectors follow the same rule, and the spelling dictionary and the
+// indexes over both are updated with what changed. It runs under the
+// write lock and doesn't modify entries.
+func (kb *KnowledgeBase) publish(current *kbState, entries []KnowledgeEntry, learned map[string]LearnedEntry, successors map[string]int) {
+	next := &kbState{entries: entries, learned: learned, successors: successors, stopWords: kb.stopWords, scan: kb.scan, maxWeightBoost: kb.maxWeightBoost}
+	added, removed := questionChanges(current, entries, learned)
+	// reweigh is set when every vector is to be weighted afresh.
+	reweigh := false
+	if kb.embeddings != nil {
+		embeddings := kb.embeddings()
+		if kb.vocab == nil {
+			kb.vocab = buildVocabIndex(embeddings)
+		}
+		next.vocab = kb.vocab
+		if kb.idfWeighting {
+			next.idf = current.idf.with(added, removed, embeddings)
+			next.idfChanges = current.idfChanges + len(added) + len(removed)
+			if current.idf == nil || next.idfChanges > next.idf.docs/idfRebuildShare {
+				reweigh, next.idfChanges = true, 0
+			}
+		}
+		vectors := make(map[string][]float32, len(current.entries))
+		if !reweigh {
+			for _, entry := range current.entries {
+				vectors[entry.ID] = entry.Vector
+			}
+		}
+		next.entries = make([]KnowledgeEntry, len(entries))
+		copy(next.entries, entries)
+		for i, entry := range next.entries {
+			if !sameVector(entry.Vector, vectors[entry.ID]) {
+				next.entries[i].Vector = next.vector(entry.Question, embeddings)
+			}
+		}
+	}
+	next.spelling = current.spelling.with(added, removed)
+	next.version = atomic.AddUint64(&kbVersions, 1)
+	next.ann = entryIndex(current, next)
+	// Whatever else changes how questions are vectorized (stopwords, the
+	// embeddings and their vocabulary) is published from an empty state.
+	next.vectorEpoch = current.vectorEpoch
+	if next.vectorEpoch == 0 || next.idf != current.idf {
+		next.vectorEpoch = atomic.AddUint64(&vectorEpochs, 1)
+	}
+	if reweigh {
+		current = &kbState{}
+	}
+	next.learnedVectors = kb.learnedVectors(current, next)
+	indexLearned(current, next)
+	kb.state.Store(next)
+}
+
+// questionChanges returns the questions of entries and learned that
+// current lacks, and those of current they lack, counting repeated
+// questions as often as they are asked. It compares questions without
+// reading them, so that what reads them can be limited to the changes.
+func questionChanges(current *kbState, entries []KnowledgeEntry, learned map[string]LearnedEntry) (added, removed []string) {
+	counts := make(map[string]int, len(current.entries)+len(current.learned))
+	for _, entry := range current.entries {
+		counts[entry.Question]++
+	}
+	for _, entry := range current.learned {
+		counts[entry.Question]++
+	}
+	have := func(question string) {
+		if counts[question] > 0 {
+			counts[question]--
+		} else {
+			added = append(added, question)
+		}
+	}
+	for _, entry := range entries {
+		have(entry.Question)
+	}
+	for _, entry := range learned {
+		have(entry.Question)
+	}
+	for question, n := range counts {
+		for ; n > 0; n-- {
+			removed = append(removed, question)
+		}
+	}
+	return added, removed
+}
+
+// sameVector reports whether a and b are the same slice, not merely equal.
+func sameVector(a, b []float32) bool {
+	return len(a) == len(b) && len(a) > 0 && &a[0] == &b[0]
+}
+
+// vector is the sentence vector of question in s.
+func (s *kbState) vector(question string, embeddings *EmbeddingStore) []float32 {
+	return s.sentenceVector(tokenize(question, embeddings), embeddings)
+}
+
+// sentenceVector averages words as the vectors of s were: without its
+// stopwords, weighted by its IDF table and looking up unknown words in its
+// vocabulary index.
+func (s *kbState) sentenceVector(words []string, embeddings *EmbeddingStore) []float32 {
+	return weightedSentenceVector(s.stopWords.filter(words), embeddings, s.idf, s.vocab)
+}
+
+// learnedVectors vectorizes the learned questions of next, reusing the
+// vectors of questions current already has. It runs under the write lock.
+func (kb *KnowledgeBase) learnedVectors(current, next *kbState) map[string][]float32 {
+	vectors := make(map[string][]float32, len(next.learned))
+	if kb.embeddings == nil {
+		return vectors
+	}
+	embeddings := kb.embeddings()
+	for key, entry := range next.learned {
+		if old, ok := current.learned[key]; ok && old.Question == entry.Question && current.learnedVectors[key] != nil {
+			vectors[key] = current.learnedVectors[key]
+		} else {
+			vectors[key] = next.vector(entry.Question, embeddings)
+		}
+	}
+	return vectors
+}
+
+// revectorize recomputes every vector, after the embeddings changed,
+// since the plain entry vectors the caller installed would otherwise be
+// kept.
+func (kb *KnowledgeBase) revectorize(op string) {
+	defer kb.writeLock(op)()
+	kb.vocab = nil
+	current := kb.view()
+	kb.publish(&kbState{}, current.entries, current.learned, current.successors)
+}
+
+// setIDFWeighting turns IDF weighting on or off and re-vectorizes every
+// entry and learned entry to match.
+func (kb *KnowledgeBase) setIDFWeighting(on bool) {
+	defer kb.writeLock("setIDFWeighting")()
+	kb.idfWeighting = on
+	current := kb.view()
+	kb.publish(&kbState{}, current.entries, current.learned, current.successors)
+}
+
+// setStopWords sets the words left out of vectors, nil for none, and
+// re-vectorizes every entry and learned entry to match.
+func (kb *KnowledgeBase) setStopWords(stopWords stopWordSet) {
+	defer kb.writeLock("setStopWords")()
+	kb.stopWords = stopWords
+	current := kb.view()
+	kb.publish(&kbState{}, current.entries, current.learned, current.successors)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// fallbackLanguage is the language of the top-level greetings, common
+// questions, default responses and starters, used for questions in a
+// language prompt.json has no section for.
+const fallbackLanguage = "en"
+
+// Bounds of language detection. A question is only taken to be in the
+// language it scores best in when it has minLanguageTrigrams trigrams and
+// beats the runner-up by minLanguageMargin nats a trigram; otherwise the
+// Accept-Language hint decides.
+const (
+	minLanguageTrigrams = 3
+	minLanguageMargin   = 0.5
+	// trigramSmoothing is added to every trigram count, so one unseen
+	// trigram doesn't rule a language out.
+	trigramSmoothing = 0.5
+)
+
+// languageSamples seed the trigram profiles of the languages most users
+// write in. The texts of a language's own prompt.json section are added,
+// so other languages are detected from those alone.
+var languageSamples = map[string]string{
+	"en": `Hello, how are you? I would like to know how this works and why it
+is done that way. What is the difference between these two things, and
+when should I use one or the other? Could you show me an example with
+some code? Thanks for the help, that makes sense now. Where can I find
+more about it? Which one is faster, and what happens if the function
+returns an error? Please tell me what the best practice is here.`,
+	"ru": `Привет, как дела? Я хотел бы узнать, как это работает и почему
+это сделано именно так. В чём разница между этими двумя вещами и когда
+нужно использовать одно или другое? Можете показать пример с кодом?
+Спасибо за помощь, теперь понятно. Где можно прочитать об этом подробнее?
+Что быстрее и что происходит, если функция возвращает ошибку? Подскажите,
+пожалуйста, как лучше всего это сделать.`,
+}
+
+// LanguageConfig overrides the greetings, common questions, fallback
+// responses and starters for questions in one language. Anything it
+// doesn't set falls back to the top-level sections.
+type LanguageConfig struct {
+	Greetings        map[string]string `json:"greetings"`
+	CommonQuestions  map[string]string `json:"common_questions"`
+	DefaultResponses map[string]string `json:"default_responses"`
+	Starters         []string          `json:"starters"`
+}
+
+// text joins everything the section says, for its trigram profile.
+func (l LanguageConfig) text() string {
+	var parts []string
+	for _, m := range []map[string]string{l.Greetings, l.CommonQuestions, l.DefaultResponses} {
+		for key, value := range m {
+			parts = append(parts, key, value)
+		}
+	}
+	return strings.Join(append(parts, l.Starters...), " ")
+}
+
+// languageCode reduces a language tag such as "ru-RU" to its lowercase
+// primary subtag, "" if it isn't one.
+func languageCode(tag string) string {
+	code := strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	if len(code) < 2 || len(code) > 3 {
+		return ""
+	}
+	for _, r := range code {
+		if r < 'a' || r > 'z' {
+			return ""
+		}
+	}
+	return code
+}
+
+// checkLanguages validates the language sections of prompt.json, keyed by
+// language code, and returns them keyed by primary subtag.
+func checkLanguages(languages map[string]LanguageConfig) (map[string]LanguageConfig, error) {
+	checked := make(map[string]LanguageConfig, len(languages))
+	for tag, language := range languages {
+		code := languageCode(tag)
+		if code == "" {
+			return nil, fmt.Errorf("languages: %q is not a language code such as \"ru\"", tag)
+		}
+		if _, dup := checked[code]; dup {
+			return nil, fmt.Errorf("languages: %q repeats language %s", tag, code)
+		}
+		if response, ok := language.DefaultResponses["keywords"]; ok {
+			if err := checkKeywordsResponse(response); err != nil {
+				return nil, fmt.Errorf("languages.%s.default_responses.keywords: %v", tag, err)
+			}
+		}
+		checked[code] = language
+	}
+	return checked, nil
+}
+
+// trigramProfile holds the smoothed log probabilities of the letter
+// trigrams of a language.
+type trigramProfile struct {
+	logProb map[string]float64
+	// unseen is the log probability of a trigram the language's texts
+	// never had.
+	unseen float64
+}
+
+// letterTrigrams returns the trigrams of the words of text, lowercase and
+// padded with a space on each side, so word starts and ends count.
+func letterTrigrams(text string) []string {
+	var trigrams []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		runes := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			trigrams = append(trigrams, string(runes[i:i+3]))
+		}
+	}
+	return trigrams
+}
+
+func buildTrigramProfile(text string) *trigramProfile {
+	counts := make(map[string]int)
+	total := 0
+	for _, trigram := range letterTrigrams(text) {
+		counts[trigram]++
+		total++
+	}
+	// The vocabulary is counted generously, as unseen trigrams are many.
+	denominator := float64(total) + trigramSmoothing*float64(len(counts)+1000)
+	p := &trigramProfile{logProb: make(map[string]float64, len(counts)), unseen: math.Log(trigramSmoothing / denominator)}
+	for trigram, n := range counts {
+		p.logProb[trigram] = math.Log((float64(n) + trigramSmoothing) / denominator)
+	}
+	return p
+}
+
+// buildLanguageProfiles profiles the fallback language and each language
+// with a section, from its sample, if any, and its section's texts.
+func buildLanguageProfiles(languages map[string]LanguageConfig) map[string]*trigramProfile {
+	profiles := make(map[string]*trigramProfile, len(languages)+1)
+	profiles[fallbackLanguage] = buildTrigramProfile(languageSamples[fallbackLanguage] + " " + languages[fallbackLanguage].text())
+	for code, language := range languages {
+		profiles[code] = buildTrigramProfile(languageSamples[code] + " " + language.text())
+	}
+	return profiles
+}
+
+// classifyLanguage returns the language text scores best in and whether
+// it is sure of it; see minLanguageMargin. Ties go to the first code
+// alphabetically.
+func classifyLanguage(profiles map[string]*trigramProfile, text string) (string, bool) {
+	trigrams := letterTrigrams(text)
+	if len(trigrams) == 0 || len(profiles) == 0 {
+		return "", false
+	}
+	codes := make([]string, 0, len(profiles))
+	for code := range profiles {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	best, bestScore, runnerUp := "", math.Inf(-1), math.Inf(-1)
+	for _, code := range codes {
+		p := profiles[code]
+		score := 0.0
+		for _, trigram := range trigrams {
+			if lp, ok := p.logProb[trigram]; ok {
+				score += lp
+			} else {
+				score += p.unseen
+			}
+		}
+		switch {
+		case score > bestScore:
+			best, bestScore, runnerUp = code, score, bestScore
+		case score > runnerUp:
+			runnerUp = score
+		}
+	}
+	margin := (bestScore - runnerUp) / float64(len(trigrams))
+	return best, len(trigrams) >= minLanguageTrigrams && margin >= minLanguageMargin
+}
+
+// acceptLanguages lists the language codes of r's Accept-Language header,
+// most preferred first; codes with q=0 are left out.
+func acceptLanguages(r *http.Request) []string {
+	type preference struct {
+		code string
+		q    float64
+	}
+	var prefs []preference
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(part, ";")
+		code := languageCode(fields[0])
+		if code == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			prefs = append(prefs, preference{code, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	codes := make([]string, len(prefs))
+	for i, p := range prefs {
+		codes[i] = p.code
+	}
+	return codes
+}
+
+// hasLanguage reports whether questions in code are answered in it: the
+// fallback language always is, others when prompt.json has a section.
+func (ai *AIEngine) hasLanguage(code string) bool {
+	_, ok := ai.Languages[code]
+	return code == fallbackLanguage || ok
+}
+
+// questionLanguage returns the language question is answered in:
+// opts.Language if set, else the language it is surely written in, else
+// the first of opts.LanguageHints the engine has, else the language it
+// scores best in, else fallbackLanguage. Without language sections every
+// question is in fallbackLanguage.
+func (ai *AIEngine) questionLanguage(question string, opts AskOptions) string {
+	if code := languageCode(opts.Language); code != "" {
+		return code
+	}
+	if len(ai.Languages) == 0 {
+		return fallbackLanguage
+	}
+	detected, sure := classifyLanguage(ai.languageProfiles, question)
+	if sure {
+		return detected
+	}
+	for _, hint := range opts.LanguageHints {
+		if code := languageCode(hint); ai.hasLanguage(code) {
+			return code
+		}
+	}
+	if detected != "" {
+		return detected
+	}
+	return fallbackLanguage
+}
+
+// greeting matches text against the greetings of language, then the
+// top-level ones.
+func (ai *AIEngine) greeting(language, text string) (string, bool) {
+	if response, ok := matchGreeting(ai.Languages[language].Greetings, text, ai.GreetingMaxEdits); ok {
+		return response, true
+	}
+	return matchGreeting(ai.Greetings, text, ai.GreetingMaxEdits)
+}
+
+// commonQuestion matches text against the common questions of language,
+// then the top-level ones.
+func (ai *AIEngine) commonQuestion(language, text string) (string, bool) {
+	if response, ok := matchCommonQuestion(ai.Languages[language].CommonQuestions, text); ok {
+		return response, true
+	}
+	return matchCommonQuestion(ai.CommonQuestions, text)
+}
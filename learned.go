@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Paging of GET /learn.
+const (
+	defaultLearnedPage = 50
+	maxLearnedPage     = 500
+)
+
+// reviseLearned replaces the answer and source URL of the learned entry
+// for question, keeping its wording, lifts any quarantine and returns the
+// revised entry. An unknown question is ErrNotFound. With a store open the entry is
+// persisted first, as by learnChecked.
+func (kb *KnowledgeBase) reviseLearned(question, answer, sourceURL string, minScore *float64) (LearnedEntry, error) {
+	key := normalizeQuestion(question)
+	var entry LearnedEntry
+	var err error
+	kb.updateLearned("reviseLearned", func(learned map[string]LearnedEntry) {
+		existing, ok := learned[key]
+		if !ok {
+			err = newError(ErrNotFound, "nothing is learned for this question")
+			return
+		}
+		entry = existing
+		entry.Answer, entry.SourceURL, entry.Quarantined = answer, sourceURL, false
+		if minScore != nil {
+			entry.MinScore = *minScore
+		}
+		if kb.store != nil && !sameLearned(entry, existing) {
+			if writeErr := kb.store.Learn(entry); writeErr != nil {
+				err = newError(ErrStoreUnavailable, "learned entry not saved: %v", writeErr)
+				return
+			}
+		}
+		learned[key] = entry
+	})
+	return entry, err
+}
+
+// forgetLearned removes the learned entry for question, and with it its
+// vector and index slot, and returns it. An unknown question is
+// ErrNotFound. With a store open the removal is persisted first.
+func (kb *KnowledgeBase) forgetLearned(question string) (LearnedEntry, error) {
+	key := normalizeQuestion(question)
+	var entry LearnedEntry
+	var err error
+	kb.updateLearned("forgetLearned", func(learned map[string]LearnedEntry) {
+		var ok bool
+		if entry, ok = learned[key]; !ok {
+			err = newError(ErrNotFound, "nothing is learned for this question")
+			return
+		}
+		if kb.store != nil {
+			if writeErr := kb.store.Forget(entry.Question); writeErr != nil {
+				err = newError(ErrStoreUnavailable, "learned entry not removed: %v", writeErr)
+				return
+			}
+		}
+		delete(learned, key)
+	})
+	return entry, err
+}
+
+// quarantineLearned keeps the learned entry id from being matched until
+// its question is taught or revised again, and reports whether there was
+// such an entry not quarantined yet. With a store open the quarantine is
+// persisted first.
+func (kb *KnowledgeBase) quarantineLearned(id string) (bool, error) {
+	var quarantined bool
+	var err error
+	kb.updateLearned("quarantineLearned", func(learned map[string]LearnedEntry) {
+		for key, entry := range learned {
+			if entry.ID != id || entry.Quarantined {
+				continue
+			}
+			entry.Quarantined = true
+			if kb.store != nil {
+				if writeErr := kb.store.Learn(entry); writeErr != nil {
+					err = newError(ErrStoreUnavailable, "quarantine not saved: %v", writeErr)
+					return
+				}
+			}
+			learned[key], quarantined = entry, true
+			return
+		}
+	})
+	return quarantined, err
+}
+
+// forgetInteractions drops the interactions answered from the learned
+// entry id from every session, so a revised or removed answer isn't
+// served again as conversation context. Cached answers need no help:
+// they are only served at the knowledge base version they were made at.
+func (ai *AIEngine) forgetInteractions(id string) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	for _, s := range ai.sessions {
+		kept := s.history[:0]
+		for _, interaction := range s.history {
+			if interaction.EntryID != id {
+				kept = append(kept, interaction)
+			}
+		}
+		for i := len(kept); i < len(s.history); i++ {
+			s.history[i] = Interaction{}
+		}
+		s.history = kept
+	}
+}
+
+// learnedListing is a learned entry as GET /learn lists it.
+type learnedListing struct {
+	ID          string   `json:"id"`
+	Question    string   `json:"question"`
+	Answer      string   `json:"answer"`
+	SourceURL   string   `json:"source_url,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Quarantined bool     `json:"quarantined,omitempty"`
+	MinScore    float64  `json:"min_score,omitempty"`
+}
+
+func newLearnedListing(entry LearnedEntry) learnedListing {
+	return learnedListing{ID: entry.ID, Question: entry.Question, Answer: entry.Answer, SourceURL: entry.SourceURL, Tags: entry.Tags, Quarantined: entry.Quarantined, MinScore: entry.MinScore}
+}
+
+// handleListLearned serves GET /learn: the learned entries sorted by
+// question, limit (at most maxLearnedPage) from offset, with the total.
+func handleListLearned(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		offset, limit, err := pageParams(r, defaultLearnedPage, maxLearnedPage)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		learned := ai.KB.view().learned
+		listings := make([]learnedListing, 0, len(learned))
+		for _, entry := range learned {
+			listings = append(listings, newLearnedListing(entry))
+		}
+		sort.Slice(listings, func(i, j int) bool {
+			a, b := strings.ToLower(listings[i].Question), strings.ToLower(listings[j].Question)
+			if a != b {
+				return a < b
+			}
+			return listings[i].ID < listings[j].ID
+		})
+		start, end := pageBounds(offset, limit, len(listings))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"total":   len(listings),
+			"offset":  offset,
+			"limit":   limit,
+			"learned": listings[start:end],
+		})
+	}
+}
+
+// LearnedUpdate revises the answer to a learned question.
+type LearnedUpdate struct {
+	Question  string `json:"question" schema:"required"`
+	Answer    string `json:"answer" schema:"required"`
+	SourceURL string `json:"source_url"`
+	// MinScore, when given, replaces the entry's; see LearnRequest.
+	MinScore *float64 `json:"min_score"`
+}
+
+// handleUpdateLearned serves PUT /learn, which replaces the answer of a
+// question learned before and returns the entry; unknown questions are
+// 404s, to be taught with POST /learn instead.
+func handleUpdateLearned(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if refuseIfOff(w, r, ai.Switches, SwitchLearning) {
+			return
+		}
+		var req LearnedUpdate
+		if err := decodeRequest(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		if req.MinScore != nil {
+			if err := checkMinScore("min_score", *req.MinScore); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		entry, err := ai.KB.reviseLearned(req.Question, req.Answer, req.SourceURL, req.MinScore)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		ai.forgetInteractions(entry.ID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newLearnedListing(entry))
+	}
+}
+
+// LearnedRemoval names a learned question to forget.
+type LearnedRemoval struct {
+	Question string `json:"question" schema:"required"`
+}
+
+// handleForgetLearned serves DELETE /learn, which removes a learned
+// question and returns the entry it had; unknown questions are 404s. It
+// works with learning switched off, so wrong answers can always be
+// withdrawn. A snapshot is taken afterwards, when snapshots are on, so
+// restoring the previous one can't bring the answer back.
+func handleForgetLearned(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req LearnedRemoval
+		if err := decodeRequest(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		entry, err := ai.KB.forgetLearned(req.Question)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		ai.forgetInteractions(entry.ID)
+		if ai.Snapshots != nil {
+			if _, err := ai.Snapshots.Take(); err != nil {
+				log.Printf("Snapshot after forgetting %s: %v", entry.ID, err)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newLearnedListing(entry))
+	}
+}
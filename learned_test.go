@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestLearnMinScore(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	tests := []struct {
+		method, body string
+		status       int
+	}{
+		{http.MethodPost, `{"question": "What is a map?", "answer": "A map is a hash table.", "min_score": 1.5}`, http.StatusBadRequest},
+		{http.MethodPost, `{"question": "What is a map?", "answer": "A map is a hash table.", "min_score": -0.5}`, http.StatusBadRequest},
+		{http.MethodPost, `{"question": "What is a map?", "answer": "A map is a hash table.", "min_score": 0.9}`, http.StatusOK},
+		{http.MethodPut, `{"question": "What is a map?", "answer": "A map maps keys to values.", "min_score": 2}`, http.StatusBadRequest},
+		{http.MethodPut, `{"question": "What is a map?", "answer": "A map maps keys to values."}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		resp, body := f.do(t, tt.method, "/learn", tt.body, testAdminKey, nil)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.body, resp.StatusCode, tt.status, body)
+		}
+	}
+	// A revision without min_score keeps the entry's.
+	learned, ok := f.deps.ai.KB.lookupLearned(normalizeQuestion("What is a map?"))
+	if !ok || learned.MinScore != 0.9 || learned.Answer != "A map maps keys to values." {
+		t.Fatalf("learned entry %+v, want min score 0.9 and the revised answer", learned)
+	}
+}
+
+func TestLearnedMinScoreGatesSimilarQuestions(t *testing.T) {
+	threshold := 0.5
+	ai := newTestEngine(t, withPrompts(func(p *PromptConfig) {
+		p.Calibrations, _ = buildCalibrations(nil)
+		p.Engine.KBMatchThreshold = &threshold
+		if err := p.Engine.applyThresholds(p.Calibrations); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	ai.KB.learn(LearnedEntry{Question: "How do channels work?", Answer: "Learned: they pass values."})
+	// The paraphrase scores about 0.68, above the threshold.
+	paraphrase := "how do channels work with values"
+	assertAnswerSource(t, ask(t, ai, paraphrase, AskOptions{}), SourceLearned)
+
+	ai.KB.learn(LearnedEntry{Question: "How do channels work?", Answer: "Learned: they pass values.", MinScore: 0.9})
+	// The exact question is always served.
+	assertAnswerSource(t, ask(t, ai, "How do channels work?", AskOptions{}), SourceLearned)
+	if answer, _ := ai.Ask(paraphrase, AskOptions{}); answer.Source == SourceLearned {
+		t.Errorf("paraphrase answered from the learned entry despite its min score")
+	}
+}
+
+// TestLearnedSurvivesVariants teaches questions and asks them back with
+// random changes of case, whitespace and trailing punctuation, which must
+// all be answered from the learned entry.
+func TestLearnedSurvivesVariants(t *testing.T) {
+	ai := newTestEngine(t)
+	ai.AdaptResponses = false
+	taught := map[string]string{
+		"How do I cancel a context?":      "Call the cancel function.",
+		"What does go vet check":          "Suspicious constructs.",
+		"Is a nil map safe to read?!":     "Yes; writes panic.",
+		"  Why   use sync.Once  ?":        "To run something exactly once.",
+		"Où sont les modules ÉPHÉMÈRES ?": "Dans le cache.",
+	}
+	for question, answer := range taught {
+		ai.KB.Learn(question, answer)
+	}
+	rng := rand.New(rand.NewSource(1))
+	spaces := []string{" ", "  ", "\t", " \n "}
+	for i := 0; i < 200; i++ {
+		for question, want := range taught {
+			variant := mangleQuestion(rng, question, spaces)
+			answer := ask(t, ai, variant, AskOptions{})
+			if answer.Source != SourceLearned || answer.Text != want {
+				t.Fatalf("%q, a variant of %q, answered %q from %s", variant, question, answer.Text, answer.Source)
+			}
+		}
+	}
+}
+
+// mangleQuestion changes the case of random letters of question, the
+// whitespace between its words and around it, and its trailing
+// punctuation.
+func mangleQuestion(rng *rand.Rand, question string, spaces []string) string {
+	words := strings.Fields(strings.TrimRightFunc(question, func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSpace(r) }))
+	var b strings.Builder
+	b.WriteString(spaces[rng.Intn(len(spaces))])
+	for i, word := range words {
+		if i > 0 {
+			b.WriteString(spaces[rng.Intn(len(spaces))])
+		}
+		for _, r := range word {
+			if rng.Intn(2) == 0 {
+				r = unicode.ToUpper(r)
+			} else {
+				r = unicode.ToLower(r)
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString([]string{"", "?", "??", "!", ".", " ?", "?!"}[rng.Intn(7)])
+	b.WriteString(spaces[rng.Intn(len(spaces))])
+	return b.String()
+}
+
+// TestLearnedLogMigratesKeys opens a log written before questions were
+// normalized, with variants of one question under their raw keys: they
+// load as one entry, under the normalized key, with the latest answer and
+// the wording it was taught with.
+func TestLearnedLogMigratesKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "askgo-learned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "learned.jsonl")
+	old := `{"ID":"How do I cancel a context?","Question":"How do I cancel a context?","Answer":"Call cancel."}
+{"ID":"how do I cancel a context","Question":"how do I cancel a context","Answer":"Call the cancel function."}
+{"ID":"What is a map?","Question":"What is a map?","Answer":"A hash table."}
+{"ID":"what is a MAP","Question":"what is a MAP","Forgotten":true}
+`
+	if err := ioutil.WriteFile(path, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := openLearnedLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	learned, _ := l.ListLearned()
+	if len(learned) != 1 {
+		t.Fatalf("loaded %+v, want one entry", learned)
+	}
+	ai := newTestEngine(t)
+	for _, entry := range learned {
+		ai.KB.learn(entry)
+	}
+	key := normalizeQuestion("How do I cancel a context?")
+	entry, ok := ai.KB.lookupLearned(key)
+	if !ok || entry.ID != learnedID(key) || entry.Answer != "Call the cancel function." || entry.Question != "how do I cancel a context" {
+		t.Errorf("migrated entry %+v, want the latest answer under %s", entry, learnedID(key))
+	}
+}
+
+// TestListLearnedShowsTaughtWording lists learned entries by the question
+// as it was taught, not by the normalized key they are stored under.
+func TestListLearnedShowsTaughtWording(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	if resp, body := f.do(t, http.MethodPost, "/learn", `{"question": "  How do I Cancel a Context?! ", "answer": "Call cancel."}`, testAdminKey, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("learn: status %d: %s", resp.StatusCode, body)
+	}
+	resp, body := f.do(t, http.MethodGet, "/learn", "", testAdminKey, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	var page struct {
+		Learned []learnedListing `json:"learned"`
+	}
+	if err := json.Unmarshal([]byte(body), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Learned) != 1 || page.Learned[0].Question != "  How do I Cancel a Context?! " {
+		t.Errorf("listed %+v, want the question as taught", page.Learned)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sort"
+)
+
+// learnedLog persists learned entries as an append-only JSON Lines file,
+// one entry per line, later lines replacing earlier ones for the same
+// question. Each line goes out in a single synced write, so a crash leaves
+// at most a torn last line, which loading skips.
+type learnedLog struct {
+	file *os.File
+	// size is the length of the file up to its last complete line.
+	size int64
+	// learned and skipped are what was read at open.
+	learned []LearnedEntry
+	skipped int
+}
+
+// learnedLine is a line of a learnedLog: an entry or, with Forgotten set,
+// the removal of the entry for Question.
+type learnedLine struct {
+	LearnedEntry
+	Forgotten bool `json:",omitempty"`
+}
+
+// Learn appends an entry.
+func (l *learnedLog) Learn(entry LearnedEntry) error {
+	return l.append(learnedLine{LearnedEntry: entry})
+}
+
+// Forget appends the removal of the entry for question.
+func (l *learnedLog) Forget(question string) error {
+	return l.append(learnedLine{LearnedEntry: LearnedEntry{ID: learnedID(normalizeQuestion(question)), Question: question}, Forgotten: true})
+}
+
+// append writes a line. A failed write is cut off again so the next line
+// starts on a clean boundary.
+func (l *learnedLog) append(line learnedLine) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := l.file.Write(data); err != nil {
+		l.file.Truncate(l.size)
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.size += int64(len(data))
+	return nil
+}
+
+// readLearnedLog reads the lines of a log, skipping those that don't
+// parse. A missing file is an empty log.
+func readLearnedLog(path string) (lines []learnedLine, skipped int, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var entry learnedLine
+			switch err := json.Unmarshal(line, &entry); {
+			case err != nil:
+				log.Printf("%s:%d: skipping corrupt learned entry: %v", path, n, err)
+				skipped++
+			case entry.Question == "":
+				log.Printf("%s:%d: skipping learned entry without a question", path, n)
+				skipped++
+			default:
+				lines = append(lines, entry)
+			}
+		}
+		if err == io.EOF {
+			return lines, skipped, nil
+		}
+	}
+}
+
+// openLearnedLog opens the log at path, creating it if needed. The file
+// is rewritten with one line per question, which drops corrupt lines,
+// superseded answers and forgotten questions.
+func openLearnedLog(path string) (*learnedLog, error) {
+	lines, skipped, err := readLearnedLog(path)
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]LearnedEntry, len(lines))
+	for _, line := range lines {
+		key := normalizeQuestion(line.Question)
+		if line.Forgotten {
+			delete(latest, key)
+			continue
+		}
+		entry := line.LearnedEntry
+		entry.ID = learnedID(key)
+		latest[key] = entry
+	}
+	all := make([]LearnedEntry, 0, len(latest))
+	for _, entry := range latest {
+		all = append(all, entry)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	var buf bytes.Buffer
+	for _, entry := range all {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &learnedLog{file: f, size: int64(buf.Len()), learned: all, skipped: skipped}, nil
+}
+
+// AddEntry does nothing: without a database, entries come from
+// prompt.json alone.
+func (l *learnedLog) AddEntry(entries ...KnowledgeEntry) error {
+	return nil
+}
+
+func (l *learnedLog) ListEntries() ([]KnowledgeEntry, error) {
+	return nil, nil
+}
+
+// ListLearned returns the entries read when the log was opened.
+func (l *learnedLog) ListLearned() ([]LearnedEntry, error) {
+	return l.learned, nil
+}
+
+func (l *learnedLog) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,45 @@
+package main
+
+import "hash/fnv"
+
+// lexicalDimension is the size of the vectors built while no embeddings
+// are loaded. Each word stem is hashed to one dimension, so the cosine
+// similarity of two such vectors measures the stems their sentences share.
+// It is prime, which spreads the hashes, and no real embeddings have it,
+// so lexical vectors kept in a store are never mistaken for real ones.
+const lexicalDimension = 1021
+
+// lexicalVector is the sentence vector of words when there are no
+// embeddings: a bag of their stems, each weighted by idf. It is nil when
+// words is empty.
+func lexicalVector(words []string, idf *idfTable) []float32 {
+	var vec []float32
+	for _, word := range words {
+		stem := stemWord(word)
+		if stem == "" {
+			continue
+		}
+		if vec == nil {
+			vec = make([]float32, lexicalDimension)
+		}
+		h := fnv.New32a()
+		h.Write([]byte(stem))
+		vec[h.Sum32()%lexicalDimension] += float32(idf.weight(word))
+	}
+	return vec
+}
+
+// embeddingVectorDimension is the dimension of the sentence vectors built
+// from embeddings: theirs, or lexicalDimension when they are empty.
+func embeddingVectorDimension(embeddings *EmbeddingStore) int {
+	if embeddings.Len() == 0 {
+		return lexicalDimension
+	}
+	return embeddings.Dimension()
+}
+
+// lexicalOnly reports whether the engine has no embeddings and matches
+// questions by the words they share instead.
+func (ai *AIEngine) lexicalOnly() bool {
+	return ai.embeddings().Len() == 0
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxBytesPerToken bounds the analyzed text when a question has few but
+// enormous whitespace-separated fields (e.g. unsegmented CJK).
+const maxBytesPerToken = 32
+
+// AnalysisLimits caps how much of a question is analyzed, so pathological
+// input can't make tokenization or prose run away.
+type AnalysisLimits struct {
+	MaxTokens        int `json:"max_tokens" schema:"minimum=1"`
+	MaxKeywords      int `json:"max_keywords" schema:"minimum=1"`
+	MaxKeywordLength int `json:"max_keyword_length" schema:"minimum=1"`
+}
+
+var defaultAnalysisLimits = AnalysisLimits{
+	MaxTokens:        256,
+	MaxKeywords:      32,
+	MaxKeywordLength: 64,
+}
+
+// withDefaults fills unset limits from defaultAnalysisLimits.
+func (l AnalysisLimits) withDefaults() AnalysisLimits {
+	if l.MaxTokens <= 0 {
+		l.MaxTokens = defaultAnalysisLimits.MaxTokens
+	}
+	if l.MaxKeywords <= 0 {
+		l.MaxKeywords = defaultAnalysisLimits.MaxKeywords
+	}
+	if l.MaxKeywordLength <= 0 {
+		l.MaxKeywordLength = defaultAnalysisLimits.MaxKeywordLength
+	}
+	return l
+}
+
+// truncateForAnalysis keeps the first MaxTokens whitespace-separated fields
+// of s, bounded in bytes, and reports whether anything was cut.
+func (l AnalysisLimits) truncateForAnalysis(s string) (string, bool) {
+	fields := 0
+	inField := false
+	for i, r := range s {
+		if unicode.IsSpace(r) {
+			inField = false
+			continue
+		}
+		if !inField {
+			if fields == l.MaxTokens {
+				return strings.TrimRightFunc(s[:i], unicode.IsSpace), true
+			}
+			fields++
+			inField = true
+		}
+	}
+	maxBytes := l.MaxTokens * maxBytesPerToken
+	if len(s) > maxBytes {
+		cut := maxBytes
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		return s[:cut], true
+	}
+	return s, false
+}
+
+// capKeywords drops overlong keywords and keeps at most MaxKeywords distinct
+// ones, reporting whether any were dropped.
+func (l AnalysisLimits) capKeywords(keywords []string) ([]string, bool) {
+	truncated := false
+	seen := make(map[string]bool)
+	var kept []string
+	for _, keyword := range keywords {
+		if len(keyword) > l.MaxKeywordLength {
+			truncated = true
+			continue
+		}
+		folded := strings.ToLower(keyword)
+		if !seen[folded] {
+			if len(seen) == l.MaxKeywords {
+				truncated = true
+				continue
+			}
+			seen[folded] = true
+		}
+		kept = append(kept, keyword)
+	}
+	return kept, truncated
+}
+
+// maxQuestionBytes is the longest question Ask accepts at all. Questions
+// up to it are answered from their truncated analysis; longer ones are
+// refused, since even normalizing them is wasted work.
+func (l AnalysisLimits) maxQuestionBytes() int {
+	return 2 * l.MaxTokens * maxBytesPerToken
+}
+
+// checkLearnQuestion rejects taught questions that exceed the caps instead of
+// silently truncating them.
+func (l AnalysisLimits) checkLearnQuestion(question string) error {
+	fields := strings.Fields(question)
+	if len(fields) > l.MaxTokens {
+		return newError(ErrInvalidInput, "question has %d words, the limit is %d", len(fields), l.MaxTokens)
+	}
+	if len(question) > l.MaxTokens*maxBytesPerToken {
+		return newError(ErrInvalidInput, "question is %d bytes, the limit is %d", len(question), l.MaxTokens*maxBytesPerToken)
+	}
+	for _, field := range fields {
+		if len(field) > l.MaxKeywordLength {
+			return newError(ErrInvalidInput, "question contains a %d-byte word, the limit is %d", len(field), l.MaxKeywordLength)
+		}
+	}
+	return nil
+}
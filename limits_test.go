@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalysisLimitsCapTheQuery(t *testing.T) {
+	ai := newTestEngine(t)
+	limits := AnalysisLimits{MaxTokens: 8, MaxKeywords: 3, MaxKeywordLength: 10}
+	tests := []struct {
+		name, question string
+		truncated      bool
+	}{
+		{"within the caps", "How do channels work?", false},
+		{"too many tokens", strings.Repeat("go ", 100), true},
+		{"too many keywords", "goroutines channels errors maps slices", true},
+		{"overlong keyword", "How do I configure internationalization?", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQuery(tt.question, ai.Embeddings, limits, ai.KB.view(), nil)
+			if q.Truncated != tt.truncated {
+				t.Errorf("truncated = %v, want %v", q.Truncated, tt.truncated)
+			}
+			if len(q.Tokens) > limits.MaxTokens {
+				t.Errorf("%d tokens, cap %d", len(q.Tokens), limits.MaxTokens)
+			}
+			distinct := make(map[string]bool)
+			for _, keyword := range q.Keywords {
+				distinct[strings.ToLower(keyword)] = true
+				if len(keyword) > limits.MaxKeywordLength {
+					t.Errorf("keyword %q over %d bytes", keyword, limits.MaxKeywordLength)
+				}
+			}
+			if len(distinct) > limits.MaxKeywords {
+				t.Errorf("%d distinct keywords %q, cap %d", len(distinct), q.Keywords, limits.MaxKeywords)
+			}
+		})
+	}
+}
+
+// TestVerboseReportsTruncatedAnalysis flags a question cut short by the
+// caps in the verbose response, and only that one.
+func TestVerboseReportsTruncatedAnalysis(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	for _, tt := range []struct {
+		question  string
+		truncated bool
+	}{
+		{"How do channels work?", false},
+		{strings.Repeat("channels ", defaultAnalysisLimits.MaxTokens+1), true},
+	} {
+		body, _ := json.Marshal(Question{Text: tt.question, Verbose: true})
+		resp, reply := f.do(t, http.MethodPost, "/ai", string(body), "", nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d: %s", resp.StatusCode, reply)
+		}
+		var got struct {
+			TruncatedAnalysis bool `json:"truncated_analysis"`
+		}
+		if err := json.Unmarshal([]byte(reply), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.TruncatedAnalysis != tt.truncated {
+			t.Errorf("%.30q: truncated_analysis = %v, want %v", tt.question, got.TruncatedAnalysis, tt.truncated)
+		}
+	}
+}
+
+// TestLearnRejectsOverCapQuestions refuses to teach questions the caps
+// would truncate, rather than truncating them.
+func TestLearnRejectsOverCapQuestions(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	limits := defaultAnalysisLimits
+	for name, question := range map[string]string{
+		"too many words": strings.Repeat("go ", limits.MaxTokens+1),
+		"too many bytes": strings.Repeat("通道是什么", limits.MaxTokens*maxBytesPerToken/15+1),
+		"overlong word":  "What is " + strings.Repeat("x", limits.MaxKeywordLength+1) + "?",
+	} {
+		body, _ := json.Marshal(map[string]string{"question": question, "answer": "No."})
+		if resp, reply := f.do(t, http.MethodPost, "/learn", string(body), testAdminKey, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400: %s", name, resp.StatusCode, reply)
+		}
+	}
+	if n := len(f.deps.ai.KB.view().learned); n != 0 {
+		t.Errorf("%d questions learned", n)
+	}
+}
+
+// Budgets of one adversarial question through the whole pipeline. They
+// are tens of times what such questions take, so only a runaway fails.
+const (
+	adversarialTimeBudget  = 500 * time.Millisecond
+	adversarialAllocBudget = 32 << 20
+)
+
+// TestAdversarialInputsStayInBudget runs adversarial questions through
+// Ask under a time and allocation budget each.
+func TestAdversarialInputsStayInBudget(t *testing.T) {
+	ai := newTestEngine(t)
+	limit := ai.Limits.maxQuestionBytes()
+	rng := rand.New(rand.NewSource(1))
+	gibberish := make([]string, 10000)
+	for i := range gibberish {
+		word := make([]byte, 8)
+		for j := range word {
+			word[j] = byte('a' + rng.Intn(26))
+		}
+		gibberish[i] = string(word)
+	}
+	inputs := map[string]string{
+		"2MB of one word":         strings.Repeat("go ", 700000),
+		"one word up to the cap":  strings.Repeat("go ", limit/3),
+		"10k distinct words":      strings.Join(gibberish, " ")[:limit],
+		"one enormous word":       strings.Repeat("x", limit),
+		"unsegmented CJK":         strings.Repeat("通道是什么", limit/15),
+		"punctuation":             strings.Repeat("?!. ", limit/4),
+		"control and zero widths": strings.Repeat("\x00\u200b\ufeff", limit/8),
+		"invalid UTF-8":           strings.Repeat("\xff\xfe go", limit/5),
+	}
+	ai.Ask("warm up", AskOptions{})
+	for name, question := range inputs {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		answer, _ := ai.Ask(question, AskOptions{})
+		took := time.Since(start)
+		runtime.ReadMemStats(&after)
+		if answer.Text == "" {
+			t.Errorf("%s: empty answer", name)
+		}
+		if took > adversarialTimeBudget {
+			t.Errorf("%s: took %v, budget %v", name, took, adversarialTimeBudget)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > adversarialAllocBudget {
+			t.Errorf("%s: allocated %d bytes, budget %d", name, allocated, adversarialAllocBudget)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of the LLM fallback cache.
+const (
+	defaultLLMCacheSize     = 1000
+	defaultLLMCacheTTL      = time.Hour
+	defaultLLMCacheMaxStale = 24 * time.Hour
+)
+
+// maxLLMAnswerBytes bounds the reply read from the model's endpoint.
+const maxLLMAnswerBytes = 64 << 10
+
+// LLMConfig configures the LLM fallback.
+type LLMConfig struct {
+	// URL is the endpoint questions are posted to; empty turns the
+	// fallback off.
+	URL string
+	// CacheSize bounds the answers kept; 0 keeps none.
+	CacheSize int
+	// TTL is how long a cached answer is served as is, and MaxStale how
+	// long after it was fetched it is still served while a fresh one is
+	// fetched in the background.
+	TTL      time.Duration
+	MaxStale time.Duration
+	// CallCost is what one call to the model costs, in whatever unit the
+	// operator bills in, for the spend-saved metric.
+	CallCost float64
+}
+
+// LLMFallback asks an external model the questions no retriever answered,
+// before the canned default responses are tried. It posts
+// {"question": ..., "language": ...} to the endpoint and serves the
+// "answer" of its JSON reply.
+//
+// Answers are cached by normalized question and language, and served
+// stale while they revalidate: within the TTL a cached answer is served
+// as is; after it, until MaxStale, it is still served while one call in
+// the background refreshes it; past MaxStale the asker waits for a fresh
+// call. The model never answers a question the knowledge base has
+// learned, since knowledge base answers always win: asked once learned, a
+// question's cached answer is dropped. A nil *LLMFallback answers nothing.
+type LLMFallback struct {
+	// The counts come first, where atomic keeps them 64-bit aligned.
+	calls, refreshes, failures, hits, staleHits, misses uint64
+
+	url      string
+	client   *http.Client
+	size     int
+	ttl      time.Duration
+	maxStale time.Duration
+	callCost float64
+
+	mu sync.Mutex
+	// order lists the cached answers, most recently used first; items
+	// finds their elements by key.
+	order *list.List
+	items map[string]*list.Element
+}
+
+// llmAnswer is an answer of the model as cached.
+type llmAnswer struct {
+	key     string
+	text    string
+	fetched time.Time
+	// refreshing is set while a background call refreshes the answer.
+	refreshing bool
+}
+
+// NewLLMFallback returns a fallback calling cfg.URL with client, or nil if
+// the URL is empty.
+func NewLLMFallback(cfg LLMConfig, client *http.Client) (*LLMFallback, error) {
+	if cfg.URL == "" {
+		return nil, nil
+	}
+	if cfg.CacheSize < 0 || cfg.TTL < 0 || cfg.MaxStale < cfg.TTL || cfg.CallCost < 0 {
+		return nil, fmt.Errorf("cache size, TTL and call cost must not be negative, and max staleness not below the TTL")
+	}
+	return &LLMFallback{
+		url:      cfg.URL,
+		client:   client,
+		size:     cfg.CacheSize,
+		ttl:      cfg.TTL,
+		maxStale: cfg.MaxStale,
+		callCost: cfg.CallCost,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}, nil
+}
+
+// answer returns the model's answer to question in language, from the
+// cache when it can, and whether it has one. state is the knowledge base
+// the question was found unanswered in.
+func (l *LLMFallback) answer(state *kbState, question, language string, now time.Time) (text string, cached, ok bool) {
+	if l == nil {
+		return "", false, false
+	}
+	key := normalizeQuestion(question) + "\xff" + language
+	if _, learned := state.lookupLearned(normalizeQuestion(question)); learned {
+		l.forget(key)
+		return "", false, false
+	}
+	l.mu.Lock()
+	if e, ok := l.items[key]; ok {
+		cached := e.Value.(*llmAnswer)
+		age := now.Sub(cached.fetched)
+		switch {
+		case age < l.ttl:
+			l.order.MoveToFront(e)
+			l.mu.Unlock()
+			atomic.AddUint64(&l.hits, 1)
+			return cached.text, true, true
+		case age < l.maxStale:
+			l.order.MoveToFront(e)
+			atomic.AddUint64(&l.staleHits, 1)
+			if !cached.refreshing {
+				cached.refreshing = true
+				go l.refresh(key, question, language, now)
+			}
+			l.mu.Unlock()
+			return cached.text, true, true
+		}
+	}
+	l.mu.Unlock()
+	atomic.AddUint64(&l.misses, 1)
+	text, err := l.call(question, language)
+	if err != nil {
+		log.Printf("LLM fallback failed: %v", err)
+		return "", false, false
+	}
+	l.put(key, text, now)
+	return text, false, true
+}
+
+// refresh fetches a fresh answer for a stale one in the background. If
+// the call fails, the stale answer stays for a later request to retry.
+func (l *LLMFallback) refresh(key, question, language string, now time.Time) {
+	atomic.AddUint64(&l.refreshes, 1)
+	text, err := l.call(question, language)
+	if err != nil {
+		log.Printf("LLM fallback refresh failed: %v", err)
+		l.mu.Lock()
+		if e, ok := l.items[key]; ok {
+			e.Value.(*llmAnswer).refreshing = false
+		}
+		l.mu.Unlock()
+		return
+	}
+	l.put(key, text, now)
+}
+
+// call asks the model.
+func (l *LLMFallback) call(question, language string) (string, error) {
+	atomic.AddUint64(&l.calls, 1)
+	text, err := l.post(question, language)
+	if err != nil {
+		atomic.AddUint64(&l.failures, 1)
+	}
+	return text, err
+}
+
+func (l *LLMFallback) post(question, language string) (string, error) {
+	body, err := json.Marshal(map[string]string{"question": question, "language": language})
+	if err != nil {
+		return "", err
+	}
+	resp, err := l.client.Post(l.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxLLMAnswerBytes))
+		return "", fmt.Errorf("%s returned %s", l.url, resp.Status)
+	}
+	var reply struct {
+		Answer string `json:"answer"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxLLMAnswerBytes)).Decode(&reply); err != nil {
+		return "", fmt.Errorf("%s: %v", l.url, err)
+	}
+	if strings.TrimSpace(reply.Answer) == "" {
+		return "", fmt.Errorf("%s returned no answer", l.url)
+	}
+	return reply.Answer, nil
+}
+
+// put caches text for key as fetched at now, evicting the least recently
+// used answer when the cache is full.
+func (l *LLMFallback) put(key, text string, now time.Time) {
+	if l.size <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	answer := &llmAnswer{key: key, text: text, fetched: now}
+	if e, ok := l.items[key]; ok {
+		e.Value = answer
+		l.order.MoveToFront(e)
+		return
+	}
+	l.items[key] = l.order.PushFront(answer)
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*llmAnswer).key)
+	}
+}
+
+// forget drops the answer cached for key.
+func (l *LLMFallback) forget(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.items[key]; ok {
+		l.order.Remove(e)
+		delete(l.items, key)
+	}
+}
+
+// Len returns the number of answers cached, however stale.
+func (l *LLMFallback) Len() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+// hitRatio is the share of answers served from the cache, fresh or stale.
+func (l *LLMFallback) hitRatio() float64 {
+	hits := atomic.LoadUint64(&l.hits) + atomic.LoadUint64(&l.staleHits)
+	total := hits + atomic.LoadUint64(&l.misses)
+	if total == 0 {
+		return 0
+	}
+	return float64(hits) / float64(total)
+}
+
+// spendSaved is the cost of the calls answers from the cache saved: one
+// per answer served from it, less the background refreshes stale ones
+// set off.
+func (l *LLMFallback) spendSaved() float64 {
+	served := atomic.LoadUint64(&l.hits) + atomic.LoadUint64(&l.staleHits)
+	return float64(served-atomic.LoadUint64(&l.refreshes)) * l.callCost
+}
+
+// registerMetrics exposes the calls and cache counts of l on m. A nil
+// fallback has none to expose.
+func (l *LLMFallback) registerMetrics(m *Metrics) {
+	if l == nil {
+		return
+	}
+	m.Counter("askgo_llm_fallback_calls_total", "Calls made to the LLM fallback, refreshes included.", func() float64 { return float64(atomic.LoadUint64(&l.calls)) })
+	m.Counter("askgo_llm_fallback_failures_total", "Calls to the LLM fallback that failed.", func() float64 { return float64(atomic.LoadUint64(&l.failures)) })
+	m.Counter("askgo_llm_fallback_cache_hits_total", "LLM answers served from the cache within the TTL.", func() float64 { return float64(atomic.LoadUint64(&l.hits)) })
+	m.Counter("askgo_llm_fallback_cache_stale_hits_total", "Stale LLM answers served from the cache while they were refreshed.", func() float64 { return float64(atomic.LoadUint64(&l.staleHits)) })
+	m.Counter("askgo_llm_fallback_cache_misses_total", "LLM answers the asker waited for a call for.", func() float64 { return float64(atomic.LoadUint64(&l.misses)) })
+	m.Gauge("askgo_llm_fallback_cache_hit_ratio", "Share of LLM answers served from the cache, fresh or stale.", l.hitRatio)
+	m.Counter("askgo_llm_fallback_spend_saved_total", "Cost of the calls the cache saved, at -llm-fallback-call-cost each.", l.spendSaved)
+	m.Gauge("askgo_llm_fallback_cache_entries", "Answers in the LLM fallback cache.", func() float64 { return float64(l.Len()) })
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// llmServer is a model endpoint answering "answer N" to its Nth call, or
+// failing every call when failing is set.
+type llmServer struct {
+	*httptest.Server
+	calls   int32
+	failing int32
+}
+
+func newLLMServer(t *testing.T) *llmServer {
+	t.Helper()
+	s := &llmServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Question, Language string }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Question == "" {
+			t.Errorf("model got %+v, %v", req, err)
+		}
+		n := atomic.AddInt32(&s.calls, 1)
+		if atomic.LoadInt32(&s.failing) != 0 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"answer": fmt.Sprint("answer ", n)})
+	}))
+	return s
+}
+
+func newTestLLM(t *testing.T, url string) *LLMFallback {
+	t.Helper()
+	l, err := NewLLMFallback(LLMConfig{URL: url, CacheSize: 10, TTL: time.Minute, MaxStale: time.Hour, CallCost: 0.5}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestLLMFallbackAnswersWhatNothingElseDoes(t *testing.T) {
+	model := newLLMServer(t)
+	defer model.Close()
+	ai := newTestEngine(t)
+	ai.LLM = newTestLLM(t, model.URL)
+
+	answer, err := ai.Ask("How do I vendor dependencies?", AskOptions{})
+	if answer.Source != SourceLLM || answer.Text != "answer 1" {
+		t.Fatalf("unmatched question answered %q from %s", answer.Text, answer.Source)
+	}
+	if !answer.LowConfidence() || err == nil {
+		t.Errorf("LLM answer counted as a match: error %v", err)
+	}
+	if answer, _ := ai.Ask("How do I vendor dependencies?", AskOptions{}); answer.Text != "answer 1" || answer.Sources[len(answer.Sources)-1].Stage != StageCache {
+		t.Errorf("asked again: %q with sources %+v, want answer 1 from the cache", answer.Text, answer.Sources)
+	}
+	if answer, _ := ai.Ask(testEntries[0].Question, AskOptions{}); answer.Source != SourceKnowledgeBase {
+		t.Errorf("knowledge base question answered from %s", answer.Source)
+	}
+	if calls := atomic.LoadInt32(&model.calls); calls != 1 {
+		t.Errorf("model called %d times, want 1", calls)
+	}
+
+	atomic.StoreInt32(&model.failing, 1)
+	if answer, _ := ai.Ask("How do I cross-compile?", AskOptions{}); answer.Source != SourceDefault {
+		t.Errorf("with the model failing, answered from %s", answer.Source)
+	}
+}
+
+// TestLLMFallbackStaleWhileRevalidate serves cached answers within the
+// TTL, stale ones while a background call refreshes them, and waits for a
+// fresh call past the staleness bound.
+func TestLLMFallbackStaleWhileRevalidate(t *testing.T) {
+	model := newLLMServer(t)
+	defer model.Close()
+	l := newTestLLM(t, model.URL)
+	state := newTestEngine(t).KB.view()
+	start := time.Now()
+	ask := func(after time.Duration) (string, bool) {
+		t.Helper()
+		text, cached, ok := l.answer(state, "How do I vendor dependencies?", "en", start.Add(after))
+		if !ok {
+			t.Fatalf("no answer after %v", after)
+		}
+		return text, cached
+	}
+	waitCalls := func(n int32) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&model.calls) < n; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("model called %d times, want %d", atomic.LoadInt32(&model.calls), n)
+			}
+		}
+	}
+
+	if text, cached := ask(0); text != "answer 1" || cached {
+		t.Fatalf("first ask: %q, cached %v", text, cached)
+	}
+	if text, cached := ask(30 * time.Second); text != "answer 1" || !cached {
+		t.Errorf("within the TTL: %q, cached %v", text, cached)
+	}
+	if text, cached := ask(2 * time.Minute); text != "answer 1" || !cached {
+		t.Errorf("stale: %q, cached %v, want the stale answer", text, cached)
+	}
+	waitCalls(2)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if text, _ := ask(2*time.Minute + 30*time.Second); text == "answer 2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("refreshed answer never served")
+		}
+	}
+	if text, cached := ask(3 * time.Hour); text != "answer 3" || cached {
+		t.Errorf("past max staleness: %q, cached %v, want a fresh call", text, cached)
+	}
+	if calls := atomic.LoadInt32(&model.calls); calls != 3 {
+		t.Errorf("model called %d times, want 3", calls)
+	}
+	// Two answers were served from the cache, one of them setting off a
+	// refresh, before polling for the refreshed one served more.
+	if got, want := l.spendSaved(), 0.5; got < want {
+		t.Errorf("spend saved %v, want at least %v", got, want)
+	}
+	if ratio := l.hitRatio(); ratio <= 0 || ratio >= 1 {
+		t.Errorf("hit ratio %v", ratio)
+	}
+}
+
+// TestLLMFallbackYieldsToLearned drops the cached answer of a question
+// once it is learned, and never calls the model for it again.
+func TestLLMFallbackYieldsToLearned(t *testing.T) {
+	model := newLLMServer(t)
+	defer model.Close()
+	ai := newTestEngine(t)
+	ai.LLM = newTestLLM(t, model.URL)
+	question := "How do I vendor dependencies?"
+	if answer, _ := ai.Ask(question, AskOptions{}); answer.Source != SourceLLM {
+		t.Fatalf("answered from %s", answer.Source)
+	}
+	ai.KB.Learn(question, "Run go mod vendor.")
+	if answer, _ := ai.Ask(question, AskOptions{}); answer.Source != SourceLearned {
+		t.Errorf("learned question answered from %s", answer.Source)
+	}
+	// Even where the learned answer isn't served, the model's isn't either.
+	if _, _, ok := ai.LLM.answer(ai.KB.view(), question, "en", time.Now()); ok {
+		t.Error("model answered a learned question")
+	}
+	if n := ai.LLM.Len(); n != 0 {
+		t.Errorf("%d answers still cached", n)
+	}
+	if calls := atomic.LoadInt32(&model.calls); calls != 1 {
+		t.Errorf("model called %d times, want 1", calls)
+	}
+}
+
+// TestLLMFallbackSwitch leaves questions to the default responses while
+// llm_fallback_enabled is off, globally or for the asker's tenant.
+func TestLLMFallbackSwitch(t *testing.T) {
+	model := newLLMServer(t)
+	defer model.Close()
+	dir, err := ioutil.TempDir("", "askgo-llm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ai := newTestEngine(t)
+	ai.LLM = newTestLLM(t, model.URL)
+	if ai.Switches, err = LoadSwitches(filepath.Join(dir, "switches.json")); err != nil {
+		t.Fatal(err)
+	}
+	question := "How do I vendor dependencies?"
+
+	ai.Switches.Set(SwitchLLMFallback, "acme", false, "test")
+	if answer, _ := ai.Ask(question, AskOptions{Tenant: "acme"}); answer.Source != SourceDefault {
+		t.Errorf("switched off for acme, acme answered from %s", answer.Source)
+	}
+	if answer, _ := ai.Ask(question, AskOptions{Tenant: "globex"}); answer.Source != SourceLLM {
+		t.Errorf("switched off for acme, globex answered from %s", answer.Source)
+	}
+	ai.Switches.Set(SwitchLLMFallback, "", false, "test")
+	if answer, _ := ai.Ask("How do I cross-compile?", AskOptions{Tenant: "globex"}); answer.Source != SourceDefault {
+		t.Errorf("switched off globally, answered from %s", answer.Source)
+	}
+	if calls := atomic.LoadInt32(&model.calls); calls != 1 {
+		t.Errorf("model called %d times, want 1", calls)
+	}
+}
+
+// TestLLMFallbackFailuresSurface reports a failing model endpoint in
+// /healthz and the metrics, labelled with its destination.
+func TestLLMFallbackFailuresSurface(t *testing.T) {
+	model := newLLMServer(t)
+	defer model.Close()
+	atomic.StoreInt32(&model.failing, 1)
+	outbound, err := NewOutbound(OutboundConfig{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := NewMetrics()
+	outbound.registerMetrics(metrics)
+	ai := newTestEngine(t)
+	if ai.LLM, err = NewLLMFallback(LLMConfig{URL: model.URL, CacheSize: 10, TTL: time.Minute, MaxStale: time.Hour}, outbound.Client("llm-fallback")); err != nil {
+		t.Fatal(err)
+	}
+	if answer, _ := ai.Ask("How do I vendor dependencies?", AskOptions{}); answer.Source != SourceDefault {
+		t.Fatalf("with the model failing, answered from %s", answer.Source)
+	}
+
+	rec := httptest.NewRecorder()
+	handleHealthz(ai, outbound)(rec, httptest.NewRequest("GET", "/healthz", nil))
+	var health struct {
+		Outbound map[string]DestinationHealth `json:"outbound"`
+		Warnings []string                     `json:"warnings"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if h := health.Outbound["llm-fallback"]; h.Requests != 1 || h.Failures != 1 || !h.Failing {
+		t.Errorf("llm-fallback health %+v", h)
+	}
+	if !containsPrefix(health.Warnings, "outbound llm-fallback: ") {
+		t.Errorf("warnings %q name no failing llm-fallback", health.Warnings)
+	}
+
+	rec = httptest.NewRecorder()
+	handleMetrics(metrics)(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, series := range []string{
+		`askgo_outbound_requests_total{destination="llm-fallback"} 1`,
+		`askgo_outbound_failures_total{destination="llm-fallback"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), series+"\n") {
+			t.Errorf("metrics lack %s", series)
+		}
+	}
+}
+
+func containsPrefix(list []string, prefix string) bool {
+	for _, s := range list {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultLockBudget is how long a knowledge base write lock may be held
+// before the watchdog logs a warning.
+const defaultLockBudget = 100 * time.Millisecond
+
+// LockStats tracks how long knowledge base writers wait for the write lock
+// and how long they hold it, per wall-clock minute. Readers don't lock.
+type LockStats struct {
+	mu      sync.Mutex
+	budget  time.Duration
+	minute  time.Time
+	current LockMinute
+	last    LockMinute
+	slow    int
+}
+
+// LockMinute is the worst wait and hold observed in one minute.
+type LockMinute struct {
+	Minute    time.Time `json:"minute"`
+	MaxWaitMS float64   `json:"max_wait_ms"`
+	MaxHoldMS float64   `json:"max_hold_ms"`
+	MaxHoldOp string    `json:"max_hold_op,omitempty"`
+}
+
+func (s *LockStats) roll(now time.Time) {
+	minute := now.Truncate(time.Minute)
+	if minute.Equal(s.minute) {
+		return
+	}
+	if minute.Sub(s.minute) == time.Minute {
+		s.last = s.current
+	} else {
+		s.last = LockMinute{Minute: minute.Add(-time.Minute)}
+	}
+	s.minute = minute
+	s.current = LockMinute{Minute: minute}
+}
+
+func (s *LockStats) observeWait(wait time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roll(time.Now())
+	if ms := durationMS(wait); ms > s.current.MaxWaitMS {
+		s.current.MaxWaitMS = ms
+	}
+}
+
+func (s *LockStats) observeHold(op string, hold time.Duration) {
+	s.mu.Lock()
+	s.roll(time.Now())
+	if ms := durationMS(hold); ms > s.current.MaxHoldMS {
+		s.current.MaxHoldMS = ms
+		s.current.MaxHoldOp = op
+	}
+	budget := s.budget
+	if budget == 0 {
+		budget = defaultLockBudget
+	}
+	slow := hold > budget
+	if slow {
+		s.slow++
+	}
+	s.mu.Unlock()
+	if slow {
+		log.Printf("Knowledge base write lock held %v by %s, over the %v budget", hold, op, budget)
+	}
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// SetBudget sets the hold time above which the watchdog warns.
+func (s *LockStats) SetBudget(budget time.Duration) {
+	s.mu.Lock()
+	s.budget = budget
+	s.mu.Unlock()
+}
+
+// writeLock takes the knowledge base write lock for op, recording the wait,
+// and returns an unlock that records the hold time.
+func (kb *KnowledgeBase) writeLock(op string) func() {
+	start := time.Now()
+	kb.mu.Lock()
+	acquired := time.Now()
+	kb.locks.observeWait(acquired.Sub(start))
+	return func() {
+		hold := time.Since(acquired)
+		kb.mu.Unlock()
+		kb.locks.observeHold(op, hold)
+	}
+}
+
+// handleLockStats serves /admin/locks.
+func handleLockStats(kb *KnowledgeBase) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := &kb.locks
+		s.mu.Lock()
+		s.roll(time.Now())
+		budget := s.budget
+		if budget == 0 {
+			budget = defaultLockBudget
+		}
+		report := map[string]interface{}{
+			"budget_ms":       durationMS(budget),
+			"slow_holds":      s.slow,
+			"current_minute":  s.current,
+			"previous_minute": s.last,
+		}
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"io/ioutil"
@@ -9,46 +14,396 @@ import (
 	"math"
 	"math/rand"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
 
-	"github.com/jdkato/prose/v2"
+	"google.golang.org/grpc"
 )
 
 type AIResponse struct {
-	Answer string `json:"answer"`
+	Answer          string `json:"answer"`
+	Highlights      []Span `json:"highlights,omitempty"`
+	EscalationToken string `json:"escalation_token,omitempty"`
+	Warning         string `json:"warning,omitempty"`
+	// EntryID and Variant identify the experiment variant served, so
+	// feedback can be attributed to it.
+	EntryID string `json:"entry_id,omitempty"`
+	Variant *int   `json:"variant,omitempty"`
+	// TruncatedAnalysis reports that only part of the question was analyzed.
+	TruncatedAnalysis bool `json:"truncated_analysis,omitempty"`
+	// TeachToken invites the user to answer the question at /ai/teach.
+	TeachToken string `json:"teach_token,omitempty"`
+	// Source is the stage that produced the answer; Sources, returned for
+	// verbose requests, breaks the answer down by every stage involved.
+	Source  string       `json:"source"`
+	Sources []SourcePart `json:"sources,omitempty"`
+	// Confidence is the calibrated score the answer was chosen on, from 0
+	// to 1, comparable across sources; it is 0 for fallbacks.
+	Confidence float64 `json:"confidence"`
+	// Related suggests follow-up questions for detailed answers.
+	Related []string `json:"related,omitempty"`
+	// Warnings lists soft problems for verbose requests.
+	Warnings []Warning `json:"warnings,omitempty"`
+	// Operators lists the inline operators applied, for verbose requests.
+	Operators *Operators `json:"operators,omitempty"`
+	// Candidates lists, for verbose requests, the best candidate of each
+	// retriever with its raw score and calibrated confidence.
+	Candidates []Candidate `json:"candidates,omitempty"`
+	// Vocabulary counts, for verbose requests, the question's words
+	// missing from the embeddings.
+	Vocabulary *VocabularyCoverage `json:"vocabulary,omitempty"`
+	// Corrected maps the misspelled words of the question to the words
+	// they were read as.
+	Corrected map[string]string `json:"corrected,omitempty"`
+	// Language is the language the question was answered in, when the
+	// prompt file has language sections.
+	Language string `json:"language,omitempty"`
 }
 
 type Question struct {
-	Text string `json:"text"`
+	Text      string `json:"text" schema:"required"`
+	Highlight bool   `json:"highlight"`
+	// Attribution overrides the configured attribution footer setting.
+	Attribution *bool  `json:"attribution"`
+	SessionID   string `json:"session_id"`
+	Scope       string `json:"scope"`
+	// Verbose adds the per-stage source breakdown to the response.
+	Verbose bool `json:"verbose"`
+	// Style is "concise", "normal" (the default) or "detailed".
+	Style string `json:"style"`
+	// Tags, when given, restrict the answer to knowledge base and learned
+	// entries carrying at least one of them: they are the AnyTags of the
+	// request. #tag operators in the text add RequireTags instead.
+	Tags []string `json:"tags"`
+	// Language, a code such as "ru", answers in that language rather than
+	// the one detected.
+	Language string `json:"language"`
 }
 
+// KnowledgeEntry is stored and exported as JSON with snake_case names;
+// see UnmarshalJSON for the CamelCase names of earlier versions.
 type KnowledgeEntry struct {
-	Question string
-	Answer   string
-	Vector   []float64
+	ID       string    `json:"id"`
+	Question string    `json:"question"`
+	Answer   string    `json:"answer"`
+	Vector   []float32 `json:"vector"`
+	// MinScore, when non-zero, is the lowest similarity at which this entry
+	// may be served, overriding the global threshold for risky answers.
+	MinScore float64 `json:"min_score"`
+	// Weight, when non-zero, multiplies the entry's similarity to rank it
+	// above or below other entries; see weightedScore.
+	Weight    float64 `json:"weight,omitempty"`
+	SourceURL string  `json:"source_url"`
+	// CreatedAt and VerifiedAt drive the re-verification workflow; entries
+	// loaded without timestamps are backfilled with the load time.
+	CreatedAt    time.Time `json:"created_at"`
+	VerifiedAt   time.Time `json:"verified_at"`
+	NeedsRewrite bool      `json:"needs_rewrite"`
+	// Quarantined entries are kept but never matched.
+	Quarantined bool `json:"quarantined"`
+	// Tombstoned entries were quarantined by an import because their
+	// source no longer has them.
+	Tombstoned bool `json:"tombstoned"`
+	// MergedInto is the entry this one was quarantined as a duplicate of.
+	MergedInto string `json:"merged_into,omitempty"`
+	// Supersedes lists the IDs of entries this one replaces. They keep
+	// matching, but when one wins this entry's answer is served instead.
+	Supersedes []string `json:"supersedes"`
+	// Variants, when present, replace Answer with a weighted A/B experiment.
+	Variants []AnswerVariant `json:"variants"`
+	// Summary, when set, is served instead of a truncated answer to
+	// requests for concise answers.
+	Summary string   `json:"summary"`
+	Tags    []string `json:"tags"`
+	// Analyzer is the analyzerVersion Vector was derived with.
+	Analyzer int `json:"analyzer"`
+}
+
+// UnmarshalJSON decodes an entry under its snake_case names or the
+// CamelCase field names it was written with before it had JSON tags, as
+// exports, snapshots and SQLite rows of earlier versions have it. Where
+// both are present the snake_case name wins.
+func (e *KnowledgeEntry) UnmarshalJSON(data []byte) error {
+	type plain KnowledgeEntry
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	renamed := false
+	for name, value := range fields {
+		snake := snakeCase(name)
+		if snake == name {
+			continue
+		}
+		if _, ok := fields[snake]; !ok {
+			fields[snake] = value
+		}
+		delete(fields, name)
+		renamed = true
+	}
+	if renamed {
+		var err error
+		if data, err = json.Marshal(fields); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+// snakeCase turns a Go field name such as SourceURL into source_url.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
 }
 
+// checkMinScore rejects a MinScore outside [0, 1]; what names the field
+// in the error.
+func checkMinScore(what string, minScore float64) error {
+	if math.IsNaN(minScore) || minScore < 0 || minScore > 1 {
+		return newError(ErrInvalidInput, "%s must be between 0 and 1", what)
+	}
+	return nil
+}
+
+type Match struct {
+	Entry KnowledgeEntry
+	// Score is RawScore, the cosine similarity, weighted by the entry's
+	// Weight.
+	Score    float64
+	RawScore float64
+	// Source is SourceKnowledgeBase or SourceLearned.
+	Source string
+}
+
+// KnowledgeBase holds the entries and learned entries. Reads go through
+// view and never lock; see kbState.
 type KnowledgeBase struct {
-	Entries        []KnowledgeEntry
-	mu             sync.RWMutex
-	LearnedEntries map[string]string
+	// state holds the current *kbState.
+	state atomic.Value
+	// mu serializes writers. It is taken through writeLock so waits and
+	// holds are recorded in locks.
+	mu    sync.Mutex
+	locks LockStats
+	// store, when open, persists every change. It is only used under mu.
+	store KnowledgeStore
+	// embeddings returns the engine's current embeddings, which every
+	// question is vectorized against. It is only used under mu.
+	embeddings func() *EmbeddingStore
+	// idfWeighting weights words by their IDF over the questions; see
+	// idfTable. It is only used under mu.
+	idfWeighting bool
+	// stopWords are left out of vectors; nil keeps every word. It is only
+	// used under mu.
+	stopWords stopWordSet
+	// vocab indexes the vocabulary of the embeddings; nil until the next
+	// publish builds it. It is only used under mu.
+	vocab *vocabIndex
+	// scan is how scans of every entry are split; see scanConfig. It is
+	// only used under mu.
+	scan scanConfig
+	// maxWeightBoost caps how far weights raise scores; see
+	// weightedScore. It is only used under mu.
+	maxWeightBoost float64
+}
+
+// LearnedEntry is a question/answer pair taught via /learn. Question keeps
+// the original wording for display.
+type LearnedEntry struct {
+	ID        string
+	Question  string
+	Answer    string
+	SourceURL string
+	// Quarantined entries are kept but never matched, until the question
+	// is taught or revised again.
+	Quarantined bool `json:",omitempty"`
+	// Tags, like those of knowledge base entries, let requests filter
+	// what may answer them.
+	Tags []string `json:",omitempty"`
+	// MinScore, like that of knowledge base entries, is the lowest
+	// similarity at which the entry may be served when its question is
+	// not asked exactly.
+	MinScore float64 `json:",omitempty"`
+}
+
+// sameLearned reports whether two learned entries are identical.
+func sameLearned(a, b LearnedEntry) bool {
+	if a.ID != b.ID || a.Question != b.Question || a.Answer != b.Answer || a.SourceURL != b.SourceURL ||
+		a.Quarantined != b.Quarantined || a.MinScore != b.MinScore || len(a.Tags) != len(b.Tags) {
+		return false
+	}
+	for i := range a.Tags {
+		if a.Tags[i] != b.Tags[i] {
+			return false
+		}
+	}
+	return true
 }
 
 func (kb *KnowledgeBase) Learn(question, answer string) {
-	kb.mu.Lock()
-	defer kb.mu.Unlock()
-	kb.LearnedEntries[question] = answer
+	kb.learn(LearnedEntry{Question: question, Answer: answer})
+}
+
+func (kb *KnowledgeBase) learn(entry LearnedEntry) {
+	if _, err := kb.learnChecked(entry, true); err != nil {
+		log.Printf("Learning %q: %v", entry.Question, err)
+	}
+}
+
+// learnChecked stores a learned entry unless that would replace a different
+// answer for the same normalized question and overwrite is false. In that
+// case the existing entry is returned with ErrConflict. With a store open
+// the entry is persisted first, and ErrStoreUnavailable means it was
+// neither saved nor learned.
+func (kb *KnowledgeBase) learnChecked(entry LearnedEntry, overwrite bool) (LearnedEntry, error) {
+	key := normalizeQuestion(entry.Question)
+	entry.ID = learnedID(key)
+	var err error
+	kb.updateLearned("learnChecked", func(learned map[string]LearnedEntry) {
+		if existing, exists := learned[key]; exists && existing.Answer != entry.Answer && !overwrite {
+			entry = existing
+			err = newError(ErrConflict, "a different answer is already learned for this question; set overwrite to replace it")
+			return
+		}
+		if kb.store != nil && !sameLearned(learned[key], entry) {
+			if writeErr := kb.store.Learn(entry); writeErr != nil {
+				err = newError(ErrStoreUnavailable, "learned entry not saved: %v", writeErr)
+				return
+			}
+		}
+		learned[key] = entry
+	})
+	return entry, err
+}
+
+// lookupLearned finds a learned entry by normalized question, unless it
+// is quarantined.
+func (kb *KnowledgeBase) lookupLearned(key string) (LearnedEntry, bool) {
+	return kb.view().lookupLearned(key)
+}
+
+// lookupLearned is KnowledgeBase.lookupLearned in s.
+func (s *kbState) lookupLearned(key string) (LearnedEntry, bool) {
+	entry, ok := s.learned[key]
+	return entry, ok && !entry.Quarantined
 }
 
 type AIEngine struct {
 	KB               *KnowledgeBase
-	Embeddings       map[string][]float64
+	Embeddings       *EmbeddingStore
 	Greetings        map[string]string
 	CommonQuestions  map[string]string
 	DefaultResponses map[string]string
-	ContextMemory    []Interaction
+	Starters         []string
+	Scopes           map[string]ScopeConfig
+	// Languages are the language sections of the prompt file, by code;
+	// see LanguageConfig.
+	Languages        map[string]LanguageConfig
+	Limits           AnalysisLimits
+	OutputProcessors []OutputProcessor
+	Verification     VerificationPolicy
+	Attribution      *Attribution
+	Calibrations     Calibrations
+	Experiments      *ExperimentTracker
+	Feedback         *FeedbackScores
 	Patterns         map[string]float64
+	Snapshots        *Snapshotter
+	Rederiver        *Rederiver
+	Switches         *Switches
+	// QueryCache keeps the analysis and vectors of recent questions; nil
+	// analyzes every question afresh.
+	QueryCache *queryCache
+	// AnswerCache keeps recent answers for questions asked again; nil
+	// answers every question afresh.
+	AnswerCache *answerCache
+	// LLM answers questions nothing else did, before the default
+	// responses; nil leaves them to the default responses.
+	LLM *LLMFallback
+	// InlineOperators enables #tag, !style, scope: and lang: operators in
+	// question text.
+	InlineOperators bool
+	// AdaptResponses frames learned and context answers with the
+	// question's keywords.
+	AdaptResponses bool
+	// StopWords never surface in answers that quote keywords, even with
+	// stopword filtering off.
+	StopWords stopWordSet
+	// PatternBudget bounds each session's influence on Patterns; its TTL
+	// ends idle sessions.
+	PatternBudget PatternBudget
+	// MaxSessions bounds the active sessions; 0 means defaultMaxSessions.
+	MaxSessions int
+	// FeedbackTuning sets what POST /feedback changes.
+	FeedbackTuning FeedbackTuning
+	// DuplicateThreshold is the similarity at which imports and /learn
+	// report an existing question as a near duplicate; 0 turns the check
+	// off.
+	DuplicateThreshold float64
+	// GreetingMaxEdits is the number of typos a greeting is still
+	// recognized with; see matchGreeting.
+	GreetingMaxEdits int
+	// SpellCorrection corrects misspelled words of questions before they
+	// are analyzed; see kbState.correctSpelling.
+	SpellCorrection bool
+	// Config holds the paths the engine was loaded from; reindexing
+	// reads the embeddings from there again.
+	Config Config
+	// Features lists the optional features turned on at startup, for
+	// /version.
+	Features map[string]bool
+
+	observers []Observer
+
+	// mu guards Embeddings against a concurrent reindex, the last
+	// reindex and validation reports, Patterns and the sessions, which
+	// every request reads and may append to.
+	mu             sync.RWMutex
+	lastReindex    *DriftReport
+	lastValidation *ValidationReport
+	sessions       map[string]*session
+	patternStats   PatternStats
+	// unhelpfulVotes holds, by learned entry ID, the sessions that said
+	// its answer didn't help; see FeedbackTuning.QuarantineVotes.
+	unhelpfulVotes map[string]map[string]bool
+
+	// embeddingsInfo caches the hash of Embeddings; embeddingMismatch
+	// records how a snapshot built against other embeddings was restored.
+	embeddingsInfo    *EmbeddingInfo
+	embeddingMismatch string
+	// loadErrors records why the prompts or embeddings failed to load;
+	// see setLoadError.
+	loadErrors map[string]error
+	// promptInfo identifies the prompt file, for /version.
+	promptInfo PromptInfo
+	// importsRefused counts imports refused for an incompatible format. It
+	// is only used under mu.
+	importsRefused int
+	// promptFiles is what each file of the prompts contributed, for
+	// /kb/export?view=files.
+	promptFiles []PromptFile
+	// languageProfiles detect the language of questions; see
+	// classifyLanguage.
+	languageProfiles map[string]*trigramProfile
+
+	// rng picks starters; it is seeded from the clock unless Seed is
+	// called, and guarded by rngMu.
+	rngMu sync.Mutex
+	rng   *rand.Rand
 }
 
 type Interaction struct {
@@ -56,99 +411,591 @@ type Interaction struct {
 	Answer   string
 	Keywords []string
 	Score    float64
+	Scope    string
+	Style    string
+	// Analyzer is the analyzerVersion Keywords were derived with.
+	Analyzer int
+	// EntryID is the learned entry Answer came from, whose interactions
+	// are forgotten with it.
+	EntryID string
 }
 
 func NewKnowledgeBase() *KnowledgeBase {
-	return &KnowledgeBase{
-		Entries:        []KnowledgeEntry{},
-		LearnedEntries: make(map[string]string),
+	kb := &KnowledgeBase{}
+	kb.state.Store(&kbState{learned: make(map[string]LearnedEntry)})
+	return kb
+}
+
+func (kb *KnowledgeBase) AddEntry(question, answer string, embeddings *EmbeddingStore) {
+	now := time.Now()
+	kb.addEntry(KnowledgeEntry{
+		ID:         entryID(question),
+		Question:   question,
+		Answer:     answer,
+		CreatedAt:  now,
+		VerifiedAt: now,
+	}, embeddings)
+}
+
+// addEntry appends entry, or if an entry already asks the same question,
+// once normalized, gives that entry entry's answer instead.
+func (kb *KnowledgeBase) addEntry(entry KnowledgeEntry, embeddings *EmbeddingStore) {
+	entry.Vector = getSentenceVector(entry.Question, embeddings)
+	entry.Analyzer = analyzerVersion
+	err := kb.updateEntries("addEntry", func(entries []KnowledgeEntry) []KnowledgeEntry {
+		return appendEntries(entries, entry)
+	})
+	if err != nil {
+		log.Printf("Entry %s not added: %v", entry.ID, err)
 	}
 }
 
-func (kb *KnowledgeBase) AddEntry(question, answer string, embeddings map[string][]float64) {
-	vector := getSentenceVector(question, embeddings)
-	kb.mu.Lock()
-	kb.Entries = append(kb.Entries, KnowledgeEntry{
-		Question: question,
-		Answer:   answer,
-		Vector:   vector,
+// addEntries is addEntry for many entries, published together so loading
+// a knowledge base costs one write rather than one per entry. If together
+// they have conflicting or cyclic supersedes links, they are added one by
+// one, leaving out only the entries at fault.
+func (kb *KnowledgeBase) addEntries(added []KnowledgeEntry, embeddings *EmbeddingStore) {
+	added = append([]KnowledgeEntry(nil), added...)
+	for i := range added {
+		added[i].Vector = getSentenceVector(added[i].Question, embeddings)
+		added[i].Analyzer = analyzerVersion
+	}
+	err := kb.updateEntries("addEntries", func(entries []KnowledgeEntry) []KnowledgeEntry {
+		return appendEntries(entries, added...)
 	})
-	kb.mu.Unlock()
+	if err != nil {
+		for _, entry := range added {
+			kb.addEntry(entry, embeddings)
+		}
+	}
 }
 
-func (kb *KnowledgeBase) FindBestMatch(question string, embeddings map[string][]float64) (string, float64) {
-	queryVec := getSentenceVector(question, embeddings)
-	kb.mu.RLock()
-	defer kb.mu.RUnlock()
-	var bestScore float64
-	var bestAnswer string
-	for _, entry := range kb.Entries {
-		score := cosineSimilarity(queryVec, entry.Vector)
-		if score > bestScore {
-			bestScore = score
-			bestAnswer = entry.Answer
+// appendEntries appends added to entries, except those repeating the
+// question of an earlier entry, once normalized, which give that entry
+// their answer instead.
+func appendEntries(entries []KnowledgeEntry, added ...KnowledgeEntry) []KnowledgeEntry {
+	byQuestion := make(map[string]int, len(entries)+len(added))
+	index := func(i int) {
+		key := normalizeQuestion(entries[i].Question)
+		if _, ok := byQuestion[key]; !ok && !entries[i].Quarantined {
+			byQuestion[key] = i
+		}
+	}
+	for i := range entries {
+		index(i)
+	}
+	for _, entry := range added {
+		if i, ok := byQuestion[normalizeQuestion(entry.Question)]; ok {
+			log.Printf("Entry %s repeats the question of %s, which takes its answer", entry.ID, entries[i].ID)
+			entries[i].Answer = entry.Answer
+			continue
+		}
+		entries = append(entries, entry)
+		index(len(entries) - 1)
+	}
+	return entries
+}
+
+// FindBestMatch returns the knowledge base entry or learned entry closest
+// to the question, among those carrying one of tags if any are given; its
+// Source tells which. The zero Match means nothing scored above zero.
+func (kb *KnowledgeBase) FindBestMatch(question string, embeddings *EmbeddingStore, tags ...string) Match {
+	matches := kb.RankMatches(question, embeddings, tags...)
+	if len(matches) == 0 {
+		return Match{}
+	}
+	return matches[0]
+}
+
+// RankMatches scores every entry and learned entry against the question
+// and returns those with a positive score, best first. Given tags, only
+// entries carrying at least one of them are returned.
+func (kb *KnowledgeBase) RankMatches(question string, embeddings *EmbeddingStore, tags ...string) []Match {
+	state := kb.view()
+	vec := state.vector(question, embeddings)
+	var matches []Match
+	if len(tags) == 0 {
+		matches = append(state.rankVector(vec), state.rankLearned(vec)...)
+	} else {
+		// The index may find no entry carrying the tags, so every entry
+		// is scored.
+		for _, match := range append(state.rankEntries(vec, nil), state.scoreLearned(vec, nil)...) {
+			if anyTag(match.Entry.Tags, tags) {
+				matches = append(matches, match)
+			}
+		}
+	}
+	return sortMatches(matches)
+}
+
+// rankLearned is rankVector for the learned entries, which it returns as
+// knowledge entries carrying the learned ID, question and answer.
+func (s *kbState) rankLearned(queryVec []float32) []Match {
+	if s.learnedANN != nil {
+		ids := s.learnedANN.candidates(queryVec)
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = s.learnedKeys[id]
+		}
+		if matches := s.scoreLearned(queryVec, keys); len(matches) > 0 && matches[0].Score >= annExactBelow {
+			return matches
+		}
+	}
+	return s.scoreLearned(queryVec, nil)
+}
+
+// scoreLearned scores the learned entries with the given keys, or all of
+// them if keys is nil. Quarantined entries are left out.
+func (s *kbState) scoreLearned(queryVec []float32, keys []string) []Match {
+	var matches []Match
+	score := func(key string, entry LearnedEntry) {
+		if entry.Quarantined {
+			return
+		}
+		if score := cosineSimilarity(queryVec, s.learnedVectors[key]); score > 0 {
+			matches = append(matches, learnedMatch(entry, score))
+		}
+	}
+	if keys == nil {
+		for key, entry := range s.learned {
+			score(key, entry)
+		}
+	}
+	for _, key := range keys {
+		if entry, ok := s.learned[key]; ok {
+			score(key, entry)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Entry.ID < matches[j].Entry.ID
+	})
+	return matches
+}
+
+// learnedMatch presents a learned entry as a match.
+func learnedMatch(entry LearnedEntry, score float64) Match {
+	return Match{
+		Entry: KnowledgeEntry{
+			ID:        entry.ID,
+			Question:  entry.Question,
+			Answer:    entry.Answer,
+			SourceURL: entry.SourceURL,
+			Tags:      entry.Tags,
+			MinScore:  entry.MinScore,
+		},
+		Score:    score,
+		RawScore: score,
+		Source:   SourceLearned,
+	}
+}
+
+// rankVector scores the entries against queryVec, which must have been
+// vectorized in s, and returns those with a positive score, best first.
+// Past annMinEntries entries, only those the index finds are scored,
+// unless none reaches annExactBelow.
+func (s *kbState) rankVector(queryVec []float32) []Match {
+	if s.ann != nil {
+		if matches := s.rankEntries(queryVec, s.ann.candidates(queryVec)); len(matches) > 0 && matches[0].Score >= annExactBelow {
+			return matches
 		}
 	}
-	return bestAnswer, bestScore
+	return s.rankEntries(queryVec, nil)
+}
+
+// rankEntries scores the entries at positions, in ascending order, or all
+// of them if positions is nil, split across goroutines as s.scan says.
+// Quarantined entries are left out.
+func (s *kbState) rankEntries(queryVec []float32, positions []int32) []Match {
+	var matches []Match
+	score := func(matches []Match, entry KnowledgeEntry) []Match {
+		if entry.Quarantined {
+			return matches
+		}
+		if raw := cosineSimilarity(queryVec, entry.Vector); raw > 0 {
+			score := weightedScore(raw, entry.weight(), s.maxWeightBoost)
+			matches = append(matches, Match{Entry: entry, Score: score, RawScore: raw, Source: SourceKnowledgeBase})
+		}
+		return matches
+	}
+	if positions == nil {
+		matches = s.scanEntries(score)
+	}
+	for _, i := range positions {
+		matches = score(matches, s.entries[i])
+	}
+	return sortMatches(matches)
+}
+
+// sortMatches orders matches best first, keeping the order of equal
+// scores. A Match carries its entry by value, so positions are sorted and
+// each match copied once rather than swapped about, which on a large base
+// cost more than scoring it.
+func sortMatches(matches []Match) []Match {
+	order := make([]int, len(matches))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return matches[order[i]].Score > matches[order[j]].Score })
+	sorted := make([]Match, len(matches))
+	for i, k := range order {
+		sorted[i] = matches[k]
+	}
+	return sorted
+}
+
+// promptFile mirrors the layout of prompt.json.
+type promptFile struct {
+	Greetings        map[string]string      `json:"greetings"`
+	CommonQuestions  map[string]string      `json:"common_questions"`
+	KnowledgeBase    []promptEntry          `json:"knowledge_base"`
+	DefaultResponses map[string]string      `json:"default_responses"`
+	Starters         []string               `json:"starters"`
+	Scopes           map[string]ScopeConfig `json:"scopes"`
+	// Languages override the greetings, common questions, default
+	// responses and starters for questions in other languages, by
+	// language code; the top-level ones are English.
+	Languages        map[string]LanguageConfig `json:"languages"`
+	AnalysisLimits   AnalysisLimits            `json:"analysis_limits"`
+	OutputProcessors []OutputProcessorConfig   `json:"output_processors"`
+	Verification     VerificationConfig        `json:"verification"`
+	Attribution      AttributionConfig         `json:"attribution"`
+	// Calibration overrides, by answer source, how retriever scores map
+	// to the confidence candidates are compared on.
+	Calibration map[string]Calibration `json:"calibration"`
+	// Engine sets the match thresholds and answer framing; see
+	// EngineConfig.
+	Engine EngineConfig `json:"engine"`
+	// Include lists further files, paths or globs, whose greetings,
+	// common questions, knowledge base and default responses are merged
+	// in. Later files win on conflicting keys. They are read with the
+	// prompt file: at startup, and by the CLI's :reload.
+	Include []string `json:"include"`
+}
+
+type promptEntry struct {
+	ID         string          `json:"id"`
+	Question   string          `json:"question" schema:"required"`
+	Answer     string          `json:"answer" schema:"required"`
+	MinScore   *float64        `json:"min_score" schema:"exclusiveMinimum=0,maximum=1"`
+	Weight     *float64        `json:"weight" schema:"exclusiveMinimum=0"`
+	SourceURL  string          `json:"source_url"`
+	CreatedAt  *time.Time      `json:"created_at"`
+	VerifiedAt *time.Time      `json:"verified_at"`
+	Variants   []promptVariant `json:"variants"`
+	Summary    string          `json:"summary"`
+	Tags       []string        `json:"tags"`
+	Supersedes []string        `json:"supersedes"`
+}
+
+type promptVariant struct {
+	Answer string   `json:"answer" schema:"required"`
+	Weight *float64 `json:"weight" schema:"exclusiveMinimum=0"`
+}
+
+// PromptConfig is the validated content of prompt.json.
+type PromptConfig struct {
+	Greetings        map[string]string
+	CommonQuestions  map[string]string
+	KnowledgeBase    []KnowledgeEntry
+	DefaultResponses map[string]string
+	Starters         []string
+	Scopes           map[string]ScopeConfig
+	Languages        map[string]LanguageConfig
+	AnalysisLimits   AnalysisLimits
+	OutputProcessors []OutputProcessor
+	Verification     VerificationPolicy
+	Attribution      *Attribution
+	Calibrations     Calibrations
+	Engine           EngineConfig
+	// Info identifies the file the prompts were loaded from.
+	Info PromptInfo
+	// Files breaks the merged content down by the file it came from, the
+	// prompt file first and then its includes.
+	Files []PromptFile
 }
 
-func loadPrompts() (map[string]string, map[string]string, []KnowledgeEntry, map[string]string) {
-	data, err := ioutil.ReadFile("prompt.json")
+// loadPrompts reads the prompt file at path. Errors name the file.
+func loadPrompts(path string) (*PromptConfig, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Fatal("Error loading prompt.json:", err)
+		return nil, err
 	}
 
-	var config struct {
-		Greetings       map[string]string `json:"greetings"`
-		CommonQuestions map[string]string `json:"common_questions"`
-		KnowledgeBase   []struct {
-			Question string `json:"question"`
-			Answer   string `json:"answer"`
-		} `json:"knowledge_base"`
-		DefaultResponses map[string]string `json:"default_responses"`
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if errs := validateSchema(raw, promptSchema, ""); len(errs) > 0 {
+		for _, err := range errs {
+			log.Printf("%s: %v", path, err)
+		}
+		return nil, fmt.Errorf("%s: %d schema violations", path, len(errs))
 	}
 
+	var config promptFile
 	if err := json.Unmarshal(data, &config); err != nil {
-		log.Fatal("Error parsing prompt.json:", err)
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	digest := sha1.New()
+	digest.Write(data)
+	files, err := mergeIncludes(&config, path, digest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
 	}
 
+	now := time.Now()
 	entries := make([]KnowledgeEntry, len(config.KnowledgeBase))
 	for i, kb := range config.KnowledgeBase {
 		entries[i] = KnowledgeEntry{
-			Question: kb.Question,
-			Answer:   kb.Answer,
+			ID:         kb.ID,
+			Question:   kb.Question,
+			Answer:     kb.Answer,
+			SourceURL:  kb.SourceURL,
+			Summary:    kb.Summary,
+			Tags:       kb.Tags,
+			Supersedes: kb.Supersedes,
+			CreatedAt:  now,
+			VerifiedAt: now,
+		}
+		for _, v := range kb.Variants {
+			variant := AnswerVariant{Answer: v.Answer, Weight: 1}
+			if v.Weight != nil {
+				variant.Weight = *v.Weight
+			}
+			entries[i].Variants = append(entries[i].Variants, variant)
+		}
+		if entries[i].ID == "" {
+			entries[i].ID = entryID(kb.Question)
+		}
+		if kb.CreatedAt != nil {
+			entries[i].CreatedAt = *kb.CreatedAt
+			entries[i].VerifiedAt = *kb.CreatedAt
+		}
+		if kb.VerifiedAt != nil {
+			entries[i].VerifiedAt = *kb.VerifiedAt
+		}
+		if kb.MinScore != nil {
+			entries[i].MinScore = *kb.MinScore
+		}
+		if kb.Weight != nil {
+			entries[i].Weight = *kb.Weight
+		}
+	}
+	if _, err := supersessions(entries); err != nil {
+		return nil, fmt.Errorf("%s: knowledge_base: %v", path, err)
+	}
+
+	processors, err := buildOutputProcessors(config.OutputProcessors)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	verification, err := config.Verification.policy()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	attribution, err := config.Attribution.build()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	calibrations, err := buildCalibrations(config.Calibration)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := config.Engine.conflicts(config.Calibration); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := config.Engine.applyThresholds(calibrations); err != nil {
+		return nil, fmt.Errorf("%s: engine.%v", path, err)
+	}
+	if _, err := config.Engine.scan(scanConfig{}); err != nil {
+		return nil, fmt.Errorf("%s: engine.%v", path, err)
+	}
+	if _, err := config.Engine.maxWeightBoost(); err != nil {
+		return nil, fmt.Errorf("%s: engine.%v", path, err)
+	}
+	if e := config.Engine.GreetingMaxEdits; e != nil && *e < 0 {
+		return nil, fmt.Errorf("%s: engine.greeting_max_edits must be at least 0", path)
+	}
+
+	if response, ok := config.DefaultResponses["keywords"]; ok {
+		if err := checkKeywordsResponse(response); err != nil {
+			return nil, fmt.Errorf("%s: default_responses.keywords: %v", path, err)
+		}
+	}
+	for name, scope := range config.Scopes {
+		if response, ok := scope.DefaultResponses["keywords"]; ok {
+			if err := checkKeywordsResponse(response); err != nil {
+				return nil, fmt.Errorf("%s: scopes.%s.default_responses.keywords: %v", path, name, err)
+			}
+		}
+	}
+
+	languages, err := checkLanguages(config.Languages)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if config.Starters != nil && len(config.Starters) == 0 {
+		log.Printf("%s: starters is empty, using the built-in starters", path)
+	}
+	for name, scope := range config.Scopes {
+		if scope.Starters != nil && len(scope.Starters) == 0 {
+			log.Printf("%s: scopes.%s.starters is empty, using the global starters", path, name)
 		}
 	}
-	return config.Greetings, config.CommonQuestions, entries, config.DefaultResponses
+
+	return &PromptConfig{
+		Greetings:        config.Greetings,
+		CommonQuestions:  config.CommonQuestions,
+		KnowledgeBase:    entries,
+		DefaultResponses: config.DefaultResponses,
+		Starters:         config.Starters,
+		Scopes:           config.Scopes,
+		Languages:        languages,
+		AnalysisLimits:   config.AnalysisLimits.withDefaults(),
+		OutputProcessors: processors,
+		Verification:     verification,
+		Attribution:      attribution,
+		Calibrations:     calibrations,
+		Engine:           config.Engine,
+		Files:            files,
+		Info: PromptInfo{
+			Path:            path,
+			Hash:            hex.EncodeToString(digest.Sum(nil))[:12],
+			Includes:        len(files) - 1,
+			Entries:         len(entries),
+			Greetings:       len(config.Greetings),
+			CommonQuestions: len(config.CommonQuestions),
+			Scopes:          len(config.Scopes),
+			Languages:       len(languages),
+		},
+	}, nil
 }
 
-func NewAIEngine(embeddings map[string][]float64) *AIEngine {
+// NewAIEngine builds an engine from the prompt file named by config.
+func NewAIEngine(config Config, embeddings *EmbeddingStore, opts ...EngineOption) (*AIEngine, error) {
+	prompts, err := loadPrompts(config.Prompts)
+	if err != nil {
+		return nil, err
+	}
+	ai := newAIEngine(prompts, embeddings, opts...)
+	ai.Config = config
+	return ai, nil
+}
+
+// newAIEngine builds an engine from loaded prompts. Missing sections get
+// their built-in defaults, so even an empty PromptConfig and nil embeddings
+// give an engine that answers every question.
+func newAIEngine(prompts *PromptConfig, embeddings *EmbeddingStore, opts ...EngineOption) *AIEngine {
+	if embeddings == nil {
+		embeddings = newEmbeddingStore(0)
+	}
 	kb := NewKnowledgeBase()
-	greetings, commonQuestions, knowledgeBase, defaultResponses := loadPrompts()
+	kb.addEntries(prompts.KnowledgeBase, embeddings)
+	scopes := prompts.Scopes
+	if scopes == nil {
+		scopes = make(map[string]ScopeConfig)
+	}
+	verification := prompts.Verification
+	if verification.MaxAge <= 0 {
+		verification.MaxAge = defaultVerificationAge
+	}
+	calibrations := prompts.Calibrations
+	if calibrations == nil {
+		calibrations, _ = buildCalibrations(nil)
+	}
 
-	for _, entry := range knowledgeBase {
-		kb.AddEntry(entry.Question, entry.Answer, embeddings)
+	ai := &AIEngine{
+		KB:                 kb,
+		Embeddings:         embeddings,
+		Greetings:          nonNil(prompts.Greetings),
+		CommonQuestions:    nonNil(prompts.CommonQuestions),
+		DefaultResponses:   nonNil(prompts.DefaultResponses),
+		Starters:           prompts.Starters,
+		Scopes:             scopes,
+		Languages:          prompts.Languages,
+		Limits:             prompts.AnalysisLimits.withDefaults(),
+		OutputProcessors:   prompts.OutputProcessors,
+		Verification:       verification,
+		Attribution:        prompts.Attribution,
+		Calibrations:       calibrations,
+		AdaptResponses:     prompts.Engine.adaptResponses(),
+		StopWords:          newStopWordSet(prompts.Engine.StopWords),
+		promptInfo:         prompts.Info,
+		promptFiles:        prompts.Files,
+		Patterns:           make(map[string]float64),
+		PatternBudget:      defaultPatternBudget,
+		FeedbackTuning:     defaultFeedbackTuning,
+		DuplicateThreshold: defaultDuplicateThreshold,
+		GreetingMaxEdits:   prompts.Engine.greetingMaxEdits(),
+		SpellCorrection:    prompts.Engine.spellCorrection(),
+		Experiments:        NewExperimentTracker(),
+		Feedback:           NewFeedbackScores(),
+		QueryCache:         newQueryCache(defaultQueryCacheSize),
+		AnswerCache:        newAnswerCache(defaultAnswerCacheSize, defaultAnswerCacheTTL),
+		rng:                rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	ai.Rederiver = NewRederiver(ai)
+	if len(ai.Languages) > 0 {
+		ai.languageProfiles = buildLanguageProfiles(ai.Languages)
 	}
+	kb.embeddings = ai.embeddings
+	if prompts.Engine.filterStopWords() {
+		kb.stopWords = ai.StopWords
+	}
+	kb.scan, _ = prompts.Engine.scan(scanConfig{})
+	kb.maxWeightBoost, _ = prompts.Engine.maxWeightBoost()
+	kb.setIDFWeighting(prompts.Engine.idfWeighting())
+	for _, opt := range opts {
+		opt(ai)
+	}
+	return ai
+}
 
-	return &AIEngine{
-		KB:               kb,
-		Embeddings:       embeddings,
-		Greetings:        greetings,
-		CommonQuestions:  commonQuestions,
-		DefaultResponses: defaultResponses,
-		Patterns:         make(map[string]float64),
+func nonNil(m map[string]string) map[string]string {
+	if m == nil {
+		return make(map[string]string)
 	}
+	return m
+}
+
+func (ai *AIEngine) embeddings() *EmbeddingStore {
+	ai.mu.RLock()
+	defer ai.mu.RUnlock()
+	return ai.Embeddings
+}
+
+func (ai *AIEngine) setEmbeddings(embeddings *EmbeddingStore) {
+	ai.mu.Lock()
+	ai.Embeddings = embeddings
+	ai.embeddingsInfo = nil
+	delete(ai.loadErrors, "embeddings")
+	ai.mu.Unlock()
 }
 
-func (ai *AIEngine) findSimilarInteraction(keywords []string) (Interaction, float64) {
+// findSimilarInteraction finds the interaction of the session sharing the
+// most keywords. Other sessions' conversations are never matched.
+func (ai *AIEngine) findSimilarInteraction(keywords []string, sessionID string) (Interaction, float64) {
 	var bestMatch Interaction
 	var bestScore float64
 
-	for _, interaction := range ai.ContextMemory {
+	ai.mu.RLock()
+	defer ai.mu.RUnlock()
+	s, ok := ai.activeSession(sessionID, time.Now())
+	if !ok {
+		return bestMatch, bestScore
+	}
+	for _, interaction := range s.history {
 		var matchCount int
 		for _, k1 := range keywords {
 			for _, k2 := range interaction.Keywords {
-				if strings.ToLower(k1) == strings.ToLower(k2) {
+				if stemWord(k1) == stemWord(k2) {
 					matchCount++
 				}
 			}
@@ -164,122 +1011,415 @@ func (ai *AIEngine) findSimilarInteraction(keywords []string) (Interaction, floa
 	return bestMatch, bestScore
 }
 
-func (ai *AIEngine) GenerateAnswer(question string) string {
-	keywords, concepts := ai.analyzeInput(question)
-	contextScore := ai.evaluateContext(keywords)
+// GenerateAnswer answers a question in the conversation of sessionID; an
+// empty session shares no context with other questions.
+func (ai *AIEngine) GenerateAnswer(question, sessionID string) string {
+	answer, _ := ai.Ask(question, AskOptions{SessionID: sessionID})
+	return answer.Text
+}
+
+// AskOptions carries per-request settings for Ask.
+type AskOptions struct {
+	// SessionID selects the conversation the question belongs to, whose
+	// earlier interactions it may be answered from, and keeps experiment
+	// variants stable for one user.
+	SessionID string
+	// Scope selects scope-specific default responses, e.g. the docs page
+	// the widget is embedded in, and the scope's tags when no tags are
+	// set.
+	Scope string
+	// Style controls answer length; see the Style constants.
+	Style string
+	// Warnings, when set, collects the soft problems met on the way.
+	Warnings *Warnings
+	// Tenant identifies the caller for per-tenant kill switches.
+	Tenant string
+	// RequireTags restricts knowledge base and learned matches to entries
+	// carrying every one of them, as #tag operators ask.
+	RequireTags []string
+	// AnyTags restricts knowledge base and learned matches to entries
+	// carrying at least one of them, as the tags request field asks;
+	// empty allows every entry. See tagsAllowed.
+	AnyTags []string
+	// Language, a code such as "ru", selects the language section of the
+	// prompt file answers are given from; empty detects it from the
+	// question, with LanguageHints, the caller's preferred languages most
+	// preferred first, deciding when the question doesn't tell.
+	Language      string
+	LanguageHints []string
+}
+
+// Ask answers a question and reports how the answer was chosen. An empty
+// question gives a starter with ErrInvalidInput, and one longer than the
+// analysis limits allow a starter with ErrTooLarge. When no entry matched, the fallback answer
+// is returned along with ErrNoCoverage if none of the question's words are
+// known, or ErrNoMatch otherwise. Without embeddings, every word is
+// unknown, so it is always ErrNoMatch.
+func (ai *AIEngine) Ask(question string, opts AskOptions) (Answer, error) {
+	return ai.AskContext(context.Background(), question, opts)
+}
 
-	bestMatch, score := ai.findSimilarInteraction(keywords)
-	if score > 0.8 {
-		return ai.adaptResponse(bestMatch.Answer, keywords)
+// AskContext is Ask with a context, which is handed to the observers.
+func (ai *AIEngine) AskContext(ctx context.Context, question string, opts AskOptions) (Answer, error) {
+	start := time.Now()
+	if opts.Warnings == nil {
+		opts.Warnings = &Warnings{}
 	}
+	q, answer, err := ai.ask(question, opts)
+	answer.Warnings = opts.Warnings.List()
+	answer.Duration = time.Since(start)
+	ai.observe(ctx, q, answer)
+	return answer, err
+}
 
-	if answer, exists := ai.KB.LearnedEntries[question]; exists {
-		adapted := ai.adaptResponse(answer, keywords)
-		ai.learnFromInteraction(question, adapted, keywords, contextScore)
-		return adapted
+func (ai *AIEngine) ask(question string, opts AskOptions) (*Query, Answer, error) {
+	// Every stage reads the one state, however the knowledge base changes
+	// meanwhile.
+	state := ai.KB.view()
+	if limit := ai.Limits.maxQuestionBytes(); len(question) > limit {
+		language := ai.questionLanguage(question[:limit], opts)
+		answer := Answer{Text: ai.starter(opts.Scope, language), Source: SourceDefault, Language: language}
+		answer.addSource(SourceDefault, "", 0, answer.Text)
+		answer.locateSources()
+		return &Query{Raw: question[:limit], kb: state}, answer, newError(ErrTooLarge, "question is %d bytes, the limit is %d", len(question), limit)
+	}
+	var ops Operators
+	if ai.InlineOperators {
+		question, ops = parseOperators(question)
+		ops.apply(&opts)
+	}
+	ai.scopeTags(&opts)
+	opts.Language = ai.questionLanguage(question, opts)
+	if strings.TrimSpace(question) == "" {
+		answer := Answer{Text: ai.starter(opts.Scope, opts.Language), Source: SourceDefault, Operators: ops, Language: opts.Language}
+		answer.addSource(SourceDefault, "", 0, answer.Text)
+		answer.locateSources()
+		return &Query{Raw: question, kb: state}, answer, newError(ErrInvalidInput, "question is empty")
+	}
+	var corrected map[string]string
+	if ai.SpellCorrection {
+		question, corrected = state.correctSpelling(question, ai.embeddings())
+	}
+	// Only sessions without history share answers; warnings the caller
+	// raised before asking aren't cached with the answer.
+	shared := ai.AnswerCache != nil && !ai.sessionHasHistory(opts.SessionID)
+	raised := len(opts.Warnings.List())
+	cacheKey := answerCacheKey(question, opts)
+	if shared {
+		if q, answer, ok := ai.answerFromCache(state, question, cacheKey, ops, opts); ok {
+			answer.Corrected, answer.Language = corrected, opts.Language
+			return q, answer, nil
+		}
+	}
+	q := newQuery(question, ai.embeddings(), ai.Limits, state, ai.QueryCache)
+	warn := opts.Warnings
+	if q.AnalysisErr != nil {
+		warn.Add(WarnAnalysisFailed, q.AnalysisErr.Error())
+	}
+	if q.Truncated {
+		warn.Add(WarnAnalysisTruncated, fmt.Sprintf("analysis is limited to %d tokens and %d keywords", ai.Limits.MaxTokens, ai.Limits.MaxKeywords))
+	}
+	if ai.lexicalOnly() {
+		warn.Add(WarnLexicalOnly, "no embeddings are loaded; entries were matched by the words they share with the question")
+	} else if coverage := q.Vocabulary(); coverage.Share() < minCoverage {
+		warn.Add(WarnLowCoverage, fmt.Sprintf("only %.0f%% of the question's words are known; %d of the %d unknown were approximated by spelling",
+			coverage.Share()*100, coverage.Synthesized, coverage.OOV))
+	}
+	answer := ai.generateAnswer(q, opts)
+	var remembered string
+	if answer.Source == SourceLearned {
+		remembered = answer.Text
+		ai.rememberLearned(question, remembered, answer.EntryID, q.Keywords, opts)
+	}
+	answer.Operators = ops
+	answer.Corrected = corrected
+	answer.Language = opts.Language
+	answer.Truncated = q.Truncated
+	answer.Keywords = q.Keywords
+	answer.Vocabulary = q.Vocabulary()
+	if answer.Entry != nil && len(answer.Entry.Variants) > 0 {
+		answer.Variant = pickVariant(answer.Entry, opts.SessionID)
+		answer.Text = answer.Entry.Variants[answer.Variant].Answer
+		ai.Experiments.recordServe(answer.Entry.ID, answer.Variant, opts.SessionID)
+	}
+	ai.applyStyle(&answer, q, opts.Style)
+	if len(answer.Sources) == 0 {
+		answer.addSource(answer.Source, answer.EntryID, answer.Score, answer.Text)
+	}
+	if answer.SupersededID != "" {
+		answer.addSource(StageSupersede, answer.SupersededID, answer.Score, answer.Text)
 	}
+	if answer.LowConfidence() {
+		warn.Add(WarnLowConfidence, fmt.Sprintf("best match scored %.2f", answer.Score))
+	}
+	if answer.Entry != nil && ai.Verification.isStale(answer.Entry, time.Now()) {
+		warn.Add(WarnStaleEntry, "entry "+answer.EntryID+" was last verified "+answer.Entry.VerifiedAt.Format("2006-01-02"))
+		if ai.Verification.Disclaimer != "" {
+			answer.addSource(StageVerification, answer.EntryID, 0, ai.Verification.Disclaimer)
+		}
+	}
+	answer.Text = ai.processOutput(question, answer.Text, answer.Entry, warn)
+	answer.locateSources()
+	var err error
+	switch {
+	case !answer.LowConfidence():
+	case len(q.Tokens) > 0 && q.Coverage() == 0 && !ai.lexicalOnly():
+		err = newError(ErrNoCoverage, "none of the question's words are known")
+	default:
+		err = newError(ErrNoMatch, "best match scored %.2f", answer.Score)
+	}
+	if shared && cacheable(answer, err) {
+		ai.AnswerCache.put(cacheKey, q.kb.version, time.Now(), cachedAnswer{
+			answer:     answer,
+			warnings:   warn.List()[raised:],
+			keywords:   q.Keywords,
+			remembered: remembered,
+		})
+	}
+	return q, answer, err
+}
 
-	questionLower := strings.ToLower(question)
+// generateAnswer selects the answer for a query. Every retriever offers
+// its best candidate; the most confident one on the calibrated scale is
+// served, the fixed retriever order only breaking ties.
+func (ai *AIEngine) generateAnswer(q *Query, opts AskOptions) Answer {
+	keywords := q.Keywords
+	retrievals, matches := ai.retrieve(q, opts)
+	best := choose(retrievals)
+	candidates := make([]Candidate, len(retrievals))
+	for i, r := range retrievals {
+		candidates[i] = r.Candidate
+	}
+	if best >= 0 {
+		answer := retrievals[best].answer()
+		answer.Confidence = retrievals[best].Confidence
+		answer.Retrieved = candidates
+		return answer
+	}
 
-	if response, exists := ai.Greetings[questionLower]; exists {
-		return response
+	fallback := Answer{Source: SourceDefault, Candidates: matches[:min(maxCandidates, len(matches))], Retrieved: candidates}
+	if len(matches) > 0 {
+		fallback.Score = matches[0].Score
 	}
 
-	for key, value := range ai.CommonQuestions {
-		if strings.Contains(questionLower, key) {
-			return value
+	// Turned off, the kill switch leaves questions to the default
+	// responses.
+	if ai.Switches.Enabled(SwitchLLMFallback, opts.Tenant) {
+		if text, cached, ok := ai.LLM.answer(q.kb, q.Raw, opts.Language, time.Now()); ok {
+			fallback.Source, fallback.Text = SourceLLM, text
+			fallback.addSource(SourceLLM, "", 0, text)
+			if cached {
+				fallback.addSource(StageCache, "", 0, text)
+			}
+			return fallback
 		}
 	}
 
-	answer, score := ai.KB.FindBestMatch(question, ai.Embeddings)
-	if score > 0.7 {
-		return answer
+	if q.AnalysisErr != nil {
+		fallback.Text, _ = ai.defaultResponse(opts.Scope, opts.Language, "error")
+		return fallback
 	}
 
-	doc, err := prose.NewDocument(question)
-	if err != nil {
-		return ai.DefaultResponses["error"]
+	if keywords := ai.StopWords.filter(keywords); len(keywords) > 0 {
+		techTerms := strings.Join(keywords[:min(3, len(keywords))], ", ")
+		defaultResponse, _ := ai.defaultResponse(opts.Scope, opts.Language, "keywords")
+		fallback.Text = fmt.Sprintf(defaultResponse, techTerms)
+		return fallback
 	}
 
-	keywords, concepts = ai.analyzeInput(question)
+	if defaultResponse, ok := ai.defaultResponse(opts.Scope, opts.Language, "default"); ok {
+		fallback.Text = defaultResponse
+		return fallback
+	}
 
-	if len(keywords) > 0 {
-		techTerms := strings.Join(keywords[:min(3, len(keywords))], ", ")
-		if defaultResponse, ok := ai.DefaultResponses["keywords"]; ok {
-			return fmt.Sprintf(defaultResponse, techTerms)
-		}
-		return fmt.Sprintf("Let's explore %s in detail. What specific aspects interest you?", techTerms)
+	fallback.Text = ai.starter(opts.Scope, opts.Language)
+	return fallback
+}
+
+// retrieve asks every retriever for its best candidate for a query and
+// returns them in retriever order, along with the knowledge base matches
+// ranked, only those tagsAllowed lets through. It changes nothing: the
+// answers are only built when a retrieval's answer is called.
+func (ai *AIEngine) retrieve(q *Query, opts AskOptions) ([]retrieval, []Match) {
+	keywords := q.Keywords
+	var retrievals []retrieval
+	offer := func(source, entryID string, score float64, answer func() Answer) {
+		retrievals = append(retrievals, retrieval{
+			Candidate: Candidate{
+				Source:     source,
+				EntryID:    entryID,
+				Score:      score,
+				Confidence: ai.Calibrations.normalize(source, score),
+			},
+			answer: answer,
+		})
 	}
 
-	if defaultResponse, ok := ai.DefaultResponses["default"]; ok {
-		return defaultResponse
+	if bestMatch, score := ai.findSimilarInteraction(keywords, opts.SessionID); score > 0 {
+		offer(SourceContext, "", score, func() Answer {
+			answer := Answer{Source: SourceContext, Score: score}
+			ai.adaptAnswer(&answer, bestMatch.Answer, keywords)
+			return answer
+		})
 	}
 
-	starters := []string{
-		"I'm here to help with Go programming. Could you specify what you'd like to learn about?",
-		"I can assist you with various Go topics. What interests you most?",
-		"Let me help you with Go! What would you like to explore?",
+	// An exact lookup is the fast path; otherwise the closest learned
+	// question competes on similarity like a knowledge base entry.
+	learned, exists := q.kb.lookupLearned(q.Key)
+	exists = exists && tagsAllowed(learned.Tags, opts)
+	learnedScore := 1.0
+	if !exists {
+		for _, match := range q.kb.rankLearned(q.Vector()) {
+			if best := match.Entry; match.Score >= best.MinScore && tagsAllowed(best.Tags, opts) {
+				learned = LearnedEntry{ID: best.ID, Question: best.Question, Answer: best.Answer, SourceURL: best.SourceURL, Tags: best.Tags, MinScore: best.MinScore}
+				learnedScore, exists = match.Score, true
+				break
+			}
+		}
+	}
+	if exists {
+		offer(SourceLearned, learned.ID, learnedScore, func() Answer {
+			answer := Answer{Source: SourceLearned, Score: learnedScore, EntryID: learned.ID, SourceURL: learned.SourceURL}
+			ai.adaptAnswer(&answer, learned.Answer, keywords)
+			return answer
+		})
 	}
 
-	return starters[rand.Intn(len(starters))]
-}
+	if response, exists := ai.greeting(opts.Language, q.Raw); exists {
+		offer(SourceGreeting, "", 1, func() Answer {
+			return Answer{Text: response, Source: SourceGreeting, Score: 1}
+		})
+	}
 
-func (ai *AIEngine) analyzeInput(input string) ([]string, []string) {
-	doc, err := prose.NewDocument(input)
-	if err != nil {
-		return nil, nil
+	if response, exists := ai.commonQuestion(opts.Language, q.Raw); exists {
+		offer(SourceCommonQuestion, "", 1, func() Answer {
+			return Answer{Text: response, Source: SourceCommonQuestion, Score: 1}
+		})
 	}
 
-	var keywords, concepts []string
-	for _, tok := range doc.Tokens() {
-		switch tok.Tag {
-		case "NOUN", "PROPN":
-			keywords = append(keywords, tok.Text)
-		case "VERB":
-			concepts = append(concepts, tok.Text)
+	var matches []Match
+	if len(opts.RequireTags) == 0 && len(opts.AnyTags) == 0 {
+		matches = q.kb.rankVector(q.Vector())
+	} else {
+		// The index may find no entry carrying the tags, so every entry
+		// is scored.
+		matches = q.kb.rankEntries(q.Vector(), nil)
+		tagged := matches[:0]
+		for _, match := range matches {
+			if tagsAllowed(match.Entry.Tags, opts) {
+				tagged = append(tagged, match)
+			}
 		}
+		matches = tagged
 	}
-	return keywords, concepts
+	for _, match := range matches {
+		if match.Score >= match.Entry.MinScore {
+			entry := match.Entry
+			offer(SourceKnowledgeBase, entry.ID, match.Score, func() Answer {
+				// A superseded entry still matches old phrasings, but the
+				// entry replacing it is what gets served.
+				var superseded string
+				if current, ok := q.kb.resolveSupersedes(entry); ok {
+					superseded, entry = entry.ID, current
+				}
+				return Answer{
+					Text:         entry.Answer,
+					Source:       SourceKnowledgeBase,
+					Score:        match.Score,
+					Entry:        &entry,
+					EntryID:      entry.ID,
+					SourceURL:    entry.SourceURL,
+					SupersededID: superseded,
+				}
+			})
+			break
+		}
+	}
+	return retrievals, matches
+}
+
+func (ai *AIEngine) analyzeInput(input string) ([]string, []string) {
+	keywords, concepts, _ := analyzeText(input)
+	return ai.KB.view().stopWords.filter(keywords), concepts
 }
 
-func (ai *AIEngine) evaluateContext(keywords []string) float64 {
+// evaluateContext scores keywords by the session's own reinforcement, so
+// one conversation never colors another's.
+func (ai *AIEngine) evaluateContext(keywords []string, sessionID string) float64 {
+	if len(keywords) == 0 {
+		return 0
+	}
+	ai.mu.RLock()
+	defer ai.mu.RUnlock()
+	s, ok := ai.activeSession(sessionID, time.Now())
+	if !ok {
+		return 0
+	}
 	var score float64
 	for _, word := range keywords {
-		if weight, exists := ai.Patterns[word]; exists {
-			score += weight
-		}
+		score += s.weights[stemWord(word)]
 	}
 	return score / float64(len(keywords))
 }
 
 func (ai *AIEngine) adaptResponse(base string, keywords []string) string {
-	if len(keywords) > 0 {
+	if keywords := ai.StopWords.filter(keywords); len(keywords) > 0 {
 		return fmt.Sprintf("Based on %s, I understand that %s",
 			strings.Join(keywords, ", "), base)
 	}
 	return base
 }
 
-func (ai *AIEngine) learnFromInteraction(q, a string, k []string, score float64) {
+// adaptAnswer sets the answer text to base adapted to the keywords,
+// recording the base and any added framing as separate sources. With
+// AdaptResponses off the text is base as is.
+func (ai *AIEngine) adaptAnswer(answer *Answer, base string, keywords []string) {
+	answer.addSource(answer.Source, answer.EntryID, answer.Score, base)
+	if !ai.AdaptResponses {
+		answer.Text = base
+		return
+	}
+	answer.Text = ai.adaptResponse(base, keywords)
+	if prefix := strings.TrimSuffix(answer.Text, base); prefix != answer.Text {
+		answer.addSource(StageAdapt, "", answer.Score, prefix)
+	}
+}
+
+// rememberLearned records a learned answer, from the learned entry
+// entryID, as an interaction of the session, unless learning is switched
+// off for the tenant.
+func (ai *AIEngine) rememberLearned(question, text, entryID string, keywords []string, opts AskOptions) {
+	if ai.Switches.Enabled(SwitchLearning, opts.Tenant) {
+		ai.learnFromInteraction(question, text, entryID, keywords, ai.evaluateContext(keywords, opts.SessionID), opts)
+	}
+}
+
+func (ai *AIEngine) learnFromInteraction(q, a, entryID string, k []string, score float64, opts AskOptions) {
 	interaction := Interaction{
 		Question: q,
 		Answer:   a,
 		Keywords: k,
 		Score:    score,
+		Scope:    opts.Scope,
+		Style:    opts.Style,
+		Analyzer: analyzerVersion,
+		EntryID:  entryID,
 	}
-	ai.ContextMemory = append(ai.ContextMemory, interaction)
-
-	for _, keyword := range k {
-		ai.Patterns[keyword] += 0.1 * score
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	ai.reinforce(opts.SessionID, k, score, time.Now())
+	if opts.SessionID != "" {
+		ai.sessions[opts.SessionID].remember(interaction)
 	}
 }
 
-func cosineSimilarity(vec1, vec2 []float64) float64 {
+func cosineSimilarity(vec1, vec2 []float32) float64 {
 	var dot, normA, normB float64
 	for i := 0; i < len(vec1) && i < len(vec2); i++ {
-		dot += vec1[i] * vec2[i]
-		normA += vec1[i] * vec1[i]
-		normB += vec2[i] * vec2[i]
+		a, b := float64(vec1[i]), float64(vec2[i])
+		dot += a * b
+		normA += a * a
+		normB += b * b
 	}
 	if normA == 0 || normB == 0 {
 		return 0
@@ -287,20 +1427,41 @@ func cosineSimilarity(vec1, vec2 []float64) float64 {
 	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
 }
 
-func getSentenceVector(sentence string, embeddings map[string][]float64) []float64 {
-	words := strings.Fields(strings.ToLower(sentence))
-	var vec []float64
+func getSentenceVector(sentence string, embeddings *EmbeddingStore) []float32 {
+	return sentenceVector(tokenize(sentence, embeddings), embeddings)
+}
+
+func sentenceVector(words []string, embeddings *EmbeddingStore) []float32 {
+	return weightedSentenceVector(words, embeddings, nil, nil)
+}
+
+// weightedSentenceVector averages the word vectors, each scaled by its
+// weight in idf. Words missing from embeddings are looked up in vocab.
+// With no embeddings at all, it is the lexical vector of words.
+func weightedSentenceVector(words []string, embeddings *EmbeddingStore, idf *idfTable, vocab *vocabIndex) []float32 {
+	if embeddings.Len() == 0 {
+		return lexicalVector(words, idf)
+	}
+	var vec []float32
 	for _, word := range words {
-		if v, ok := embeddings[word]; ok {
-			vec = addVectors(vec, v)
+		v, found := vocab.lookup(word, embeddings)
+		if found == vocabMissing {
+			continue
+		}
+		if vec == nil {
+			vec = make([]float32, len(v))
+		}
+		weight := float32(idf.weight(word))
+		for i := 0; i < len(vec) && i < len(v); i++ {
+			vec[i] += weight * v[i]
 		}
 	}
 	return averageVector(vec, len(words))
 }
 
-func addVectors(a, b []float64) []float64 {
+func addVectors(a, b []float32) []float32 {
 	if len(a) == 0 {
-		return append([]float64{}, b...)
+		return append([]float32{}, b...)
 	}
 	for i := 0; i < len(a) && i < len(b); i++ {
 		a[i] += b[i]
@@ -308,81 +1469,786 @@ func addVectors(a, b []float64) []float64 {
 	return a
 }
 
-func averageVector(vec []float64, count int) []float64 {
+func averageVector(vec []float32, count int) []float32 {
 	if count == 0 {
 		return vec
 	}
 	for i := 0; i < len(vec); i++ {
-		vec[i] /= float64(count)
+		vec[i] /= float32(count)
 	}
 	return vec
 }
 
-func loadEmbeddings() map[string][]float64 {
-	data, _ := ioutil.ReadFile("embeddings.json")
-	var embeddings map[string][]float64
-	json.Unmarshal(data, &embeddings)
-	return embeddings
+// loadEmbeddings reads the embeddings file at path, in format and at most
+// limit words of it if limit is positive, and the per-language files
+// beside it. An unreadable main file is logged and returned as the error,
+// along with whatever the per-language files hold.
+func loadEmbeddings(path, format string, limit int) (*EmbeddingStore, error) {
+	embeddings, loadErr := readEmbeddings(path, format, limit)
+	if loadErr != nil {
+		log.Printf("Error loading embeddings: %v", loadErr)
+	}
+	if embeddings == nil {
+		embeddings = newEmbeddingStore(0)
+	}
+
+	// Per-language vocabularies such as embeddings.zh.json are merged in
+	// without overriding words from the main file. Merging copies a
+	// memory-mapped main file into memory.
+	dim := embeddings.Dimension()
+	ext := filepath.Ext(path)
+	extra, _ := filepath.Glob(strings.TrimSuffix(path, ext) + ".*" + ext)
+	for _, path := range extra {
+		lang, err := readEmbeddings(path, format, limit)
+		if err != nil {
+			log.Printf("Error loading %v", err)
+			continue
+		}
+		if d := lang.Dimension(); dim > 0 && d > 0 && d != dim {
+			log.Printf("Skipping %s: its vectors have %d dimensions, not %d", path, d, dim)
+			continue
+		}
+		for _, word := range lang.Words() {
+			embeddings.add(word, lang.Lookup(word))
+		}
+		dim = embeddings.Dimension()
+	}
+	if embeddings.Len() == 0 {
+		log.Println("No embeddings loaded; questions can only match entries by the words they share")
+	} else {
+		log.Printf("Loaded %d words of dimension %d from %s", embeddings.Len(), dim, path)
+	}
+	return embeddings, loadErr
+}
+
+// aliasedQuestion accepts "question" as an alias for "text", which many
+// clients send by mistake.
+type aliasedQuestion struct {
+	Question
+	Alias string `json:"question"`
+}
+
+func (q *aliasedQuestion) normalize() {
+	if q.Text == "" {
+		q.Text = q.Alias
+	}
 }
 
-func handleAI(ai *AIEngine) http.HandlerFunc {
+// handleAI serves /ai, as JSON or, to clients accepting text/event-stream,
+// as server-sent events paced by pacing and pausing streamInterval between
+// words. teach and mirror are nil unless the teach flow and mirroring are
+// enabled.
+func handleAI(ai *AIEngine, escalations *EscalationStore, teach *TeachStore, mirror *Mirror, questionAlias bool, streamInterval time.Duration, pacing Pacing) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		start := time.Now()
+		var question Question
+		var err error
+		warnings := &Warnings{}
+		if questionAlias {
+			var aliased aliasedQuestion
+			err = decodeRequest(r, &aliased)
+			question = aliased.Question
+			if aliased.Alias != "" {
+				warnings.Add(WarnDeprecatedField, `"question" is accepted as an alias; send "text"`)
+			}
+		} else {
+			err = decodeRequest(r, &question)
+		}
+		if err != nil {
+			writeError(w, err)
 			return
 		}
-		var question Question
-		if err := json.NewDecoder(r.Body).Decode(&question); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if !validStyle(question.Style) {
+			writeError(w, newError(ErrInvalidInput, `style must be "concise", "normal" or "detailed"`))
+			return
+		}
+		if question.Verbose {
+			chargeStage(r, StageVerbose)
+		}
+		sessionID, err := requestSession(w, r, question.SessionID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if warning := rateLimitWarning(r); warning != "" {
+			warnings.Add(WarnRateLimit, warning)
+		}
+		result, err := ai.AskContext(r.Context(), question.Text, AskOptions{
+			SessionID:     sessionID,
+			Scope:         question.Scope,
+			Style:         question.Style,
+			Warnings:      warnings,
+			Tenant:        requestTenant(r),
+			AnyTags:       question.Tags,
+			Language:      question.Language,
+			LanguageHints: acceptLanguages(r),
+		})
+		if result.Source == SourceLLM && !result.cached() {
+			chargeStage(r, StageLLM)
+		}
+		// A fallback is still an answer; only refuse malformed questions.
+		if errors.Is(err, ErrInvalidInput) || errors.Is(err, ErrTooLarge) {
+			writeError(w, err)
+			return
+		}
+		if ai.Attribution.enabled(question.Attribution) {
+			text := ai.Attribution.apply(result)
+			result.addSource(StageAttribution, result.EntryID, 0, strings.TrimPrefix(text, result.Text))
+			result.Text = text
+			result.locateSources()
+		}
+		if mirror != nil {
+			mirror.Offer(question, result, time.Since(start))
+		}
+		answer := result.Text
+		response := AIResponse{
+			Answer:            answer,
+			Source:            result.Source,
+			Confidence:        result.Confidence,
+			Related:           result.Related,
+			Warning:           rateLimitWarning(r),
+			TruncatedAnalysis: result.Truncated,
+			Corrected:         result.Corrected,
+		}
+		if len(ai.Languages) > 0 {
+			response.Language = result.Language
+		}
+		if result.Entry != nil && len(result.Entry.Variants) > 0 {
+			response.EntryID = result.EntryID
+			response.Variant = &result.Variant
+		}
+		if result.LowConfidence() {
+			response.EscalationToken = escalations.Issue(question.Text, result, ai.sessionHistory(sessionID))
+			if teach != nil && ai.Switches.Enabled(SwitchLearning, requestTenant(r)) {
+				response.TeachToken = teach.Offer(question.Text)
+			}
+		}
+		if question.Verbose {
+			response.Sources = result.Sources
+			response.Candidates = result.Retrieved
+			response.Warnings = warnings.List()
+			response.Vocabulary = &result.Vocabulary
+			if !result.Operators.empty() {
+				response.Operators = &result.Operators
+			}
+		}
+		if question.Highlight {
+			response.Highlights = findHighlights(answer, result.Keywords, ai.embeddings(), ai.KB.view().vocab)
+		}
+		if wantsEventStream(r) {
+			streamAnswer(w, r, response, streamInterval, pacing)
 			return
 		}
-		answer := ai.GenerateAnswer(question.Text)
-		response := AIResponse{Answer: answer}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
 	}
 }
 
-func handleTemplates(w http.ResponseWriter, r *http.Request) {
-	tmpl, _ := template.ParseGlob("templates/*")
-	data := struct {
-		Title string
-	}{
-		Title: "Go AI Assistant",
+func handleTemplates(tmpl *template.Template) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := struct {
+			Title string
+		}{
+			Title: "Go AI Assistant",
+		}
+		renderTemplate(w, tmpl, "index.html", data)
 	}
-	tmpl.ExecuteTemplate(w, "index.html", data)
 }
 
 type LearnRequest struct {
-	Question string `json:"question"`
-	Answer   string `json:"answer"`
+	Question  string   `json:"question" schema:"required"`
+	Answer    string   `json:"answer" schema:"required"`
+	SourceURL string   `json:"source_url"`
+	Tags      []string `json:"tags"`
+	// MinScore is the lowest similarity, from 0 to 1, at which the answer
+	// is served to questions that aren't this one.
+	MinScore float64 `json:"min_score"`
+	// Overwrite must be set to replace a different existing answer.
+	Overwrite bool `json:"overwrite"`
 }
 
 func handleLearn(ai *AIEngine) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		if refuseIfOff(w, r, ai.Switches, SwitchLearning) {
 			return
 		}
 		var req LearnRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if err := decodeRequest(r, &req); err != nil {
+			writeError(w, err)
 			return
 		}
-		ai.KB.Learn(req.Question, req.Answer)
-		w.WriteHeader(http.StatusOK)
+		if err := ai.Limits.checkLearnQuestion(req.Question); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkMinScore("min_score", req.MinScore); err != nil {
+			writeError(w, err)
+			return
+		}
+		entry := LearnedEntry{Question: req.Question, Answer: req.Answer, SourceURL: req.SourceURL, Tags: req.Tags, MinScore: req.MinScore}
+		learned, err := ai.KB.learnChecked(entry, req.Overwrite)
+		if err != nil {
+			if !errors.Is(err, ErrConflict) {
+				writeError(w, err)
+				return
+			}
+			status, code := errorResponse(err)
+			writeErrorBody(w, status, code, err.Error(), map[string]interface{}{
+				"existing_question": learned.Question,
+				"existing_answer":   learned.Answer,
+			})
+			return
+		}
+		state := ai.KB.view()
+		duplicates := state.nearDuplicates(learned.ID, state.learnedVectors[normalizeQuestion(learned.Question)], ai.DuplicateThreshold)
+		if duplicates == nil {
+			duplicates = []NearDuplicate{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":              learned.ID,
+			"near_duplicates": duplicates,
+		})
 	}
 }
 
 func main() {
-	embeddings := loadEmbeddings()
-	ai := NewAIEngine(embeddings)
-	http.HandleFunc("/learn", handleLearn(ai))
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-	http.HandleFunc("/ai", handleAI(ai))
-	http.HandleFunc("/", handleTemplates)
-	fmt.Println("Server starting on http://0.0.0.0:8080")
-	http.ListenAndServe("0.0.0.0:8080", nil)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import-faq":
+			runImportFAQ(os.Args[2:])
+			return
+		case "init":
+			runInit(os.Args[2:])
+			return
+		case "pack-embeddings":
+			runPackEmbeddings(os.Args[2:])
+			return
+		case "bench-ann":
+			runBenchANN(os.Args[2:])
+			return
+		case "bench-scan":
+			runBenchScan(os.Args[2:])
+			return
+		}
+	}
+
+	cli := flag.Bool("cli", false, "answer questions read from stdin, one a line, instead of serving HTTP, for trying out prompt file changes")
+	adminToken := flag.String("admin-token", "", "admin key accepted as a bearer token on admin routes and /learn")
+	adminKeyFile := flag.String("admin-key-file", "", "file of further admin keys, one per line (keys in $"+adminKeysEnv+", comma-separated, are accepted too)")
+	noAuth := flag.Bool("no-auth", false, "leave admin routes and /learn unauthenticated, for local development")
+	corsOrigins := flag.String("cors-origins", "", "comma-separated origins, such as https://docs.example.com, whose pages may call /ai and its follow-up routes (* = any; empty = same origin only)")
+	corsMaxAge := flag.Duration("cors-max-age", 10*time.Minute, "how long browsers may cache a CORS preflight")
+	rateBudget := flag.Float64("rate-budget", 60, "rate limit budget in tokens per client")
+	rateRefill := flag.Float64("rate-refill", 1, "rate limit tokens refilled per second")
+	learnBudget := flag.Float64("rate-learn-budget", 20, "separate rate limit budget in tokens per client for /learn and /ai/teach, on top of -rate-budget (0 = none)")
+	learnRefill := flag.Float64("rate-learn-refill", 0.2, "tokens of the /learn budget refilled per second")
+	proxies := flag.String("trusted-proxies", "", "comma-separated IPs or CIDRs of proxies whose X-Forwarded-For names the client and X-Forwarded-Proto its scheme (empty = the headers are ignored)")
+	costModelPath := flag.String("rate-cost-model", "", "JSON file overriding the rate limit cost model")
+	escalationTTL := flag.Duration("escalation-ttl", 30*time.Minute, "how long an escalation token stays valid")
+	teachEnabled := flag.Bool("teach", false, "invite users to teach answers to questions the bot doesn't know")
+	teachTTL := flag.Duration("teach-ttl", 30*time.Minute, "how long a teach token stays valid")
+	teachModeration := flag.Bool("teach-moderation", true, "hold taught answers for approval at /admin/teach instead of learning them at once")
+	mirrorURL := flag.String("mirror-url", "", "base URL of a secondary instance to mirror /ai traffic to (empty = no mirroring)")
+	mirrorPercent := flag.Float64("mirror-percent", 10, "percentage of /ai requests mirrored")
+	mirrorQueue := flag.Int("mirror-queue", 100, "mirrored requests queued before further ones are dropped")
+	escalationWebhook := flag.String("escalation-webhook", "", "URL escalations are forwarded to as JSON")
+	llmURL := flag.String("llm-fallback-url", "", `endpoint questions nothing answered are posted to as {"question", "language"}, whose {"answer"} is served before the default responses (empty = no LLM fallback)`)
+	llmCacheSize := flag.Int("llm-fallback-cache-size", defaultLLMCacheSize, "LLM answers cached by question (0 = none)")
+	llmTTL := flag.Duration("llm-fallback-ttl", defaultLLMCacheTTL, "how long a cached LLM answer is served as is")
+	llmMaxStale := flag.Duration("llm-fallback-max-stale", defaultLLMCacheMaxStale, "how long after it was fetched a cached LLM answer is still served while it is refreshed in the background")
+	llmCallCost := flag.Float64("llm-fallback-call-cost", 0, "cost of one LLM call, for askgo_llm_fallback_spend_saved_total")
+	swapMemoryMB := flag.Int64("swap-memory-limit", 4096, "MiB allowed for old plus new embeddings during a hot swap (0 = unlimited)")
+	flag.BoolVar(&strictJSON, "strict-json", true, "reject request bodies with unknown fields")
+	questionAlias := flag.Bool("ai-question-alias", false, `accept "question" as an alias for "text" on /ai`)
+	snapshotDir := flag.String("snapshot-dir", "snapshots", "directory periodic state snapshots are written to")
+	snapshotInterval := flag.Duration("snapshot-interval", 5*time.Minute, "how often to snapshot state (0 = never)")
+	snapshotKeep := flag.Int("snapshot-keep", 12, "number of snapshots to retain (0 = unlimited)")
+	snapshotMaxAge := flag.Duration("snapshot-max-age", 7*24*time.Hour, "delete snapshots older than this (0 = never)")
+	restoreSnapshot := flag.String("restore-snapshot", "", "restore state from this snapshot file instead of the newest one")
+	caBundle := flag.String("outbound-ca-bundle", "", "PEM file of extra CA certificates trusted for outbound calls")
+	clientCert := flag.String("outbound-client-cert", "", "PEM client certificate for outbound mutual TLS")
+	clientKey := flag.String("outbound-client-key", "", "PEM client key for outbound mutual TLS")
+	outboundTimeout := flag.Duration("outbound-timeout", 10*time.Second, "default timeout for outbound calls")
+	outboundTimeouts := flag.String("outbound-timeouts", "", "per-destination timeouts, e.g. escalation-webhook=5s")
+	seed := flag.Int64("seed", 0, "seed for random answer choices (0 = seed from the clock)")
+	rederiveBudget := flag.Duration("rederive-budget", 5*time.Second, "how long startup waits for data derived by an older analyzer to be re-derived before finishing in the background (0 = only warn)")
+	reindexOnMismatch := flag.Bool("reindex-on-mismatch", false, "re-vectorize restored state built against embeddings of another dimension instead of refusing to start")
+	statsFloor := flag.Int("public-stats-floor", 10, "topics counted fewer times are merged into \"other\" on /stats")
+	statsRounding := flag.Int("public-stats-rounding", 10, "counts on /stats are rounded to a multiple of this")
+	lockBudget := flag.Duration("kb-lock-budget", defaultLockBudget, "warn when a knowledge base write lock is held longer than this")
+	storeKind := flag.String("store", "memory", `where the knowledge base is persisted: "memory" (learned entries in -learned-file) or "sqlite" (everything in -db)`)
+	dbPath := flag.String("db", "askgo.db", "SQLite database of the sqlite store")
+	learnedFile := flag.String("learned-file", "learned.jsonl", "file learned entries are persisted to and reloaded from with the memory store (empty = not persisted)")
+	switchesFile := flag.String("switches-file", "switches.json", "file runtime kill switches are persisted to")
+	tenantKeyFile := flag.String("tenant-key-file", "", `file of API keys callers send as X-API-Key, one "tenant key" pair per line, for per-tenant kill switches`)
+	inlineOperators := flag.Bool("inline-operators", true, "parse #tag, !style, scope: and lang: operators in questions")
+	kbThreshold := flag.Float64("kb-threshold", -1, "cosine similarity a knowledge base or learned entry must exceed to be served, in [0, 1) (-1 = engine.kb_match_threshold in the prompt file, else 0.7)")
+	contextThreshold := flag.Float64("context-threshold", -1, "keyword overlap with an earlier interaction needed to reuse its answer, in [0, 1) (-1 = engine.context_match_threshold in the prompt file, else 0.8)")
+	maxWeightBoost := flag.Float64("max-weight-boost", -1, "how far a knowledge base entry's weight may raise its score above the cosine similarity, in [0, 1] (-1 = engine.max_weight_boost in the prompt file, else 0.1)")
+	greetingMaxEdits := flag.Int("greeting-max-edits", -1, "typos a greeting is still recognized with (-1 = engine.greeting_max_edits in the prompt file, else 2; 0 = exact greetings only)")
+	noAdapt := flag.Bool("no-adapt-responses", false, "serve learned and context answers as is, without the \"Based on ...\" framing")
+	noIDF := flag.Bool("no-idf-weighting", false, "average the words of sentence vectors equally instead of weighting them by IDF over the questions")
+	noStopWords := flag.Bool("no-stopwords", false, "keep stopwords like \"what\" and \"thing\" in sentence vectors and keywords")
+	noSpelling := flag.Bool("no-spell-correction", false, "leave misspelled words of questions uncorrected (overrides engine.spell_correction)")
+	requireEmbeddings := flag.Bool("require-embeddings", false, "exit if no embeddings load, instead of serving lexical-only answers matched by shared words")
+	feedbackFloor := flag.Float64("feedback-floor", 0.5, "entries whose feedback score is below this are listed on /entries/problem")
+	logAnswers := flag.Bool("log-answers", false, "log the source, score and entry of every answer")
+	feedbackMinVotes := flag.Int("feedback-min-votes", 5, "votes an entry needs before /entries/problem judges it")
+	patternCap := flag.Float64("pattern-session-cap", defaultPatternBudget.Cap, "total pattern weight one session may contribute")
+	patternDecay := flag.Float64("pattern-session-decay", defaultPatternBudget.Decay, "share of a session's pattern weight kept when it is folded into the global patterns")
+	patternTTL := flag.Duration("pattern-session-ttl", defaultPatternBudget.TTL, "idle time after which a session ends and its pattern weight is folded into the global patterns")
+	feedbackBoost := flag.Float64("feedback-boost", defaultFeedbackTuning.Boost, "pattern weight /feedback adds to each keyword of a helpful answer, in the caller's session")
+	feedbackMaxWeight := flag.Float64("feedback-max-weight", defaultFeedbackTuning.MaxWeight, "pattern weight /feedback raises keywords to at most")
+	feedbackDecay := flag.Float64("feedback-decay", defaultFeedbackTuning.Decay, "share of its pattern weight each keyword of an unhelpful answer keeps")
+	feedbackScoreStep := flag.Float64("feedback-score-step", defaultFeedbackTuning.ScoreStep, "how far /feedback moves the score of the interaction answered")
+	feedbackQuarantine := flag.Bool("feedback-quarantine", false, "quarantine learned entries whose answers /feedback says didn't help")
+	feedbackQuarantineVotes := flag.Int("feedback-quarantine-votes", defaultFeedbackTuning.QuarantineVotes, "sessions that must say a learned entry's answer didn't help before -feedback-quarantine withdraws it")
+	duplicateThreshold := flag.Float64("duplicate-threshold", defaultDuplicateThreshold, "similarity at which imports and /learn report an existing question as a near duplicate, in [0, 1] (0 = no check)")
+	maxSessions := flag.Int("max-sessions", defaultMaxSessions, "conversations kept at once; the least recently active is ended to make room")
+	answerCacheSize := flag.Int("answer-cache-size", defaultAnswerCacheSize, "answers kept for questions asked again, until the knowledge base changes (0 = none)")
+	answerCacheTTL := flag.Duration("answer-cache-ttl", defaultAnswerCacheTTL, "how long a cached answer is served at most (0 = no answer cache)")
+	queryCacheSize := flag.Int("query-cache-size", defaultQueryCacheSize, "recent questions whose keywords and vector are kept for when they are asked again (0 = none)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long shutdown waits for in-flight requests before giving up")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate to serve HTTPS with; reloaded on SIGHUP (requires -tls-key)")
+	tlsKey := flag.String("tls-key", "", "PEM key of -tls-cert")
+	tlsRedirect := flag.String("tls-redirect", "", "address of a plain HTTP listener redirecting to HTTPS, e.g. :80 (empty = none)")
+	publicURLFlag := flag.String("public-url", "", "public base URL of the server, such as https://askgo.example.com, that /sitemap.xml and canonical links of /qa pages are built on (empty = no sitemap)")
+	maxBody := flag.Int64("max-body-bytes", 64<<10, "largest request body accepted on /ai, /learn, /ai/teach and the other writes but /kb/import")
+	maxImportBody := flag.Int64("max-import-body-bytes", 32<<20, "largest knowledge base export accepted on /kb/import")
+	readTimeout := flag.Duration("read-timeout", 15*time.Second, "how long a client may take to send a request")
+	grpcAddr := flag.String("grpc-addr", "", "address to serve the gRPC API on, such as :9090, with the HTTP server's TLS certificate if it has one (empty = no gRPC)")
+	chatRate := flag.Float64("ws-rate", defaultChatRate, "messages a minute one /ws connection may send, after a burst of 5 (0 = unlimited)")
+	pacing := flag.Bool("pacing", false, "hold answers streamed over text/event-stream and /ws back for a typing delay; plain JSON answers are never delayed")
+	pacingBase := flag.Duration("pacing-base", defaultPacingBase, "typing delay of every paced answer")
+	pacingPerChar := flag.Duration("pacing-per-char", defaultPacingPerChar, "typing delay added per character of a paced answer")
+	pacingMax := flag.Duration("pacing-max", defaultPacingMax, "longest typing delay of a paced answer")
+	streamInterval := flag.Duration("stream-interval", defaultStreamInterval, "pause between the words of an answer streamed to clients accepting text/event-stream or calling AskStream (0 = none)")
+	writeTimeout := flag.Duration("write-timeout", 60*time.Second, "how long a response may take, from the end of the request headers")
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute, "how long an idle keep-alive connection is kept open")
+	logLevelName := flag.String("log-level", "info", "request log level: debug (adds question text), info, warn (4xx and 5xx only), error (5xx only) or off")
+	bootstrap := flag.Bool("bootstrap", false, "write a starter prompt file and toy embeddings where they are missing, as askgo init does")
+	configFlags := registerConfigFlags(flag.CommandLine)
+	flag.Parse()
+
+	config, err := configFlags.load(flag.CommandLine)
+	if err != nil {
+		log.Fatal("Error loading configuration: ", err)
+	}
+	var engineFlags EngineConfig
+	if *kbThreshold != -1 {
+		if err := checkThreshold("-kb-threshold", *kbThreshold); err != nil {
+			log.Fatal(err)
+		}
+		engineFlags.KBMatchThreshold = kbThreshold
+	}
+	if *contextThreshold != -1 {
+		if err := checkThreshold("-context-threshold", *contextThreshold); err != nil {
+			log.Fatal(err)
+		}
+		engineFlags.ContextMatchThreshold = contextThreshold
+	}
+	if *maxWeightBoost != -1 {
+		if *maxWeightBoost < 0 || *maxWeightBoost > 1 {
+			log.Fatal("-max-weight-boost must be between 0 and 1")
+		}
+		engineFlags.MaxWeightBoost = maxWeightBoost
+	}
+	if *greetingMaxEdits != -1 {
+		if *greetingMaxEdits < 0 {
+			log.Fatal("-greeting-max-edits must be at least 0")
+		}
+		engineFlags.GreetingMaxEdits = greetingMaxEdits
+	}
+	if *noAdapt {
+		engineFlags.AdaptResponses = new(bool)
+	}
+	if *noIDF {
+		engineFlags.IDFWeighting = new(bool)
+	}
+	if *noStopWords {
+		engineFlags.FilterStopWords = new(bool)
+	}
+	if *noSpelling {
+		engineFlags.SpellCorrection = new(bool)
+	}
+	if *bootstrap {
+		written, err := writeStarter(config, false)
+		if err != nil {
+			log.Fatal("Error writing starter files: ", err)
+		}
+		for _, path := range written {
+			fmt.Println("Bootstrap: wrote", path)
+		}
+	}
+	if *cli {
+		os.Exit(runCLI(config, engineFlags, os.Stdin, os.Stdout, os.Stderr))
+	}
+	if err := config.check("templates", "static"); err != nil {
+		log.Fatal("Error in configuration: ", err)
+	}
+
+	var certs *certReloader
+	switch {
+	case (*tlsCert == "") != (*tlsKey == ""):
+		log.Fatal("-tls-cert and -tls-key must be given together")
+	case *tlsCert != "":
+		certs, err = newCertReloader(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatal("Error loading TLS certificate: ", err)
+		}
+	case *tlsRedirect != "":
+		log.Fatal("-tls-redirect requires -tls-cert and -tls-key")
+	}
+
+	adminKeys, err := loadAdminKeys(*adminToken, *adminKeyFile)
+	if err != nil {
+		log.Fatal("Error loading admin keys:", err)
+	}
+
+	costModel, err := loadCostModel(*costModelPath)
+	if err != nil {
+		log.Fatal("Error loading cost model:", err)
+	}
+	limiter := NewRateLimiter(*rateBudget, *rateRefill, costModel)
+	if *learnBudget > 0 {
+		limiter.SetClassLimit("learn", *learnBudget, *learnRefill)
+	}
+	trustedProxies, err = parseTrustedProxies(*proxies)
+	if err != nil {
+		log.Fatal("Error parsing -trusted-proxies:", err)
+	}
+	timeouts, err := parseTimeouts(*outboundTimeouts)
+	if err != nil {
+		log.Fatal("Error parsing -outbound-timeouts:", err)
+	}
+	outbound, err := NewOutbound(OutboundConfig{
+		CABundle:   *caBundle,
+		ClientCert: *clientCert,
+		ClientKey:  *clientKey,
+		Timeout:    *outboundTimeout,
+		Timeouts:   timeouts,
+	})
+	if err != nil {
+		log.Fatal("Error configuring outbound HTTP:", err)
+	}
+
+	templates, err := loadTemplates(config.Templates)
+	if err != nil {
+		log.Fatal("Error loading templates: ", err)
+	}
+
+	// Missing or broken prompts and embeddings leave the server running
+	// degraded, with /readyz reporting why, rather than exiting, unless
+	// -require-embeddings is set. Without embeddings, questions are
+	// matched by the words they share with entries.
+	embeddings, embeddingsErr := loadEmbeddings(config.Embeddings, config.EmbeddingsFormat, config.embeddingsLimit())
+	if err := config.check("embeddings"); err != nil {
+		embeddingsErr = err
+	}
+	if embeddings.Len() == 0 {
+		reason := "the file holds no vectors"
+		if embeddingsErr != nil {
+			reason = strings.TrimPrefix(embeddingsErr.Error(), "embeddings: ")
+		}
+		if *requireEmbeddings {
+			log.Fatalf("No embeddings loaded (%s); -require-embeddings is set", reason)
+		}
+		fmt.Printf("Serving degraded (lexical-only): no embeddings loaded (%s); questions match entries by shared words only\n", reason)
+	}
+	if isToyEmbeddings(embeddings) {
+		fmt.Println("Warning: toy embeddings loaded; answers match on shared words only. Do not run this in production.")
+	}
+	level, err := parseLogLevel(*logLevelName)
+	if err != nil {
+		log.Fatal("Error parsing -log-level: ", err)
+	}
+	requestLog := NewRequestLog(level, os.Stderr)
+	stats, unanswered, metrics := NewAnswerStats(), NewUnansweredLog(), NewMetrics()
+	observers := []EngineOption{WithObserver(recordAnswers(stats, unanswered)), WithObserver(metrics.observe), WithObserver(requestLog.observe)}
+	if *logAnswers {
+		observers = append(observers, WithObserver(AsyncObserver(logAnswer, answerLogBuffer)))
+	}
+	promptsErr := config.check("prompts")
+	var ai *AIEngine
+	if promptsErr == nil {
+		ai, promptsErr = NewAIEngine(config, embeddings, observers...)
+	}
+	if promptsErr != nil {
+		fmt.Println("Error loading prompts:", promptsErr)
+		fmt.Println("Serving degraded: only built-in defaults and learned answers until the prompts load")
+		ai = newAIEngine(&PromptConfig{}, embeddings, observers...)
+		ai.Config = config
+		ai.setLoadError("prompts", promptsErr)
+	}
+	ai.setLoadError("embeddings", embeddingsErr)
+	metrics.Gauge("askgo_kb_entries", "Knowledge base entries.", func() float64 { return float64(len(ai.KB.view().entries)) })
+	metrics.Gauge("askgo_learned_entries", "Learned entries.", func() float64 { return float64(len(ai.KB.view().learned)) })
+	metrics.Counter("askgo_kb_imports_refused_total", "KB imports refused for a format this server can't read.", func() float64 { return float64(ai.ImportsRefused()) })
+	if *queryCacheSize < 0 {
+		log.Fatal("-query-cache-size must not be negative")
+	}
+	ai.QueryCache = newQueryCache(*queryCacheSize)
+	ai.QueryCache.registerMetrics(metrics)
+	if *streamInterval < 0 {
+		log.Fatal("-stream-interval must not be negative")
+	}
+	if *chatRate < 0 {
+		log.Fatal("-ws-rate must not be negative")
+	}
+	publicURL, err := parsePublicURL(*publicURLFlag)
+	if err != nil {
+		log.Fatal("-public-url: ", err)
+	}
+	if *pacingBase < 0 || *pacingPerChar < 0 || *pacingMax < 0 {
+		log.Fatal("-pacing-base, -pacing-per-char and -pacing-max must not be negative")
+	}
+	if *answerCacheSize < 0 || *answerCacheTTL < 0 {
+		log.Fatal("-answer-cache-size and -answer-cache-ttl must not be negative")
+	}
+	ai.AnswerCache = newAnswerCache(*answerCacheSize, *answerCacheTTL)
+	ai.AnswerCache.registerMetrics(metrics)
+	ai.LLM, err = NewLLMFallback(LLMConfig{URL: *llmURL, CacheSize: *llmCacheSize, TTL: *llmTTL, MaxStale: *llmMaxStale, CallCost: *llmCallCost}, outbound.Client("llm-fallback"))
+	if err != nil {
+		log.Fatal("-llm-fallback-*: ", err)
+	}
+	ai.LLM.registerMetrics(metrics)
+	outbound.registerMetrics(metrics)
+	ai.KB.locks.SetBudget(*lockBudget)
+	ai.InlineOperators = *inlineOperators
+	ai.Configure(engineFlags)
+	ai.Features = map[string]bool{
+		"inline_operators":  *inlineOperators,
+		"teach":             *teachEnabled,
+		"teach_moderation":  *teachEnabled && *teachModeration,
+		"mirror":            *mirrorURL != "",
+		"escalation_hook":   *escalationWebhook != "",
+		"llm_fallback":      *llmURL != "",
+		"snapshots":         *snapshotInterval > 0,
+		"sqlite_store":      *storeKind == "sqlite",
+		"strict_json":       strictJSON,
+		"ai_question_alias": *questionAlias,
+		"log_answers":       *logAnswers,
+		"admin_auth":        !*noAuth,
+	}
+	if *patternCap < 0 || *patternDecay < 0 || *patternDecay > 1 {
+		log.Fatal("-pattern-session-cap must be at least 0 and -pattern-session-decay in [0, 1]")
+	}
+	ai.PatternBudget = PatternBudget{Cap: *patternCap, Decay: *patternDecay, TTL: *patternTTL}
+	if *feedbackBoost < 0 || *feedbackMaxWeight <= 0 || *feedbackDecay < 0 || *feedbackDecay > 1 || *feedbackScoreStep < 0 || *feedbackScoreStep > 1 {
+		log.Fatal("-feedback-boost must be at least 0, -feedback-max-weight above 0 and -feedback-decay and -feedback-score-step in [0, 1]")
+	}
+	if *feedbackQuarantineVotes < 1 {
+		log.Fatal("-feedback-quarantine-votes must be at least 1")
+	}
+	if *duplicateThreshold < 0 || *duplicateThreshold > 1 {
+		log.Fatal("-duplicate-threshold must be in [0, 1]")
+	}
+	ai.DuplicateThreshold = *duplicateThreshold
+	ai.FeedbackTuning = FeedbackTuning{
+		Boost:           *feedbackBoost,
+		MaxWeight:       *feedbackMaxWeight,
+		Decay:           *feedbackDecay,
+		ScoreStep:       *feedbackScoreStep,
+		Quarantine:      *feedbackQuarantine,
+		QuarantineVotes: *feedbackQuarantineVotes,
+	}
+	if *maxSessions < 1 {
+		log.Fatal("-max-sessions must be at least 1")
+	}
+	ai.MaxSessions = *maxSessions
+	ai.Switches, err = LoadSwitches(*switchesFile)
+	if err != nil {
+		log.Fatal("Error loading kill switches:", err)
+	}
+	tenants, err := loadTenantKeys(*tenantKeyFile)
+	if err != nil {
+		log.Fatal("Error loading tenant keys:", err)
+	}
+	if err := ai.Switches.rekeyTenants(tenants); err != nil {
+		log.Fatal("Error rewriting kill switch tenants:", err)
+	}
+	if off := ai.Switches.Off(); len(off) > 0 {
+		fmt.Println("Kill switches off:", strings.Join(off, ", "))
+	}
+	if *seed != 0 {
+		ai.Seed(*seed)
+	}
+	fmt.Println("Knowledge base check:", ai.ValidateKB(false).summary())
+	if *restoreSnapshot != "" {
+		snap, err := loadSnapshotFile(*restoreSnapshot)
+		if err != nil {
+			log.Fatal("Error restoring snapshot "+*restoreSnapshot+":", err)
+		}
+		if err := ai.restoreSnapshot(snap, *reindexOnMismatch); err != nil {
+			log.Fatal("Error restoring snapshot "+*restoreSnapshot+": ", err)
+		}
+		fmt.Println("Restored snapshot", *restoreSnapshot)
+	}
+	if *snapshotInterval > 0 {
+		ai.Snapshots = NewSnapshotter(ai, *snapshotDir, *snapshotInterval, *snapshotKeep, *snapshotMaxAge)
+		metrics.Counter("askgo_snapshots_refused_total", "Snapshots skipped for a format this server can't read.", func() float64 { return float64(ai.Snapshots.Refused()) })
+		if *restoreSnapshot == "" {
+			path, err := ai.Snapshots.LoadLatest(*reindexOnMismatch)
+			if err != nil {
+				log.Fatal("Error restoring snapshot "+path+": ", err)
+			}
+			if path != "" {
+				fmt.Println("Restored snapshot", path)
+			}
+		}
+		ai.Snapshots.Start()
+	}
+	var store KnowledgeStore
+	switch *storeKind {
+	case "memory":
+		if *learnedFile != "" {
+			learnedLog, err := openLearnedLog(*learnedFile)
+			if err != nil {
+				log.Fatal("Error opening learned entries file:", err)
+			}
+			fmt.Printf("Learned entries: %d loaded from %s, %d corrupt lines skipped\n", len(learnedLog.learned), *learnedFile, learnedLog.skipped)
+			store = learnedLog
+		}
+	case "sqlite":
+		if store, err = openSQLiteStore(*dbPath); err != nil {
+			log.Fatal("Error opening database:", err)
+		}
+	default:
+		log.Fatal(`-store must be "memory" or "sqlite"`)
+	}
+	if store != nil {
+		load, err := ai.openStore(store)
+		if err != nil {
+			log.Fatal("Error loading the knowledge store:", err)
+		}
+		if *storeKind == "sqlite" {
+			fmt.Printf("Knowledge store %s: %d entries and %d learned entries loaded\n", *dbPath, load.Entries, load.Learned)
+		}
+	}
+	ai.checkAnalyzer(*rederiveBudget)
+	escalations := NewEscalationStore(*escalationTTL, *escalationWebhook, outbound.Client("escalation-webhook"), ai.Switches)
+	examples := NewExampleCapture()
+	swap := NewEmbeddingSwap(ai, *swapMemoryMB<<20)
+	feedbackPolicy := FeedbackPolicy{Floor: *feedbackFloor, MinVotes: *feedbackMinVotes}
+	statsPolicy := PublicStatsPolicy{Floor: *statsFloor, Rounding: *statsRounding}
+	var teach *TeachStore
+	if *teachEnabled {
+		teach = NewTeachStore(*teachTTL, *teachModeration)
+	}
+	var mirror *Mirror
+	if *mirrorURL != "" {
+		if *mirrorPercent <= 0 || *mirrorPercent > 100 {
+			log.Fatal("-mirror-percent must be in (0, 100]")
+		}
+		mirror = NewMirror(MirrorConfig{URL: *mirrorURL, Percent: *mirrorPercent, Queue: *mirrorQueue}, outbound.Client("mirror"))
+		fmt.Printf("Mirroring %g%% of /ai traffic to %s\n", *mirrorPercent, *mirrorURL)
+	}
+
+	cors, err := NewCORS(*corsOrigins, *corsMaxAge)
+	if err != nil {
+		log.Fatal("Error parsing -cors-origins:", err)
+	}
+	if *corsOrigins != "" {
+		fmt.Println("CORS origins:", *corsOrigins)
+	}
+	routes := appRoutes(routeDeps{
+		ai:             ai,
+		escalations:    escalations,
+		teach:          teach,
+		mirror:         mirror,
+		unanswered:     unanswered,
+		swap:           swap,
+		limiter:        limiter,
+		stats:          stats,
+		metrics:        metrics,
+		examples:       examples,
+		outbound:       outbound,
+		cors:           cors,
+		templates:      templates,
+		feedbackPolicy: feedbackPolicy,
+		statsPolicy:    statsPolicy,
+		static:         config.Static,
+		publicURL:      publicURL,
+		maxBody:        *maxBody,
+		maxImportBody:  *maxImportBody,
+		questionAlias:  *questionAlias,
+		streamInterval: *streamInterval,
+		pacing:         Pacing{Enabled: *pacing, Base: *pacingBase, PerChar: *pacingPerChar, Max: *pacingMax, observe: metrics.observePacing},
+		chatRate:       *chatRate,
+	})
+	router, err := NewRouter(routes, NewAdminAuth(adminKeys, *noAuth), limiter, cors)
+	if err != nil {
+		log.Fatal("Error building routes:", err)
+	}
+	router.tenants = tenants
+	switch {
+	case *noAuth:
+		fmt.Println("-no-auth set: admin routes and /learn are unauthenticated; use it for local development only")
+	case len(adminKeys) == 0:
+		fmt.Println("No admin keys set: admin routes and /learn refuse every request; set -admin-token, -admin-key-file or $" + adminKeysEnv + ", or run with -no-auth")
+	default:
+		fmt.Printf("Admin keys: %d loaded\n", len(adminKeys))
+	}
+	if provenance, err := json.Marshal(ai.provenance()); err == nil {
+		fmt.Println("Provenance:", string(provenance))
+	}
+	inflight := &inflightRequests{}
+	server := &http.Server{
+		Addr:         config.Addr,
+		Handler:      requestLog.Middleware(inflight.Middleware(metrics.Middleware(router, examples.Middleware(router)))),
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
+	}
+	listeners := []func() error{server.ListenAndServe}
+	var redirect *http.Server
+	if certs != nil {
+		listeners[0] = serveTLS(server, certs)
+		fmt.Println("TLS certificate:", certs.describe())
+		if *tlsRedirect != "" {
+			redirect = &http.Server{Addr: *tlsRedirect, Handler: httpsRedirect(config.Addr)}
+			listeners = append(listeners, redirect.ListenAndServe)
+			fmt.Println("Redirecting http://" + *tlsRedirect + " to HTTPS")
+		}
+		fmt.Println("Server starting on https://" + config.Addr)
+	} else {
+		fmt.Println("Server starting on http://" + config.Addr)
+	}
+	var grpcServer *grpc.Server
+	if *grpcAddr != "" {
+		grpcServer = newGRPCServer(&grpcAssistant{ai: ai, admin: NewAdminAuth(adminKeys, *noAuth), tenants: tenants, streamInterval: *streamInterval}, certs)
+		listeners = append(listeners, serveGRPC(grpcServer, *grpcAddr))
+		fmt.Println("gRPC server starting on " + *grpcAddr)
+	}
+	if err := serveUntilSignal(certs, listeners...); err != nil {
+		log.Fatal("Error starting server:", err)
+	}
+	if redirect != nil {
+		redirect.Close()
+	}
+	drainErr := drain(server, inflight, *shutdownTimeout)
+	if grpcServer != nil {
+		drainGRPC(grpcServer, *shutdownTimeout)
+	}
+
+	// Learned entries are written through as they are learned; what is
+	// left is the state only snapshots keep, and closing the store.
+	if ai.Snapshots != nil {
+		if path, err := ai.Snapshots.Take(); err != nil {
+			log.Printf("Final snapshot failed: %v", err)
+		} else {
+			log.Printf("Final snapshot written to %s", path)
+		}
+	}
+	if store != nil {
+		if err := store.Close(); err != nil {
+			log.Printf("Error closing the knowledge store: %v", err)
+		}
+	}
+	if drainErr != nil {
+		os.Exit(1)
+	}
 }
 
 func min(a, b int) int {
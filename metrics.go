@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency
+// histograms.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// scoreBuckets are the upper bounds of the best-match score histogram.
+var scoreBuckets = []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1}
+
+// Metrics counts requests and answers and serves them at /metrics in the
+// Prometheus text format. Like the stats it never records question text;
+// requests are labelled by route pattern, not path, so the number of
+// series stays bounded.
+type Metrics struct {
+	requests        *counterVec
+	requestDuration *histogramVec
+	answers         *counterVec
+	answerDuration  *histogramVec
+	bestScore       *histogramVec
+	pacing          *histogramVec
+	outbound        *counterVec
+	outboundFailed  *counterVec
+
+	mu     sync.Mutex
+	gauges []gauge
+}
+
+// gauge is a value read at every scrape; kind is its Prometheus type,
+// gauge or counter.
+type gauge struct {
+	name, help, kind string
+	value            func() float64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:        newCounterVec("askgo_http_requests_total", "HTTP requests by route, method and status code.", "route", "method", "code"),
+		requestDuration: newHistogramVec("askgo_http_request_duration_seconds", "HTTP response latency by route.", latencyBuckets, "route"),
+		answers:         newCounterVec("askgo_answers_total", "Answers by the source they came from.", "source"),
+		answerDuration:  newHistogramVec("askgo_answer_duration_seconds", "Time taken to answer a question.", latencyBuckets),
+		bestScore:       newHistogramVec("askgo_kb_best_score", "Cosine similarity of the best knowledge base match per question.", scoreBuckets),
+		pacing:          newHistogramVec("askgo_pacing_delay_seconds", "Delay pacing added to streamed answers, which askgo_answer_duration_seconds leaves out.", latencyBuckets),
+		outbound:        newCounterVec("askgo_outbound_requests_total", "Outbound calls by destination.", "destination"),
+		outboundFailed:  newCounterVec("askgo_outbound_failures_total", "Outbound calls by destination that failed to connect or got a 5xx status.", "destination"),
+	}
+}
+
+// Gauge registers a value read at every scrape.
+func (m *Metrics) Gauge(name, help string, value func() float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges = append(m.gauges, gauge{name, help, "gauge", value})
+}
+
+// Counter registers a count kept elsewhere, read at every scrape. value
+// must never decrease.
+func (m *Metrics) Counter(name, help string, value func() float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges = append(m.gauges, gauge{name, help, "counter", value})
+}
+
+// statusRecorder remembers the status code a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes flushes on, for streamed responses.
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes hijacking on, for WebSocket upgrades, which are counted as
+// switching protocols.
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer can't be hijacked")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Middleware counts and times the requests next serves, labelled by the
+// route of router that matches them.
+func (m *Metrics) Middleware(router *Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := router.Pattern(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		m.requests.add(1, route, r.Method, strconv.Itoa(rec.status))
+		m.requestDuration.observe(time.Since(start).Seconds(), route)
+	})
+}
+
+// observe is the engine observer behind the answer metrics.
+func (m *Metrics) observe(ctx context.Context, q Query, a Answer) {
+	if strings.TrimSpace(q.Raw) == "" {
+		return
+	}
+	m.answers.add(1, a.Source)
+	m.answerDuration.observe(a.Duration.Seconds())
+	if score, ok := bestKBScore(a); ok {
+		m.bestScore.observe(score)
+	}
+}
+
+// observePacing records a pacing delay; see Pacing.
+func (m *Metrics) observePacing(d time.Duration) {
+	m.pacing.observe(d.Seconds())
+}
+
+// observeOutbound records an outbound call; see Outbound.
+func (m *Metrics) observeOutbound(destination string, failed bool) {
+	m.outbound.add(1, destination)
+	if failed {
+		m.outboundFailed.add(1, destination)
+	} else {
+		// A destination that never failed still has its series.
+		m.outboundFailed.add(0, destination)
+	}
+}
+
+// bestKBScore finds the score of the closest knowledge base entry when it
+// was served, lost to another source or was the best of a fallback's near
+// misses.
+func bestKBScore(a Answer) (float64, bool) {
+	for _, c := range a.Retrieved {
+		if c.Source == SourceKnowledgeBase {
+			return c.Score, true
+		}
+	}
+	if len(a.Candidates) > 0 {
+		return a.Candidates[0].Score, true
+	}
+	return 0, false
+}
+
+// handleMetrics serves GET /metrics.
+func handleMetrics(m *Metrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		out := bufio.NewWriter(w)
+		m.requests.write(out)
+		m.requestDuration.write(out)
+		m.answers.write(out)
+		m.answerDuration.write(out)
+		m.bestScore.write(out)
+		m.pacing.write(out)
+		m.outbound.write(out)
+		m.outboundFailed.write(out)
+		m.mu.Lock()
+		gauges := m.gauges
+		m.mu.Unlock()
+		for _, g := range gauges {
+			fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", g.name, g.help, g.name, g.kind, g.name, formatFloat(g.value()))
+		}
+		out.Flush()
+	}
+}
+
+// counterVec is a counter with labels.
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) add(delta float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key, ""), formatFloat(c.values[key]))
+	}
+}
+
+// histogramVec is a histogram with labels.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, ""), s.count)
+	}
+}
+
+// formatLabels renders the label set of a series, whose values are
+// joined in key, plus the le label of a histogram bucket if given.
+func formatLabels(names []string, key, le string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", names[i], value))
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=%q", le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
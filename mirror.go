@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// mirrorWorkers is the number of requests in flight to the secondary.
+	mirrorWorkers = 2
+	// mirrorDisagreements bounds the recent disagreements kept for the
+	// report.
+	mirrorDisagreements = 20
+)
+
+// MirrorConfig configures traffic mirroring. Mirroring is off unless URL
+// is set.
+type MirrorConfig struct {
+	// URL is the base URL of the secondary instance.
+	URL string
+	// Percent is the share of /ai requests mirrored.
+	Percent float64
+	// Queue bounds the mirrored requests waiting to be sent; beyond it
+	// requests are dropped rather than held.
+	Queue int
+}
+
+// mirrorRequest is a primary answer waiting to be compared with the
+// secondary's.
+type mirrorRequest struct {
+	question Question
+	primary  Answer
+	latency  time.Duration
+}
+
+// MirrorDisagreement is a mirrored question the two instances answered
+// differently. Questions and answers are redacted.
+type MirrorDisagreement struct {
+	Question        string    `json:"question"`
+	PrimarySource   string    `json:"primary_source"`
+	PrimaryAnswer   string    `json:"primary_answer"`
+	SecondarySource string    `json:"secondary_source"`
+	SecondaryAnswer string    `json:"secondary_answer"`
+	ScoreDelta      float64   `json:"score_delta"`
+	At              time.Time `json:"at"`
+}
+
+// MirrorReport compares the primary with the secondary over the mirrored
+// requests. Score deltas are secondary minus primary.
+type MirrorReport struct {
+	Target  string  `json:"target"`
+	Percent float64 `json:"percent"`
+	Sent    int     `json:"sent"`
+	// Dropped requests found the queue full.
+	Dropped int `json:"dropped"`
+	Failed  int `json:"failed"`
+	// LastError is why the last failed request failed.
+	LastError string `json:"last_error,omitempty"`
+	// Redacted counts questions the redaction rules changed before they
+	// were sent; their answers may differ for that reason alone.
+	Redacted            int                  `json:"redacted"`
+	Compared            int                  `json:"compared"`
+	Agreed              int                  `json:"agreed"`
+	AgreementRate       *float64             `json:"agreement_rate,omitempty"`
+	SourceAgreementRate *float64             `json:"source_agreement_rate,omitempty"`
+	MeanScoreDelta      *float64             `json:"mean_score_delta,omitempty"`
+	MeanAbsScoreDelta   *float64             `json:"mean_abs_score_delta,omitempty"`
+	PrimaryLatencyMs    *float64             `json:"primary_latency_ms,omitempty"`
+	SecondaryLatencyMs  *float64             `json:"secondary_latency_ms,omitempty"`
+	Disagreements       []MirrorDisagreement `json:"disagreements"`
+}
+
+// Mirror forwards a sample of /ai traffic to a secondary instance and
+// compares its answers with the primary's. Sending happens on background
+// workers; the request path only samples and enqueues.
+type Mirror struct {
+	cfg    MirrorConfig
+	client *http.Client
+	queue  chan mirrorRequest
+
+	mu                               sync.Mutex
+	report                           MirrorReport
+	sourceAgreed                     int
+	scoreDelta, absScoreDelta        float64
+	primaryLatency, secondaryLatency time.Duration
+}
+
+func NewMirror(cfg MirrorConfig, client *http.Client) *Mirror {
+	m := &Mirror{
+		cfg:    cfg,
+		client: client,
+		queue:  make(chan mirrorRequest, cfg.Queue),
+		report: MirrorReport{Target: cfg.URL, Percent: cfg.Percent},
+	}
+	for i := 0; i < mirrorWorkers; i++ {
+		go m.work()
+	}
+	return m
+}
+
+// Offer mirrors a request with probability Percent. It never blocks.
+func (m *Mirror) Offer(question Question, primary Answer, latency time.Duration) {
+	if rand.Float64()*100 >= m.cfg.Percent {
+		return
+	}
+	select {
+	case m.queue <- mirrorRequest{question, primary, latency}:
+	default:
+		m.mu.Lock()
+		m.report.Dropped++
+		m.mu.Unlock()
+	}
+}
+
+func (m *Mirror) work() {
+	for req := range m.queue {
+		m.send(req)
+	}
+}
+
+// send asks the secondary the redacted question. Only the text, scope and
+// style are forwarded: no session, cookies or client headers.
+func (m *Mirror) send(req mirrorRequest) {
+	text := redact(req.question.Text)
+	body, _ := json.Marshal(map[string]interface{}{
+		"text":    text,
+		"scope":   req.question.Scope,
+		"style":   req.question.Style,
+		"verbose": true,
+	})
+	start := time.Now()
+	secondary, err := m.ask(body)
+	latency := time.Since(start)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.report.Sent++
+	if text != req.question.Text {
+		m.report.Redacted++
+	}
+	if err != nil {
+		m.report.Failed++
+		m.report.LastError = err.Error()
+		return
+	}
+	var score float64
+	if len(secondary.Sources) > 0 {
+		score = secondary.Sources[0].Score
+	}
+	delta := score - req.primary.Score
+	m.report.Compared++
+	m.scoreDelta += delta
+	m.absScoreDelta += math.Abs(delta)
+	m.primaryLatency += req.latency
+	m.secondaryLatency += latency
+	sameSource := secondary.Source == req.primary.Source
+	if sameSource {
+		m.sourceAgreed++
+	}
+	if sameSource && secondary.Answer == req.primary.Text {
+		m.report.Agreed++
+		return
+	}
+	m.report.Disagreements = append(m.report.Disagreements, MirrorDisagreement{
+		Question:        text,
+		PrimarySource:   req.primary.Source,
+		PrimaryAnswer:   redact(req.primary.Text),
+		SecondarySource: secondary.Source,
+		SecondaryAnswer: redact(secondary.Answer),
+		ScoreDelta:      delta,
+		At:              time.Now(),
+	})
+	if n := len(m.report.Disagreements); n > mirrorDisagreements {
+		m.report.Disagreements = append([]MirrorDisagreement(nil), m.report.Disagreements[n-mirrorDisagreements:]...)
+	}
+}
+
+func (m *Mirror) ask(body []byte) (AIResponse, error) {
+	var response AIResponse
+	resp, err := m.client.Post(strings.TrimSuffix(m.cfg.URL, "/")+"/ai", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return response, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return response, fmt.Errorf("secondary returned %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	return response, err
+}
+
+// Report returns the comparison so far.
+func (m *Mirror) Report() MirrorReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.report
+	r.Disagreements = append([]MirrorDisagreement{}, r.Disagreements...)
+	if n := float64(r.Compared); n > 0 {
+		agreement := float64(r.Agreed) / n
+		sourceAgreement := float64(m.sourceAgreed) / n
+		scoreDelta, absScoreDelta := m.scoreDelta/n, m.absScoreDelta/n
+		primary := m.primaryLatency.Seconds() * 1000 / n
+		secondary := m.secondaryLatency.Seconds() * 1000 / n
+		r.AgreementRate, r.SourceAgreementRate = &agreement, &sourceAgreement
+		r.MeanScoreDelta, r.MeanAbsScoreDelta = &scoreDelta, &absScoreDelta
+		r.PrimaryLatencyMs, r.SecondaryLatencyMs = &primary, &secondary
+	}
+	return r
+}
+
+// handleMirror serves GET /admin/mirror.
+func handleMirror(m *Mirror) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.Report())
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// answerLogBuffer is the queue size of the -log-answers observer.
+const answerLogBuffer = 256
+
+// Observer is told about every answer the engine gives, fallbacks and
+// starters for empty questions included. Observers run synchronously on
+// the asking goroutine, in the order they were registered, so they must
+// be fast and must not modify the query or answer they are given; wrap
+// anything slow with AsyncObserver.
+type Observer func(ctx context.Context, q Query, a Answer)
+
+// EngineOption configures an engine at construction.
+type EngineOption func(*AIEngine)
+
+// WithObserver registers an observer.
+func WithObserver(observer Observer) EngineOption {
+	return func(ai *AIEngine) {
+		ai.observers = append(ai.observers, observer)
+	}
+}
+
+// AsyncObserver runs observer on its own goroutine, fed through a queue of
+// size buffer. When the queue is full answers are dropped rather than
+// slowing down the engine. The context passed on may already be done by
+// the time observer sees it.
+func AsyncObserver(observer Observer, buffer int) Observer {
+	type observation struct {
+		ctx context.Context
+		q   Query
+		a   Answer
+	}
+	queue := make(chan observation, buffer)
+	go func() {
+		for o := range queue {
+			observer(o.ctx, o.q, o.a)
+		}
+	}()
+	return func(ctx context.Context, q Query, a Answer) {
+		select {
+		case queue <- observation{ctx, q, a}:
+		default:
+		}
+	}
+}
+
+func (ai *AIEngine) observe(ctx context.Context, q *Query, a Answer) {
+	for _, observer := range ai.observers {
+		observer(ctx, *q, a)
+	}
+}
+
+// recordAnswers is the observer behind /admin/stats and
+// /admin/unanswered.
+func recordAnswers(stats *AnswerStats, unanswered *UnansweredLog) Observer {
+	return func(ctx context.Context, q Query, a Answer) {
+		if strings.TrimSpace(q.Raw) == "" {
+			return
+		}
+		stats.record(a, time.Now())
+		if a.LowConfidence() {
+			unanswered.Record(q.Raw, q.Vector())
+		}
+	}
+}
+
+// logAnswer logs how a question was answered, with the ID of the request
+// that asked it. Like the stats it leaves the question text out.
+func logAnswer(ctx context.Context, q Query, a Answer) {
+	codes := make([]string, len(a.Warnings))
+	for i, warning := range a.Warnings {
+		codes[i] = warning.Code
+	}
+	log.Printf("answer: request=%s source=%s score=%.2f entry=%q warnings=%s", requestID(ctx), a.Source, a.Score, a.EntryID, strings.Join(codes, ","))
+}
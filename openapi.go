@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OpenAPI is the OpenAPI 3 document /openapi.json serves: one operation
+// per route, with the examples captured for it. It is derived from the
+// route table, so every route is in it.
+type OpenAPI struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       openAPIInfo                            `json:"info"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components openAPIComponents                      `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+// openAPIOperation is one method of a path. Prefix is set for routes
+// serving every path under theirs, which OpenAPI can't express.
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+	RequestBody *openAPIBody               `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Prefix      bool                       `json:"x-prefix,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name     string `json:"name"`
+	In       string `json:"in"`
+	Required bool   `json:"required"`
+}
+
+type openAPIBody struct {
+	Content map[string]openAPIMedia `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                  `json:"description"`
+	Content     map[string]openAPIMedia `json:"content,omitempty"`
+}
+
+// openAPIMedia holds one example payload: parsed JSON, or the text of
+// anything else, including JSON cut short by the size cap.
+type openAPIMedia struct {
+	Example interface{} `json:"example,omitempty"`
+}
+
+// openAPIDocument describes routes, with the examples of each endpoint by
+// status code as ExampleCapture.List returns them. An endpoint with no
+// example yet gets a default response.
+func openAPIDocument(routes []Route, examples map[string]map[string]Example) OpenAPI {
+	doc := OpenAPI{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: "AskGo", Version: version},
+		Paths:   make(map[string]map[string]openAPIOperation),
+		Components: openAPIComponents{SecuritySchemes: map[string]openAPISecurityScheme{
+			"adminKey": {Type: "http", Scheme: "bearer"},
+		}},
+	}
+	for _, route := range routes {
+		op := openAPIOperation{
+			OperationID: route.Method + " " + route.Pattern,
+			Responses:   make(map[string]openAPIResponse),
+			Prefix:      strings.HasSuffix(route.Pattern, "/") && route.Pattern != "/",
+		}
+		for _, segment := range strings.Split(route.Pattern, "/") {
+			if isPathParam(segment) {
+				op.Parameters = append(op.Parameters, openAPIParameter{Name: strings.Trim(segment, "{}"), In: "path", Required: true})
+			}
+		}
+		if route.Admin {
+			op.Security = []map[string][]string{{"adminKey": {}}}
+		}
+		byStatus := examples[op.OperationID]
+		statuses := make([]string, 0, len(byStatus))
+		for status := range byStatus {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			example := byStatus[status]
+			response := openAPIResponse{Description: http.StatusText(example.Status)}
+			if example.Response != "" {
+				response.Content = map[string]openAPIMedia{mediaType(example.ContentType): {Example: examplePayload(example.Response)}}
+			}
+			op.Responses[strconv.Itoa(example.Status)] = response
+			// The request of the example with the lowest status, usually
+			// a success, stands for them all.
+			if op.RequestBody == nil && example.Request != "" {
+				op.RequestBody = &openAPIBody{Content: map[string]openAPIMedia{"application/json": {Example: examplePayload(example.Request)}}}
+			}
+		}
+		if len(op.Responses) == 0 {
+			op.Responses["default"] = openAPIResponse{Description: "No example captured yet; errors use the error envelope."}
+		}
+		if doc.Paths[route.Pattern] == nil {
+			doc.Paths[route.Pattern] = make(map[string]openAPIOperation)
+		}
+		doc.Paths[route.Pattern][strings.ToLower(route.Method)] = op
+	}
+	return doc
+}
+
+func mediaType(contentType string) string {
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+// examplePayload parses a captured body as JSON, or keeps it as text.
+func examplePayload(body string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(body), &v); err == nil {
+		return v
+	}
+	return body
+}
+
+// handleOpenAPI serves GET /openapi.json. routes returns the route table,
+// which includes this route.
+func handleOpenAPI(c *ExampleCapture, routes func() []Route) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openAPIDocument(routes(), c.List()))
+	}
+}
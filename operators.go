@@ -0,0 +1,102 @@
+package main
+
+import "strings"
+
+// Operators are the search-style operators recognized inline in a
+// question, e.g. "channels vs mutexes #concurrency !detailed":
+//
+//	#tag        only match entries carrying the tag (every #tag given)
+//	!style      answer style, as the style request field
+//	scope:name  scope, as the scope request field
+//	lang:code   language of the question
+//
+// Anything else, including "!" followed by an unknown style, stays in the
+// question text.
+type Operators struct {
+	Tags  []string `json:"tags,omitempty"`
+	Style string   `json:"style,omitempty"`
+	Scope string   `json:"scope,omitempty"`
+	Lang  string   `json:"lang,omitempty"`
+}
+
+func (o Operators) empty() bool {
+	return len(o.Tags) == 0 && o.Style == "" && o.Scope == "" && o.Lang == ""
+}
+
+// parseOperators extracts the recognized operators from text and returns
+// the text without them. Text without operators is returned unchanged.
+func parseOperators(text string) (string, Operators) {
+	var ops Operators
+	fields := strings.Fields(text)
+	kept := fields[:0]
+	for _, field := range fields {
+		switch {
+		case len(field) > 1 && field[0] == '#':
+			ops.Tags = append(ops.Tags, strings.ToLower(field[1:]))
+		case len(field) > 1 && field[0] == '!' && validStyle(field[1:]):
+			ops.Style = field[1:]
+		case strings.HasPrefix(field, "scope:") && len(field) > len("scope:"):
+			ops.Scope = field[len("scope:"):]
+		case strings.HasPrefix(field, "lang:") && len(field) > len("lang:"):
+			ops.Lang = strings.ToLower(field[len("lang:"):])
+		default:
+			kept = append(kept, field)
+		}
+	}
+	if ops.empty() {
+		return text, ops
+	}
+	return strings.Join(kept, " "), ops
+}
+
+// apply sets the request options the operators stand for. Operators win
+// over request fields, since they were typed for this question; #tag
+// operators add to RequireTags and leave the AnyTags of the tags field be.
+func (o Operators) apply(opts *AskOptions) {
+	opts.RequireTags = append(opts.RequireTags, o.Tags...)
+	if o.Style != "" {
+		opts.Style = o.Style
+	}
+	if o.Scope != "" {
+		opts.Scope = o.Scope
+	}
+}
+
+// anyTag reports whether tags and filter share a tag, or filter is empty.
+func anyTag(tags, filter []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, want := range filter {
+		for _, tag := range tags {
+			if strings.EqualFold(tag, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allTags reports whether tags include every one of required.
+func allTags(tags, required []string) bool {
+	for _, want := range required {
+		found := false
+		for _, tag := range tags {
+			if strings.EqualFold(tag, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// tagsAllowed reports whether an entry carrying tags may answer a request
+// with opts: it carries every one of opts.RequireTags and, unless
+// opts.AnyTags is empty, at least one of those.
+func tagsAllowed(tags []string, opts AskOptions) bool {
+	return allTags(tags, opts.RequireTags) && anyTag(tags, opts.AnyTags)
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestTagsAllowed(t *testing.T) {
+	tags := []string{"concurrency", "Beginner"}
+	tests := []struct {
+		opts AskOptions
+		want bool
+	}{
+		{AskOptions{}, true},
+		{AskOptions{AnyTags: []string{"beginner", "stdlib"}}, true},
+		{AskOptions{AnyTags: []string{"stdlib"}}, false},
+		{AskOptions{RequireTags: []string{"concurrency", "beginner"}}, true},
+		{AskOptions{RequireTags: []string{"concurrency", "stdlib"}}, false},
+		{AskOptions{RequireTags: []string{"concurrency"}, AnyTags: []string{"stdlib", "beginner"}}, true},
+		{AskOptions{RequireTags: []string{"stdlib"}, AnyTags: []string{"beginner"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tagsAllowed(tags, tt.opts); got != tt.want {
+			t.Errorf("tagsAllowed(%v, require %v, any %v) = %v, want %v", tags, tt.opts.RequireTags, tt.opts.AnyTags, got, tt.want)
+		}
+	}
+}
+
+// TestTagOperatorsRequireEveryTag answers through #tag operators, which
+// require every tag of knowledge base and learned entries alike, and the
+// tags field, which accepts any of its tags.
+func TestTagOperatorsRequireEveryTag(t *testing.T) {
+	entries := append([]KnowledgeEntry(nil), testEntries...)
+	entries[0].Tags = []string{"concurrency", "beginner"}
+	entries[1].Tags = []string{"concurrency"}
+	entries[2].Tags = []string{"basics"}
+	ai := newTestEngine(t, withEntries(entries...))
+	ai.InlineOperators = true
+	ai.KB.learn(LearnedEntry{Question: "How do I stop a goroutine?", Answer: "Cancel its context.", Tags: []string{"concurrency"}})
+
+	tests := []struct {
+		question string
+		opts     AskOptions
+		entry    string
+	}{
+		{"How do channels work? #concurrency", AskOptions{}, "channels"},
+		{"How do channels work? #concurrency #beginner", AskOptions{}, ""},
+		{"How do channels work?", AskOptions{AnyTags: []string{"beginner", "concurrency"}}, "channels"},
+		{"How do channels work?", AskOptions{AnyTags: []string{"beginner", "basics"}}, ""},
+		{"How do I stop a goroutine? #concurrency", AskOptions{}, learnedID(normalizeQuestion("How do I stop a goroutine?"))},
+		{"How do I stop a goroutine? #concurrency #beginner", AskOptions{}, ""},
+	}
+	for _, tt := range tests {
+		answer, _ := ai.Ask(tt.question, tt.opts)
+		if tt.entry == "" && (answer.EntryID == "channels" || answer.Source == SourceLearned) {
+			t.Errorf("%q with any of %v was answered from %s %s", tt.question, tt.opts.AnyTags, answer.Source, answer.EntryID)
+		}
+		if tt.entry != "" && answer.EntryID != tt.entry {
+			t.Errorf("%q with any of %v was answered from %s %q, want %s", tt.question, tt.opts.AnyTags, answer.Source, answer.EntryID, tt.entry)
+		}
+	}
+}
+
+// TestScopeTagsAreTheDefaultFilter restricts retrieval to the scope's
+// tags unless the request sets tags of its own.
+func TestScopeTagsAreTheDefaultFilter(t *testing.T) {
+	entries := append([]KnowledgeEntry(nil), testEntries...)
+	entries[1].Tags = []string{"sdk"}
+	entries[2].Tags = []string{"basics"}
+	ai := newTestEngine(t, withEntries(entries...), withPrompts(func(p *PromptConfig) {
+		p.Scopes = map[string]ScopeConfig{"sdk": {Tags: []string{"sdk"}}}
+	}))
+	ai.InlineOperators = true
+
+	tests := []struct {
+		question string
+		opts     AskOptions
+		entry    string
+	}{
+		{"How do channels work?", AskOptions{Scope: "sdk"}, "channels"},
+		{"How are errors handled in Go?", AskOptions{Scope: "sdk"}, ""},
+		{"How are errors handled in Go?", AskOptions{Scope: "sdk", AnyTags: []string{"basics"}}, "errors"},
+		{"How are errors handled in Go? #basics", AskOptions{Scope: "sdk"}, "errors"},
+		{"How are errors handled in Go?", AskOptions{Scope: "docs"}, "errors"},
+	}
+	for _, tt := range tests {
+		answer, _ := ai.Ask(tt.question, tt.opts)
+		if answer.EntryID != tt.entry {
+			t.Errorf("%q in scope %q was answered from %q, want %q", tt.question, tt.opts.Scope, answer.EntryID, tt.entry)
+		}
+	}
+	if result := ai.Search("How are errors handled in Go?", AskOptions{Scope: "sdk"}, 5); len(result.Matches) != 0 && result.Matches[0].ID == "errors" {
+		t.Errorf("search in scope sdk ranked errors first")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutboundConfig configures HTTP calls the server makes to other services.
+// Proxies come from HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+type OutboundConfig struct {
+	// CABundle is a PEM file of extra trusted roots, added to the system
+	// pool.
+	CABundle string
+	// ClientCert and ClientKey are a PEM key pair presented for mutual TLS.
+	ClientCert string
+	ClientKey  string
+	Timeout    time.Duration
+	// Timeouts overrides Timeout per destination.
+	Timeouts map[string]time.Duration
+}
+
+// Outbound hands out HTTP clients for named destinations and tracks the
+// health of each destination, reported by /healthz and, once
+// registerMetrics is called, counted in the metrics. The destinations are
+// "escalation-webhook", "mirror" and "llm-fallback". The tree has no remote
+// prompt sync or Slack/Telegram clients; when added they take theirs from
+// here too.
+type Outbound struct {
+	transport *http.Transport
+	timeout   time.Duration
+	timeouts  map[string]time.Duration
+
+	mu     sync.Mutex
+	health map[string]*DestinationHealth
+	// observe, if set, is told of every call.
+	observe func(destination string, failed bool)
+}
+
+// DestinationHealth counts the calls made to one destination.
+type DestinationHealth struct {
+	Requests    int        `json:"requests"`
+	Failures    int        `json:"failures"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	// Failing reports that the most recent call failed.
+	Failing bool `json:"failing"`
+}
+
+func NewOutbound(cfg OutboundConfig) (*Outbound, error) {
+	tlsConfig := &tls.Config{}
+	if cfg.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(cfg.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", cfg.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = tlsConfig
+	return &Outbound{
+		transport: transport,
+		timeout:   cfg.Timeout,
+		timeouts:  cfg.Timeouts,
+		health:    make(map[string]*DestinationHealth),
+	}, nil
+}
+
+// Client returns an HTTP client for a destination. Every call it makes is
+// recorded against the destination's health.
+func (o *Outbound) Client(destination string) *http.Client {
+	timeout := o.timeout
+	if t, ok := o.timeouts[destination]; ok {
+		timeout = t
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &recordingTransport{outbound: o, destination: destination},
+	}
+}
+
+type recordingTransport struct {
+	outbound    *Outbound
+	destination string
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.outbound.transport.RoundTrip(req)
+	switch {
+	case err != nil:
+		t.outbound.record(t.destination, err)
+	case resp.StatusCode >= 500:
+		t.outbound.record(t.destination, errors.New(resp.Status))
+	default:
+		t.outbound.record(t.destination, nil)
+	}
+	return resp, err
+}
+
+func (o *Outbound) record(destination string, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	h, ok := o.health[destination]
+	if !ok {
+		h = &DestinationHealth{}
+		o.health[destination] = h
+	}
+	h.Requests++
+	h.Failing = err != nil
+	if o.observe != nil {
+		o.observe(destination, err != nil)
+	}
+	if err != nil {
+		now := time.Now()
+		h.Failures++
+		h.LastError = err.Error()
+		h.LastFailure = &now
+	}
+}
+
+// registerMetrics counts the calls made from now on in m, labelled by
+// destination.
+func (o *Outbound) registerMetrics(m *Metrics) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.observe = m.observeOutbound
+}
+
+// Health returns the health of every destination called so far.
+func (o *Outbound) Health() map[string]DestinationHealth {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	out := make(map[string]DestinationHealth, len(o.health))
+	for destination, h := range o.health {
+		out[destination] = *h
+	}
+	return out
+}
+
+// parseTimeouts parses "destination=duration" pairs separated by commas.
+func parseTimeouts(s string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q: want destination=duration", pair)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", pair, err)
+		}
+		timeouts[strings.TrimSpace(parts[0])] = d
+	}
+	return timeouts, nil
+}
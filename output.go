@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// OutputProcessorConfig describes one step of the output pipeline in
+// prompt.json. Which fields apply depends on Type.
+type OutputProcessorConfig struct {
+	Type        string   `json:"type" schema:"required,enum=append|replace-regex|banned-phrases|template-wrap"`
+	Text        string   `json:"text,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	Replacement string   `json:"replacement,omitempty"`
+	Phrases     []string `json:"phrases,omitempty"`
+	Template    string   `json:"template,omitempty"`
+}
+
+// OutputProcessor transforms an answer after it has been selected.
+type OutputProcessor interface {
+	Name() string
+	Process(question, answer string) (string, error)
+}
+
+type appendProcessor struct {
+	text string
+}
+
+func (p appendProcessor) Name() string { return "append" }
+
+func (p appendProcessor) Process(question, answer string) (string, error) {
+	return answer + p.text, nil
+}
+
+type regexProcessor struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+func (p regexProcessor) Name() string { return "replace-regex" }
+
+func (p regexProcessor) Process(question, answer string) (string, error) {
+	return p.re.ReplaceAllString(answer, p.replacement), nil
+}
+
+type bannedPhrasesProcessor struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+func (p bannedPhrasesProcessor) Name() string { return "banned-phrases" }
+
+func (p bannedPhrasesProcessor) Process(question, answer string) (string, error) {
+	return p.re.ReplaceAllLiteralString(answer, p.replacement), nil
+}
+
+type templateProcessor struct {
+	tmpl *template.Template
+}
+
+func (p templateProcessor) Name() string { return "template-wrap" }
+
+func (p templateProcessor) Process(question, answer string) (string, error) {
+	var buf bytes.Buffer
+	data := struct {
+		Question string
+		Answer   string
+	}{question, answer}
+	if err := p.tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func buildOutputProcessors(configs []OutputProcessorConfig) ([]OutputProcessor, error) {
+	processors := make([]OutputProcessor, 0, len(configs))
+	for i, c := range configs {
+		switch c.Type {
+		case "append":
+			processors = append(processors, appendProcessor{text: c.Text})
+		case "replace-regex":
+			re, err := regexp.Compile(c.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("output_processors[%d]: %v", i, err)
+			}
+			processors = append(processors, regexProcessor{re: re, replacement: c.Replacement})
+		case "banned-phrases":
+			if len(c.Phrases) == 0 {
+				return nil, fmt.Errorf("output_processors[%d]: banned-phrases needs phrases", i)
+			}
+			quoted := make([]string, len(c.Phrases))
+			for j, phrase := range c.Phrases {
+				quoted[j] = regexp.QuoteMeta(phrase)
+			}
+			re := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+			processors = append(processors, bannedPhrasesProcessor{re: re, replacement: c.Replacement})
+		case "template-wrap":
+			tmpl, err := template.New(fmt.Sprintf("output_processors[%d]", i)).Parse(c.Template)
+			if err != nil {
+				return nil, fmt.Errorf("output_processors[%d]: %v", i, err)
+			}
+			processors = append(processors, templateProcessor{tmpl: tmpl})
+		default:
+			return nil, fmt.Errorf("output_processors[%d]: unknown type %q", i, c.Type)
+		}
+	}
+	return processors, nil
+}
+
+// processOutput runs the answer through every configured processor in
+// order. Any failure fails open: the unprocessed answer is returned.
+// Answers served from entries past the verification age get the configured
+// disclaimer first.
+func (ai *AIEngine) processOutput(question, answer string, entry *KnowledgeEntry, warn *Warnings) string {
+	processed := answer
+	if entry != nil && ai.Verification.Disclaimer != "" && ai.Verification.isStale(entry, time.Now()) {
+		processed += " " + ai.Verification.Disclaimer
+	}
+	for _, p := range ai.OutputProcessors {
+		out, err := p.Process(question, processed)
+		if err != nil {
+			log.Printf("Output processor %s failed: %v", p.Name(), err)
+			warn.Add(WarnOutputProcessor, p.Name()+": "+err.Error())
+			return answer
+		}
+		processed = out
+	}
+	return processed
+}
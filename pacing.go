@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+)
+
+// Defaults of the pacing flags.
+const (
+	defaultPacingBase    = 400 * time.Millisecond
+	defaultPacingPerChar = 15 * time.Millisecond
+	defaultPacingMax     = 2 * time.Second
+)
+
+// Pacing holds streamed answers back for a moment, shown to the user as
+// typing, so the widget doesn't answer before the question has settled.
+// It applies to the SSE and WebSocket paths only: plain JSON answers and
+// every other route are never delayed. Pacing is off unless Enabled, as
+// for load tests.
+type Pacing struct {
+	Enabled bool
+	// The delay is Base plus PerChar for each character of the answer,
+	// at most Max.
+	Base    time.Duration
+	PerChar time.Duration
+	Max     time.Duration
+	// observe, when set, records each delay served. The delays are kept
+	// out of the answer duration, which times the engine alone.
+	observe func(time.Duration)
+}
+
+// delay is how long answer is held back.
+func (p Pacing) delay(answer string) time.Duration {
+	if !p.Enabled {
+		return 0
+	}
+	d := p.Base + time.Duration(utf8.RuneCountInString(answer))*p.PerChar
+	if p.Max > 0 && d > p.Max {
+		d = p.Max
+	}
+	return d
+}
+
+// wait holds answer back for its delay, or until ctx is done as when the
+// client goes away, and reports whether it waited the delay out.
+func (p Pacing) wait(ctx context.Context, answer string) bool {
+	d := p.delay(answer)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	start := time.Now()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		if p.observe != nil {
+			p.observe(time.Since(start))
+		}
+		return false
+	case <-timer.C:
+	}
+	if p.observe != nil {
+		p.observe(d)
+	}
+	return true
+}
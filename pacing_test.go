@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPacingDelay(t *testing.T) {
+	on := Pacing{Enabled: true, Base: 100 * time.Millisecond, PerChar: 10 * time.Millisecond, Max: time.Second}
+	tests := []struct {
+		name   string
+		pacing Pacing
+		answer string
+		want   time.Duration
+	}{
+		{"disabled", Pacing{Base: time.Second}, "hi", 0},
+		{"short", on, "hi", 120 * time.Millisecond},
+		// Characters, not bytes, are paced.
+		{"multibyte", on, "héllo, 世界", 190 * time.Millisecond},
+		{"capped", on, strings.Repeat("x", 500), time.Second},
+	}
+	for _, tt := range tests {
+		if got := tt.pacing.delay(tt.answer); got != tt.want {
+			t.Errorf("%s: delay %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPacingWaitStopsWhenClientLeaves(t *testing.T) {
+	var observed []time.Duration
+	pacing := Pacing{Enabled: true, Base: time.Minute, observe: func(d time.Duration) { observed = append(observed, d) }}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if pacing.wait(ctx, "an answer") {
+		t.Fatal("wait ran to the end after the context was cancelled")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("wait took %v after the context was cancelled", elapsed)
+	}
+	if len(observed) != 1 || observed[0] >= time.Minute {
+		t.Errorf("observed %v, want the delay actually waited", observed)
+	}
+}
+
+func TestStreamAnswerIsPacedAfterTyping(t *testing.T) {
+	pacing := Pacing{Enabled: true, Base: 50 * time.Millisecond}
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	streamAnswer(rec, httptest.NewRequest(http.MethodPost, "/ai", nil), AIResponse{Answer: "two words"}, 0, pacing)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("stream took %v, want the 50ms pacing delay", elapsed)
+	}
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "event: typing\n") || !strings.Contains(body, "event: done\n") {
+		t.Errorf("stream:\n%s", body)
+	}
+
+	// A client gone during the delay gets nothing after the typing event.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec = httptest.NewRecorder()
+	streamAnswer(rec, httptest.NewRequest(http.MethodPost, "/ai", nil).WithContext(ctx), AIResponse{Answer: "two words"}, 0, pacing)
+	if body := rec.Body.String(); strings.Contains(body, "event: chunk") {
+		t.Errorf("stream to a departed client:\n%s", body)
+	}
+}
+
+func TestPacingSparesPlainJSON(t *testing.T) {
+	ai := newTestEngine(t)
+	pacing := Pacing{Enabled: true, Base: time.Minute, Max: time.Minute}
+	handler := handleAI(ai, NewEscalationStore(time.Hour, "", nil, nil), nil, nil, false, 0, pacing)
+	req := httptest.NewRequest(http.MethodPost, "/ai", strings.NewReader(`{"text": "What is a goroutine?"}`))
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler(rec, req)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("a plain JSON answer was paced")
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d: %s", rec.Code, rec.Body)
+	}
+}
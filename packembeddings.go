@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+)
+
+// runPackEmbeddings is the pack-embeddings subcommand. It converts an
+// embeddings file in any format readEmbeddings knows to the packed format,
+// which the server memory-maps instead of parsing, and with -bench reports
+// how much heap each way of holding the vectors takes.
+func runPackEmbeddings(args []string) {
+	fs := flag.NewFlagSet("pack-embeddings", flag.ExitOnError)
+	format := fs.String("format", formatAuto, "format of the input: auto, json, text, binary or packed")
+	limit := fs.Int("limit", 0, "pack only the first N words of an input other than JSON (0 = all)")
+	bench := fs.Bool("bench", false, "measure the heap the input takes as float64 maps, as an in-memory store and as a mapped store")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: askgo pack-embeddings [-format f] [-limit n] [-bench] input output")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := checkEmbeddingsFormat(*format); err != nil {
+		log.Fatal(err)
+	}
+	if *limit < 0 {
+		log.Fatal("-limit must not be negative")
+	}
+	in, out := fs.Arg(0), fs.Arg(1)
+
+	embeddings, err := readEmbeddings(in, *format, *limit)
+	if err != nil {
+		log.Fatal("Error reading embeddings: ", err)
+	}
+	if embeddings.Len() == 0 {
+		log.Fatalf("%s: no vectors", in)
+	}
+	err = writeFileAtomicFunc(out, func(w io.Writer) error {
+		return writePackedEmbeddings(w, embeddings)
+	})
+	if err == nil {
+		err = os.Chmod(out, 0644)
+	}
+	if err != nil {
+		log.Fatal("Error writing packed embeddings: ", err)
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Packed %d words of dimension %d into %s (%d MiB)\n", embeddings.Len(), embeddings.Dimension(), out, info.Size()>>20)
+	if *bench {
+		embeddings = nil
+		benchEmbeddings(out)
+	}
+}
+
+// benchEmbeddings prints the heap the packed file at path takes loaded
+// the three ways the server has held embeddings.
+func benchEmbeddings(path string) {
+	var words, dim int
+	asMaps := heapGrowth(func() interface{} {
+		embeddings, err := readEmbeddings(path, formatPacked, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		words, dim = embeddings.Len(), embeddings.Dimension()
+		maps := make(map[string][]float64, embeddings.Len())
+		for _, word := range embeddings.Words() {
+			vec := make([]float64, dim)
+			for i, x := range embeddings.Lookup(word) {
+				vec[i] = float64(x)
+			}
+			maps[word] = vec
+		}
+		return maps
+	})
+	inMemory := heapGrowth(func() interface{} {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		embeddings, err := readPackedEmbeddings(bufio.NewReader(f), 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return embeddings
+	})
+	mapped := heapGrowth(func() interface{} {
+		embeddings, err := readEmbeddings(path, formatPacked, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !embeddings.mapped {
+			fmt.Println("(this platform can't map files; the mapped store is in memory)")
+		}
+		return embeddings
+	})
+	fmt.Printf("Heap for %d words of dimension %d:\n", words, dim)
+	fmt.Printf("  map[string][]float64  %8.1f MiB\n", float64(asMaps)/(1<<20))
+	fmt.Printf("  in-memory store       %8.1f MiB\n", float64(inMemory)/(1<<20))
+	fmt.Printf("  memory-mapped store   %8.1f MiB\n", float64(mapped)/(1<<20))
+}
+
+// heapGrowth returns how much the live heap grows by what build returns.
+func heapGrowth(build func() interface{}) int64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	v := build()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(v)
+	return int64(after.HeapAlloc) - int64(before.HeapAlloc)
+}
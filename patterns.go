@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// patternReinforcement is the weight an interaction adds to each of its
+// keywords, per unit of context score.
+const patternReinforcement = 0.1
+
+// maxPatternReports bounds the sessions listed by /admin/patterns.
+const maxPatternReports = 20
+
+// PatternBudget bounds how far one conversation can move the global
+// Patterns. A session's reinforcement is kept apart while the session is
+// active and only shapes its own context scores; once the session has
+// been idle for TTL it is folded into Patterns, scaled by Decay. Cap
+// bounds the total weight a session may contribute, so no conversation
+// moves the global weights by more than Cap times Decay in total.
+type PatternBudget struct {
+	Cap   float64
+	Decay float64
+	TTL   time.Duration
+}
+
+var defaultPatternBudget = PatternBudget{Cap: 1, Decay: 0.5, TTL: 30 * time.Minute}
+
+// PatternStats counts sessions folded into Patterns.
+type PatternStats struct {
+	Folded int `json:"folded"`
+	// Capped sessions offered more than the cap.
+	Capped int `json:"capped"`
+	// Contributed is the total weight added to Patterns.
+	Contributed float64 `json:"contributed"`
+	// Evicted sessions were ended early to stay within MaxSessions.
+	Evicted int `json:"evicted"`
+}
+
+// reinforce records an interaction's reinforcement for its session,
+// keyed by keyword stem and trimmed to what is left of the session's cap. Sessionless interactions
+// are folded at once. The caller holds ai.mu.
+func (ai *AIEngine) reinforce(sessionID string, keywords []string, score float64, now time.Time) {
+	s := &session{weights: make(map[string]float64)}
+	if sessionID != "" {
+		s = ai.sessionFor(sessionID, now)
+	}
+	offered := patternReinforcement * score * float64(len(keywords))
+	s.offered += offered
+	s.turns++
+	s.lastSeen = now
+	kept := offered
+	if room := ai.PatternBudget.Cap - s.kept; kept > room {
+		kept = math.Max(0, room)
+	}
+	if kept > 0 {
+		for _, keyword := range keywords {
+			s.weights[stemWord(keyword)] += patternReinforcement * score * kept / offered
+		}
+		s.kept += kept
+	}
+	if sessionID == "" {
+		ai.fold(s)
+	}
+}
+
+// foldExpired ends the sessions idle for longer than the TTL, folding
+// their reinforcement. The caller holds ai.mu.
+func (ai *AIEngine) foldExpired(now time.Time) {
+	for id, s := range ai.sessions {
+		if now.Sub(s.lastSeen) > ai.PatternBudget.TTL {
+			ai.fold(s)
+			delete(ai.sessions, id)
+		}
+	}
+}
+
+func (ai *AIEngine) fold(s *session) {
+	for keyword, weight := range s.weights {
+		ai.Patterns[keyword] += weight * ai.PatternBudget.Decay
+	}
+	ai.patternStats.Folded++
+	ai.patternStats.Contributed += s.kept * ai.PatternBudget.Decay
+	if s.offered > s.kept {
+		ai.patternStats.Capped++
+	}
+}
+
+// SessionPatternReport describes one active session's reinforcement. The
+// session is identified by a hash, since session IDs come from clients.
+type SessionPatternReport struct {
+	Session  string    `json:"session"`
+	Turns    int       `json:"turns"`
+	History  int       `json:"history"`
+	Offered  float64   `json:"offered"`
+	Kept     float64   `json:"kept"`
+	Capped   bool      `json:"capped"`
+	Keywords []string  `json:"top_keywords"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// PatternReport is the response of /admin/patterns.
+type PatternReport struct {
+	Cap         float64                `json:"cap"`
+	Decay       float64                `json:"decay"`
+	TTLSeconds  float64                `json:"ttl_seconds"`
+	Patterns    int                    `json:"patterns"`
+	Active      int                    `json:"active_sessions"`
+	MaxSessions int                    `json:"max_sessions"`
+	Stats       PatternStats           `json:"stats"`
+	Sessions    []SessionPatternReport `json:"top_sessions"`
+}
+
+// patternReport lists the active sessions contributing most, after
+// folding the expired ones.
+func (ai *AIEngine) patternReport(now time.Time) PatternReport {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	ai.foldExpired(now)
+	report := PatternReport{
+		Cap:         ai.PatternBudget.Cap,
+		Decay:       ai.PatternBudget.Decay,
+		TTLSeconds:  ai.PatternBudget.TTL.Seconds(),
+		Patterns:    len(ai.Patterns),
+		Active:      len(ai.sessions),
+		MaxSessions: ai.maxSessions(),
+		Stats:       ai.patternStats,
+		Sessions:    []SessionPatternReport{},
+	}
+	for id, s := range ai.sessions {
+		sum := sha1.Sum([]byte(id))
+		report.Sessions = append(report.Sessions, SessionPatternReport{
+			Session:  hex.EncodeToString(sum[:])[:10],
+			Turns:    s.turns,
+			History:  len(s.history),
+			Offered:  s.offered,
+			Kept:     s.kept,
+			Capped:   s.offered > s.kept,
+			Keywords: topKeywords(s.weights, 5),
+			LastSeen: s.lastSeen,
+		})
+	}
+	sort.Slice(report.Sessions, func(i, j int) bool { return report.Sessions[i].Offered > report.Sessions[j].Offered })
+	if len(report.Sessions) > maxPatternReports {
+		report.Sessions = report.Sessions[:maxPatternReports]
+	}
+	return report
+}
+
+func topKeywords(weights map[string]float64, n int) []string {
+	keywords := make([]string, 0, len(weights))
+	for keyword := range weights {
+		keywords = append(keywords, keyword)
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if weights[keywords[i]] != weights[keywords[j]] {
+			return weights[keywords[i]] > weights[keywords[j]]
+		}
+		return keywords[i] < keywords[j]
+	})
+	return keywords[:min(n, len(keywords))]
+}
+
+// handlePatterns serves GET /admin/patterns.
+func handlePatterns(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ai.patternReport(time.Now()))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestChattySessionIsCapped runs one 500-turn session beside a short one
+// and checks that, once folded, the chatty session moved the global
+// weights by no more than the cap times the decay.
+func TestChattySessionIsCapped(t *testing.T) {
+	ai := newTestEngine(t)
+	ai.PatternBudget = PatternBudget{Cap: 1, Decay: 0.5, TTL: time.Hour}
+	before := make(map[string]float64)
+	for keyword, weight := range ai.Patterns {
+		before[keyword] = weight
+	}
+	for turn := 0; turn < 500; turn++ {
+		keywords := []string{"goroutine", "channel", fmt.Sprint("topic", turn%7)}
+		ai.learnFromInteraction("q", "a", "", keywords, 1, AskOptions{SessionID: "chatty"})
+	}
+	ai.learnFromInteraction("q", "a", "", []string{"map"}, 1, AskOptions{SessionID: "quiet"})
+
+	report := ai.patternReport(time.Now())
+	if len(report.Sessions) != 2 {
+		t.Fatalf("%d sessions reported", len(report.Sessions))
+	}
+	top := report.Sessions[0]
+	if top.Turns != 500 || !top.Capped || top.Kept > ai.PatternBudget.Cap+1e-9 || top.Offered <= top.Kept {
+		t.Errorf("top session %+v", top)
+	}
+	if top.Session == "chatty" || len(top.Session) != 10 {
+		t.Errorf("session ID %q isn't hashed", top.Session)
+	}
+	if report.Sessions[1].Capped {
+		t.Errorf("quiet session %+v is capped", report.Sessions[1])
+	}
+
+	// Fold the chatty session alone, by ending it.
+	ai.endSession("chatty")
+	moved := 0.0
+	for keyword, weight := range ai.Patterns {
+		moved += math.Abs(weight - before[keyword])
+	}
+	if limit := ai.PatternBudget.Cap * ai.PatternBudget.Decay; moved > limit+1e-9 {
+		t.Errorf("500 turns moved the global weights by %.3f, cap %.3f", moved, limit)
+	}
+	if moved == 0 {
+		t.Error("the folded session moved nothing")
+	}
+	if stats := ai.patternReport(time.Now()).Stats; stats.Folded != 1 || stats.Capped != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+// TestPatternsAdminView lists the most active sessions first, folds idle
+// ones, and bounds the list.
+func TestPatternsAdminView(t *testing.T) {
+	ai := newTestEngine(t)
+	ai.PatternBudget = PatternBudget{Cap: 1, Decay: 0.5, TTL: time.Hour}
+	for i := 0; i < maxPatternReports+5; i++ {
+		for turn := 0; turn <= i; turn++ {
+			ai.learnFromInteraction("q", "a", "", []string{"channel"}, 0.1, AskOptions{SessionID: fmt.Sprint("session-", i)})
+		}
+	}
+	rec := httptest.NewRecorder()
+	handlePatterns(ai)(rec, httptest.NewRequest("GET", "/admin/patterns", nil))
+	var report PatternReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Active != maxPatternReports+5 || len(report.Sessions) != maxPatternReports {
+		t.Fatalf("%d active, %d listed", report.Active, len(report.Sessions))
+	}
+	for i, s := range report.Sessions {
+		if want := maxPatternReports + 5 - i; s.Turns != want {
+			t.Errorf("session %d has %d turns, want %d", i, s.Turns, want)
+		}
+		if len(s.Keywords) != 1 || s.Keywords[0] != stemWord("channel") {
+			t.Errorf("session %d keywords %q", i, s.Keywords)
+		}
+	}
+	// Past the TTL every session is folded.
+	if later := ai.patternReport(time.Now().Add(2 * time.Hour)); later.Active != 0 || later.Stats.Folded != maxPatternReports+5 {
+		t.Errorf("after the TTL: %d active, stats %+v", later.Active, later.Stats)
+	}
+}
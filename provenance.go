@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+)
+
+// version and commit identify the build. They are set at link time, e.g.
+// go build -ldflags "-X main.version=v1.2.0 -X main.commit=abc123"; the
+// Makefile does this from git.
+var (
+	version = "unknown"
+	commit  = "unknown"
+)
+
+// PromptInfo identifies the prompt file the engine was loaded from. Hash
+// covers the file and its includes, in the order they were merged.
+type PromptInfo struct {
+	Path            string `json:"path"`
+	Hash            string `json:"hash"`
+	Includes        int    `json:"includes"`
+	Entries         int    `json:"entries"`
+	Greetings       int    `json:"greetings"`
+	CommonQuestions int    `json:"common_questions"`
+	Scopes          int    `json:"scopes"`
+	Languages       int    `json:"languages,omitempty"`
+}
+
+// EmbeddingProvenance is EmbeddingInfo with the vocabulary size. Toy is
+// set for the toy embeddings askgo init writes, Mapped for packed
+// embeddings used in place.
+type EmbeddingProvenance struct {
+	EmbeddingInfo
+	Vocabulary int  `json:"vocabulary"`
+	Toy        bool `json:"toy,omitempty"`
+	Mapped     bool `json:"mapped,omitempty"`
+}
+
+// KBCounts counts what the knowledge base holds now, including entries
+// added since the prompt file was loaded.
+type KBCounts struct {
+	Entries int `json:"entries"`
+	Learned int `json:"learned"`
+}
+
+// Provenance describes what a server is running: the build, the data it
+// loaded and the features turned on. It holds no secrets, so it can be
+// served publicly; switches are reported globally, since tenants are
+// identified by API key.
+type Provenance struct {
+	Version       string              `json:"version"`
+	Commit        string              `json:"commit"`
+	GoVersion     string              `json:"go_version"`
+	Analyzer      int                 `json:"analyzer"`
+	Prompts       PromptInfo          `json:"prompts"`
+	Embeddings    EmbeddingProvenance `json:"embeddings"`
+	KnowledgeBase KBCounts            `json:"knowledge_base"`
+	Switches      map[string]bool     `json:"switches"`
+	Features      map[string]bool     `json:"features"`
+}
+
+// provenance describes the engine as it is now.
+func (ai *AIEngine) provenance() Provenance {
+	state := ai.KB.view()
+	embeddings := ai.embeddings()
+	p := Provenance{
+		Version:   version,
+		Commit:    commit,
+		GoVersion: runtime.Version(),
+		Analyzer:  analyzerVersion,
+		Prompts:   ai.promptInfo,
+		Embeddings: EmbeddingProvenance{
+			EmbeddingInfo: ai.embeddingInfo(),
+			Vocabulary:    embeddings.Len(),
+			Toy:           isToyEmbeddings(embeddings),
+			Mapped:        embeddings != nil && embeddings.mapped,
+		},
+		KnowledgeBase: KBCounts{Entries: len(state.entries), Learned: len(state.learned)},
+		Switches:      make(map[string]bool, len(knownSwitches)),
+		Features:      make(map[string]bool, len(ai.Features)),
+	}
+	for name := range knownSwitches {
+		p.Switches[name] = ai.Switches.Enabled(name, "")
+	}
+	for name, on := range ai.Features {
+		p.Features[name] = on
+	}
+	return p
+}
+
+// handleVersion serves GET /version.
+func handleVersion(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ai.provenance())
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestVersionWithoutLdflags serves /version from a test binary, built
+// without -ldflags, and expects every field with the build ones
+// "unknown".
+func TestVersionWithoutLdflags(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	resp, body := f.do(t, http.MethodGet, "/version", "", "", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"version", "commit", "go_version", "analyzer", "prompts", "embeddings", "knowledge_base", "switches", "features"} {
+		if _, ok := fields[field]; !ok {
+			t.Errorf("/version lacks %s: %s", field, body)
+		}
+	}
+	var got Provenance
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != "unknown" || got.Commit != "unknown" {
+		t.Errorf("version %q, commit %q, want unknown", got.Version, got.Commit)
+	}
+	if got.GoVersion != runtime.Version() || got.Analyzer != analyzerVersion {
+		t.Errorf("go %q, analyzer %d", got.GoVersion, got.Analyzer)
+	}
+	if got.KnowledgeBase.Entries != len(f.deps.ai.KB.view().entries) || got.Embeddings.Vocabulary != f.deps.ai.Embeddings.Len() {
+		t.Errorf("counts %+v, vocabulary %d", got.KnowledgeBase, got.Embeddings.Vocabulary)
+	}
+	for name := range knownSwitches {
+		if _, ok := got.Switches[name]; !ok {
+			t.Errorf("switch %s not reported", name)
+		}
+	}
+	if strings.Contains(body, testAdminKey) {
+		t.Errorf("/version leaks the admin key: %s", body)
+	}
+}
+
+// TestEnvelopesCarryProvenance stamps snapshots and exports with the
+// provenance /version serves.
+func TestEnvelopesCarryProvenance(t *testing.T) {
+	ai := newTestEngine(t)
+	want := ai.provenance()
+	for name, got := range map[string]*Provenance{"snapshot": ai.snapshot().Provenance, "export": ai.ExportKB().Provenance} {
+		if got == nil || !reflect.DeepEqual(*got, want) {
+			t.Errorf("%s provenance %+v, want %+v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// publicTag marks knowledge base entries that get a public page.
+const publicTag = "public"
+
+func isPublic(entry KnowledgeEntry) bool {
+	for _, tag := range entry.Tags {
+		if tag == publicTag {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePublicURL checks the public base URL of the server, such as
+// https://askgo.example.com, and returns it without a trailing slash.
+// Sitemaps and canonical links are built from it rather than the Host
+// header, which clients choose.
+func parsePublicURL(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("%q is not an http or https URL without credentials, query or fragment", s)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// qaURL is the public URL of the page of entry id.
+func qaURL(publicURL, id string) string {
+	return publicURL + "/qa/" + url.PathEscape(id)
+}
+
+type qaPage struct {
+	Question   string
+	Paragraphs []string
+	SourceURL  string
+	Related    []KnowledgeEntry
+	// Canonical is the page's public URL, when one is configured.
+	Canonical string
+}
+
+// handleQA renders /qa/{id}, a static page for one public entry, with a
+// canonical link under publicURL if set.
+func handleQA(ai *AIEngine, tmpl *template.Template, publicURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/qa/")
+		state := ai.KB.view()
+		entry, ok := state.entryByID(id)
+		if !ok || !isPublic(entry) || entry.Quarantined {
+			http.NotFound(w, r)
+			return
+		}
+		answer := ai.processOutput(entry.Question, entry.Answer, &entry, nil)
+		page := qaPage{
+			Question:  entry.Question,
+			SourceURL: entry.SourceURL,
+			Related:   state.related(entry.Vector, entry.ID, isPublic),
+		}
+		if publicURL != "" {
+			page.Canonical = qaURL(publicURL, entry.ID)
+		}
+		for _, p := range strings.Split(answer, "\n\n") {
+			if p = strings.TrimSpace(p); p != "" {
+				page.Paragraphs = append(page.Paragraphs, p)
+			}
+		}
+		renderTemplate(w, tmpl, "qa.html", page)
+	}
+}
+
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+type sitemap struct {
+	XMLName xml.Name     `xml:"urlset"`
+	NS      string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// handleSitemap lists the /qa pages of every public entry under
+// publicURL; without one there is no sitemap, since its URLs must be
+// absolute. It is built from the current knowledge base on each request,
+// so it follows reindexes, quarantines and swaps without a separate cache
+// to invalidate.
+func handleSitemap(ai *AIEngine, publicURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if publicURL == "" {
+			writeError(w, newError(ErrNotFound, "no sitemap: the server has no -public-url"))
+			return
+		}
+		m := sitemap{NS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+		for _, entry := range ai.KB.view().entries {
+			if isPublic(entry) && !entry.Quarantined {
+				m.URLs = append(m.URLs, sitemapURL{Loc: qaURL(publicURL, entry.ID)})
+			}
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(m)
+	}
+}
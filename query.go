@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/jdkato/prose/v2"
+)
+
+// Query is a question analyzed once per request. Every stage of
+// GenerateAnswer reads from it so they all agree on normalization.
+type Query struct {
+	Raw        string
+	Normalized string
+	// Key is the normalized form used to look up learned entries.
+	Key      string
+	Tokens   []string
+	Keywords []string
+	Concepts []string
+	// AnalysisErr is set when prose failed to parse the question.
+	AnalysisErr error
+	// Truncated reports that the analysis limits cut tokens or keywords.
+	Truncated bool
+
+	embeddings *EmbeddingStore
+	kb         *kbState
+	cache      *queryCache
+	cacheKey   string
+	vector     []float32
+	vectorized bool
+	coverage   VocabularyCoverage
+	counted    bool
+}
+
+// newQuery analyzes raw, reusing what cache holds of the same text. Its
+// keywords and vector leave out the stopwords of kb, the knowledge base
+// state it will be compared against, and its vector is weighted by kb's
+// IDF table.
+func newQuery(raw string, embeddings *EmbeddingStore, limits AnalysisLimits, kb *kbState, cache *queryCache) *Query {
+	text, truncated := limits.truncateForAnalysis(raw)
+	q := &Query{
+		Raw:        raw,
+		Normalized: strings.ToLower(raw),
+		Key:        normalizeQuestion(raw),
+		Tokens:     tokenize(text, embeddings),
+		Truncated:  truncated,
+		embeddings: embeddings,
+		kb:         kb,
+		cache:      cache,
+		cacheKey:   queryCacheKey(text),
+	}
+	if len(q.Tokens) > limits.MaxTokens {
+		q.Tokens = q.Tokens[:limits.MaxTokens]
+		q.Truncated = true
+	}
+	keywords, concepts, err := cache.analysis(q.cacheKey, text)
+	q.Keywords, truncated = limits.capKeywords(kb.stopWords.filter(keywords))
+	q.Concepts, q.AnalysisErr = concepts, err
+	q.Truncated = q.Truncated || truncated
+	return q
+}
+
+// Coverage is the share of tokens that have embeddings, directly or by
+// stem; a question with no tokens is fully covered.
+func (q *Query) Coverage() float64 {
+	return q.Vocabulary().Share()
+}
+
+// Vocabulary counts the tokens missing from the embeddings, computing it
+// on first use.
+func (q *Query) Vocabulary() VocabularyCoverage {
+	if !q.counted {
+		q.coverage = VocabularyCoverage{Tokens: len(q.Tokens)}
+		for _, token := range q.Tokens {
+			switch _, found := q.kb.vocab.lookup(token, q.embeddings); found {
+			case vocabSubword:
+				q.coverage.Synthesized++
+				q.coverage.OOV++
+			case vocabMissing:
+				q.coverage.OOV++
+			}
+		}
+		q.counted = true
+	}
+	return q.coverage
+}
+
+// Vector returns the sentence vector, computing it on first use unless
+// the query cache has it.
+func (q *Query) Vector() []float32 {
+	if !q.vectorized {
+		var ok bool
+		if q.vector, ok = q.cache.vector(q.cacheKey, q.kb.vectorEpoch); !ok {
+			q.vector = q.kb.sentenceVector(q.Tokens, q.embeddings)
+			q.cache.setVector(q.cacheKey, q.kb.vectorEpoch, q.vector)
+		}
+		q.vectorized = true
+	}
+	return q.vector
+}
+
+// taggerModel is the part-of-speech model analyzeText tags with, loaded
+// once: prose loads it anew for every document it isn't given one, which
+// costs more than the rest of answering a question. The tagger only reads
+// it, so every question shares it.
+var taggerModel struct {
+	once  sync.Once
+	model *prose.Model
+}
+
+// analyzeText extracts nouns as keywords and verbs as concepts. Only the
+// tags are read, so sentences and named entities are left out.
+func analyzeText(input string) ([]string, []string, error) {
+	taggerModel.once.Do(func() {
+		doc, _ := prose.NewDocument("", prose.WithExtraction(false), prose.WithSegmentation(false))
+		taggerModel.model = doc.Model
+	})
+	doc, err := prose.NewDocument(input, prose.UsingModel(taggerModel.model), prose.WithExtraction(false), prose.WithSegmentation(false))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var keywords, concepts []string
+	for _, tok := range doc.Tokens() {
+		switch {
+		case strings.HasPrefix(tok.Tag, "NN"):
+			keywords = append(keywords, tok.Text)
+		case strings.HasPrefix(tok.Tag, "VB"):
+			concepts = append(concepts, tok.Text)
+		}
+	}
+	return keywords, concepts, nil
+}
+
+// normalizeQuestion folds case, collapses whitespace and drops trailing
+// punctuation so differently typed forms of a question share one key.
+func normalizeQuestion(question string) string {
+	key := strings.Join(strings.Fields(strings.ToLower(question)), " ")
+	return strings.TrimRightFunc(key, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSpace(r)
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenAnswer is what one question of the golden corpus was analyzed
+// into and answered with.
+type goldenAnswer struct {
+	Question string   `json:"question"`
+	Key      string   `json:"key"`
+	Tokens   []string `json:"tokens"`
+	Keywords []string `json:"keywords"`
+	Concepts []string `json:"concepts"`
+	Source   string   `json:"source"`
+	EntryID  string   `json:"entry_id,omitempty"`
+	Text     string   `json:"text"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// goldenQuestions is a corpus of representative questions: each answer
+// source, case, whitespace and punctuation variants, and text no
+// retriever knows.
+var goldenQuestions = []string{
+	"hello",
+	"Hello!",
+	"who are you",
+	"What is a goroutine?",
+	"  what   IS a goroutine ",
+	"What are goroutines?",
+	"How do channels work?",
+	"how do channels work",
+	"How are errors handled in Go?",
+	"errors",
+	"How do I close a channel?",
+	"HOW DO I CLOSE A CHANNEL",
+	"Go语言的通道是什么？",
+	"zebra quantum marmalade",
+	"",
+}
+
+// TestGoldenAnswers analyzes and answers the golden corpus and compares
+// the result with testdata/golden/answers.json, so a change to how
+// questions are normalized or answered shows up as a diff. Run it with
+// -update to accept one.
+func TestGoldenAnswers(t *testing.T) {
+	ai := newTestEngine(t)
+	ai.KB.Learn("How do I close a channel?", "The sender calls close.")
+	var got []goldenAnswer
+	for _, question := range goldenQuestions {
+		q := newQuery(question, ai.Embeddings, ai.Limits, ai.KB.view(), nil)
+		answer, err := ai.Ask(question, AskOptions{})
+		var failure string
+		if err != nil {
+			failure = err.Error()
+		}
+		got = append(got, goldenAnswer{
+			Question: question,
+			Key:      q.Key,
+			Tokens:   q.Tokens,
+			Keywords: q.Keywords,
+			Concepts: q.Concepts,
+			Source:   answer.Source,
+			EntryID:  answer.EntryID,
+			Text:     answer.Text,
+			Error:    failure,
+		})
+	}
+	path := filepath.Join("testdata", "golden", "answers.json")
+	if *updateGolden {
+		data, err := json.MarshalIndent(got, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []goldenAnswer
+	if err := json.Unmarshal(data, &want); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%d answers, golden file has %d; run with -update if the corpus changed", len(got), len(want))
+	}
+	for i := range got {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("%q:\n got %+v\nwant %+v", got[i].Question, got[i], want[i])
+		}
+	}
+}
+
+// TestAskAnalyzesOnce answers a question with a single analysis and a
+// single vector, shared by every stage.
+func TestAskAnalyzesOnce(t *testing.T) {
+	ai := newTestEngine(t)
+	ai.QueryCache = newQueryCache(16)
+	ask(t, ai, "How do channels work?", AskOptions{})
+	c := ai.QueryCache
+	if c.misses != 1 || c.hits != 0 {
+		t.Errorf("analyzed %d times and reused %d, want once and none", c.misses, c.hits)
+	}
+	if c.vectorMisses != 1 || c.vectorHits != 0 {
+		t.Errorf("vectorized %d times and reused %d, want once and none", c.vectorMisses, c.vectorHits)
+	}
+}
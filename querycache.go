@@ -0,0 +1,160 @@
+package main
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultQueryCacheSize is the number of questions whose analysis is kept
+// by default.
+const defaultQueryCacheSize = 1000
+
+// queryCache remembers, for the most recently asked questions, what
+// newQuery computes from their text alone: the keywords and concepts
+// prose extracts, which takes far longer than the rest of an answer, and
+// the sentence vector. The analysis never goes stale. The vector depends
+// on the knowledge base state it was weighted by, so it is only reused
+// while the state's vectorEpoch is the one it was computed under. A nil
+// cache keeps nothing.
+type queryCache struct {
+	// The counts come first, where atomic keeps them 64-bit aligned.
+	hits, misses             uint64
+	vectorHits, vectorMisses uint64
+
+	size int
+
+	mu sync.Mutex
+	// order lists the cached questions, most recently used first; items
+	// finds their elements by key.
+	order *list.List
+	items map[string]*list.Element
+}
+
+// cachedQuery is what queryCache keeps of one question. Its fields are
+// only used under the cache's mu.
+type cachedQuery struct {
+	key                string
+	keywords, concepts []string
+	err                error
+	// vector was computed under epoch; nil until the question is
+	// vectorized.
+	vector []float32
+	epoch  uint64
+}
+
+// newQueryCache returns a cache of up to size questions, or nil if size
+// is 0.
+func newQueryCache(size int) *queryCache {
+	if size <= 0 {
+		return nil
+	}
+	return &queryCache{size: size, order: list.New(), items: make(map[string]*list.Element, size)}
+}
+
+// queryCacheKey is the key text is cached under. Runs of whitespace are
+// folded, which neither prose nor tokenize tell apart; case is kept, since
+// prose tags "Go" and "go" differently.
+func queryCacheKey(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// analysis returns the keywords, concepts and error analyzeText gave for
+// key, computing and caching them on a miss.
+func (c *queryCache) analysis(key, text string) (keywords, concepts []string, err error) {
+	if c == nil {
+		return analyzeText(text)
+	}
+	c.mu.Lock()
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		cached := e.Value.(*cachedQuery)
+		keywords, concepts, err = cached.keywords, cached.concepts, cached.err
+		c.mu.Unlock()
+		atomic.AddUint64(&c.hits, 1)
+		// Callers filter and cap the keywords; they get their own copy.
+		return append([]string(nil), keywords...), append([]string(nil), concepts...), err
+	}
+	c.mu.Unlock()
+	atomic.AddUint64(&c.misses, 1)
+
+	keywords, concepts, err = analyzeText(text)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[key]; !ok {
+		c.items[key] = c.order.PushFront(&cachedQuery{
+			key:      key,
+			keywords: append([]string(nil), keywords...),
+			concepts: append([]string(nil), concepts...),
+			err:      err,
+		})
+		if c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.items, oldest.Value.(*cachedQuery).key)
+		}
+	}
+	return keywords, concepts, err
+}
+
+// vector returns the sentence vector cached for key under epoch.
+func (c *queryCache) vector(key string, epoch uint64) ([]float32, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	var vec []float32
+	if e, ok := c.items[key]; ok {
+		if cached := e.Value.(*cachedQuery); cached.vector != nil && cached.epoch == epoch {
+			vec = cached.vector
+		}
+	}
+	c.mu.Unlock()
+	if vec == nil {
+		atomic.AddUint64(&c.vectorMisses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&c.vectorHits, 1)
+	return vec, true
+}
+
+// setVector caches vec, computed under epoch, as the vector of key if key
+// is still cached. Callers must not modify vec afterwards.
+func (c *queryCache) setVector(key string, epoch uint64, vec []float32) {
+	if c == nil || vec == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		cached := e.Value.(*cachedQuery)
+		cached.vector, cached.epoch = vec, epoch
+	}
+}
+
+// Len returns the number of questions cached.
+func (c *queryCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// registerMetrics exposes the hit and miss counts of c on m. A nil cache
+// has none to expose.
+func (c *queryCache) registerMetrics(m *Metrics) {
+	if c == nil {
+		return
+	}
+	count := func(n *uint64) func() float64 {
+		return func() float64 { return float64(atomic.LoadUint64(n)) }
+	}
+	m.Counter("askgo_query_cache_hits_total", "Questions whose analysis was found in the query cache.", count(&c.hits))
+	m.Counter("askgo_query_cache_misses_total", "Questions analyzed afresh.", count(&c.misses))
+	m.Counter("askgo_query_cache_vector_hits_total", "Question vectors found in the query cache.", count(&c.vectorHits))
+	m.Counter("askgo_query_cache_vector_misses_total", "Question vectors computed afresh.", count(&c.vectorMisses))
+	m.Gauge("askgo_query_cache_entries", "Questions in the query cache.", func() float64 { return float64(c.Len()) })
+}
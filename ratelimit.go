@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRateBuckets triggers eviction of idle, fully refilled buckets. If
+// none are, the least recently used bucket goes, so memory stays bounded
+// however many clients there are.
+const maxRateBuckets = 10000
+
+// CostModel prices a request in rate-limit tokens: a base cost, a cost per
+// KiB of request body, an extra cost per endpoint class and an extra cost
+// per costly stage the handler ran, such as verbose answers. SoftLimits
+// sets, per endpoint class, the fraction of the budget a client may use
+// before responses start carrying a warning; the hard limit is the budget
+// itself.
+type CostModel struct {
+	Base       float64            `json:"base"`
+	PerKB      float64            `json:"per_kb"`
+	Classes    map[string]float64 `json:"classes"`
+	Stages     map[string]float64 `json:"stages"`
+	SoftLimits map[string]float64 `json:"soft_limits"`
+}
+
+// Stages handlers charge with chargeStage.
+const (
+	// StageVerbose is an /ai answer with its per-stage breakdown.
+	StageVerbose = "verbose"
+	// StageExplain is a report of how a question would be decided, from
+	// /search or /ai/candidates.
+	StageExplain = "explain"
+	// StageLLM is an /ai answer the LLM fallback was called for; answers
+	// it served from its cache aren't charged.
+	StageLLM = "llm"
+)
+
+// defaultSoftLimit applies to endpoint classes without a soft limit.
+const defaultSoftLimit = 0.8
+
+var defaultCostModel = CostModel{
+	Base:       1,
+	PerKB:      4,
+	Classes:    map[string]float64{"ai": 0, "learn": 4, "admin": 2},
+	Stages:     map[string]float64{StageVerbose: 2, StageExplain: 2, StageLLM: 8},
+	SoftLimits: map[string]float64{},
+}
+
+type rateWarningKey struct{}
+
+// rateLimitWarning returns the soft-limit warning attached to the request,
+// if any, so handlers can repeat it in their JSON body.
+func rateLimitWarning(r *http.Request) string {
+	warning, _ := r.Context().Value(rateWarningKey{}).(string)
+	return warning
+}
+
+func loadCostModel(path string) (CostModel, error) {
+	model := defaultCostModel
+	if path == "" {
+		return model, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return model, err
+	}
+	if err := json.Unmarshal(data, &model); err != nil {
+		return model, fmt.Errorf("%s: %v", path, err)
+	}
+	return model, nil
+}
+
+func (m CostModel) softLimit(class string) float64 {
+	if limit, ok := m.SoftLimits[class]; ok {
+		return limit
+	}
+	return defaultSoftLimit
+}
+
+// chargesKey holds the *rateCharges of a rate limited request.
+type chargesKey struct{}
+
+// rateCharges collects the stages a handler ran, to be charged once it
+// returns.
+type rateCharges struct {
+	stages []string
+}
+
+// chargeStage charges the caller for a costly stage of the request, on
+// top of what it was charged on arrival. Requests that aren't rate
+// limited are not charged.
+func chargeStage(r *http.Request, stage string) {
+	if charges, ok := r.Context().Value(chargesKey{}).(*rateCharges); ok {
+		charges.stages = append(charges.stages, stage)
+	}
+}
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (m CostModel) cost(class string, size int64) float64 {
+	if size < 0 {
+		size = 0
+	}
+	return m.Base + m.PerKB*float64(size)/1024 + m.Classes[class]
+}
+
+// extraCost is what a request is charged once it has been handled: the
+// body read beyond the prepaid bytes, as with chunked bodies, whose size
+// isn't known on arrival, and the stages it ran.
+func (m CostModel) extraCost(prepaid, read int64, stages []string) float64 {
+	var cost float64
+	if read > prepaid {
+		cost += m.PerKB * float64(read-prepaid) / 1024
+	}
+	for _, stage := range stages {
+		cost += m.Stages[stage]
+	}
+	return cost
+}
+
+// costClass buckets requests for the consumption metrics.
+func costClass(class string, size int64) string {
+	switch {
+	case size < 256:
+		return class + "/small"
+	case size < 4096:
+		return class + "/medium"
+	}
+	return class + "/large"
+}
+
+// rateLimit is the size of a bucket and how fast it refills.
+type rateLimit struct {
+	capacity float64
+	refill   float64 // tokens per second
+}
+
+type bucket struct {
+	rateLimit
+	tokens  float64
+	updated time.Time
+}
+
+// level is the balance of b at now.
+func (b *bucket) level(now time.Time) float64 {
+	return math.Min(b.capacity, b.tokens+now.Sub(b.updated).Seconds()*b.refill)
+}
+
+// RateLimiter is a token bucket per client IP and per tenant API key.
+// Requests consume tokens according to the cost model rather than counting
+// one each. A class given its own limit with SetClassLimit is also charged
+// to a separate bucket per client, so it can be held to a stricter budget.
+type RateLimiter struct {
+	rateLimit
+	model   CostModel
+	classes map[string]rateLimit
+
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	consumed map[string]float64
+	rejected map[string]int
+	warned   map[string]int
+}
+
+func NewRateLimiter(capacity, refill float64, model CostModel) *RateLimiter {
+	return &RateLimiter{
+		rateLimit: rateLimit{capacity, refill},
+		model:     model,
+		classes:   make(map[string]rateLimit),
+		buckets:   make(map[string]*bucket),
+		consumed:  make(map[string]float64),
+		rejected:  make(map[string]int),
+		warned:    make(map[string]int),
+	}
+}
+
+// SetClassLimit gives requests of class a budget of their own on top of
+// the shared one, e.g. a stricter one for endpoints that change state.
+func (l *RateLimiter) SetClassLimit(class string, capacity, refill float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.classes[class] = rateLimit{capacity, refill}
+}
+
+func (l *RateLimiter) bucketFor(key string, limit rateLimit, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateBuckets {
+			l.evict(now)
+		}
+		b = &bucket{rateLimit: limit, tokens: limit.capacity, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = b.level(now)
+	b.updated = now
+	return b
+}
+
+func (l *RateLimiter) evict(now time.Time) {
+	var oldest string
+	for key, b := range l.buckets {
+		if b.level(now) >= b.capacity {
+			delete(l.buckets, key)
+		} else if oldest == "" || b.updated.Before(l.buckets[oldest].updated) {
+			oldest = key
+		}
+	}
+	if len(l.buckets) >= maxRateBuckets {
+		delete(l.buckets, oldest)
+	}
+}
+
+// take charges cost to every key's bucket if all of them can afford it.
+// Keys of a class with its own limit get an extra bucket under that limit.
+// It returns the lowest remaining balance, the capacity of its bucket and
+// how long until that bucket is full again, or until the request could
+// succeed when it is rejected.
+func (l *RateLimiter) take(class string, keys []string, cost float64, now time.Time) (float64, float64, time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var buckets []*bucket
+	for _, key := range keys {
+		buckets = append(buckets, l.bucketFor(key, l.rateLimit, now))
+		if limit, ok := l.classes[class]; ok {
+			buckets = append(buckets, l.bucketFor("class:"+class+"|"+key, limit, now))
+		}
+	}
+	for _, b := range buckets {
+		if b.tokens < cost {
+			wait := (cost - b.tokens) / b.refill
+			return b.tokens, b.capacity, time.Duration(wait * float64(time.Second)), false
+		}
+	}
+	lowest := buckets[0]
+	for _, b := range buckets {
+		b.tokens -= cost
+		if b.tokens/b.capacity < lowest.tokens/lowest.capacity {
+			lowest = b
+		}
+	}
+	reset := (lowest.capacity - lowest.tokens) / lowest.refill
+	return lowest.tokens, lowest.capacity, time.Duration(reset * float64(time.Second)), true
+}
+
+// charge takes cost from the buckets take would, after the fact: it is
+// never refused, but a bucket may go into debt, down to minus its
+// capacity, which later requests wait out.
+func (l *RateLimiter) charge(class string, keys []string, cost float64, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		buckets := []*bucket{l.bucketFor(key, l.rateLimit, now)}
+		if limit, ok := l.classes[class]; ok {
+			buckets = append(buckets, l.bucketFor("class:"+class+"|"+key, limit, now))
+		}
+		for _, b := range buckets {
+			b.tokens = math.Max(b.tokens-cost, -b.capacity)
+		}
+	}
+}
+
+// trustedProxies are the proxies whose X-Forwarded-For header is
+// believed. It is empty unless -trusted-proxies is set, since any client
+// can send the header.
+var trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses a comma-separated list of IPs and CIDRs.
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func isTrustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	for _, n := range trustedProxies {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address of the client. Behind trusted proxies it is the
+// last X-Forwarded-For hop added by a proxy that is not trusted, so a
+// client can't pick its own address by sending the header.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		host = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// Limit wraps a handler so each request is charged to the caller's budgets.
+// A request is charged on arrival for its declared body size, and once
+// handled for the bytes it turned out to have and the stages it ran; see
+// CostModel.extraCost.
+func (l *RateLimiter) Limit(class string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		prepaid := r.ContentLength
+		if prepaid < 0 {
+			prepaid = 0
+		}
+		cost := l.model.cost(class, prepaid)
+		keys := []string{"ip:" + clientIP(r)}
+		// Only a tenant's key gets a bucket of its own: made-up keys
+		// would mint buckets at will and push out those of real clients.
+		if key := r.Header.Get("X-API-Key"); key != "" && requestTenant(r) != "" {
+			keys = append(keys, "key:"+key)
+		}
+		metricClass := costClass(class, prepaid)
+
+		remaining, capacity, reset, ok := l.take(class, keys, cost, time.Now())
+		resetSeconds := int(math.Ceil(reset.Seconds()))
+		w.Header().Set("X-RateLimit-Limit", strconv.FormatFloat(capacity, 'f', -1, 64))
+		w.Header().Set("X-RateLimit-Remaining", strconv.FormatFloat(math.Max(0, math.Floor(remaining)), 'f', -1, 64))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(resetSeconds))
+		soft := ok && remaining < capacity*(1-l.model.softLimit(class))
+		l.mu.Lock()
+		switch {
+		case !ok:
+			l.rejected[metricClass]++
+		case soft:
+			l.warned[metricClass]++
+		}
+		l.mu.Unlock()
+
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(resetSeconds))
+			writeErrorBody(w, http.StatusTooManyRequests, "rate_limited", "rate limit exceeded", map[string]interface{}{
+				"cost":          cost,
+				"reset_seconds": resetSeconds,
+			})
+			return
+		}
+		if soft {
+			warning := fmt.Sprintf("rate limit nearly exhausted: %d seconds until the budget is full again", resetSeconds)
+			w.Header().Set("X-RateLimit-Warning", warning)
+			r = r.WithContext(context.WithValue(r.Context(), rateWarningKey{}, warning))
+		}
+		body := &countingBody{ReadCloser: r.Body}
+		r.Body = body
+		charges := &rateCharges{}
+		next(w, r.WithContext(context.WithValue(r.Context(), chargesKey{}, charges)))
+
+		extra := l.model.extraCost(prepaid, body.n, charges.stages)
+		if extra > 0 {
+			l.charge(class, keys, extra, time.Now())
+		}
+		l.mu.Lock()
+		l.consumed[costClass(class, body.n)] += cost + extra
+		l.mu.Unlock()
+	}
+}
+
+// warningBand counts buckets whose balance is below the default soft limit.
+func (l *RateLimiter) warningBand(now time.Time) int {
+	var n int
+	for _, b := range l.buckets {
+		if b.level(now) < b.capacity*(1-defaultSoftLimit) {
+			n++
+		}
+	}
+	return n
+}
+
+func handleRateLimitStats(l *RateLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l.mu.Lock()
+		stats := map[string]interface{}{
+			"clients":              len(l.buckets),
+			"clients_warning_band": l.warningBand(time.Now()),
+			"consumed":             l.consumed,
+			"rejected":             l.rejected,
+			"warned":               l.warned,
+		}
+		data, err := json.Marshal(stats)
+		l.mu.Unlock()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// limitedRequest sends a request with body through a handler rate limited
+// by l, which reads the body and runs stages, and returns the status.
+func limitedRequest(l *RateLimiter, body string, chunked bool, stages ...string) int {
+	handler := l.Limit("ai", func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		for _, stage := range stages {
+			chargeStage(r, stage)
+		}
+	})
+	req := httptest.NewRequest(http.MethodPost, "/ai", strings.NewReader(body))
+	if chunked {
+		req.ContentLength = -1
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec.Code
+}
+
+func TestRateLimitCharges(t *testing.T) {
+	model := CostModel{Base: 1, PerKB: 4, Stages: map[string]float64{StageVerbose: 3}}
+	kib := strings.Repeat("x", 1024)
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool
+		stages  []string
+		want    float64
+	}{
+		{"empty", "", false, nil, 1},
+		{"declared body", kib, false, nil, 5},
+		// A chunked body is charged for the bytes read, once read.
+		{"chunked body", kib + kib, true, nil, 9},
+		{"stage", "", false, []string{StageVerbose}, 4},
+		{"unpriced stage", "", false, []string{StageExplain}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(100, 0.0001, model)
+			if status := limitedRequest(l, tt.body, tt.chunked, tt.stages...); status != http.StatusOK {
+				t.Fatalf("status %d", status)
+			}
+			if spent := 100 - balanceOf(l, "ip:192.0.2.1"); math.Abs(spent-tt.want) > 0.01 {
+				t.Errorf("charged %.2f, want %.2f", spent, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitChunkedOverageIsOwed(t *testing.T) {
+	l := NewRateLimiter(10, 0.0001, CostModel{Base: 1, PerKB: 4})
+	// Declared, the body is refused up front.
+	if status := limitedRequest(l, strings.Repeat("x", 4096), false); status != http.StatusTooManyRequests {
+		t.Fatalf("declared 4KiB body: status %d, want 429", status)
+	}
+	// Chunked, it is served, and the overage is owed: the bucket goes into
+	// debt and the next request is refused.
+	if status := limitedRequest(l, strings.Repeat("x", 4096), true); status != http.StatusOK {
+		t.Fatalf("chunked 4KiB body: status %d, want 200", status)
+	}
+	if got := balanceOf(l, "ip:192.0.2.1"); got >= 0 || got < -10 {
+		t.Errorf("balance %.2f, want a debt of at most the capacity", got)
+	}
+	if status := limitedRequest(l, "", false); status != http.StatusTooManyRequests {
+		t.Errorf("request after the overage: status %d, want 429", status)
+	}
+}
+
+func TestVerboseAnswersCostMore(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	f.deps.limiter.model = CostModel{Base: 1, Stages: map[string]float64{StageVerbose: 5}}
+	f.deps.limiter.refill = 0.0001
+	spent := func(body string) float64 {
+		before := balanceOf(f.deps.limiter, "ip:127.0.0.1")
+		if resp, text := f.do(t, http.MethodPost, "/ai", body, "", nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d: %s", resp.StatusCode, text)
+		}
+		return before - balanceOf(f.deps.limiter, "ip:127.0.0.1")
+	}
+	plain := spent(`{"text": "What is a goroutine?"}`)
+	verbose := spent(`{"text": "What is a goroutine?", "verbose": true}`)
+	if math.Abs(verbose-plain-5) > 0.01 {
+		t.Errorf("verbose answer cost %.2f, plain %.2f; want 5 more", verbose, plain)
+	}
+}
+
+// balanceOf is what the bucket of key holds, full if it has none yet.
+func balanceOf(l *RateLimiter, key string) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		return b.tokens
+	}
+	return l.capacity
+}
+
+// TestRateLimitKeysOfTenantsOnly gives only keys TenantKeys knows a bucket,
+// so made-up keys can't crowd the buckets of real clients out.
+func TestRateLimitKeysOfTenantsOnly(t *testing.T) {
+	tenants := TenantKeys{tenants: map[[32]byte]string{sha256.Sum256([]byte("tenant-secret")): "acme"}}
+	l := NewRateLimiter(100, 1, CostModel{Base: 1})
+	handler := l.Limit("ai", func(w http.ResponseWriter, r *http.Request) {})
+	send := func(key string) {
+		req := httptest.NewRequest(http.MethodPost, "/ai", nil)
+		req.Header.Set("X-API-Key", key)
+		handler(httptest.NewRecorder(), withTenant(req, tenants))
+	}
+	for i := 0; i < 100; i++ {
+		send(fmt.Sprint("made-up-", i))
+	}
+	send("tenant-secret")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.buckets["key:tenant-secret"]; !ok {
+		t.Error("tenant key has no bucket")
+	}
+	if len(l.buckets) != 2 {
+		t.Errorf("%d buckets, want the client IP's and the tenant key's", len(l.buckets))
+	}
+}
+
+// TestLLMAnswersCostMore charges an /ai answer the LLM fallback was
+// called for, but not one it served from its cache.
+func TestLLMAnswersCostMore(t *testing.T) {
+	model := newLLMServer(t)
+	defer model.Close()
+	f := newRouteFixture(t)
+	defer f.close()
+	f.deps.ai.LLM = newTestLLM(t, model.URL)
+	f.deps.limiter.model = CostModel{Base: 1, Stages: map[string]float64{StageLLM: 7}}
+	f.deps.limiter.refill = 0.0001
+	spent := func(body string) float64 {
+		before := balanceOf(f.deps.limiter, "ip:127.0.0.1")
+		if resp, text := f.do(t, http.MethodPost, "/ai", body, "", nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d: %s", resp.StatusCode, text)
+		}
+		return before - balanceOf(f.deps.limiter, "ip:127.0.0.1")
+	}
+	matched := spent(`{"text": "What is a goroutine?"}`)
+	called := spent(`{"text": "How do I vendor dependencies?"}`)
+	cached := spent(`{"text": "How do I vendor dependencies?"}`)
+	if math.Abs(called-matched-7) > 0.01 {
+		t.Errorf("LLM answer cost %.2f, matched %.2f; want 7 more", called, matched)
+	}
+	if math.Abs(cached-matched) > 0.01 {
+		t.Errorf("cached LLM answer cost %.2f, matched %.2f; want the same", cached, matched)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// setLoadError records that the prompts or embeddings failed to load, so
+// the server can run degraded instead of exiting. A nil err clears it.
+func (ai *AIEngine) setLoadError(component string, err error) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	if err == nil {
+		delete(ai.loadErrors, component)
+		return
+	}
+	if ai.loadErrors == nil {
+		ai.loadErrors = make(map[string]error)
+	}
+	ai.loadErrors[component] = err
+}
+
+// unready lists what keeps the engine from serving real answers, by
+// component: prompts or embeddings that failed to load, and knowledge base
+// vectors still being re-derived. It is empty once the engine is ready.
+func (ai *AIEngine) unready() map[string]string {
+	problems := make(map[string]string)
+	ai.mu.RLock()
+	for component, err := range ai.loadErrors {
+		// Errors from Config.check already name the setting.
+		problems[component] = strings.TrimPrefix(err.Error(), component+": ")
+	}
+	ai.mu.RUnlock()
+
+	if ai.lexicalOnly() {
+		message := "0 words loaded, serving lexical-only"
+		if err, ok := problems["embeddings"]; ok {
+			message += ": " + err
+		}
+		problems["embeddings"] = message
+	}
+	if status := ai.Rederiver.Status(); status.State == "running" {
+		problems["knowledge_base"] = fmt.Sprintf("re-deriving vectors, %d of %d done", status.Done, status.Total)
+	}
+	return problems
+}
+
+// unreadyList is unready as sorted "component: problem" lines.
+func (ai *AIEngine) unreadyList() []string {
+	problems := ai.unready()
+	list := make([]string, 0, len(problems))
+	for component, problem := range problems {
+		list = append(list, component+": "+problem)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// handleReadyz serves GET /readyz: 200 once the prompts and embeddings are
+// loaded and the knowledge base vectors are built, else 503 listing what
+// is missing. Mode is "semantic", or "lexical-only" while no embeddings
+// are loaded.
+func handleReadyz(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		body := map[string]interface{}{"status": "ready"}
+		if missing := ai.unreadyList(); len(missing) > 0 {
+			status = http.StatusServiceUnavailable
+			body = map[string]interface{}{"status": "not ready", "missing": missing}
+		}
+		body["mode"] = "semantic"
+		if ai.lexicalOnly() {
+			body["mode"] = "lexical-only"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// maxRetainedVectorBytes bounds the memory spent keeping the old vectors
+	// around for the drift report while a reindex runs.
+	maxRetainedVectorBytes = 256 << 20
+	// driftNeighbors is the nearest-neighbor set size compared per entry.
+	driftNeighbors = 5
+	// driftNeighborLimit skips the quadratic neighbor comparison on KBs
+	// larger than this.
+	driftNeighborLimit = 5000
+	driftMostChanged   = 10
+)
+
+// EntryDrift describes how one entry moved between the old and new vectors.
+type EntryDrift struct {
+	ID              string   `json:"id"`
+	Question        string   `json:"question"`
+	Cosine          *float64 `json:"cosine,omitempty"`
+	NeighborOverlap float64  `json:"neighbor_overlap"`
+}
+
+// DriftReport compares entry vectors before and after a reindex. Cosine
+// values are only present when the old and new dimensions agree; neighbor
+// overlap (Jaccard of the nearest-neighbor sets) works regardless.
+type DriftReport struct {
+	GeneratedAt         time.Time    `json:"generated_at"`
+	Entries             int          `json:"entries"`
+	OldDimension        int          `json:"old_dimension"`
+	NewDimension        int          `json:"new_dimension"`
+	MeanCosine          *float64     `json:"mean_cosine,omitempty"`
+	MinCosine           *float64     `json:"min_cosine,omitempty"`
+	MeanNeighborOverlap *float64     `json:"mean_neighbor_overlap,omitempty"`
+	MostChanged         []EntryDrift `json:"most_changed,omitempty"`
+	Skipped             string       `json:"skipped,omitempty"`
+}
+
+func vectorDimension(entries []KnowledgeEntry) int {
+	for _, entry := range entries {
+		if len(entry.Vector) > 0 {
+			return len(entry.Vector)
+		}
+	}
+	return 0
+}
+
+// snapshotEntries returns the current entries including their vectors.
+// The slice is shared and must not be modified.
+func (kb *KnowledgeBase) snapshotEntries() []KnowledgeEntry {
+	return kb.view().entries
+}
+
+// buildVectors computes vectors for the current entries against new
+// embeddings without holding the write lock, reporting progress as it goes.
+func (kb *KnowledgeBase) buildVectors(embeddings *EmbeddingStore, progress func(done, total int)) [][]float32 {
+	current := kb.snapshotEntries()
+	vectors := make([][]float32, len(current))
+	for i, entry := range current {
+		vectors[i] = getSentenceVector(entry.Question, embeddings)
+		if progress != nil {
+			progress(i+1, len(current))
+		}
+	}
+	return vectors
+}
+
+// swapIndex installs prebuilt vectors together with the embeddings they were
+// built from. Entries added since the vectors were built are vectorized
+// during the swap.
+func (ai *AIEngine) swapIndex(vectors [][]float32, embeddings *EmbeddingStore) []KnowledgeEntry {
+	ai.KB.updateEntries("swapIndex", func(entries []KnowledgeEntry) []KnowledgeEntry {
+		for i := range entries {
+			if i < len(vectors) {
+				entries[i].Vector = vectors[i]
+			} else {
+				entries[i].Vector = getSentenceVector(entries[i].Question, embeddings)
+			}
+			entries[i].Analyzer = analyzerVersion
+		}
+		ai.setEmbeddings(embeddings)
+		return entries
+	})
+	ai.KB.revectorize("swapIndex")
+	return ai.KB.view().entries
+}
+
+// Reindex swaps in new embeddings, re-vectorizes the knowledge base and
+// returns a drift report comparing the old and new vectors.
+func (ai *AIEngine) Reindex(embeddings *EmbeddingStore) *DriftReport {
+	return ai.reindex(embeddings, nil)
+}
+
+func (ai *AIEngine) reindex(embeddings *EmbeddingStore, progress func(done, total int)) *DriftReport {
+	report := &DriftReport{GeneratedAt: time.Now()}
+
+	var old []KnowledgeEntry
+	current := ai.KB.snapshotEntries()
+	retained := len(current) * vectorDimension(current) * 8
+	if retained <= maxRetainedVectorBytes {
+		old = current
+	} else {
+		report.Skipped = "old vectors exceed the retention budget"
+	}
+
+	updated := ai.swapIndex(ai.KB.buildVectors(embeddings, progress), embeddings)
+
+	report.Entries = len(updated)
+	report.NewDimension = vectorDimension(updated)
+	if old != nil {
+		report.OldDimension = vectorDimension(old)
+		compareVectors(report, old, updated)
+	}
+
+	ai.mu.Lock()
+	ai.lastReindex = report
+	ai.mu.Unlock()
+
+	if report.MeanCosine != nil {
+		log.Printf("Reindex complete: %d entries, dim %d -> %d, mean cosine %.3f, min cosine %.3f",
+			report.Entries, report.OldDimension, report.NewDimension, *report.MeanCosine, *report.MinCosine)
+	} else {
+		log.Printf("Reindex complete: %d entries, dim %d -> %d", report.Entries, report.OldDimension, report.NewDimension)
+	}
+	if report.MeanNeighborOverlap != nil {
+		log.Printf("Reindex neighbor overlap: mean %.3f", *report.MeanNeighborOverlap)
+	}
+	return report
+}
+
+func compareVectors(report *DriftReport, old, updated []KnowledgeEntry) {
+	n := len(old)
+	if len(updated) < n {
+		n = len(updated)
+	}
+	if n == 0 {
+		return
+	}
+	drifts := make([]EntryDrift, n)
+	for i := 0; i < n; i++ {
+		drifts[i] = EntryDrift{ID: updated[i].ID, Question: updated[i].Question, NeighborOverlap: 1}
+	}
+
+	if report.OldDimension == report.NewDimension {
+		var sum float64
+		minCosine := math.Inf(1)
+		for i := 0; i < n; i++ {
+			c := cosineSimilarity(old[i].Vector, updated[i].Vector)
+			drifts[i].Cosine = &c
+			sum += c
+			minCosine = math.Min(minCosine, c)
+		}
+		mean := sum / float64(n)
+		report.MeanCosine = &mean
+		report.MinCosine = &minCosine
+	}
+
+	if n > driftNeighborLimit {
+		report.Skipped = "too many entries for neighbor comparison"
+		return
+	}
+	oldNeighbors := nearestNeighbors(old[:n])
+	newNeighbors := nearestNeighbors(updated[:n])
+	var sum float64
+	for i := 0; i < n; i++ {
+		drifts[i].NeighborOverlap = jaccard(oldNeighbors[i], newNeighbors[i])
+		sum += drifts[i].NeighborOverlap
+	}
+	mean := sum / float64(n)
+	report.MeanNeighborOverlap = &mean
+
+	sort.SliceStable(drifts, func(i, j int) bool { return drifts[i].NeighborOverlap < drifts[j].NeighborOverlap })
+	report.MostChanged = drifts[:min(driftMostChanged, len(drifts))]
+}
+
+// nearestNeighbors returns, for each entry, the indices of its closest
+// entries by cosine similarity.
+func nearestNeighbors(entries []KnowledgeEntry) [][]int {
+	neighbors := make([][]int, len(entries))
+	for i := range entries {
+		type scored struct {
+			index int
+			score float64
+		}
+		var candidates []scored
+		for j := range entries {
+			if i != j {
+				candidates = append(candidates, scored{j, cosineSimilarity(entries[i].Vector, entries[j].Vector)})
+			}
+		}
+		sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].score > candidates[b].score })
+		for k := 0; k < len(candidates) && k < driftNeighbors; k++ {
+			neighbors[i] = append(neighbors[i], candidates[k].index)
+		}
+	}
+	return neighbors
+}
+
+func jaccard(a, b []int) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	set := make(map[int]bool, len(a))
+	for _, x := range a {
+		set[x] = true
+	}
+	var shared int
+	for _, x := range b {
+		if set[x] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+func handleReindex(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		embeddings, err := loadEmbeddings(ai.Config.Embeddings, ai.Config.EmbeddingsFormat, ai.Config.embeddingsLimit())
+		if err != nil {
+			writeError(w, newError(ErrStoreUnavailable, "embeddings not reloaded: %v", err))
+			return
+		}
+		report := ai.Reindex(embeddings)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+func handleReindexReport(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ai.mu.RLock()
+		report := ai.lastReindex
+		ai.mu.RUnlock()
+		if report == nil {
+			writeError(w, newError(ErrNotFound, "no reindex has run yet"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
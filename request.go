@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// strictJSON makes request decoding reject fields the endpoint does not
+// know, so a misspelled field is an error rather than a silently empty one.
+var strictJSON = true
+
+// requestNormalizer is implemented by request types that need fixing up,
+// such as resolving a field alias, before required fields are checked.
+type requestNormalizer interface {
+	normalize()
+}
+
+// decodeRequest decodes a JSON request body into v and checks that every
+// field tagged `schema:"required"` is set. Errors name the offending field
+// and list the fields the endpoint accepts.
+func decodeRequest(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	if strictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, ErrTooLarge) {
+			return err
+		}
+		const prefix = "json: unknown field "
+		if msg := err.Error(); strings.HasPrefix(msg, prefix) {
+			return newError(ErrInvalidInput, "unknown field %s; accepted fields: %s",
+				strings.TrimPrefix(msg, prefix), strings.Join(requestFields(v, false), ", "))
+		}
+		return newError(ErrInvalidInput, "%v", err)
+	}
+	if n, ok := v.(requestNormalizer); ok {
+		n.normalize()
+	}
+	value := reflect.Indirect(reflect.ValueOf(v))
+	for _, name := range requestFields(v, true) {
+		if requestField(value, name).IsZero() {
+			return newError(ErrInvalidInput, "missing required field %q; accepted fields: %s",
+				name, strings.Join(requestFields(v, false), ", "))
+		}
+	}
+	return nil
+}
+
+// requestFields lists the JSON field names of a request type, or only the
+// required ones.
+func requestFields(v interface{}, requiredOnly bool) []string {
+	var names []string
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				walk(field.Type)
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" || field.PkgPath != "" {
+				continue
+			}
+			if requiredOnly && !applySchemaTag(map[string]interface{}{}, field.Tag.Get("schema")) {
+				continue
+			}
+			names = append(names, name)
+		}
+	}
+	walk(reflect.TypeOf(v))
+	return names
+}
+
+// requestField returns the field of a request struct with the given JSON
+// name, looking through embedded structs.
+func requestField(v reflect.Value, name string) reflect.Value {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if f := requestField(v.Field(i), name); f.IsValid() {
+				return f
+			}
+			continue
+		}
+		if strings.Split(field.Tag.Get("json"), ",")[0] == name {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+// limitedBody caps a request body with http.MaxBytesReader, which also
+// makes the server close the connection, and reports the overrun as
+// ErrTooLarge. MaxBytesReader fails only once limit bytes have been read,
+// which tells its error apart from a broken connection.
+type limitedBody struct {
+	io.ReadCloser
+	limit, read int64
+}
+
+func limitBody(w http.ResponseWriter, r *http.Request, limit int64) {
+	r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), limit: limit}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.read >= b.limit {
+		err = newError(ErrTooLarge, "request body exceeds %d bytes", b.limit)
+	}
+	return n, err
+}
+
+// pageParams parses the ?offset= and ?limit= of a paginated listing. The
+// offset defaults to 0 and the limit to def; a limit must be 1 to most.
+func pageParams(r *http.Request, def, most int) (offset, limit int, err error) {
+	offset, limit = 0, def
+	query := r.URL.Query()
+	if param := query.Get("offset"); param != "" {
+		if offset, err = strconv.Atoi(param); err != nil || offset < 0 {
+			return 0, 0, newError(ErrInvalidInput, "offset must be a non-negative integer")
+		}
+	}
+	if param := query.Get("limit"); param != "" {
+		if limit, err = strconv.Atoi(param); err != nil || limit < 1 || limit > most {
+			return 0, 0, newError(ErrInvalidInput, "limit must be 1 to %d", most)
+		}
+	}
+	return offset, limit, nil
+}
+
+// pageBounds returns the bounds of the page of n items at offset and
+// limit, empty past the end.
+func pageBounds(offset, limit, n int) (start, end int) {
+	start = min(offset, n)
+	return start, min(start+limit, n)
+}
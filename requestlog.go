@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestIDHeader carries the request ID in both directions.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs taken from clients.
+const maxRequestIDLength = 128
+
+// Request log levels, from the most to the least verbose. Question text is
+// only logged at debug.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+	levelOff
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error", "off"}
+
+func parseLogLevel(s string) (logLevel, error) {
+	for i, name := range logLevelNames {
+		if s == name {
+			return logLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q; use one of %s", s, strings.Join(logLevelNames, ", "))
+}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+type requestIDKey struct{}
+
+// requestID returns the ID of the request ctx belongs to, or "".
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID accepts client IDs that are safe to echo and log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestRecord is one line of the request log. The answer fields are
+// filled in by RequestLog.observe when the request asked a question.
+type requestRecord struct {
+	Time       string   `json:"time"`
+	Level      string   `json:"level"`
+	RequestID  string   `json:"request_id"`
+	Method     string   `json:"method"`
+	Path       string   `json:"path"`
+	Status     int      `json:"status"`
+	DurationMS float64  `json:"duration_ms"`
+	Source     string   `json:"source,omitempty"`
+	Score      *float64 `json:"score,omitempty"`
+	EntryID    string   `json:"entry_id,omitempty"`
+	Question   string   `json:"question,omitempty"`
+}
+
+type requestRecordKey struct{}
+
+// RequestLog assigns every request an ID, echoed in the X-Request-ID
+// header, and logs one JSON line per request. An ID sent by the client is
+// kept, so lines correlate with the caller's own logs.
+type RequestLog struct {
+	level logLevel
+	out   *log.Logger
+}
+
+func NewRequestLog(level logLevel, w io.Writer) *RequestLog {
+	return &RequestLog{level: level, out: log.New(w, "", 0)}
+}
+
+func (l *RequestLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		record := &requestRecord{RequestID: id, Method: r.Method, Path: r.URL.Path}
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = context.WithValue(ctx, requestRecordKey{}, record)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		level := levelInfo
+		switch {
+		case rec.status >= 500:
+			level = levelError
+		case rec.status >= 400:
+			level = levelWarn
+		}
+		if level < l.level {
+			return
+		}
+		record.Time = start.UTC().Format(time.RFC3339Nano)
+		record.Level = level.String()
+		record.Status = rec.status
+		record.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+		line, err := json.Marshal(record)
+		if err != nil {
+			return
+		}
+		l.out.Print(string(line))
+	})
+}
+
+// observe is the engine observer that adds the answer to the request's
+// log line. It runs on the request's goroutine, before the line is
+// written.
+func (l *RequestLog) observe(ctx context.Context, q Query, a Answer) {
+	record, ok := ctx.Value(requestRecordKey{}).(*requestRecord)
+	if !ok {
+		return
+	}
+	score := a.Score
+	record.Source, record.Score, record.EntryID = a.Source, &score, a.EntryID
+	if l.level <= levelDebug {
+		record.Question = q.Raw
+	}
+}
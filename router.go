@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Route is one row of the routing table. The router derives 405 and
+// OPTIONS responses, admin authentication and rate limiting from the
+// table, so handlers only see requests they are meant to serve.
+type Route struct {
+	// Pattern is an exact path whose segments may be {name} wildcards, or
+	// a subtree when it ends in "/", as with http.ServeMux.
+	Pattern string
+	Method  string
+	Handler http.HandlerFunc
+	// Admin routes require an admin key; see AdminAuth.
+	Admin bool
+	// RateClass is the cost model class requests are charged to; empty
+	// routes are not rate limited.
+	RateClass string
+	// MaxBody caps the request body in bytes; 0 leaves it unlimited.
+	MaxBody int64
+	// CORS routes may be called from the origins the router's CORS allows.
+	CORS bool
+}
+
+// routeGroup is the routes sharing a pattern, by method.
+type routeGroup struct {
+	pattern  string
+	segments []string
+	subtree  bool
+	// rank orders overlapping patterns: exact patterns beat subtrees,
+	// literal segments beat wildcards and longer subtrees beat shorter
+	// ones.
+	rank    int
+	methods map[string]http.HandlerFunc
+	allow   string
+	// cors lists the methods open to cross-origin callers.
+	cors map[string]bool
+}
+
+// Router dispatches requests through a routing table.
+type Router struct {
+	groups []*routeGroup
+	admin  AdminAuth
+	cors   *CORS
+	// tenants resolves the X-API-Key of requests for requestTenant.
+	tenants TenantKeys
+}
+
+// NewRouter builds a router from a table. A pattern and method may appear
+// only once. Admin routes are checked by admin; a nil limiter disables
+// rate limiting and a nil cors leaves CORS routes same-origin only.
+func NewRouter(routes []Route, admin AdminAuth, limiter *RateLimiter, cors *CORS) (*Router, error) {
+	if cors == nil {
+		cors = &CORS{}
+	}
+	rt := &Router{admin: admin, cors: cors}
+	byPattern := make(map[string]*routeGroup)
+	for _, route := range routes {
+		if route.Handler == nil {
+			return nil, fmt.Errorf("%s %s has no handler", route.Method, route.Pattern)
+		}
+		g, ok := byPattern[route.Pattern]
+		if !ok {
+			g = newRouteGroup(route.Pattern)
+			byPattern[route.Pattern] = g
+			rt.groups = append(rt.groups, g)
+		}
+		if _, ok := g.methods[route.Method]; ok {
+			return nil, fmt.Errorf("%s %s is routed twice", route.Method, route.Pattern)
+		}
+		handler := route.Handler
+		if route.MaxBody > 0 {
+			handler = withBodyLimit(route.MaxBody, handler)
+		}
+		if route.RateClass != "" && limiter != nil {
+			handler = limiter.Limit(route.RateClass, handler)
+		}
+		if route.Admin {
+			handler = rt.requireAdmin(handler)
+		}
+		if route.CORS {
+			// Outermost, so refusals and 429s carry the CORS headers too.
+			handler = cors.wrap(handler)
+			g.cors[route.Method] = true
+		}
+		g.methods[route.Method] = handler
+	}
+	for _, g := range rt.groups {
+		methods := []string{http.MethodOptions}
+		for method := range g.methods {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		g.allow = strings.Join(methods, ", ")
+	}
+	sort.SliceStable(rt.groups, func(i, j int) bool { return rt.groups[i].rank > rt.groups[j].rank })
+	return rt, nil
+}
+
+// withBodyLimit caps the request body of handler at limit bytes.
+func withBodyLimit(limit int64, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			writeError(w, newError(ErrTooLarge, "request body exceeds %d bytes", limit))
+			return
+		}
+		limitBody(w, r, limit)
+		handler(w, r)
+	}
+}
+
+func newRouteGroup(pattern string) *routeGroup {
+	g := &routeGroup{
+		pattern: pattern,
+		subtree: strings.HasSuffix(pattern, "/"),
+		methods: make(map[string]http.HandlerFunc),
+		cors:    make(map[string]bool),
+	}
+	if g.subtree {
+		g.rank = len(pattern)
+		return g
+	}
+	g.segments = strings.Split(pattern, "/")
+	g.rank = 1 << 20
+	for _, segment := range g.segments {
+		if !isPathParam(segment) {
+			g.rank += len(segment) + 1
+		}
+	}
+	return g
+}
+
+func isPathParam(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// match reports whether path matches the pattern and returns the values
+// of its wildcards.
+func (g *routeGroup) match(path string) (map[string]string, bool) {
+	if g.subtree {
+		return nil, strings.HasPrefix(path, g.pattern)
+	}
+	segments := strings.Split(path, "/")
+	if len(segments) != len(g.segments) {
+		return nil, false
+	}
+	var params map[string]string
+	for i, segment := range g.segments {
+		switch {
+		case !isPathParam(segment):
+			if segment != segments[i] {
+				return nil, false
+			}
+		case segments[i] == "":
+			return nil, false
+		default:
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[strings.Trim(segment, "{}")] = segments[i]
+		}
+	}
+	return params, true
+}
+
+type pathParamsKey struct{}
+
+// pathParam returns the value of a {name} wildcard in the matched pattern.
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+// lookup returns the most specific group matching path and the values of
+// its wildcards.
+func (rt *Router) lookup(path string) (*routeGroup, map[string]string) {
+	for _, g := range rt.groups {
+		if params, ok := g.match(path); ok {
+			return g, params
+		}
+	}
+	return nil, nil
+}
+
+// Pattern returns the pattern of the route serving r, or "" if there is
+// none.
+func (rt *Router) Pattern(r *http.Request) string {
+	if g, _ := rt.lookup(r.URL.Path); g != nil {
+		return g.pattern
+	}
+	return ""
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g, params := rt.lookup(r.URL.Path)
+	if g == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == http.MethodOptions {
+		if method := r.Header.Get("Access-Control-Request-Method"); method != "" && g.cors[method] && r.Header.Get("Origin") != "" {
+			rt.cors.preflight(w, r, corsMethods(g.cors))
+			return
+		}
+		w.Header().Set("Allow", g.allow)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	handler, ok := g.methods[r.Method]
+	if !ok {
+		w.Header().Set("Allow", g.allow)
+		writeError(w, newError(ErrMethodNotAllowed, "method %s is not allowed; allowed: %s", r.Method, g.allow))
+		return
+	}
+	if params != nil {
+		r = r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params))
+	}
+	handler(w, withTenant(r, rt.tenants))
+}
+
+// requireAdmin rejects requests without an admin key as a bearer token.
+func (rt *Router) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	if rt.admin.Open {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := rt.admin.identify(r.Header.Get("Authorization"))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, err)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), adminIdentityKey{}, id)))
+	}
+}
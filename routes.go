@@ -0,0 +1,113 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// routeDeps is what the route table is built from: the engine, the stores
+// and services main sets up around it, and the flags the handlers take.
+type routeDeps struct {
+	ai             *AIEngine
+	escalations    *EscalationStore
+	teach          *TeachStore
+	mirror         *Mirror
+	unanswered     *UnansweredLog
+	swap           *EmbeddingSwap
+	limiter        *RateLimiter
+	stats          *AnswerStats
+	metrics        *Metrics
+	examples       *ExampleCapture
+	outbound       *Outbound
+	cors           *CORS
+	templates      *template.Template
+	feedbackPolicy FeedbackPolicy
+	statsPolicy    PublicStatsPolicy
+	// static is the directory /static/ serves.
+	static         string
+	publicURL      string
+	maxBody        int64
+	maxImportBody  int64
+	questionAlias  bool
+	streamInterval time.Duration
+	// pacing delays the answers of the SSE and WebSocket paths.
+	pacing   Pacing
+	chatRate float64
+}
+
+// appRoutes is the route table of the server. Routes for optional
+// features are only in it when the feature is set up: teach when d.teach
+// is set, the mirror report when d.mirror is.
+func appRoutes(d routeDeps) []Route {
+	ai := d.ai
+	// routes is complete by the time /openapi.json is served from it.
+	var routes []Route
+	routes = []Route{
+		{Pattern: "/ai", Method: http.MethodPost, Handler: handleAI(ai, d.escalations, d.teach, d.mirror, d.questionAlias, d.streamInterval, d.pacing), RateClass: "ai", MaxBody: d.maxBody, CORS: true},
+		{Pattern: "/ai/candidates", Method: http.MethodPost, Handler: handleCandidates(ai), RateClass: "ai", MaxBody: d.maxBody},
+		{Pattern: "/search", Method: http.MethodPost, Handler: handleSearch(ai), RateClass: "ai", MaxBody: d.maxBody},
+		{Pattern: "/learn", Method: http.MethodPost, Handler: handleLearn(ai), Admin: true, RateClass: "learn", MaxBody: d.maxBody},
+		{Pattern: "/learn", Method: http.MethodGet, Handler: handleListLearned(ai), Admin: true},
+		{Pattern: "/learn", Method: http.MethodPut, Handler: handleUpdateLearned(ai), Admin: true, RateClass: "learn", MaxBody: d.maxBody},
+		{Pattern: "/learn", Method: http.MethodDelete, Handler: handleForgetLearned(ai), Admin: true, RateClass: "learn", MaxBody: d.maxBody},
+		{Pattern: "/escalate", Method: http.MethodPost, Handler: handleEscalate(d.escalations), RateClass: "ai", MaxBody: d.maxBody, CORS: true},
+		{Pattern: "/escalations", Method: http.MethodGet, Handler: handleEscalations(d.escalations), Admin: true},
+		{Pattern: "/entries", Method: http.MethodGet, Handler: handleEntries(ai), Admin: true},
+		{Pattern: "/entries/expiring", Method: http.MethodGet, Handler: handleExpiring(ai), Admin: true},
+		{Pattern: "/entries/review", Method: http.MethodPost, Handler: handleReview(ai), Admin: true, MaxBody: d.maxBody},
+		{Pattern: "/entries/problem", Method: http.MethodGet, Handler: handleProblemEntries(ai, d.feedbackPolicy), Admin: true},
+		{Pattern: "/feedback", Method: http.MethodPost, Handler: handleFeedback(ai), RateClass: "ai", MaxBody: d.maxBody, CORS: true},
+		{Pattern: "/entries/{id}/feedback", Method: http.MethodPost, Handler: handleEntryFeedback(ai), RateClass: "ai", MaxBody: d.maxBody, CORS: true},
+		{Pattern: "/entries/{id}/experiment", Method: http.MethodGet, Handler: handleExperiment(ai)},
+		{Pattern: "/entries/{id}/experiment", Method: http.MethodPost, Handler: handleExperiment(ai), RateClass: "ai", MaxBody: d.maxBody},
+		{Pattern: "/entries/{id}/experiment/end", Method: http.MethodPost, Handler: handleEndExperiment(ai), Admin: true, MaxBody: d.maxBody},
+		{Pattern: "/kb/entries", Method: http.MethodGet, Handler: handleKBEntries(ai), Admin: true},
+		{Pattern: "/kb/export", Method: http.MethodGet, Handler: handleKBExport(ai), Admin: true},
+		{Pattern: "/kb/import", Method: http.MethodPost, Handler: handleKBImport(ai), Admin: true, MaxBody: d.maxImportBody},
+		{Pattern: "/kb/dedupe", Method: http.MethodPost, Handler: handleDedupe(ai), Admin: true, MaxBody: d.maxBody},
+		{Pattern: "/kb/import-faq", Method: http.MethodPost, Handler: handleFAQImport(ai), Admin: true, MaxBody: d.maxBody},
+		{Pattern: "/admin/reindex", Method: http.MethodPost, Handler: handleReindex(ai), Admin: true, MaxBody: d.maxBody},
+		{Pattern: "/admin/reindex/report", Method: http.MethodGet, Handler: handleReindexReport(ai), Admin: true},
+		{Pattern: "/admin/validate", Method: http.MethodPost, Handler: handleValidate(ai), Admin: true, MaxBody: d.maxBody},
+		{Pattern: "/admin/rederive", Method: http.MethodGet, Handler: handleRederive(ai), Admin: true},
+		{Pattern: "/admin/rederive", Method: http.MethodPost, Handler: handleRederive(ai), Admin: true, MaxBody: d.maxBody},
+		{Pattern: "/admin/unanswered", Method: http.MethodGet, Handler: handleUnanswered(d.unanswered), Admin: true},
+		{Pattern: "/admin/unanswered/learn", Method: http.MethodPost, Handler: handleClusterLearn(ai, d.unanswered), Admin: true, MaxBody: d.maxBody},
+		{Pattern: "/admin/embeddings", Method: http.MethodGet, Handler: handleEmbeddingSwap(d.swap), Admin: true},
+		{Pattern: "/admin/embeddings", Method: http.MethodPost, Handler: handleEmbeddingSwap(d.swap), Admin: true, MaxBody: d.maxBody},
+		{Pattern: "/admin/ratelimit", Method: http.MethodGet, Handler: handleRateLimitStats(d.limiter), Admin: true},
+		{Pattern: "/admin/stats", Method: http.MethodGet, Handler: handleStats(d.stats), Admin: true},
+		{Pattern: "/admin/patterns", Method: http.MethodGet, Handler: handlePatterns(ai), Admin: true},
+		{Pattern: "/admin/locks", Method: http.MethodGet, Handler: handleLockStats(ai.KB), Admin: true},
+		{Pattern: "/admin/config", Method: http.MethodGet, Handler: handleEffectiveConfig(ai), Admin: true},
+		{Pattern: "/admin/switches", Method: http.MethodGet, Handler: handleSwitches(ai.Switches), Admin: true},
+		{Pattern: "/admin/switches", Method: http.MethodPost, Handler: handleSwitches(ai.Switches), Admin: true, MaxBody: d.maxBody},
+		{Pattern: "/admin/examples", Method: http.MethodGet, Handler: handleExamples(d.examples), Admin: true},
+		{Pattern: "/admin/examples", Method: http.MethodPost, Handler: handleExamples(d.examples), Admin: true, MaxBody: d.maxBody},
+		{Pattern: "/healthz", Method: http.MethodGet, Handler: handleHealthz(ai, d.outbound)},
+		{Pattern: "/readyz", Method: http.MethodGet, Handler: handleReadyz(ai)},
+		{Pattern: "/metrics", Method: http.MethodGet, Handler: handleMetrics(d.metrics), Admin: true},
+		{Pattern: "/version", Method: http.MethodGet, Handler: handleVersion(ai)},
+		{Pattern: "/stats", Method: http.MethodGet, Handler: handlePublicStats(d.stats, d.statsPolicy)},
+		{Pattern: "/schema/prompt.json", Method: http.MethodGet, Handler: handlePromptSchema},
+		{Pattern: "/openapi.json", Method: http.MethodGet, Handler: handleOpenAPI(d.examples, func() []Route { return routes }), Admin: true},
+		{Pattern: "/sitemap.xml", Method: http.MethodGet, Handler: handleSitemap(ai, d.publicURL)},
+		{Pattern: "/qa/", Method: http.MethodGet, Handler: handleQA(ai, d.templates, d.publicURL)},
+		{Pattern: "/static/", Method: http.MethodGet, Handler: http.StripPrefix("/static/", http.FileServer(http.Dir(d.static))).ServeHTTP},
+		{Pattern: "/", Method: http.MethodGet, Handler: handleTemplates(d.templates)},
+	}
+	if d.teach != nil {
+		routes = append(routes,
+			Route{Pattern: "/ai/teach", Method: http.MethodPost, Handler: handleTeach(ai, d.teach), RateClass: "learn", MaxBody: d.maxBody, CORS: true},
+			Route{Pattern: "/admin/teach", Method: http.MethodGet, Handler: handleTeachModeration(ai, d.teach), Admin: true},
+			Route{Pattern: "/admin/teach", Method: http.MethodPost, Handler: handleTeachModeration(ai, d.teach), Admin: true, MaxBody: d.maxBody},
+		)
+	}
+	if d.mirror != nil {
+		routes = append(routes, Route{Pattern: "/admin/mirror", Method: http.MethodGet, Handler: handleMirror(d.mirror), Admin: true})
+	}
+	// /ws checks origins itself: WebSockets aren't subject to CORS.
+	routes = append(routes, Route{Pattern: "/ws", Method: http.MethodGet, Handler: handleChat(ai, d.cors, d.chatRate, d.maxBody, d.pacing), RateClass: "ai"})
+	return routes
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminKey = "test-admin-key"
+
+// routeFixture is a server over a fresh test engine with every optional
+// feature set up, and the stores behind it for cases to prepare.
+type routeFixture struct {
+	deps   routeDeps
+	server *httptest.Server
+	// dir is a scratch directory removed by close.
+	dir string
+}
+
+// newRouteFixture serves appRoutes over a test engine whose knowledge base
+// has a public entry and an entry running an experiment. The caller must
+// close it.
+func newRouteFixture(t *testing.T, opts ...testOption) *routeFixture {
+	t.Helper()
+	entries := append([]KnowledgeEntry(nil), testEntries...)
+	entries[0].Tags = []string{publicTag}
+	entries = append(entries, KnowledgeEntry{
+		ID:       "closures",
+		Question: "What is a closure?",
+		Answer:   "A closure is a function value that references variables from outside its body.",
+		Variants: []AnswerVariant{
+			{Answer: "A closure captures variables from its enclosing function.", Weight: 1},
+			{Answer: "A closure is a function value bound to outside variables.", Weight: 1},
+		},
+	})
+	ai := newTestEngine(t, append([]testOption{withEntries(entries...)}, opts...)...)
+	templates, err := loadTemplates("templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbound, err := NewOutbound(OutboundConfig{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "askgo-routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	embeddings := filepath.Join(dir, "embeddings.json")
+	if err := ioutil.WriteFile(embeddings, []byte(`{"goroutine": [1, 0], "channel": [0, 1]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ai.Config.Embeddings, ai.Config.EmbeddingsFormat = embeddings, "json"
+	if ai.Switches, err = LoadSwitches(filepath.Join(dir, "switches.json")); err != nil {
+		t.Fatal(err)
+	}
+	stats := NewAnswerStats()
+	f := &routeFixture{dir: dir, deps: routeDeps{
+		ai:             ai,
+		escalations:    NewEscalationStore(time.Hour, "", nil, ai.Switches),
+		teach:          NewTeachStore(time.Hour, false),
+		mirror:         NewMirror(MirrorConfig{URL: "http://127.0.0.1:1/ai", Queue: 1}, http.DefaultClient),
+		unanswered:     NewUnansweredLog(),
+		swap:           NewEmbeddingSwap(ai, 0),
+		limiter:        NewRateLimiter(1000, 1000, CostModel{}),
+		stats:          stats,
+		metrics:        NewMetrics(),
+		examples:       NewExampleCapture(),
+		outbound:       outbound,
+		templates:      templates,
+		feedbackPolicy: FeedbackPolicy{},
+		statsPolicy:    PublicStatsPolicy{},
+		static:         "static",
+		publicURL:      "https://askgo.example.com",
+		maxBody:        64 << 10,
+		maxImportBody:  1 << 20,
+	}}
+	router, err := NewRouter(appRoutes(f.deps), NewAdminAuth([]string{testAdminKey}, false), f.deps.limiter, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.server = httptest.NewServer(f.deps.examples.Middleware(router))
+	return f
+}
+
+func (f *routeFixture) close() {
+	f.server.Close()
+	os.RemoveAll(f.dir)
+}
+
+// do sends a request to the fixture's server, with the admin key when
+// key is set, and returns the response with its body read.
+func (f *routeFixture) do(t *testing.T, method, path, body, key string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, f.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	for name, value := range header {
+		if name == "Host" {
+			// The client sends req.Host, not a Host header.
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return resp, ""
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+// routeCase is the success case of one route.
+type routeCase struct {
+	path string
+	body string
+	// prepare, when set, sets up what the request needs and returns its
+	// path and body, which override path and body.
+	prepare func(t *testing.T, f *routeFixture) (path, body string)
+	header  map[string]string
+	// status is the status of success, 200 when zero.
+	status int
+}
+
+// routeCases holds a success case for every route of appRoutes, keyed by
+// method and pattern. TestRouteTable fails for routes without one, so new
+// endpoints can't ship untested.
+var routeCases = map[string]routeCase{
+	"POST /ai":            {path: "/ai", body: `{"text": "What is a goroutine?"}`},
+	"POST /ai/candidates": {path: "/ai/candidates", body: `{"text": "What is a goroutine?"}`},
+	"POST /search":        {path: "/search", body: `{"text": "goroutine"}`},
+	"POST /learn":         {path: "/learn", body: `{"question": "What is a map?", "answer": "A map is a hash table."}`},
+	"GET /learn":          {path: "/learn"},
+	"PUT /learn": {prepare: func(t *testing.T, f *routeFixture) (string, string) {
+		f.deps.ai.KB.learn(LearnedEntry{Question: "What is a map?", Answer: "A map is a hash table."})
+		return "/learn", `{"question": "What is a map?", "answer": "A map maps keys to values."}`
+	}},
+	"DELETE /learn": {prepare: func(t *testing.T, f *routeFixture) (string, string) {
+		f.deps.ai.KB.learn(LearnedEntry{Question: "What is a map?", Answer: "A map is a hash table."})
+		return "/learn", `{"question": "What is a map?"}`
+	}},
+	"POST /escalate": {prepare: func(t *testing.T, f *routeFixture) (string, string) {
+		token := f.deps.escalations.Issue("How do I vendor cgo code?", Answer{}, nil)
+		return "/escalate", `{"token": "` + token + `", "comment": "still stuck"}`
+	}},
+	"GET /escalations":      {path: "/escalations"},
+	"GET /entries":          {path: "/entries"},
+	"GET /entries/expiring": {path: "/entries/expiring"},
+	"POST /entries/review":  {path: "/entries/review", body: `{"id": "channels", "action": "verify"}`},
+	"GET /entries/problem":  {path: "/entries/problem"},
+	"POST /feedback": {prepare: func(t *testing.T, f *routeFixture) (string, string) {
+		learnSlice(t, f.deps.ai)
+		ask(t, f.deps.ai, "How do I reverse a slice?", AskOptions{SessionID: "route-test"})
+		return "/feedback", `{"question": "How do I reverse a slice?", "helpful": true, "session_id": "route-test"}`
+	}},
+	"POST /entries/{id}/feedback":  {path: "/entries/channels/feedback", body: `{"helpful": true}`},
+	"GET /entries/{id}/experiment": {path: "/entries/closures/experiment"},
+	"POST /entries/{id}/experiment": {prepare: func(t *testing.T, f *routeFixture) (string, string) {
+		ask(t, f.deps.ai, "What is a closure?", AskOptions{SessionID: "route-test"})
+		return "/entries/closures/experiment", `{"helpful": true, "session_id": "route-test"}`
+	}},
+	"POST /entries/{id}/experiment/end": {path: "/entries/closures/experiment/end", body: `{"winner": 1}`},
+	"GET /kb/entries":                   {path: "/kb/entries"},
+	"GET /kb/export":                    {path: "/kb/export"},
+	"POST /kb/import": {path: "/kb/import", body: `{"entries": [
+		{"ID": "maps", "Question": "What is a map?", "Answer": "A map is a hash table."}]}`},
+	"POST /kb/dedupe": {path: "/kb/dedupe", body: `{"dry_run": true}`},
+	"POST /kb/import-faq": {prepare: func(t *testing.T, f *routeFixture) (string, string) {
+		faq := filepath.Join(f.dir, "faq")
+		if err := os.Mkdir(faq, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(faq, "maps.md"), []byte("# What is a map?\n\nA map is a hash table.\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return "/kb/import-faq", `{"path": "` + faq + `", "dry_run": true}`
+	}},
+	"POST /admin/reindex": {path: "/admin/reindex"},
+	"GET /admin/reindex/report": {prepare: func(t *testing.T, f *routeFixture) (string, string) {
+		f.deps.ai.Reindex(f.deps.ai.embeddings())
+		return "/admin/reindex/report", ""
+	}},
+	"POST /admin/validate":  {path: "/admin/validate", body: `{}`},
+	"GET /admin/rederive":   {path: "/admin/rederive"},
+	"POST /admin/rederive":  {path: "/admin/rederive", status: http.StatusAccepted},
+	"GET /admin/unanswered": {path: "/admin/unanswered"},
+	"POST /admin/unanswered/learn": {prepare: func(t *testing.T, f *routeFixture) (string, string) {
+		f.deps.unanswered.Record("How do I profile a program?", []float32{1, 0})
+		_, clusters := f.deps.unanswered.Clusters()
+		return "/admin/unanswered/learn", `{"cluster_id": "` + clusters[0].ID + `", "answer": "Use pprof."}`
+	}},
+	"GET /admin/embeddings": {path: "/admin/embeddings"},
+	"POST /admin/embeddings": {status: http.StatusAccepted, prepare: func(t *testing.T, f *routeFixture) (string, string) {
+		return "/admin/embeddings", `{"path": "` + f.deps.ai.Config.Embeddings + `", "format": "json"}`
+	}},
+	"GET /admin/ratelimit":    {path: "/admin/ratelimit"},
+	"GET /admin/stats":        {path: "/admin/stats"},
+	"GET /admin/patterns":     {path: "/admin/patterns"},
+	"GET /admin/locks":        {path: "/admin/locks"},
+	"GET /admin/config":       {path: "/admin/config"},
+	"GET /admin/switches":     {path: "/admin/switches"},
+	"POST /admin/switches":    {path: "/admin/switches", body: `{"switch": "learning_enabled", "enabled": false}`},
+	"GET /admin/examples":     {path: "/admin/examples"},
+	"POST /admin/examples":    {path: "/admin/examples", body: `{"enabled": true}`},
+	"GET /healthz":            {path: "/healthz"},
+	"GET /readyz":             {path: "/readyz"},
+	"GET /metrics":            {path: "/metrics"},
+	"GET /version":            {path: "/version"},
+	"GET /stats":              {path: "/stats"},
+	"GET /schema/prompt.json": {path: "/schema/prompt.json"},
+	"GET /openapi.json":       {path: "/openapi.json"},
+	"GET /sitemap.xml":        {path: "/sitemap.xml"},
+	"GET /qa/":                {path: "/qa/goroutines"},
+	"GET /static/":            {path: "/static/style.css"},
+	"GET /":                   {path: "/"},
+	"POST /ai/teach": {prepare: func(t *testing.T, f *routeFixture) (string, string) {
+		token := f.deps.teach.Offer("What is a slice?")
+		return "/ai/teach", `{"token": "` + token + `", "answer": "A slice is a view of an array."}`
+	}},
+	"GET /admin/teach": {path: "/admin/teach"},
+	"POST /admin/teach": {prepare: func(t *testing.T, f *routeFixture) (string, string) {
+		f.deps.teach.queue(TaughtAnswer{ID: "taught-1", Question: "What is a slice?", Answer: "A slice is a view of an array."})
+		return "/admin/teach", `{"id": "taught-1", "action": "reject"}`
+	}},
+	"GET /admin/mirror": {path: "/admin/mirror"},
+	"GET /ws": {path: "/ws", status: http.StatusSwitchingProtocols, header: map[string]string{
+		"Connection":            "Upgrade",
+		"Upgrade":               "websocket",
+		"Sec-WebSocket-Version": "13",
+		"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+	}},
+}
+
+// TestRouteTable walks the route table. Every route must have a case in
+// routeCases, which must succeed with the admin key; admin routes must
+// refuse requests without a key or with a wrong one, and other routes
+// must serve requests without one.
+func TestRouteTable(t *testing.T) {
+	f := newRouteFixture(t)
+	routes := appRoutes(f.deps)
+	f.close()
+
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		route := route
+		name := route.Method + " " + route.Pattern
+		seen[name] = true
+		c, ok := routeCases[name]
+		if !ok {
+			t.Errorf("%s has no case in routeCases", name)
+			continue
+		}
+		status := c.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		run := func(t *testing.T, key string, want int) {
+			f := newRouteFixture(t)
+			defer f.close()
+			path, body := c.path, c.body
+			if c.prepare != nil {
+				path, body = c.prepare(t, f)
+			}
+			resp, got := f.do(t, route.Method, path, body, key, c.header)
+			if resp.StatusCode != want {
+				t.Fatalf("%s %s: status %d, want %d: %s", route.Method, path, resp.StatusCode, want, got)
+			}
+		}
+		t.Run(name+"/success", func(t *testing.T) {
+			run(t, testAdminKey, status)
+		})
+		if route.Admin {
+			t.Run(name+"/no key", func(t *testing.T) {
+				run(t, "", http.StatusUnauthorized)
+			})
+			t.Run(name+"/wrong key", func(t *testing.T) {
+				run(t, "not-"+testAdminKey, http.StatusForbidden)
+			})
+		} else {
+			t.Run(name+"/public", func(t *testing.T) {
+				run(t, "", status)
+			})
+		}
+	}
+	for name := range routeCases {
+		if !seen[name] {
+			t.Errorf("routeCases has %s, which is not routed", name)
+		}
+	}
+}
+
+// TestPublicWritesAreLimited holds the routes anyone may write to to a
+// rate class and a body cap.
+func TestPublicWritesAreLimited(t *testing.T) {
+	f := newRouteFixture(t)
+	routes := appRoutes(f.deps)
+	f.close()
+	for _, route := range routes {
+		if route.Admin || route.Method == http.MethodGet {
+			continue
+		}
+		if route.RateClass == "" || route.MaxBody <= 0 {
+			t.Errorf("%s %s is public but has rate class %q and body cap %d", route.Method, route.Pattern, route.RateClass, route.MaxBody)
+		}
+	}
+}
+
+// TestWritesHaveBodyCap caps the body of every write, public or admin.
+func TestWritesHaveBodyCap(t *testing.T) {
+	f := newRouteFixture(t)
+	routes := appRoutes(f.deps)
+	f.close()
+	for _, route := range routes {
+		if route.Method != http.MethodGet && route.MaxBody <= 0 {
+			t.Errorf("%s %s has no body cap", route.Method, route.Pattern)
+		}
+	}
+}
+
+// TestImportBodyCap takes exports larger than other requests on
+// /kb/import, up to the import cap.
+func TestImportBodyCap(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	pad := func(n int64) string {
+		return `{"entries": []` + strings.Repeat(" ", int(n)) + `}`
+	}
+	if resp, body := f.do(t, http.MethodPost, "/kb/import", pad(f.deps.maxBody), testAdminKey, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("export over -max-body-bytes: status %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := f.do(t, http.MethodPost, "/kb/import", pad(f.deps.maxImportBody), testAdminKey, nil); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("export over -max-import-body-bytes: status %d, want 413", resp.StatusCode)
+	}
+	if resp, _ := f.do(t, http.MethodPost, "/kb/import-faq", pad(f.deps.maxBody), testAdminKey, nil); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("FAQ import over -max-body-bytes: status %d, want 413", resp.StatusCode)
+	}
+}
+
+// TestPublicLinksIgnoreHost builds the sitemap and canonical links on the
+// configured public URL, whatever Host the client sends.
+func TestPublicLinksIgnoreHost(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	header := map[string]string{"Host": "evil.example"}
+	for _, path := range []string{"/sitemap.xml", "/qa/goroutines"} {
+		resp, body := f.do(t, http.MethodGet, path, "", "", header)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d", path, resp.StatusCode)
+		}
+		if strings.Contains(body, "evil.example") || !strings.Contains(body, "https://askgo.example.com/qa/goroutines") {
+			t.Errorf("%s:\n%s", path, body)
+		}
+	}
+}
+
+func TestParsePublicURL(t *testing.T) {
+	for in, want := range map[string]string{"": "", "https://askgo.example.com/": "https://askgo.example.com", "http://host:8080/help": "http://host:8080/help"} {
+		if got, err := parsePublicURL(in); err != nil || got != want {
+			t.Errorf("parsePublicURL(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"askgo.example.com", "ftp://host", "https://host/?q=1", "https://user:pw@host", "https://host/#top"} {
+		if _, err := parsePublicURL(in); err == nil {
+			t.Errorf("parsePublicURL(%q) accepted it", in)
+		}
+	}
+}
+
+// TestEffectiveConfig reports the settings in force, whichever way they
+// were set, and the switches as toggled.
+func TestEffectiveConfig(t *testing.T) {
+	threshold := 0.6
+	f := newRouteFixture(t)
+	defer f.close()
+	if err := f.deps.ai.Configure(EngineConfig{KBMatchThreshold: &threshold}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.deps.ai.Switches.Set(SwitchLLMFallback, "acme", false, "test"); err != nil {
+		t.Fatal(err)
+	}
+	resp, body := f.do(t, http.MethodGet, "/admin/config", "", testAdminKey, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	var config EffectiveConfig
+	if err := json.Unmarshal([]byte(body), &config); err != nil {
+		t.Fatal(err)
+	}
+	if got := config.Engine.KBMatchThreshold; got == nil || math.Abs(*got-threshold) > 1e-9 {
+		t.Errorf("kb_match_threshold %v, want %v", got, threshold)
+	}
+	if got := config.Engine.ContextMatchThreshold; got == nil || math.Abs(*got-0.8) > 1e-9 {
+		t.Errorf("default context_match_threshold %v, want 0.8", got)
+	}
+	if got := config.Engine.SpellCorrection; got == nil || !*got {
+		t.Errorf("spell_correction %v, want on by default", got)
+	}
+	for name := range knownSwitches {
+		if on, ok := config.Switches.Global[name]; !ok || !on {
+			t.Errorf("global switch %s reported %v, %v", name, on, ok)
+		}
+	}
+	if on, ok := config.Switches.Tenants["acme"][SwitchLLMFallback]; !ok || on {
+		t.Errorf("acme's %s reported %v, %v, want off", SwitchLLMFallback, on, ok)
+	}
+}
+
+// TestOpenAPICoversEveryRoute fails for a route of appRoutes missing from
+// the OpenAPI document.
+func TestOpenAPICoversEveryRoute(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	_, body := f.do(t, "GET", "/openapi.json", "", testAdminKey, nil)
+	var doc OpenAPI
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range appRoutes(f.deps) {
+		op, ok := doc.Paths[route.Pattern][strings.ToLower(route.Method)]
+		if !ok {
+			t.Errorf("%s %s is not in the OpenAPI document", route.Method, route.Pattern)
+			continue
+		}
+		if route.Admin != (len(op.Security) > 0) {
+			t.Errorf("%s %s: admin %v, security %v", route.Method, route.Pattern, route.Admin, op.Security)
+		}
+	}
+	if op := doc.Paths["/entries/{id}/feedback"]["post"]; len(op.Parameters) != 1 || op.Parameters[0].Name != "id" {
+		t.Errorf("path parameters %+v, want id", op.Parameters)
+	}
+}
+
+// TestExamplesCoverEveryRoute captures all traffic of a synthetic run of
+// routeCases, after which every route has an example in the OpenAPI
+// document.
+func TestExamplesCoverEveryRoute(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	if resp, body := f.do(t, "POST", "/admin/examples", `{"enabled": true, "sample_rate": 1}`, testAdminKey, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("enabling capture: %d %s", resp.StatusCode, body)
+	}
+	routes := appRoutes(f.deps)
+	for _, route := range routes {
+		c := routeCases[route.Method+" "+route.Pattern]
+		path, body := c.path, c.body
+		if c.prepare != nil {
+			path, body = c.prepare(t, f)
+		}
+		f.do(t, route.Method, path, body, testAdminKey, c.header)
+	}
+	// A WebSocket's example is recorded once the server closes it.
+	var missing []string
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		doc := openAPIDocument(routes, f.deps.examples.List())
+		missing = missing[:0]
+		for _, route := range routes {
+			op := doc.Paths[route.Pattern][strings.ToLower(route.Method)]
+			if _, ok := op.Responses["default"]; ok {
+				missing = append(missing, route.Method+" "+route.Pattern)
+			}
+		}
+		if len(missing) == 0 || time.Now().After(deadline) {
+			break
+		}
+	}
+	if len(missing) > 0 {
+		t.Fatalf("no example for %v", missing)
+	}
+	doc := openAPIDocument(routes, f.deps.examples.List())
+	ai := doc.Paths["/ai"]["post"]
+	if ai.RequestBody == nil || ai.RequestBody.Content["application/json"].Example == nil {
+		t.Errorf("POST /ai has no request example: %+v", ai.RequestBody)
+	}
+	if _, ok := ai.Responses["200"].Content["application/json"].Example.(map[string]interface{}); !ok {
+		t.Errorf("POST /ai response example %+v, want a JSON object", ai.Responses["200"])
+	}
+}
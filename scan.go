@@ -0,0 +1,91 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+// defaultParallelScanMin is the number of entries from which a scan of
+// them all is split across goroutines by default. Below it, scoring every
+// entry takes a few milliseconds at most, less than is won by splitting.
+const defaultParallelScanMin = 2000
+
+// scanConfig is how rankEntries splits a scan of every entry. The zero
+// value is the default.
+type scanConfig struct {
+	// minEntries is the number of entries from which scans are split; 0
+	// means defaultParallelScanMin.
+	minEntries int
+	// workers is the number of goroutines a scan is split across; 0 means
+	// GOMAXPROCS, 1 never splits.
+	workers int
+}
+
+// workersFor returns the number of goroutines a scan of n entries is
+// split across, 1 to scan them in the caller's.
+func (c scanConfig) workersFor(n int) int {
+	threshold := c.minEntries
+	if threshold == 0 {
+		threshold = defaultParallelScanMin
+	}
+	workers := c.workers
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if n < threshold || workers < 1 {
+		return 1
+	}
+	if workers > n {
+		workers = n
+	}
+	return workers
+}
+
+// scanEntries scores every entry of s with score, which appends to the
+// matches it is given, and returns the matches in entry order. Large scans
+// are split into one chunk per worker; each worker collects its own
+// matches, which are joined in chunk order, so the result is the same as
+// a scan in one goroutine.
+func (s *kbState) scanEntries(score func(matches []Match, entry KnowledgeEntry) []Match) []Match {
+	workers := s.scan.workersFor(len(s.entries))
+	if workers == 1 {
+		var matches []Match
+		for _, entry := range s.entries {
+			matches = score(matches, entry)
+		}
+		return matches
+	}
+	chunks := make([][]Match, workers)
+	size := (len(s.entries) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for w := range chunks {
+		start := w * size
+		end := min(start+size, len(s.entries))
+		if start >= end {
+			break
+		}
+		wg.Add(1)
+		go func(w int, entries []KnowledgeEntry) {
+			defer wg.Done()
+			for _, entry := range entries {
+				chunks[w] = score(chunks[w], entry)
+			}
+		}(w, s.entries[start:end])
+	}
+	wg.Wait()
+	var matches []Match
+	for _, chunk := range chunks {
+		matches = append(matches, chunk...)
+	}
+	return matches
+}
+
+// setScan sets how scans of every entry are split. The entries and their
+// vectors are unchanged, so the current state is republished as it is.
+func (kb *KnowledgeBase) setScan(scan scanConfig) {
+	defer kb.writeLock("setScan")()
+	kb.scan = scan
+	next := *kb.view()
+	next.scan = scan
+	kb.state.Store(&next)
+}
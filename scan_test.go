@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestScanWorkersFor(t *testing.T) {
+	procs := runtime.GOMAXPROCS(0)
+	tests := []struct {
+		config scanConfig
+		n      int
+		want   int
+	}{
+		{scanConfig{}, defaultParallelScanMin - 1, 1},
+		{scanConfig{}, defaultParallelScanMin, procs},
+		{scanConfig{minEntries: 10, workers: 4}, 9, 1},
+		{scanConfig{minEntries: 10, workers: 4}, 10, 4},
+		{scanConfig{minEntries: 1, workers: 8}, 3, 3},
+		{scanConfig{minEntries: 1, workers: 1}, 100000, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.config.workersFor(tt.n); got != tt.want {
+			t.Errorf("%+v.workersFor(%d) = %d, want %d", tt.config, tt.n, got, tt.want)
+		}
+	}
+}
+
+// TestParallelScanRanksAsSerial splits a scan across different numbers
+// of workers, more than there are entries among them, and expects the
+// ranking of one goroutine, with quarantined entries still left out.
+func TestParallelScanRanksAsSerial(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	entries := benchEntries(rng, 3001, 32)
+	for i := 0; i < len(entries); i += 100 {
+		entries[i].Quarantined = true
+	}
+	probes := make([][]float32, 20)
+	for i := range probes {
+		probes[i] = noisyVector(rng, entries[rng.Intn(len(entries))].Vector, 32, 0.4)
+	}
+	serial := &kbState{entries: entries, scan: scanConfig{workers: 1}}
+	for _, workers := range []int{2, 3, 7, len(entries) + 5} {
+		split := &kbState{entries: entries, scan: scanConfig{minEntries: 1, workers: workers}}
+		for i, probe := range probes {
+			want, got := serial.rankEntries(probe, nil), split.rankEntries(probe, nil)
+			if len(got) != len(want) {
+				t.Fatalf("%d workers, probe %d: %d matches, want %d", workers, i, len(got), len(want))
+			}
+			for j := range want {
+				if got[j].Entry.ID != want[j].Entry.ID || got[j].Score != want[j].Score {
+					t.Fatalf("%d workers, probe %d: match %d is %s at %v, want %s at %v",
+						workers, i, j, got[j].Entry.ID, got[j].Score, want[j].Entry.ID, want[j].Score)
+				}
+				if got[j].Entry.Quarantined {
+					t.Fatalf("%d workers ranked quarantined %s", workers, got[j].Entry.ID)
+				}
+			}
+		}
+	}
+}
+
+// TestParallelScanRace ranks through split scans while entries are
+// published and the scan settings change. Run it with -race.
+func TestParallelScanRace(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	kb := NewKnowledgeBase()
+	kb.setScan(scanConfig{minEntries: 100, workers: 4})
+	entries := benchEntries(rng, 1000, 16)
+	kb.updateEntries("test", func([]KnowledgeEntry) []KnowledgeEntry { return entries })
+	probe := noisyVector(rng, entries[0].Vector, 16, 0.4)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if matches := kb.view().rankEntries(probe, nil); len(matches) == 0 {
+					t.Error("no matches")
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		added := noisyVector(rng, nil, 16, 1)
+		kb.updateEntries("test", func(entries []KnowledgeEntry) []KnowledgeEntry {
+			return append(entries, KnowledgeEntry{ID: fmt.Sprint("added-", i), Vector: added})
+		})
+		kb.setScan(scanConfig{minEntries: 100, workers: 1 + i%5})
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// BenchmarkScan scores every entry of a synthetic 50k-entry knowledge
+// base in one goroutine, and split across 2, 4 and GOMAXPROCS workers.
+func BenchmarkScan(b *testing.B) {
+	rng := rand.New(rand.NewSource(50000))
+	entries := benchEntries(rng, 50000, 300)
+	probes := make([][]float32, 200)
+	for i := range probes {
+		probes[i] = noisyVector(rng, entries[rng.Intn(len(entries))].Vector, 300, 0.4)
+	}
+	counts := []int{1, 2, 4}
+	if procs := runtime.GOMAXPROCS(0); procs > 4 {
+		counts = append(counts, procs)
+	}
+	for _, workers := range counts {
+		state := &kbState{entries: entries, scan: scanConfig{minEntries: 1, workers: workers}}
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				state.rankEntries(probes[i%len(probes)], nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The JSON Schema for prompt.json is generated from promptFile, so the
+// schema and the loader cannot drift apart. Constraints beyond the Go type
+// come from `schema` struct tags: required, enum=a|b, minimum=, maximum=,
+// exclusiveMinimum=.
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// promptSchema is the schema of prompt.json.
+var promptSchema = buildSchema(reflect.TypeOf(promptFile{}))
+
+func buildSchema(t reflect.Type) map[string]interface{} {
+	schema := typeSchema(t)
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "AskGo prompt configuration"
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" || field.PkgPath != "" {
+				continue
+			}
+			prop := typeSchema(field.Type)
+			if applySchemaTag(prop, field.Tag.Get("schema")) {
+				required = append(required, name)
+			}
+			properties[name] = prop
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
+
+// applySchemaTag adds the constraints from a schema tag and reports whether
+// the field is required.
+func applySchemaTag(prop map[string]interface{}, tag string) bool {
+	required := false
+	for _, part := range strings.Split(tag, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		switch kv[0] {
+		case "required":
+			required = true
+		case "enum":
+			prop["enum"] = strings.Split(kv[1], "|")
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+			if n, err := strconv.ParseFloat(kv[1], 64); err == nil {
+				prop[kv[0]] = n
+			}
+		}
+	}
+	return required
+}
+
+// validateSchema checks a decoded JSON value against a schema generated by
+// typeSchema and returns one error per violation, prefixed with its JSON
+// pointer path.
+func validateSchema(value interface{}, schema map[string]interface{}, path string) []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", pointer(path), fmt.Sprintf(format, args...)))
+	}
+	if value == nil {
+		return nil
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			fail("expected object")
+			return errs
+		}
+		if required, ok := schema["required"].([]string); ok {
+			for _, name := range required {
+				if _, present := obj[name]; !present {
+					fail("missing required property %q", name)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		extra, _ := schema["additionalProperties"].(map[string]interface{})
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			v := obj[name]
+			if prop, ok := properties[name].(map[string]interface{}); ok {
+				errs = append(errs, validateSchema(v, prop, path+"/"+name)...)
+			} else if extra != nil {
+				errs = append(errs, validateSchema(v, extra, path+"/"+name)...)
+			}
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			fail("expected array")
+			return errs
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, v := range arr {
+			errs = append(errs, validateSchema(v, items, path+"/"+strconv.Itoa(i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			fail("expected string")
+			return errs
+		}
+		if enum, ok := schema["enum"].([]string); ok && !containsString(enum, s) {
+			fail("must be one of %s, got %q", strings.Join(enum, ", "), s)
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				fail("expected RFC 3339 date-time, got %q", s)
+			}
+		}
+	case "number", "integer":
+		n, ok := value.(float64)
+		if !ok {
+			fail("expected %s", schema["type"])
+			return errs
+		}
+		if schema["type"] == "integer" && n != float64(int64(n)) {
+			fail("expected integer")
+		}
+		if min, ok := schema["minimum"].(float64); ok && n < min {
+			fail("must be >= %v, got %v", min, n)
+		}
+		if min, ok := schema["exclusiveMinimum"].(float64); ok && n <= min {
+			fail("must be > %v, got %v", min, n)
+		}
+		if max, ok := schema["maximum"].(float64); ok && n > max {
+			fail("must be <= %v, got %v", max, n)
+		}
+		if max, ok := schema["exclusiveMaximum"].(float64); ok && n >= max {
+			fail("must be < %v, got %v", max, n)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("expected boolean")
+		}
+	}
+	return errs
+}
+
+func pointer(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func handlePromptSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	json.NewEncoder(w).Encode(promptSchema)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// defaultStarters are used when neither the scope nor prompt.json defines
+// any starters.
+var defaultStarters = []string{
+	"I'm here to help with Go programming. Could you specify what you'd like to learn about?",
+	"I can assist you with various Go topics. What interests you most?",
+	"Let me help you with Go! What would you like to explore?",
+}
+
+// builtinResponses are used for default_responses keys that neither the
+// scope nor prompt.json sets. "default" has no built-in; the starters are
+// used instead.
+var builtinResponses = map[string]string{
+	"error":    "Sorry, I couldn't make sense of that question. Could you rephrase it?",
+	"keywords": "Let's explore %s in detail. What specific aspects interest you?",
+}
+
+// checkKeywordsResponse makes sure a "keywords" response formats cleanly
+// with the keyword list instead of producing "%!s(MISSING)" junk.
+func checkKeywordsResponse(response string) error {
+	if strings.Count(response, "%s") != 1 || strings.Contains(fmt.Sprintf(response, ""), "%!") {
+		return fmt.Errorf("must contain exactly one %%s for the keywords and no other verbs, got %q", response)
+	}
+	return nil
+}
+
+// ScopeConfig overrides fallback responses for one scope. Anything it
+// doesn't set falls back to the global default_responses and starters.
+// Tags, when set, restrict retrieval to entries carrying any of them for
+// requests that don't ask for tags themselves.
+type ScopeConfig struct {
+	DefaultResponses map[string]string `json:"default_responses"`
+	Starters         []string          `json:"starters"`
+	Tags             []string          `json:"tags"`
+}
+
+// scopeTags makes the scope's tags the tag filter of a request that set
+// none, neither in the tags field nor with #tag operators.
+func (ai *AIEngine) scopeTags(opts *AskOptions) {
+	if len(opts.RequireTags) == 0 && len(opts.AnyTags) == 0 {
+		opts.AnyTags = ai.Scopes[opts.Scope].Tags
+	}
+}
+
+// defaultResponse looks a default response up in the language's section
+// first, then in the scope, then in the global set, then in the built-ins.
+// Unknown scopes and languages simply use the global set, and empty
+// responses count as unset.
+func (ai *AIEngine) defaultResponse(scope, language, key string) (string, bool) {
+	if response := ai.Languages[language].DefaultResponses[key]; response != "" {
+		return response, true
+	}
+	if response := ai.Scopes[scope].DefaultResponses[key]; response != "" {
+		return response, true
+	}
+	if response := ai.DefaultResponses[key]; response != "" {
+		return response, true
+	}
+	response, ok := builtinResponses[key]
+	return response, ok
+}
+
+// starter picks a conversation starter from the language's pool, the
+// scope's pool, the global pool or the built-ins, in that order of
+// preference.
+func (ai *AIEngine) starter(scope, language string) string {
+	starters := ai.Languages[language].Starters
+	if len(starters) == 0 {
+		starters = ai.Scopes[scope].Starters
+	}
+	if len(starters) == 0 {
+		starters = ai.Starters
+	}
+	if len(starters) == 0 {
+		starters = defaultStarters
+	}
+	ai.rngMu.Lock()
+	defer ai.rngMu.Unlock()
+	return starters[ai.rng.Intn(len(starters))]
+}
+
+// Seed reseeds the engine's random choices, making them reproducible.
+func (ai *AIEngine) Seed(seed int64) {
+	ai.rngMu.Lock()
+	ai.rng = rand.New(rand.NewSource(seed))
+	ai.rngMu.Unlock()
+}
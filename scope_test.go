@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestStarterSequenceIsSeeded pins the starters a fixed seed picks, and
+// picks them again after reseeding.
+func TestStarterSequenceIsSeeded(t *testing.T) {
+	ai := newTestEngine(t)
+	ai.Starters = []string{"a", "b", "c", "d"}
+	picks := func() []string {
+		ai.Seed(42)
+		var got []string
+		for i := 0; i < 8; i++ {
+			got = append(got, ai.starter("", ""))
+		}
+		return got
+	}
+	want := []string{"b", "d", "a", "c", "d", "b", "b", "a"}
+	if got := picks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("seed 42 picked %q, want %q", got, want)
+	}
+	if got := picks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("reseeded, picked %q, want %q", got, want)
+	}
+}
+
+// TestStarterPools picks starters from the language's pool, else the
+// scope's, else prompt.json's, else the built-ins.
+func TestStarterPools(t *testing.T) {
+	ai := newTestEngine(t)
+	ai.Starters = []string{"global"}
+	ai.Scopes = map[string]ScopeConfig{"web": {Starters: []string{"web"}}, "bare": {}}
+	ai.Languages = map[string]LanguageConfig{"ru": {Starters: []string{"ru"}}}
+	tests := []struct {
+		scope, language, want string
+	}{
+		{"web", "ru", "ru"},
+		{"web", "", "web"},
+		{"bare", "", "global"},
+		{"", "", "global"},
+		{"", "de", "global"},
+	}
+	for _, tt := range tests {
+		if got := ai.starter(tt.scope, tt.language); got != tt.want {
+			t.Errorf("scope %q, language %q: %q, want %q", tt.scope, tt.language, got, tt.want)
+		}
+	}
+	ai.Starters = nil
+	if got := ai.starter("", ""); !contains(defaultStarters, got) {
+		t.Errorf("without starters, picked %q, not a built-in", got)
+	}
+}
+
+// TestEmptyStarterPoolsWarn logs a warning for an empty starters list,
+// global or of a scope, and falls back past it.
+func TestEmptyStarterPoolsWarn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "askgo-prompts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prompt.json")
+	prompt := `{"knowledge_base": [{"id": "maps", "question": "What is a map?", "answer": "A hash table."}],
+		"starters": [], "scopes": {"web": {"starters": []}}}`
+	if err := ioutil.WriteFile(path, []byte(prompt), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	prompts, err := loadPrompts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"starters is empty, using the built-in starters", "scopes.web.starters is empty, using the global starters"} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("log %q lacks %q", logged.String(), want)
+		}
+	}
+	ai := newTestEngine(t, withPrompts(func(p *PromptConfig) { *p = *prompts }))
+	if got := ai.starter("web", ""); !contains(defaultStarters, got) {
+		t.Errorf("empty pools picked %q, not a built-in", got)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
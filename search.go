@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type searchRequest struct {
+	Text string `json:"text" schema:"required"`
+	// SessionID is the conversation whose context is scored; empty means
+	// none.
+	SessionID string   `json:"session_id"`
+	Scope     string   `json:"scope"`
+	Tags      []string `json:"tags"`
+	// K is the number of matches wanted, at most candidatesLimit; 0 means
+	// defaultCandidatesK.
+	K int `json:"k"`
+}
+
+// SearchMatch is a knowledge base entry ranked by /search, and whether its
+// score reaches the entry's own MinScore. RawScore is the cosine
+// similarity, and Score that weighted by the entry's Weight.
+type SearchMatch struct {
+	ID       string  `json:"id"`
+	Question string  `json:"question"`
+	RawScore float64 `json:"raw_score"`
+	Weight   float64 `json:"weight"`
+	Score    float64 `json:"score"`
+	MinScore float64 `json:"min_score,omitempty"`
+	Passed   bool    `json:"passed"`
+}
+
+// SearchGate is a retriever's candidate and whether its confidence passed
+// the minConfidence threshold every candidate must exceed to be served.
+type SearchGate struct {
+	Candidate
+	Threshold float64 `json:"threshold"`
+	Passed    bool    `json:"passed"`
+}
+
+// SearchResult explains how /ai would decide on a question.
+type SearchResult struct {
+	Keywords []string `json:"keywords"`
+	// Corrected maps the misspelled words of the question to the words
+	// they were read as.
+	Corrected    map[string]string `json:"corrected,omitempty"`
+	ContextScore float64           `json:"context_score"`
+	Matches      []SearchMatch     `json:"matches"`
+	Gates        []SearchGate      `json:"gates"`
+	// Source is the source of the answer /ai would serve, "default" for
+	// the fallback.
+	Source string `json:"source"`
+}
+
+// Search runs the answer pipeline on question as far as choosing the
+// answer and reports each step, without building the answer or touching
+// anything Ask would: no session, pattern, cache or statistics update.
+// Up to k knowledge base matches are returned.
+func (ai *AIEngine) Search(question string, opts AskOptions, k int) SearchResult {
+	ai.scopeTags(&opts)
+	opts.Language = ai.questionLanguage(question, opts)
+	state := ai.KB.view()
+	var corrected map[string]string
+	if ai.SpellCorrection {
+		question, corrected = state.correctSpelling(question, ai.embeddings())
+	}
+	q := newQuery(question, ai.embeddings(), ai.Limits, state, nil)
+	retrievals, matches := ai.retrieve(q, opts)
+	result := SearchResult{
+		Keywords:     q.Keywords,
+		Corrected:    corrected,
+		ContextScore: ai.evaluateContext(q.Keywords, opts.SessionID),
+		Matches:      []SearchMatch{},
+		Gates:        []SearchGate{},
+		Source:       SourceDefault,
+	}
+	if result.Keywords == nil {
+		result.Keywords = []string{}
+	}
+	for _, m := range matches[:min(k, len(matches))] {
+		result.Matches = append(result.Matches, SearchMatch{
+			ID:       m.Entry.ID,
+			Question: m.Entry.Question,
+			RawScore: m.RawScore,
+			Weight:   m.Entry.weight(),
+			Score:    m.Score,
+			MinScore: m.Entry.MinScore,
+			Passed:   m.Score >= m.Entry.MinScore,
+		})
+	}
+	if best := choose(retrievals); best >= 0 {
+		result.Source = retrievals[best].Source
+	}
+	for _, r := range retrievals {
+		result.Gates = append(result.Gates, SearchGate{
+			Candidate: r.Candidate,
+			Threshold: minConfidence,
+			Passed:    r.Confidence > minConfidence,
+		})
+	}
+	return result
+}
+
+// handleSearch serves POST /search: /ai in dry-run mode, which reports the
+// ranked knowledge base matches, the question's keywords, its context
+// score in the session and which retrievers passed the confidence
+// threshold, but learns nothing and leaves the session as it was.
+func handleSearch(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req searchRequest
+		if err := decodeRequest(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		chargeStage(r, StageExplain)
+		switch {
+		case strings.TrimSpace(req.Text) == "":
+			writeError(w, newError(ErrInvalidInput, "text is empty"))
+			return
+		case len(req.Text) > ai.Limits.maxQuestionBytes():
+			writeError(w, newError(ErrTooLarge, "text is %d bytes, the limit is %d", len(req.Text), ai.Limits.maxQuestionBytes()))
+			return
+		case req.K < 0 || req.K > candidatesLimit(r):
+			writeError(w, newError(ErrInvalidInput, "k must be between 1 and %d", candidatesLimit(r)))
+			return
+		case req.K == 0:
+			req.K = defaultCandidatesK
+		}
+		result := ai.Search(req.Text, AskOptions{SessionID: req.SessionID, Scope: req.Scope, AnyTags: req.Tags}, req.K)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// sessionCookie carries the session ID of clients, such as the bundled
+// page, that don't send session_id in the request body.
+const sessionCookie = "askgo_session"
+
+// maxSessionHistory bounds the interactions kept per session; the oldest
+// are dropped first.
+const maxSessionHistory = 50
+
+// defaultMaxSessions bounds the sessions kept at once unless -max-sessions
+// says otherwise.
+const defaultMaxSessions = 10000
+
+// session is the conversational state of one user: the interactions the
+// context retriever matches against and the pattern reinforcement that
+// shapes its context scores. Nothing in it is seen by other sessions. A
+// session ends after PatternBudget.TTL idle, or when it is the least
+// recently seen and MaxSessions are active, and its reinforcement is then
+// folded into the global Patterns.
+type session struct {
+	history []Interaction
+	weights map[string]float64
+	// offered is the weight the session's interactions asked for; kept
+	// is what the cap let through.
+	offered  float64
+	kept     float64
+	turns    int
+	lastSeen time.Time
+}
+
+// validSessionID accepts the same IDs as validRequestID, so session IDs
+// are as safe to store and echo.
+func validSessionID(id string) bool {
+	return validRequestID(id)
+}
+
+// requestSession picks the session of a /ai request: session_id from the
+// body if given, else the session cookie. A browser, which names its
+// Origin on every POST, is given a new cookie when it has neither, so the
+// bundled page keeps a conversation without sending IDs itself. Other
+// clients without an ID get no session and no conversational memory.
+func requestSession(w http.ResponseWriter, r *http.Request, bodyID string) (string, error) {
+	if bodyID != "" {
+		if !validSessionID(bodyID) {
+			return "", newError(ErrInvalidInput, "session_id must be 1 to %d letters, digits or -_.: characters", maxRequestIDLength)
+		}
+		return bodyID, nil
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil && validSessionID(cookie.Value) {
+		return cookie.Value, nil
+	}
+	if r.Header.Get("Origin") == "" {
+		return "", nil
+	}
+	id := newRequestID() + newRequestID()
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: id, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode})
+	return id, nil
+}
+
+// activeSession returns the session id unless it is unknown or has been
+// idle past the TTL and awaits folding. The caller holds ai.mu.
+func (ai *AIEngine) activeSession(id string, now time.Time) (*session, bool) {
+	s, ok := ai.sessions[id]
+	if !ok || id == "" || now.Sub(s.lastSeen) > ai.PatternBudget.TTL {
+		return nil, false
+	}
+	return s, true
+}
+
+// sessionFor returns the session id, starting it if needed after ending
+// the idle sessions and, when MaxSessions are still active, the least
+// recently seen one. The caller holds ai.mu for writing.
+func (ai *AIEngine) sessionFor(id string, now time.Time) *session {
+	ai.foldExpired(now)
+	if ai.sessions == nil {
+		ai.sessions = make(map[string]*session)
+	}
+	if s, ok := ai.sessions[id]; ok {
+		return s
+	}
+	for len(ai.sessions) >= ai.maxSessions() {
+		ai.endOldestSession()
+	}
+	s := &session{weights: make(map[string]float64), lastSeen: now}
+	ai.sessions[id] = s
+	return s
+}
+
+func (ai *AIEngine) maxSessions() int {
+	if ai.MaxSessions <= 0 {
+		return defaultMaxSessions
+	}
+	return ai.MaxSessions
+}
+
+// endOldestSession folds and drops the least recently seen session. The
+// caller holds ai.mu for writing.
+func (ai *AIEngine) endOldestSession() {
+	var oldest string
+	var oldestSeen time.Time
+	for id, s := range ai.sessions {
+		if oldest == "" || s.lastSeen.Before(oldestSeen) {
+			oldest, oldestSeen = id, s.lastSeen
+		}
+	}
+	ai.fold(ai.sessions[oldest])
+	delete(ai.sessions, oldest)
+	ai.patternStats.Evicted++
+}
+
+// endSession folds and drops the session id, if it is still active, as
+// when the connection owning it closes.
+func (ai *AIEngine) endSession(id string) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	if s, ok := ai.sessions[id]; ok {
+		ai.fold(s)
+		delete(ai.sessions, id)
+	}
+}
+
+// sessionHistory returns a copy of the interactions of the session id,
+// oldest first, or nil if it is not active.
+func (ai *AIEngine) sessionHistory(id string) []Interaction {
+	ai.mu.RLock()
+	defer ai.mu.RUnlock()
+	s, ok := ai.activeSession(id, time.Now())
+	if !ok {
+		return nil
+	}
+	return append([]Interaction(nil), s.history...)
+}
+
+// remember appends an interaction to the session's history, dropping the
+// oldest beyond maxSessionHistory. The caller holds ai.mu for writing.
+func (s *session) remember(interaction Interaction) {
+	s.history = append(s.history, interaction)
+	if extra := len(s.history) - maxSessionHistory; extra > 0 {
+		s.history = append(s.history[:0:0], s.history[extra:]...)
+	}
+}
+
+// SessionSnapshot is one active session as saved in snapshots, so a
+// restart doesn't cut conversations short.
+type SessionSnapshot struct {
+	ID       string             `json:"id"`
+	History  []Interaction      `json:"history"`
+	Weights  map[string]float64 `json:"weights"`
+	Offered  float64            `json:"offered"`
+	Kept     float64            `json:"kept"`
+	Turns    int                `json:"turns"`
+	LastSeen time.Time          `json:"last_seen"`
+}
+
+// sessionSnapshots copies the active sessions. The caller holds ai.mu.
+func (ai *AIEngine) sessionSnapshots() []SessionSnapshot {
+	var snaps []SessionSnapshot
+	for id, s := range ai.sessions {
+		weights := make(map[string]float64, len(s.weights))
+		for word, weight := range s.weights {
+			weights[word] = weight
+		}
+		snaps = append(snaps, SessionSnapshot{
+			ID:       id,
+			History:  append([]Interaction(nil), s.history...),
+			Weights:  weights,
+			Offered:  s.offered,
+			Kept:     s.kept,
+			Turns:    s.turns,
+			LastSeen: s.lastSeen,
+		})
+	}
+	return snaps
+}
+
+// restoreSessions replaces the active sessions. Sessions that have gone
+// idle since are folded on the next interaction like any other. The
+// caller holds ai.mu for writing.
+func (ai *AIEngine) restoreSessions(snaps []SessionSnapshot) {
+	ai.sessions = make(map[string]*session, len(snaps))
+	for _, snap := range snaps {
+		if !validSessionID(snap.ID) {
+			continue
+		}
+		weights := snap.Weights
+		if weights == nil {
+			weights = make(map[string]float64)
+		}
+		ai.sessions[snap.ID] = &session{
+			history:  snap.History,
+			weights:  weights,
+			offered:  snap.Offered,
+			kept:     snap.Kept,
+			turns:    snap.Turns,
+			lastSeen: snap.LastSeen,
+		}
+	}
+}
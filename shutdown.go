@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// inflightRequests counts the requests being served, so shutdown can
+// report how many it drained.
+type inflightRequests struct {
+	n int64
+}
+
+func (c *inflightRequests) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&c.n, 1)
+		defer atomic.AddInt64(&c.n, -1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *inflightRequests) count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// serveUntilSignal runs the listeners until SIGINT or SIGTERM arrives. It
+// returns an error only if a listener stopped on its own, e.g. because its
+// address was taken. With certs set, SIGHUP reloads the certificate.
+func serveUntilSignal(certs *certReloader, listeners ...func() error) error {
+	failed := make(chan error, len(listeners))
+	for _, serve := range listeners {
+		go func(serve func() error) {
+			if err := serve(); err != http.ErrServerClosed {
+				failed <- err
+			}
+		}(serve)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	if certs != nil {
+		signal.Notify(signals, syscall.SIGHUP)
+	}
+	defer signal.Stop(signals)
+	for {
+		select {
+		case err := <-failed:
+			return err
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reloadCerts(certs)
+				continue
+			}
+			log.Printf("Received %v, shutting down", sig)
+			return nil
+		}
+	}
+}
+
+// drain stops the server accepting requests and waits at most timeout for
+// the ones in flight to finish.
+func drain(server *http.Server, inflight *inflightRequests, timeout time.Duration) error {
+	pending := inflight.count()
+	log.Printf("Draining %d in-flight requests (timeout %s)", pending, timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	remaining := inflight.count()
+	if err != nil {
+		log.Printf("Drained %d of %d requests; %d still running when the shutdown deadline passed", pending-remaining, pending, remaining)
+		return err
+	}
+	log.Printf("Drained %d requests", pending)
+	return nil
+}
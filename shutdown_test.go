@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// slowServer serves requests that block until release is closed, counted
+// by inflight.
+func slowServer(t *testing.T, release <-chan struct{}) (*httptest.Server, *inflightRequests) {
+	t.Helper()
+	inflight := &inflightRequests{}
+	server := httptest.NewServer(inflight.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("done"))
+	})))
+	return server, inflight
+}
+
+// startRequests sends n requests and waits until the server has them all
+// in flight. Each request's status, or 0 on error, is sent on the
+// returned channel.
+func startRequests(t *testing.T, server *httptest.Server, inflight *inflightRequests, n int) <-chan int {
+	t.Helper()
+	statuses := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for inflight.count() < int64(n) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d requests in flight", inflight.count(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return statuses
+}
+
+// TestDrainWaitsForSlowRequests shuts down with slow requests in flight:
+// new connections are refused, and the slow ones finish before drain
+// returns.
+func TestDrainWaitsForSlowRequests(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	release := make(chan struct{})
+	server, inflight := slowServer(t, release)
+	defer server.Close()
+	statuses := startRequests(t, server, inflight, 3)
+
+	drained := make(chan error)
+	go func() { drained <- drain(server.Config, inflight, 5*time.Second) }()
+	// Once draining, the listener is closed.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("still accepting connections while draining")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("drain returned %v with requests in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if status := <-statuses; status != http.StatusOK {
+			t.Errorf("drained request got %d", status)
+		}
+	}
+	if !strings.Contains(logged.String(), "Drained 3 requests") {
+		t.Errorf("log %q doesn't count the drained requests", logged.String())
+	}
+}
+
+// TestDrainDeadline gives up on requests still running at the deadline
+// and reports them.
+func TestDrainDeadline(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	release := make(chan struct{})
+	server, inflight := slowServer(t, release)
+	// Closing the server waits for the abandoned requests, so release
+	// them first.
+	defer server.Close()
+	defer close(release)
+	startRequests(t, server, inflight, 2)
+
+	if err := drain(server.Config, inflight, 20*time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("drain returned %v, want the deadline error", err)
+	}
+	if !strings.Contains(logged.String(), "2 still running") {
+		t.Errorf("log %q doesn't count the abandoned requests", logged.String())
+	}
+}
+
+// TestServeUntilSignal returns on SIGTERM, and with the error of a
+// listener that fails on its own.
+func TestServeUntilSignal(t *testing.T) {
+	// Catch SIGTERM here too, so a signal sent before serveUntilSignal
+	// listens for it doesn't end the test binary.
+	caught := make(chan os.Signal, 1)
+	signal.Notify(caught, syscall.SIGTERM)
+	defer signal.Stop(caught)
+
+	server := &http.Server{Handler: http.NotFoundHandler()}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	returned := make(chan error)
+	go func() { returned <- serveUntilSignal(nil, func() error { return server.Serve(listener) }) }()
+	time.Sleep(50 * time.Millisecond)
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Errorf("returned %v on SIGTERM", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGTERM didn't stop serving")
+	}
+
+	failure := make(chan error)
+	taken := listener.Addr().String()
+	go func() {
+		failure <- serveUntilSignal(nil, func() error { return http.ListenAndServe(taken, nil) })
+	}()
+	if err := <-failure; err == nil {
+		t.Error("a listener that couldn't bind returned no error")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// defaultGreetingMaxEdits is the number of typos a greeting is matched
+// with by default; see matchGreeting.
+const defaultGreetingMaxEdits = 2
+
+// greetingAddressees may follow a greeting without making it a question,
+// as in "hi there" or "hello everyone".
+var greetingAddressees = map[string]bool{
+	"there": true, "all": true, "everyone": true, "everybody": true,
+	"folks": true, "friend": true, "friends": true, "bot": true,
+}
+
+// plainWords splits text into lowercase words, dropping the punctuation
+// around and between them, so "Hello!" and "hello" agree.
+func plainWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// editDistance is the Levenshtein distance between a and b, in runes.
+func editDistance(a, b string) int {
+	x, y := []rune(a), []rune(b)
+	row := make([]int, len(y)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			next := min(min(row[j]+1, row[j-1]+1), diagonal+cost)
+			diagonal, row[j] = row[j], next
+		}
+	}
+	return row[len(y)]
+}
+
+// matchGreeting returns the response to text if it is one of greetings,
+// ignoring case and punctuation and allowing a trailing addressee ("hi
+// there"). Failing an exact match, the greeting within maxEdits typos is
+// taken, the fewest first; short greetings allow at most a typo for every
+// two letters, so "hi" never matches "ok".
+func matchGreeting(greetings map[string]string, text string, maxEdits int) (string, bool) {
+	words := plainWords(text)
+	for len(words) > 1 && greetingAddressees[words[len(words)-1]] {
+		words = words[:len(words)-1]
+	}
+	if len(words) == 0 {
+		return "", false
+	}
+	said := strings.Join(words, " ")
+	keys := make([]string, 0, len(greetings))
+	for key := range greetings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	best, bestEdits := "", maxEdits+1
+	for _, key := range keys {
+		greeting := strings.Join(plainWords(key), " ")
+		if greeting == said {
+			return greetings[key], true
+		}
+		allowed := min(maxEdits, len([]rune(greeting))/2)
+		if edits := editDistance(greeting, said); edits <= allowed && edits < bestEdits {
+			best, bestEdits = key, edits
+		}
+	}
+	if bestEdits > maxEdits {
+		return "", false
+	}
+	return greetings[best], true
+}
+
+// matchCommonQuestion returns the response whose key occurs in text as
+// whole words, a key of several words as a phrase, so "go" matches "is go
+// fast" but not "mongodb". The longest key found wins, then the first in
+// order.
+func matchCommonQuestion(questions map[string]string, text string) (string, bool) {
+	words := plainWords(text)
+	keys := make([]string, 0, len(questions))
+	for key := range questions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	best, bestLen := "", 0
+	for _, key := range keys {
+		phrase := plainWords(key)
+		if len(phrase) > bestLen && containsPhrase(words, phrase) {
+			best, bestLen = key, len(phrase)
+		}
+	}
+	if bestLen == 0 {
+		return "", false
+	}
+	return questions[best], true
+}
+
+// containsPhrase reports whether phrase occurs in words as consecutive
+// words.
+func containsPhrase(words, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		found := true
+		for j, word := range phrase {
+			if words[i+j] != word {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestMatchGreeting(t *testing.T) {
+	greetings := map[string]string{
+		"hello":        "Hello!",
+		"hi":           "Hi!",
+		"good morning": "Good morning!",
+	}
+	tests := []struct {
+		text, want string
+	}{
+		{"hello", "Hello!"},
+		{"Hello!", "Hello!"},
+		{"  HELLO ,  ", "Hello!"},
+		{"hello?!", "Hello!"},
+		{"hi there", "Hi!"},
+		{"Hi, everyone!", "Hi!"},
+		{"Good   morning.", "Good morning!"},
+		{"good morning all", "Good morning!"},
+		// Typos, up to one for every two letters.
+		{"helo", "Hello!"},
+		{"hellooo", "Hello!"},
+		{"hlelo", "Hello!"},
+		{"god morning", "Good morning!"},
+		{"hii", "Hi!"},
+		// Not greetings.
+		{"ok", ""},
+		{"hi how do channels work", ""},
+		{"there", ""},
+		{"!!!", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, ok := matchGreeting(greetings, tt.text, defaultGreetingMaxEdits)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("matchGreeting(%q) = %q, %v; want %q", tt.text, got, ok, tt.want)
+		}
+	}
+	if got, ok := matchGreeting(greetings, "helo", 0); ok {
+		t.Errorf("with no typos allowed, \"helo\" matched %q", got)
+	}
+}
+
+func TestMatchCommonQuestion(t *testing.T) {
+	questions := map[string]string{
+		"go":          "A programming language.",
+		"who are you": "A Go assistant.",
+		"you":         "Me?",
+		"how":         "How what?",
+	}
+	tests := []struct {
+		text, want string
+	}{
+		{"is go fast?", "A programming language."},
+		{"Go!", "A programming language."},
+		{"should I use mongodb", ""},
+		{"I like golang", ""},
+		{"Who are you?", "A Go assistant."},
+		// The longest key wins over the words inside it.
+		{"so who are you then", "A Go assistant."},
+		{"are you there", "Me?"},
+		{"you who are", "Me?"},
+		{"somehow it broke", ""},
+		{"youtube", ""},
+		{"how", "How what?"},
+	}
+	for _, tt := range tests {
+		got, ok := matchCommonQuestion(questions, tt.text)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("matchCommonQuestion(%q) = %q, %v; want %q", tt.text, got, ok, tt.want)
+		}
+	}
+}
+
+// TestSmallTalkVariantsAnswered asks the engine the punctuated and
+// mistyped greetings that used to fall through to the default response.
+func TestSmallTalkVariantsAnswered(t *testing.T) {
+	ai := newTestEngine(t)
+	for question, source := range map[string]string{
+		"Hello!":           SourceGreeting,
+		"hello there":      SourceGreeting,
+		"helo":             SourceGreeting,
+		"Who are you?":     SourceCommonQuestion,
+		"so, who are you?": SourceCommonQuestion,
+	} {
+		if answer, _ := ai.Ask(question, AskOptions{}); answer.Source != source {
+			t.Errorf("%q answered %q from %s, want %s", question, answer.Text, answer.Source, source)
+		}
+	}
+}
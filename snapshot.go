@@ -0,0 +1,386 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const snapshotTimeFormat = "20060102T150405.000Z"
+
+// Snapshot format versions. Each snapshot records the format it was
+// written in and the oldest format able to read it, so a binary from
+// either side of a rolling deploy can tell whether a file is safe to load.
+// Files without a version predate versioning and are format 1.
+const (
+	snapshotFormat        = 2
+	snapshotMinCompatible = 1
+)
+
+// errSnapshotFormat marks snapshots this binary is too old to read.
+var errSnapshotFormat = errors.New("incompatible snapshot format")
+
+// Snapshot is the mutable engine state that would otherwise be lost on a
+// crash: what users have taught, the active conversations and what ended
+// ones have reinforced, the experiment counters and the feedback scores.
+type Snapshot struct {
+	Format        int       `json:"format"`
+	MinCompatible int       `json:"min_compatible"`
+	TakenAt       time.Time `json:"taken_at"`
+	// Analyzer is the analyzerVersion of the binary that took the
+	// snapshot; each interaction also carries its own.
+	Analyzer int `json:"analyzer"`
+	// Embeddings identifies the embeddings the state was built against.
+	Embeddings EmbeddingInfo      `json:"embeddings"`
+	Learned    []LearnedEntry     `json:"learned"`
+	Patterns   map[string]float64 `json:"patterns"`
+	// Sessions are the active conversations. Snapshots from before
+	// sessions kept one global context_memory instead, which is dropped
+	// on restore since it can't be attributed to anyone.
+	Sessions    []SessionSnapshot               `json:"sessions"`
+	Experiments map[string]map[int]VariantStats `json:"experiments"`
+	Feedback    map[string]FeedbackScore        `json:"feedback"`
+	// Provenance describes the server that took the snapshot. It is
+	// informational; restoring doesn't read it.
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// snapshot copies the mutable state of the engine.
+func (ai *AIEngine) snapshot() Snapshot {
+	s := Snapshot{
+		Format:        snapshotFormat,
+		MinCompatible: snapshotMinCompatible,
+		TakenAt:       time.Now().UTC(),
+		Analyzer:      analyzerVersion,
+		Embeddings:    ai.embeddingInfo(),
+		Experiments:   ai.Experiments.all(),
+		Feedback:      ai.Feedback.all(),
+	}
+	provenance := ai.provenance()
+	s.Provenance = &provenance
+
+	for _, entry := range ai.KB.view().learned {
+		s.Learned = append(s.Learned, entry)
+	}
+	sort.Slice(s.Learned, func(i, j int) bool { return s.Learned[i].ID < s.Learned[j].ID })
+
+	ai.mu.RLock()
+	s.Patterns = make(map[string]float64, len(ai.Patterns))
+	for word, weight := range ai.Patterns {
+		s.Patterns[word] = weight
+	}
+	s.Sessions = ai.sessionSnapshots()
+	ai.mu.RUnlock()
+	sort.Slice(s.Sessions, func(i, j int) bool { return s.Sessions[i].ID < s.Sessions[j].ID })
+	return s
+}
+
+// restore replaces the mutable state of the engine with a snapshot.
+func (ai *AIEngine) restore(s Snapshot) {
+	learned := make(map[string]LearnedEntry, len(s.Learned))
+	for _, entry := range s.Learned {
+		learned[normalizeQuestion(entry.Question)] = entry
+	}
+	ai.KB.replaceLearned("restore", learned)
+	entryIDs := make(map[string]bool)
+	for _, entry := range ai.KB.view().entries {
+		entryIDs[entry.ID] = true
+	}
+
+	patterns := s.Patterns
+	if patterns == nil {
+		patterns = make(map[string]float64)
+	}
+	ai.mu.Lock()
+	ai.Patterns = patterns
+	ai.restoreSessions(s.Sessions)
+	ai.mu.Unlock()
+
+	ai.Experiments.restore(s.Experiments)
+	// Entries deleted from prompt.json since the snapshot lose their
+	// feedback with them.
+	ai.Feedback.restore(s.Feedback, func(id string) bool { return entryIDs[id] })
+}
+
+// EmbeddingInfo identifies a set of embeddings by dimension and content
+// hash.
+type EmbeddingInfo struct {
+	Dimension int    `json:"dimension"`
+	Hash      string `json:"hash"`
+}
+
+func (e EmbeddingInfo) String() string {
+	return fmt.Sprintf("%d-dim %s", e.Dimension, e.Hash)
+}
+
+func hashEmbeddings(embeddings *EmbeddingStore) EmbeddingInfo {
+	words := append([]string(nil), embeddings.Words()...)
+	sort.Strings(words)
+	h := sha1.New()
+	var buf [4]byte
+	for _, word := range words {
+		h.Write([]byte(word))
+		h.Write([]byte{0})
+		for _, x := range embeddings.Lookup(word) {
+			binary.LittleEndian.PutUint32(buf[:], math.Float32bits(x))
+			h.Write(buf[:])
+		}
+	}
+	return EmbeddingInfo{
+		Dimension: embeddings.Dimension(),
+		Hash:      hex.EncodeToString(h.Sum(nil))[:12],
+	}
+}
+
+// embeddingInfo returns the info of the current embeddings, hashing them
+// on first use after a swap.
+func (ai *AIEngine) embeddingInfo() EmbeddingInfo {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	if ai.embeddingsInfo == nil {
+		info := hashEmbeddings(ai.Embeddings)
+		ai.embeddingsInfo = &info
+	}
+	return *ai.embeddingsInfo
+}
+
+// restoreSnapshot restores a snapshot after checking it was built against
+// embeddings of the current dimension. On a mismatch it refuses unless
+// reindex is set, in which case the restored state is re-vectorized against
+// the current embeddings. A changed hash with the same dimension is only
+// reported. Either way the outcome is kept for /healthz.
+func (ai *AIEngine) restoreSnapshot(s Snapshot, reindex bool) error {
+	current := ai.embeddingInfo()
+	if s.Embeddings.Hash == "" || s.Embeddings == current {
+		ai.restore(s)
+		return nil
+	}
+	status := fmt.Sprintf("snapshot built with %v embeddings, running with %v", s.Embeddings, current)
+	if s.Embeddings.Dimension != current.Dimension {
+		if !reindex {
+			return fmt.Errorf("%s; restart with -reindex-on-mismatch to re-vectorize", status)
+		}
+		ai.restore(s)
+		ai.Reindex(ai.embeddings())
+		status += "; re-vectorized against the current embeddings"
+	} else {
+		ai.restore(s)
+		status += "; same dimension, restored as is"
+	}
+	log.Println("Embedding mismatch:", status)
+	ai.mu.Lock()
+	ai.embeddingMismatch = status
+	ai.mu.Unlock()
+	return nil
+}
+
+// Snapshotter periodically writes the engine state to timestamped files
+// in a directory and prunes old ones.
+type Snapshotter struct {
+	ai       *AIEngine
+	dir      string
+	interval time.Duration
+	// keep and maxAge bound the retained snapshots; zero disables either
+	// bound. The newest snapshot is always kept.
+	keep   int
+	maxAge time.Duration
+
+	mu      sync.Mutex
+	last    time.Time
+	lastErr error
+	// refused counts snapshots skipped for an incompatible format.
+	refused int
+}
+
+func NewSnapshotter(ai *AIEngine, dir string, interval time.Duration, keep int, maxAge time.Duration) *Snapshotter {
+	return &Snapshotter{ai: ai, dir: dir, interval: interval, keep: keep, maxAge: maxAge}
+}
+
+// Start takes a snapshot every interval until the process exits.
+func (s *Snapshotter) Start() {
+	go func() {
+		for range time.Tick(s.interval) {
+			if _, err := s.Take(); err != nil {
+				log.Printf("Snapshot failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Take writes a snapshot and prunes old ones. The file is written
+// atomically, so a crash mid-write never leaves a truncated snapshot
+// behind.
+func (s *Snapshotter) Take() (string, error) {
+	snap := s.ai.snapshot()
+	path, err := s.write(snap)
+	s.mu.Lock()
+	s.lastErr = err
+	if err == nil {
+		s.last = snap.TakenAt
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return "", newError(ErrStoreUnavailable, "snapshot not written: %v", err)
+	}
+	s.prune(snap.TakenAt)
+	return path, nil
+}
+
+func (s *Snapshotter) write(snap Snapshot) (string, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.dir, "snapshot-"+snap.TakenAt.Format(snapshotTimeFormat)+".json")
+	if err := writeFileAtomic(path, data); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	return writeFileAtomicFunc(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeFileAtomicFunc is writeFileAtomic for content write streams.
+func writeFileAtomicFunc(path string, write func(w io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// files lists the snapshots in the directory, newest first.
+func (s *Snapshotter) files() []string {
+	paths, _ := filepath.Glob(filepath.Join(s.dir, "snapshot-*.json"))
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	return paths
+}
+
+func (s *Snapshotter) prune(now time.Time) {
+	for i, path := range s.files() {
+		if i == 0 {
+			continue
+		}
+		expired := s.keep > 0 && i >= s.keep
+		if s.maxAge > 0 {
+			if info, err := os.Stat(path); err == nil && now.Sub(info.ModTime()) > s.maxAge {
+				expired = true
+			}
+		}
+		if expired {
+			if err := os.Remove(path); err != nil {
+				log.Printf("Pruning snapshot %s: %v", path, err)
+			}
+		}
+	}
+}
+
+// LoadLatest restores the newest readable snapshot, falling back to older
+// ones when a file is corrupt or in a format this binary can't read. It returns the path restored, or "" when
+// there is no usable snapshot, and fails if the snapshot can't be restored
+// against the current embeddings.
+func (s *Snapshotter) LoadLatest(reindex bool) (string, error) {
+	for _, path := range s.files() {
+		snap, err := loadSnapshotFile(path)
+		if err != nil {
+			log.Printf("Skipping snapshot %s: %v", path, err)
+			if errors.Is(err, errSnapshotFormat) {
+				s.mu.Lock()
+				s.refused++
+				s.mu.Unlock()
+			}
+			continue
+		}
+		if err := s.ai.restoreSnapshot(snap, reindex); err != nil {
+			return path, err
+		}
+		s.mu.Lock()
+		s.last = snap.TakenAt
+		s.mu.Unlock()
+		return path, nil
+	}
+	return "", nil
+}
+
+func loadSnapshotFile(path string) (Snapshot, error) {
+	var snap Snapshot
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return snap, err
+	}
+	// The version is read first: a newer format may lay out the rest so
+	// this binary can't decode it.
+	var version struct {
+		Format        int `json:"format"`
+		MinCompatible int `json:"min_compatible"`
+	}
+	json.Unmarshal(data, &version)
+	if version.Format == 0 {
+		version.Format, version.MinCompatible = 1, 1
+	}
+	if version.MinCompatible == 0 {
+		version.MinCompatible = version.Format
+	}
+	if version.MinCompatible > snapshotFormat {
+		return snap, fmt.Errorf("%w: written in format %d, which needs format %d or newer; this binary reads format %d",
+			errSnapshotFormat, version.Format, version.MinCompatible, snapshotFormat)
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, err
+	}
+	snap.Format, snap.MinCompatible = version.Format, version.MinCompatible
+	if snap.TakenAt.IsZero() {
+		return snap, errors.New("missing taken_at")
+	}
+	return snap, nil
+}
+
+// Refused returns how many snapshots were skipped for an incompatible
+// format.
+func (s *Snapshotter) Refused() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refused
+}
+
+// Last returns the time of the last snapshot taken or restored and the
+// error of the last attempt, if it failed.
+func (s *Snapshotter) Last() (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last, s.lastErr
+}
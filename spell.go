@@ -0,0 +1,204 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// minSpellWordLen is the length, in runes, below which words are never
+// corrected: short words are too often something the dictionary lacks.
+const minSpellWordLen = 4
+
+// spellDictionary counts the words of the knowledge base and learned
+// questions. With the vocabulary of the embeddings, through vocabIndex, it
+// is what questions are spelled against.
+type spellDictionary struct {
+	counts map[string]int
+}
+
+// with returns d counting the words of the added questions too, and no
+// longer those of the removed ones, so a write only reads the questions
+// it changes. d, which may be nil, is not modified.
+func (d *spellDictionary) with(added, removed []string) *spellDictionary {
+	if d != nil && len(added)+len(removed) == 0 {
+		return d
+	}
+	next := &spellDictionary{counts: make(map[string]int)}
+	if d != nil {
+		for word, n := range d.counts {
+			next.counts[word] = n
+		}
+	}
+	for _, question := range removed {
+		for _, word := range plainWords(question) {
+			if next.counts[word]--; next.counts[word] <= 0 {
+				delete(next.counts, word)
+			}
+		}
+	}
+	for _, question := range added {
+		for _, word := range plainWords(question) {
+			next.counts[word]++
+		}
+	}
+	return next
+}
+
+// spellEdits is the number of typos corrected in a word of n runes: one
+// in short words, two in longer ones.
+func spellEdits(n int) int {
+	if n <= 5 {
+		return 1
+	}
+	return 2
+}
+
+// known reports whether word, lowercase, is in the embeddings, directly or
+// by its stem, or in a question of s.
+func (s *kbState) known(word string, embeddings *EmbeddingStore) bool {
+	if s.spelling != nil && s.spelling.counts[word] > 0 {
+		return true
+	}
+	if embeddings.Lookup(word) != nil {
+		return true
+	}
+	stem := stemWord(word)
+	if embeddings.Lookup(stem) != nil {
+		return true
+	}
+	if s.vocab != nil {
+		_, ok := s.vocab.stems[stem]
+		return ok
+	}
+	return false
+}
+
+// correctWord returns the correction of word, lowercase and unknown to s:
+// the dictionary word fewest edits away, within spellEdits. Ties go to the
+// word most used in questions, then to the one earlier in the embeddings,
+// which list words most frequent first as the common files do, then to
+// the first alphabetically.
+func (s *kbState) correctWord(word string) (string, bool) {
+	n := utf8.RuneCountInString(word)
+	edits := spellEdits(n)
+	type candidate struct {
+		word  string
+		edits int
+		count int
+		rank  int
+	}
+	var best *candidate
+	consider := func(w string, rank int) {
+		if d := utf8.RuneCountInString(w) - n; d > edits || d < -edits {
+			return
+		}
+		c := candidate{word: w, edits: editDistance(word, w), rank: rank}
+		if c.edits > edits || c.edits == 0 {
+			return
+		}
+		if s.spelling != nil {
+			c.count = s.spelling.counts[w]
+		}
+		switch {
+		case best == nil:
+		case c.edits != best.edits:
+			if c.edits > best.edits {
+				return
+			}
+		case c.count != best.count:
+			if c.count < best.count {
+				return
+			}
+		case c.rank != best.rank:
+			if c.rank > best.rank {
+				return
+			}
+		case w > best.word:
+			return
+		}
+		best = &c
+	}
+	if s.vocab != nil {
+		ranked := make(map[int32]bool)
+		for _, gram := range ngrams(word) {
+			for _, pos := range s.vocab.ngrams[gram] {
+				if !ranked[pos] {
+					ranked[pos] = true
+					consider(s.vocab.words[pos], int(pos))
+				}
+			}
+		}
+	}
+	if s.spelling != nil {
+		for w := range s.spelling.counts {
+			consider(w, math.MaxInt32)
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return best.word, true
+}
+
+// correctSpelling rewrites the words of text that are neither in the
+// embeddings nor in any question, but are a typo or two from a word that
+// is, and returns the rewritten text with the corrections made, by
+// original word; nil if none were. Words with digits, underscores or CJK
+// characters, like "utf8", and short words are left as they are.
+func (s *kbState) correctSpelling(text string, embeddings *EmbeddingStore) (string, map[string]string) {
+	inWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_' }
+	var corrected map[string]string
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if !inWord(r) {
+			b.WriteString(text[i : i+size])
+			i += size
+			continue
+		}
+		j := i
+		for j < len(text) {
+			r, size := utf8.DecodeRuneInString(text[j:])
+			if !inWord(r) {
+				break
+			}
+			j += size
+		}
+		word := text[i:j]
+		i = j
+		lower := strings.ToLower(word)
+		fix, ok := "", false
+		if utf8.RuneCountInString(lower) >= minSpellWordLen && plainLetters(lower) && !s.known(lower, embeddings) {
+			fix, ok = s.correctWord(lower)
+		}
+		if !ok {
+			b.WriteString(word)
+			continue
+		}
+		if corrected == nil {
+			corrected = make(map[string]string)
+		}
+		corrected[lower] = fix
+		if first, _ := utf8.DecodeRuneInString(word); unicode.IsUpper(first) {
+			r, size := utf8.DecodeRuneInString(fix)
+			fix = string(unicode.ToUpper(r)) + fix[size:]
+		}
+		b.WriteString(fix)
+	}
+	if corrected == nil {
+		return text, nil
+	}
+	return b.String(), corrected
+}
+
+// plainLetters reports whether word is all letters and none of them CJK.
+func plainLetters(word string) bool {
+	for _, r := range word {
+		if !unicode.IsLetter(r) || isCJK(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS entries (
+	id       TEXT PRIMARY KEY,
+	entry    TEXT NOT NULL,
+	vector   BLOB,
+	analyzer INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS learned (
+	key         TEXT PRIMARY KEY,
+	id          TEXT NOT NULL,
+	question    TEXT NOT NULL,
+	answer      TEXT NOT NULL,
+	source_url  TEXT NOT NULL,
+	quarantined INTEGER NOT NULL DEFAULT 0,
+	tags        TEXT NOT NULL DEFAULT '',
+	min_score   REAL NOT NULL DEFAULT 0
+);`
+
+// learnedColumns are the columns of the learned table added since it was
+// first created, with their definitions, which databases created before
+// them are brought up to date with. tags holds a JSON array, or nothing.
+var learnedColumns = []struct{ name, definition string }{
+	{"quarantined", "INTEGER NOT NULL DEFAULT 0"},
+	{"tags", "TEXT NOT NULL DEFAULT ''"},
+	{"min_score", "REAL NOT NULL DEFAULT 0"},
+}
+
+// sqliteStore keeps the knowledge base in a SQLite database. Entries are
+// stored as JSON with the vector split out into a little-endian float64
+// blob. Writes are synchronous, so a learned entry is on disk once Learn
+// returns.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_synchronous=FULL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// One connection serializes writers; the knowledge base already
+	// serves reads from memory.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, column := range learnedColumns {
+		var found int
+		err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('learned') WHERE name = ?`, column.name).Scan(&found)
+		if err == nil && found == 0 {
+			_, err = db.Exec(`ALTER TABLE learned ADD COLUMN ` + column.name + ` ` + column.definition)
+		}
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// encodeVector stores vector as float64s, as it has been since vectors
+// were float64 in memory.
+func encodeVector(vector []float32) []byte {
+	b := make([]byte, 8*len(vector))
+	for i, x := range vector {
+		binary.LittleEndian.PutUint64(b[8*i:], math.Float64bits(float64(x)))
+	}
+	return b
+}
+
+func decodeVector(b []byte) []float32 {
+	vector := make([]float32, len(b)/8)
+	for i := range vector {
+		vector[i] = float32(math.Float64frombits(binary.LittleEndian.Uint64(b[8*i:])))
+	}
+	return vector
+}
+
+func (s *sqliteStore) AddEntry(entries ...KnowledgeEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO entries (id, entry, vector, analyzer) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, entry := range entries {
+		vector := entry.Vector
+		entry.Vector = nil
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(entry.ID, data, encodeVector(vector), entry.Analyzer); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Learn(entry LearnedEntry) error {
+	var tags []byte
+	if len(entry.Tags) > 0 {
+		var err error
+		if tags, err = json.Marshal(entry.Tags); err != nil {
+			return err
+		}
+	}
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO learned (key, id, question, answer, source_url, quarantined, tags, min_score) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		normalizeQuestion(entry.Question), entry.ID, entry.Question, entry.Answer, entry.SourceURL, entry.Quarantined, string(tags), entry.MinScore)
+	return err
+}
+
+func (s *sqliteStore) Forget(question string) error {
+	_, err := s.db.Exec(`DELETE FROM learned WHERE key = ?`, normalizeQuestion(question))
+	return err
+}
+
+func (s *sqliteStore) ListEntries() ([]KnowledgeEntry, error) {
+	rows, err := s.db.Query(`SELECT entry, vector, analyzer FROM entries ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []KnowledgeEntry
+	for rows.Next() {
+		var data, vector []byte
+		var entry KnowledgeEntry
+		var analyzer int
+		if err := rows.Scan(&data, &vector, &analyzer); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, err
+		}
+		entry.Vector = decodeVector(vector)
+		entry.Analyzer = analyzer
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *sqliteStore) ListLearned() ([]LearnedEntry, error) {
+	rows, err := s.db.Query(`SELECT id, question, answer, source_url, quarantined, tags, min_score FROM learned ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var learned []LearnedEntry
+	for rows.Next() {
+		var entry LearnedEntry
+		var tags string
+		if err := rows.Scan(&entry.ID, &entry.Question, &entry.Answer, &entry.SourceURL, &entry.Quarantined, &tags, &entry.MinScore); err != nil {
+			return nil, err
+		}
+		if tags != "" {
+			if err := json.Unmarshal([]byte(tags), &entry.Tags); err != nil {
+				return nil, err
+			}
+		}
+		learned = append(learned, entry)
+	}
+	return learned, rows.Err()
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openTestStore opens a SQLite store in the fixture's scratch directory.
+func openTestStore(t *testing.T, f *routeFixture) *sqliteStore {
+	t.Helper()
+	store, err := openSQLiteStore(filepath.Join(f.dir, "askgo.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// storedLearned returns the learned entries in store by question.
+func storedLearned(t *testing.T, store KnowledgeStore) map[string]LearnedEntry {
+	t.Helper()
+	learned, err := store.ListLearned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byQuestion := make(map[string]LearnedEntry, len(learned))
+	for _, entry := range learned {
+		byQuestion[entry.Question] = entry
+	}
+	return byQuestion
+}
+
+// TestSQLiteStoreRoundTrip writes entries, vectors included, and learned
+// entries, replaces and forgets some, and reads back what is left after
+// reopening the database.
+func TestSQLiteStoreRoundTrip(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	store := openTestStore(t, f)
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	entries := []KnowledgeEntry{
+		{ID: "a", Question: "What is a goroutine?", Answer: "A lightweight thread.", Vector: []float32{0.5, -0.25, 1}, Analyzer: analyzerVersion, CreatedAt: created, VerifiedAt: created},
+		{ID: "b", Question: "What is a channel?", Answer: "A typed pipe.", Vector: []float32{0, 1, 0}, Analyzer: analyzerVersion, CreatedAt: created, VerifiedAt: created, NeedsRewrite: true},
+	}
+	if err := store.AddEntry(entries...); err != nil {
+		t.Fatal(err)
+	}
+	entries[1].Answer = "A typed conduit."
+	if err := store.AddEntry(entries[1]); err != nil {
+		t.Fatal(err)
+	}
+	minScore := 0.8
+	for _, entry := range []LearnedEntry{
+		{Question: "How do I close a channel?", Answer: "The sender calls close.", Tags: []string{"channels"}, MinScore: minScore},
+		{Question: "What is nil?", Answer: "The zero value of pointers."},
+		{Question: "What is nil?", Answer: "The zero value of pointers, maps and more."},
+		{Question: "Is Go fast?", Answer: "Fast enough."},
+	} {
+		entry.ID = learnedID(normalizeQuestion(entry.Question))
+		if err := store.Learn(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Forget("is go fast"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store = openTestStore(t, f)
+	defer store.Close()
+	got, err := store.ListEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("entries read back as %+v, want %+v", got, entries)
+	}
+	learned := storedLearned(t, store)
+	if len(learned) != 2 {
+		t.Errorf("%d learned entries read back, want 2: %+v", len(learned), learned)
+	}
+	closing := learned["How do I close a channel?"]
+	if closing.Answer != "The sender calls close." || !reflect.DeepEqual(closing.Tags, []string{"channels"}) || closing.MinScore != minScore {
+		t.Errorf("learned entry read back as %+v", closing)
+	}
+	if nilEntry := learned["What is nil?"]; nilEntry.Answer != "The zero value of pointers, maps and more." {
+		t.Errorf("replaced learned entry read back as %+v", nilEntry)
+	}
+}
+
+// TestLearnIsDurableImmediately teaches and forgets answers through the
+// HTTP handlers and finds each change in the database, read through a
+// second connection, as soon as the request returns.
+func TestLearnIsDurableImmediately(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	store := openTestStore(t, f)
+	defer store.Close()
+	if _, err := f.deps.ai.openStore(store); err != nil {
+		t.Fatal(err)
+	}
+	reader := openTestStore(t, f)
+	defer reader.Close()
+
+	body, _ := json.Marshal(LearnRequest{Question: "How do I close a channel?", Answer: "The sender calls close."})
+	if resp, reply := f.do(t, http.MethodPost, "/learn", string(body), testAdminKey, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("/learn status %d: %s", resp.StatusCode, reply)
+	}
+	if entry, ok := storedLearned(t, reader)["How do I close a channel?"]; !ok || entry.Answer != "The sender calls close." {
+		t.Fatalf("learned entry not on disk after /learn: %+v", entry)
+	}
+
+	body, _ = json.Marshal(LearnedRemoval{Question: "how do i close a channel"})
+	if resp, reply := f.do(t, http.MethodDelete, "/learn", string(body), testAdminKey, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE /learn status %d: %s", resp.StatusCode, reply)
+	}
+	if entry, ok := storedLearned(t, reader)["How do I close a channel?"]; ok {
+		t.Errorf("forgotten entry still on disk: %+v", entry)
+	}
+}
+
+// TestSQLiteStoreConcurrentRequests teaches answers through /learn while
+// other requests ask for them, and expects every answer in the database
+// once the requests return. Run it with -race.
+func TestSQLiteStoreConcurrentRequests(t *testing.T) {
+	f := newRouteFixture(t)
+	defer f.close()
+	store := openTestStore(t, f)
+	defer store.Close()
+	if _, err := f.deps.ai.openStore(store); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, perWriter = 4, 10
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				body, _ := json.Marshal(LearnRequest{Question: fmt.Sprintf("What is answer %d-%d?", w, i), Answer: fmt.Sprintf("Answer %d-%d.", w, i)})
+				if resp, reply := f.do(t, http.MethodPost, "/learn", string(body), testAdminKey, nil); resp.StatusCode != http.StatusOK {
+					t.Errorf("/learn status %d: %s", resp.StatusCode, reply)
+					return
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				body := fmt.Sprintf(`{"text": "What is answer %d-%d?"}`, w, i)
+				if resp, reply := f.do(t, http.MethodPost, "/ai", body, "", nil); resp.StatusCode != http.StatusOK {
+					t.Errorf("/ai status %d: %s", resp.StatusCode, reply)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	learned := storedLearned(t, store)
+	for w := 0; w < writers; w++ {
+		for i := 0; i < perWriter; i++ {
+			question := fmt.Sprintf("What is answer %d-%d?", w, i)
+			if entry, ok := learned[question]; !ok || entry.Answer != fmt.Sprintf("Answer %d-%d.", w, i) {
+				t.Errorf("%q stored as %+v", question, entry)
+			}
+		}
+	}
+}
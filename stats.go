@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// statsDays is the window the stats cover.
+	statsDays = 7
+	// otherTopic collects topics below the public floor and untagged
+	// answers.
+	otherTopic = "other"
+)
+
+// dayStats counts the questions of one day.
+type dayStats struct {
+	Total    int
+	Answered int
+	BySource map[string]int
+	ByTopic  map[string]int
+}
+
+// AnswerStats counts questions per day by source and by the tags of the
+// entry served. It never stores question text.
+type AnswerStats struct {
+	mu   sync.Mutex
+	days map[string]*dayStats
+}
+
+func NewAnswerStats() *AnswerStats {
+	return &AnswerStats{days: make(map[string]*dayStats)}
+}
+
+func (s *AnswerStats) record(answer Answer, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := now.UTC().Format("2006-01-02")
+	day, ok := s.days[key]
+	if !ok {
+		day = &dayStats{BySource: make(map[string]int), ByTopic: make(map[string]int)}
+		s.days[key] = day
+		s.prune(now)
+	}
+	day.Total++
+	day.BySource[answer.Source]++
+	if answer.LowConfidence() {
+		return
+	}
+	day.Answered++
+	if answer.Entry == nil || len(answer.Entry.Tags) == 0 {
+		day.ByTopic[otherTopic]++
+		return
+	}
+	for _, tag := range answer.Entry.Tags {
+		day.ByTopic[tag]++
+	}
+}
+
+func (s *AnswerStats) prune(now time.Time) {
+	oldest := now.UTC().AddDate(0, 0, -statsDays+1).Format("2006-01-02")
+	for key := range s.days {
+		if key < oldest {
+			delete(s.days, key)
+		}
+	}
+}
+
+// StatsReport sums the stats window.
+type StatsReport struct {
+	Days     int            `json:"days"`
+	Total    int            `json:"total"`
+	Answered int            `json:"answered"`
+	BySource map[string]int `json:"by_source,omitempty"`
+	Topics   map[string]int `json:"topics"`
+}
+
+func (s *AnswerStats) report(now time.Time) StatsReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	r := StatsReport{Days: statsDays, BySource: make(map[string]int), Topics: make(map[string]int)}
+	for _, day := range s.days {
+		r.Total += day.Total
+		r.Answered += day.Answered
+		for source, n := range day.BySource {
+			r.BySource[source] += n
+		}
+		for topic, n := range day.ByTopic {
+			r.Topics[topic] += n
+		}
+	}
+	return r
+}
+
+// PublicStatsPolicy coarsens stats for publication: topics counted fewer
+// than Floor times are merged into "other", and every count is rounded to
+// a multiple of Rounding.
+type PublicStatsPolicy struct {
+	Floor    int
+	Rounding int
+}
+
+func (p PublicStatsPolicy) round(n int) int {
+	if p.Rounding <= 1 {
+		return n
+	}
+	return int(math.Round(float64(n)/float64(p.Rounding))) * p.Rounding
+}
+
+// publish returns the public view of a report. Sources are internal and
+// dropped; topics are tag names, never question text. If "other" itself
+// ends up under the floor it is dropped too.
+func (p PublicStatsPolicy) publish(r StatsReport) StatsReport {
+	public := StatsReport{
+		Days:     r.Days,
+		Total:    p.round(r.Total),
+		Answered: p.round(r.Answered),
+		Topics:   make(map[string]int),
+	}
+	other := r.Topics[otherTopic]
+	topics := make([]string, 0, len(r.Topics))
+	for topic := range r.Topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		n := r.Topics[topic]
+		if topic == otherTopic {
+			continue
+		}
+		if n < p.Floor {
+			other += n
+			continue
+		}
+		public.Topics[topic] = p.round(n)
+	}
+	if other >= p.Floor && other > 0 {
+		public.Topics[otherTopic] = p.round(other)
+	}
+	return public
+}
+
+// handleStats serves the exact stats at /admin/stats.
+func handleStats(stats *AnswerStats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats.report(time.Now()))
+	}
+}
+
+// handlePublicStats serves the coarsened stats at /stats.
+func handlePublicStats(stats *AnswerStats, policy PublicStatsPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(policy.publish(stats.report(time.Now())))
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublicStatsFloorAndRounding(t *testing.T) {
+	report := StatsReport{
+		Days:     statsDays,
+		Total:    137,
+		Answered: 121,
+		BySource: map[string]int{SourceKnowledgeBase: 121, SourceDefault: 16},
+		Topics:   map[string]int{"channels": 52, "maps": 31, "generics": 4, "cgo": 3, otherTopic: 6},
+	}
+	got := PublicStatsPolicy{Floor: 10, Rounding: 5}.publish(report)
+	want := StatsReport{
+		Days:     statsDays,
+		Total:    135,
+		Answered: 120,
+		// generics and cgo are under the floor and merged into other.
+		Topics: map[string]int{"channels": 50, "maps": 30, otherTopic: 15},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("published %+v, want %+v", got, want)
+	}
+	// An "other" still under the floor is dropped.
+	report.Topics = map[string]int{"channels": 52, "cgo": 3}
+	if got := (PublicStatsPolicy{Floor: 10, Rounding: 5}).publish(report); !reflect.DeepEqual(got.Topics, map[string]int{"channels": 50}) {
+		t.Errorf("topics %v, want channels alone", got.Topics)
+	}
+}
+
+// TestPublicStatsLeakNothing records random traffic and checks the public
+// payload: no question or answer text, no topic under the floor, and
+// every count rounded. The admin stats stay exact.
+func TestPublicStatsLeakNothing(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	policy := PublicStatsPolicy{Floor: 5, Rounding: 10}
+	now := time.Now()
+	for round := 0; round < 50; round++ {
+		stats := NewAnswerStats()
+		var questions []string
+		exact := make(map[string]int)
+		total := 0
+		for i := rng.Intn(200); i > 0; i-- {
+			question := fmt.Sprintf("secret question %d-%d", round, i)
+			questions = append(questions, question)
+			answer := Answer{Text: "secret answer to " + question, Source: SourceDefault}
+			if rng.Intn(4) > 0 {
+				answer.Source = SourceKnowledgeBase
+				var tags []string
+				for j := rng.Intn(3); j > 0; j-- {
+					// A long tail of rare tags, some used once.
+					tags = append(tags, fmt.Sprint("tag-", rng.Intn(1+rng.Intn(40))))
+				}
+				answer.Entry = &KnowledgeEntry{ID: question, Question: question, Answer: answer.Text, Tags: tags}
+			}
+			stats.record(answer, now)
+			total++
+			if answer.Entry != nil {
+				if len(answer.Entry.Tags) == 0 {
+					exact[otherTopic]++
+				}
+				for _, tag := range answer.Entry.Tags {
+					exact[tag]++
+				}
+			}
+		}
+
+		rec := httptest.NewRecorder()
+		handlePublicStats(stats, policy)(rec, httptest.NewRequest("GET", "/stats", nil))
+		payload := rec.Body.String()
+		if strings.Contains(payload, "secret") {
+			t.Fatalf("public stats leak text: %s", payload)
+		}
+		var public StatsReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &public); err != nil {
+			t.Fatal(err)
+		}
+		if public.BySource != nil {
+			t.Errorf("public stats carry sources: %v", public.BySource)
+		}
+		for topic, n := range public.Topics {
+			if topic != otherTopic && exact[topic] < policy.Floor {
+				t.Errorf("topic %s counted %d times, under the floor %d", topic, exact[topic], policy.Floor)
+			}
+			if n%policy.Rounding != 0 {
+				t.Errorf("topic %s count %d not rounded to %d", topic, n, policy.Rounding)
+			}
+		}
+		if public.Total%policy.Rounding != 0 || public.Answered%policy.Rounding != 0 {
+			t.Errorf("totals %d and %d not rounded to %d", public.Total, public.Answered, policy.Rounding)
+		}
+
+		rec = httptest.NewRecorder()
+		handleStats(stats)(rec, httptest.NewRequest("GET", "/admin/stats", nil))
+		var admin StatsReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &admin); err != nil {
+			t.Fatal(err)
+		}
+		if admin.Total != total || (len(exact) > 0 || len(admin.Topics) > 0) && !reflect.DeepEqual(admin.Topics, exact) {
+			t.Errorf("admin stats %d questions, topics %v; want %d, %v", admin.Total, admin.Topics, total, exact)
+		}
+	}
+}
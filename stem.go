@@ -0,0 +1,217 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stemWord reduces word to its Porter stem, so "channels" and "channel",
+// or "closing" and "closed", compare equal. Case and surrounding
+// punctuation are dropped first. Words with anything but ASCII letters
+// are returned as they are, lowercased.
+func stemWord(word string) string {
+	word = strings.ToLower(strings.TrimFunc(word, unicode.IsPunct))
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+	return porterStem(word)
+}
+
+// porterStem implements the Porter (1980) stemming algorithm on a word of
+// lowercase ASCII letters.
+func porterStem(word string) string {
+	w := word
+	w = step1a(w)
+	w = step1b(w)
+	w = step1c(w)
+	w = replaceSuffix(w, 0, step2Suffixes)
+	w = replaceSuffix(w, 0, step3Suffixes)
+	w = step4(w)
+	w = step5(w)
+	return w
+}
+
+// isConsonant reports whether w[i] is a consonant. A "y" is one at the
+// start of a word or after a vowel.
+func isConsonant(w string, i int) bool {
+	switch w[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !isConsonant(w, i-1)
+	}
+	return true
+}
+
+// measure counts the vowel-consonant sequences in w, Porter's m.
+func measure(w string) int {
+	m, i := 0, 0
+	for i < len(w) && isConsonant(w, i) {
+		i++
+	}
+	for i < len(w) {
+		for i < len(w) && !isConsonant(w, i) {
+			i++
+		}
+		if i == len(w) {
+			break
+		}
+		for i < len(w) && isConsonant(w, i) {
+			i++
+		}
+		m++
+	}
+	return m
+}
+
+func hasVowel(w string) bool {
+	for i := range w {
+		if !isConsonant(w, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// endsDoubleConsonant reports whether w ends in a doubled consonant.
+func endsDoubleConsonant(w string) bool {
+	n := len(w)
+	return n >= 2 && w[n-1] == w[n-2] && isConsonant(w, n-1)
+}
+
+// endsCVC reports whether w ends consonant-vowel-consonant, the last not
+// w, x or y, as in "hop" but not "snow".
+func endsCVC(w string) bool {
+	n := len(w)
+	if n < 3 || !isConsonant(w, n-1) || isConsonant(w, n-2) || !isConsonant(w, n-3) {
+		return false
+	}
+	c := w[n-1]
+	return c != 'w' && c != 'x' && c != 'y'
+}
+
+func step1a(w string) string {
+	switch {
+	case strings.HasSuffix(w, "sses"):
+		return w[:len(w)-2]
+	case strings.HasSuffix(w, "ies"):
+		return w[:len(w)-2]
+	case strings.HasSuffix(w, "ss"):
+		return w
+	case strings.HasSuffix(w, "s"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+func step1b(w string) string {
+	if strings.HasSuffix(w, "eed") {
+		if measure(w[:len(w)-3]) > 0 {
+			return w[:len(w)-1]
+		}
+		return w
+	}
+	var stem string
+	switch {
+	case strings.HasSuffix(w, "ed") && hasVowel(w[:len(w)-2]):
+		stem = w[:len(w)-2]
+	case strings.HasSuffix(w, "ing") && hasVowel(w[:len(w)-3]):
+		stem = w[:len(w)-3]
+	default:
+		return w
+	}
+	switch {
+	case strings.HasSuffix(stem, "at"), strings.HasSuffix(stem, "bl"), strings.HasSuffix(stem, "iz"):
+		return stem + "e"
+	case endsDoubleConsonant(stem):
+		if c := stem[len(stem)-1]; c != 'l' && c != 's' && c != 'z' {
+			return stem[:len(stem)-1]
+		}
+	case measure(stem) == 1 && endsCVC(stem):
+		return stem + "e"
+	}
+	return stem
+}
+
+func step1c(w string) string {
+	if strings.HasSuffix(w, "y") && hasVowel(w[:len(w)-1]) {
+		return w[:len(w)-1] + "i"
+	}
+	return w
+}
+
+// suffixRule replaces suffix with replacement.
+type suffixRule struct {
+	suffix, replacement string
+}
+
+// Within a step, longer suffixes come before those they end with: only
+// the first suffix w ends with is considered.
+var (
+	step2Suffixes = []suffixRule{
+		{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"},
+		{"izer", "ize"}, {"bli", "ble"}, {"alli", "al"}, {"entli", "ent"},
+		{"eli", "e"}, {"ousli", "ous"}, {"ization", "ize"}, {"ation", "ate"},
+		{"ator", "ate"}, {"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"},
+		{"ousness", "ous"}, {"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"},
+		{"logi", "log"},
+	}
+	step3Suffixes = []suffixRule{
+		{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"},
+		{"ical", "ic"}, {"ful", ""}, {"ness", ""},
+	}
+	step4Suffixes = []string{
+		"ement", "ment", "ent", "ance", "ence", "able", "ible", "ant", "ism",
+		"ate", "iti", "ous", "ive", "ize", "ion", "al", "er", "ic", "ou",
+	}
+)
+
+// replaceSuffix applies the first rule whose suffix w ends with, if the
+// stem before it has a measure above minMeasure.
+func replaceSuffix(w string, minMeasure int, rules []suffixRule) string {
+	for _, rule := range rules {
+		if strings.HasSuffix(w, rule.suffix) {
+			stem := w[:len(w)-len(rule.suffix)]
+			if measure(stem) > minMeasure {
+				return stem + rule.replacement
+			}
+			return w
+		}
+	}
+	return w
+}
+
+func step4(w string) string {
+	for _, suffix := range step4Suffixes {
+		if !strings.HasSuffix(w, suffix) {
+			continue
+		}
+		stem := w[:len(w)-len(suffix)]
+		if suffix == "ion" && !strings.HasSuffix(stem, "s") && !strings.HasSuffix(stem, "t") {
+			return w
+		}
+		if measure(stem) > 1 {
+			return stem
+		}
+		return w
+	}
+	return w
+}
+
+func step5(w string) string {
+	if strings.HasSuffix(w, "e") {
+		stem := w[:len(w)-1]
+		if m := measure(stem); m > 1 || (m == 1 && !endsCVC(stem)) {
+			w = stem
+		}
+	}
+	if strings.HasSuffix(w, "ll") && measure(w) > 1 {
+		w = w[:len(w)-1]
+	}
+	return w
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStemWord(t *testing.T) {
+	// Examples from Porter's paper, then the normalization stemWord adds.
+	tests := map[string]string{
+		"caresses": "caress", "ponies": "poni", "ties": "ti", "cats": "cat",
+		"feed": "feed", "agreed": "agre", "plastered": "plaster", "motoring": "motor",
+		"sing": "sing", "conflated": "conflat", "hopping": "hop", "filing": "file",
+		"happy": "happi", "relational": "relat", "conditional": "condit", "digitizer": "digit",
+		"hopeful": "hope", "goodness": "good", "revival": "reviv", "adjustment": "adjust",
+		"probate": "probat", "controll": "control",
+		"Channels?": "channel", "goroutine": "goroutin", "goroutines": "goroutin",
+		"go": "go", "通道": "通道", "naïve": "naïve",
+	}
+	for word, want := range tests {
+		if got := stemWord(word); got != want {
+			t.Errorf("stemWord(%q) = %q, want %q", word, got, want)
+		}
+	}
+}
+
+// TestWordVariantsRetrieveSameEntry asks with plural, singular and verb
+// forms the embeddings lack, and gets the entry written with another
+// form, looked up through its stem.
+func TestWordVariantsRetrieveSameEntry(t *testing.T) {
+	ai := newTestEngine(t, withEntries(
+		KnowledgeEntry{ID: "close", Question: "How do I close a channel?", Answer: "The sender calls close."},
+		KnowledgeEntry{ID: "start", Question: "How do I start a goroutine?", Answer: "Put go before a function call."},
+		KnowledgeEntry{ID: "iterate", Question: "How do I iterate over a map?", Answer: "With a for range loop."},
+		KnowledgeEntry{ID: "handle", Question: "How should errors be handled?", Answer: "Check the returned error."},
+	))
+	tests := map[string][]string{
+		"close":   {"closing channels", "How are channels closed?", "close channel"},
+		"start":   {"starting goroutines", "How are goroutines started?", "Goroutine starts"},
+		"iterate": {"iterating maps", "iterates over maps", "map iteration"},
+		"handle":  {"handling errors", "How do I handle an error?", "error handler"},
+	}
+	for id, questions := range tests {
+		for _, question := range questions {
+			answer, _ := ai.Ask(question, AskOptions{})
+			if answer.Entry == nil || answer.Entry.ID != id {
+				t.Errorf("%q answered %q from %s, want entry %s", question, answer.Text, answer.Source, id)
+			}
+		}
+	}
+}
+
+// TestInteractionsMatchByStem finds an earlier interaction whose keyword
+// is another form of the question's.
+func TestInteractionsMatchByStem(t *testing.T) {
+	ai := newTestEngine(t)
+	ai.mu.Lock()
+	ai.sessionFor("stems", time.Now()).remember(Interaction{Question: "What is a goroutine?", Keywords: []string{"goroutine"}, Analyzer: analyzerVersion})
+	ai.mu.Unlock()
+	interaction, score := ai.findSimilarInteraction([]string{"Goroutines"}, "stems")
+	if interaction.Question != "What is a goroutine?" || score != 1 {
+		t.Errorf("found %+v at %v", interaction, score)
+	}
+}
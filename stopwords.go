@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// defaultStopWords are English words too common, or too vague, to tell
+// questions apart: function words, and nouns like "thing" and "way" that
+// the tagger keeps as keywords. Go terms such as "type", "map" and
+// "select" are deliberately absent.
+var defaultStopWords = []string{
+	"a", "about", "above", "after", "again", "against", "all", "am", "an",
+	"and", "any", "are", "as", "at", "be", "because", "been", "before",
+	"being", "below", "between", "both", "but", "by", "can", "could", "did",
+	"do", "does", "doing", "down", "during", "each", "few", "for", "from",
+	"further", "had", "has", "have", "having", "he", "her", "here", "hers",
+	"him", "his", "how", "i", "if", "in", "into", "is", "it", "its", "just",
+	"me", "more", "most", "my", "no", "nor", "not", "now", "of", "off", "on",
+	"once", "only", "or", "other", "our", "out", "over", "own", "same",
+	"she", "should", "so", "some", "such", "than", "that", "the", "their",
+	"them", "then", "there", "these", "they", "this", "those", "through",
+	"to", "too", "under", "until", "up", "very", "was", "we", "were", "what",
+	"when", "where", "which", "while", "who", "whom", "why", "will", "with",
+	"would", "you", "your",
+	"please", "thanks", "hi", "hello",
+	"thing", "things", "way", "ways", "stuff", "something", "anything",
+	"everything", "lot", "lots", "bit", "kind", "question", "questions",
+}
+
+// stopWordSet holds lowercase stopwords. A nil set keeps every word.
+type stopWordSet map[string]bool
+
+// newStopWordSet returns the built-in stopwords plus extra.
+func newStopWordSet(extra []string) stopWordSet {
+	s := make(stopWordSet, len(defaultStopWords)+len(extra))
+	for _, word := range defaultStopWords {
+		s[word] = true
+	}
+	for _, word := range extra {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			s[word] = true
+		}
+	}
+	return s
+}
+
+// has reports whether word is a stopword, ignoring case and surrounding
+// punctuation, so "What" and "thing?" are caught too.
+func (s stopWordSet) has(word string) bool {
+	return s[strings.ToLower(strings.TrimFunc(word, unicode.IsPunct))]
+}
+
+// filter returns words without the stopwords. It doesn't modify words.
+func (s stopWordSet) filter(words []string) []string {
+	if len(s) == 0 {
+		return words
+	}
+	var kept []string
+	for _, word := range words {
+		if !s.has(word) {
+			kept = append(kept, word)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+)
+
+// KnowledgeStore persists the knowledge base. The KnowledgeBase keeps
+// serving from memory, so matching still scores every vector in Go; the
+// store is written through on every change and read back at startup.
+type KnowledgeStore interface {
+	// AddEntry inserts or replaces entries by ID, all or none.
+	AddEntry(entries ...KnowledgeEntry) error
+	// Learn inserts or replaces a learned entry by normalized question. It
+	// must be durable when it returns.
+	Learn(entry LearnedEntry) error
+	// Forget removes the learned entry for a question by its normalized
+	// form, if there is one. It must be durable when it returns.
+	Forget(question string) error
+	ListEntries() ([]KnowledgeEntry, error)
+	ListLearned() ([]LearnedEntry, error)
+	Close() error
+}
+
+// storeLoad describes what openStore read back from a store.
+type storeLoad struct {
+	Entries int
+	Learned int
+}
+
+// openStore merges a store into the knowledge base and writes every later
+// change through to it. prompt.json stays authoritative for the entries it
+// defines, but their review state (timestamps and rewrite flag) is kept
+// from the store; entries only the store has are added with vectors
+// re-derived when they are stale. Learned entries from the store win over
+// those restored from a snapshot, and the store is then brought up to date
+// with everything in memory.
+func (ai *AIEngine) openStore(store KnowledgeStore) (storeLoad, error) {
+	var load storeLoad
+	stored, err := store.ListEntries()
+	if err != nil {
+		return load, err
+	}
+	learned, err := store.ListLearned()
+	if err != nil {
+		return load, err
+	}
+	load.Entries, load.Learned = len(stored), len(learned)
+
+	embeddings := ai.embeddings()
+	dimension := embeddingVectorDimension(embeddings)
+	var merged []KnowledgeEntry
+	err = ai.KB.updateEntries("openStore", func(entries []KnowledgeEntry) []KnowledgeEntry {
+		byID := make(map[string]int, len(entries))
+		for i, entry := range entries {
+			byID[entry.ID] = i
+		}
+		for _, entry := range stored {
+			if i, ok := byID[entry.ID]; ok {
+				entries[i].CreatedAt = entry.CreatedAt
+				entries[i].VerifiedAt = entry.VerifiedAt
+				entries[i].NeedsRewrite = entry.NeedsRewrite
+				continue
+			}
+			if entry.Analyzer != analyzerVersion || len(entry.Vector) != dimension {
+				entry.Vector = getSentenceVector(entry.Question, embeddings)
+				entry.Analyzer = analyzerVersion
+			}
+			entries = append(entries, entry)
+		}
+		merged = entries
+		return entries
+	})
+	if err != nil {
+		return load, fmt.Errorf("merging entries: %v", err)
+	}
+	if err := store.AddEntry(merged...); err != nil {
+		return load, fmt.Errorf("writing entries: %v", err)
+	}
+
+	ai.KB.updateLearned("openStore", func(current map[string]LearnedEntry) {
+		saved := make(map[string]LearnedEntry, len(learned))
+		for _, entry := range learned {
+			key := normalizeQuestion(entry.Question)
+			entry.ID = learnedID(key)
+			current[key] = entry
+			saved[key] = entry
+		}
+		for key, entry := range current {
+			if err == nil && !sameLearned(saved[key], entry) {
+				err = store.Learn(entry)
+			}
+		}
+		if err == nil {
+			ai.KB.store = store
+		}
+	})
+	if err != nil {
+		return load, fmt.Errorf("writing learned entries: %v", err)
+	}
+	return load, nil
+}
+
+// persistEntries writes the entries fn changed to the store. It runs
+// under the write lock, after fn.
+func (kb *KnowledgeBase) persistEntries(op string, before, after []KnowledgeEntry) {
+	previous := make(map[string]KnowledgeEntry, len(before))
+	for _, entry := range before {
+		previous[entry.ID] = entry
+	}
+	var changed []KnowledgeEntry
+	for _, entry := range after {
+		if old, ok := previous[entry.ID]; !ok || !reflect.DeepEqual(old, entry) {
+			changed = append(changed, entry)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	if err := kb.store.AddEntry(changed...); err != nil {
+		log.Printf("%s: %d entries not saved: %v", op, len(changed), err)
+	}
+}
+
+// persistLearned is persistEntries for the learned entries.
+func (kb *KnowledgeBase) persistLearned(op string, before, after map[string]LearnedEntry) {
+	var failed int
+	var lastErr error
+	for key, entry := range after {
+		if old, ok := before[key]; ok && sameLearned(old, entry) {
+			continue
+		}
+		if err := kb.store.Learn(entry); err != nil {
+			failed, lastErr = failed+1, err
+		}
+	}
+	if failed > 0 {
+		log.Printf("%s: %d learned entries not saved: %v", op, failed, lastErr)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// defaultStreamInterval is the pause between the chunks of a streamed
+// answer: quick enough to read as typing, slow enough to be seen.
+const defaultStreamInterval = 30 * time.Millisecond
+
+// wantsEventStream reports whether the request accepts server-sent events,
+// in which case /ai streams its answer instead of returning JSON.
+func wantsEventStream(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			if mediaType, _, err := mime.ParseMediaType(part); err == nil && mediaType == "text/event-stream" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// answerChunks splits text into words, each with the whitespace after it
+// and the first with any before it, so the chunks join back into text.
+func answerChunks(text string) []string {
+	var chunks []string
+	start, seenWord, afterSpace := 0, false, false
+	for i, r := range text {
+		space := unicode.IsSpace(r)
+		if seenWord && afterSpace && !space {
+			chunks = append(chunks, text[start:i])
+			start = i
+		}
+		seenWord = seenWord || !space
+		afterSpace = space
+	}
+	if start < len(text) {
+		chunks = append(chunks, text[start:])
+	}
+	return chunks
+}
+
+// streamAnswer writes response as server-sent events: a "chunk" event of
+// {"text": ...} for each word of the answer, interval apart, then a "done"
+// event carrying the whole response, answer included, as /ai returns it.
+// With pacing on, a "typing" event comes first and the chunks follow the
+// pacing delay. It stops when the client goes away. An interval of 0
+// sends the chunks without pausing.
+func streamAnswer(w http.ResponseWriter, r *http.Request, response AIResponse, interval time.Duration, pacing Pacing) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keeps proxies such as nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	flusher, _ := w.(http.Flusher)
+	send := func(event string, data interface{}) {
+		payload, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if pacing.delay(response.Answer) > 0 {
+		send("typing", struct{}{})
+		if !pacing.wait(r.Context(), response.Answer) {
+			return
+		}
+	}
+
+	var timer *time.Timer
+	for i, chunk := range answerChunks(response.Answer) {
+		if i > 0 && interval > 0 {
+			if timer == nil {
+				timer = time.NewTimer(interval)
+				defer timer.Stop()
+			} else {
+				timer.Reset(interval)
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-timer.C:
+			}
+		} else if r.Context().Err() != nil {
+			return
+		}
+		send("chunk", struct {
+			Text string `json:"text"`
+		}{chunk})
+	}
+	send("done", response)
+}
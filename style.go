@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Answer styles a client can request.
+const (
+	StyleConcise  = "concise"
+	StyleNormal   = "normal"
+	StyleDetailed = "detailed"
+)
+
+const (
+	// conciseSentences is the sentence budget of a concise answer.
+	conciseSentences = 2
+	// maxRelated bounds the related questions suggested in detailed mode,
+	// and minRelatedScore is how similar they must be to the question.
+	maxRelated      = 3
+	minRelatedScore = 0.5
+)
+
+func validStyle(style string) bool {
+	switch style {
+	case "", StyleConcise, StyleNormal, StyleDetailed:
+		return true
+	}
+	return false
+}
+
+// summarize returns the first paragraph of text cut to at most maxSentences
+// sentences.
+func summarize(text string, maxSentences int) string {
+	text = strings.TrimSpace(text)
+	if i := strings.Index(text, "\n\n"); i >= 0 {
+		text = text[:i]
+	}
+	sentences := 0
+	for i, r := range text {
+		if r != '.' && r != '!' && r != '?' {
+			continue
+		}
+		rest := text[i+1:]
+		if rest != "" && !unicode.IsSpace(rune(rest[0])) {
+			continue
+		}
+		sentences++
+		if sentences == maxSentences {
+			return text[:i+1]
+		}
+	}
+	return text
+}
+
+// applyStyle adjusts an answer for the requested style. Concise answers use
+// the entry's summary when it has one.
+func (ai *AIEngine) applyStyle(answer *Answer, q *Query, style string) {
+	switch style {
+	case StyleConcise:
+		if answer.Entry != nil && answer.Entry.Summary != "" {
+			answer.Text = answer.Entry.Summary
+			answer.Sources = nil
+			return
+		}
+		answer.Text = summarize(answer.Text, conciseSentences)
+	case StyleDetailed:
+		for _, entry := range q.kb.related(q.Vector(), answer.EntryID, nil) {
+			answer.Related = append(answer.Related, entry.Question)
+		}
+	}
+}
+
+// related returns up to maxRelated entries of s similar to vec, other
+// than the entry with ID exclude, that pass keep (nil keeps all).
+func (s *kbState) related(vec []float32, exclude string, keep func(KnowledgeEntry) bool) []KnowledgeEntry {
+	var entries []KnowledgeEntry
+	for _, match := range s.rankVector(vec) {
+		if len(entries) == maxRelated || match.Score < minRelatedScore {
+			break
+		}
+		if match.Entry.ID != exclude && (keep == nil || keep(match.Entry)) {
+			entries = append(entries, match.Entry)
+		}
+	}
+	return entries
+}
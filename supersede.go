@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// maxSupersedeDepth bounds how many superseding entries a match is
+// redirected through.
+const maxSupersedeDepth = 5
+
+// supersessions indexes which entry supersedes each superseded ID, as a
+// position in entries. It rejects an ID superseded by two entries, since
+// the redirect would be ambiguous, and any cycle, including an entry
+// superseding itself. Superseded IDs that don't exist are kept: the entry
+// may be added later.
+func supersessions(entries []KnowledgeEntry) (map[string]int, error) {
+	successors := make(map[string]int)
+	for i, entry := range entries {
+		for _, id := range entry.Supersedes {
+			if j, ok := successors[id]; ok && j != i {
+				return nil, newError(ErrInvalidInput, "entry %s is superseded by both %s and %s", id, entries[j].ID, entry.ID)
+			}
+			successors[id] = i
+		}
+	}
+	ids := make([]string, 0, len(successors))
+	for id := range successors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, start := range ids {
+		chain := []string{start}
+		seen := map[string]bool{start: true}
+		for id := start; ; {
+			i, ok := successors[id]
+			if !ok {
+				break
+			}
+			id = entries[i].ID
+			chain = append(chain, id)
+			if seen[id] {
+				return nil, newError(ErrInvalidInput, "supersedes cycle: %s", strings.Join(chain, " -> "))
+			}
+			seen[id] = true
+		}
+	}
+	return successors, nil
+}
+
+// resolveSupersedes follows the chain of entries superseding entry and
+// returns the last, stopping before a quarantined entry or after
+// maxSupersedeDepth steps. It reports whether entry was superseded.
+func (s *kbState) resolveSupersedes(entry KnowledgeEntry) (KnowledgeEntry, bool) {
+	current := entry
+	for depth := 0; depth < maxSupersedeDepth; depth++ {
+		i, ok := s.successors[current.ID]
+		if !ok || s.entries[i].Quarantined {
+			break
+		}
+		current = s.entries[i]
+	}
+	return current, current.ID != entry.ID
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// EmbeddingSwap rebuilds the index against a new embeddings file in the
+// background while queries keep using the old one, then swaps them.
+type EmbeddingSwap struct {
+	ai *AIEngine
+	// memoryLimit caps the combined size of the old and new embeddings;
+	// zero disables the check.
+	memoryLimit int64
+
+	mu     sync.Mutex
+	status SwapStatus
+}
+
+// SwapStatus is the pollable progress of the current or last swap.
+type SwapStatus struct {
+	State      string     `json:"state"`
+	Path       string     `json:"path,omitempty"`
+	Done       int        `json:"done"`
+	Total      int        `json:"total"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	ETASeconds *float64   `json:"eta_seconds,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+func NewEmbeddingSwap(ai *AIEngine, memoryLimit int64) *EmbeddingSwap {
+	return &EmbeddingSwap{ai: ai, memoryLimit: memoryLimit, status: SwapStatus{State: "idle"}}
+}
+
+func loadEmbeddingsFile(path, format string, limit int) (*EmbeddingStore, error) {
+	embeddings, err := readEmbeddings(path, format, limit)
+	if err != nil {
+		return nil, err
+	}
+	if embeddings.Len() == 0 {
+		return nil, fmt.Errorf("%s: no vectors", path)
+	}
+	return embeddings, nil
+}
+
+// Start begins a swap to the embeddings file at path, read in format and
+// cut to limit words if limit is positive, unless one is already running.
+func (s *EmbeddingSwap) Start(path, format string, limit int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status.State == "loading" || s.status.State == "building" {
+		return newError(ErrConflict, "a swap of %s is already in progress", s.status.Path)
+	}
+	now := time.Now()
+	s.status = SwapStatus{State: "loading", Path: path, StartedAt: &now}
+	go s.run(path, format, limit, now)
+	return nil
+}
+
+func (s *EmbeddingSwap) fail(err error) {
+	log.Printf("Embeddings swap failed: %v", err)
+	s.mu.Lock()
+	s.status.State = "failed"
+	s.status.Error = err.Error()
+	s.status.ETASeconds = nil
+	s.mu.Unlock()
+}
+
+func (s *EmbeddingSwap) run(path, format string, limit int, started time.Time) {
+	embeddings, err := loadEmbeddingsFile(path, format, limit)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	if s.memoryLimit > 0 {
+		combined := s.ai.embeddings().Bytes() + embeddings.Bytes()
+		if combined > s.memoryLimit {
+			s.fail(fmt.Errorf("old and new embeddings need ~%d MiB, limit is %d MiB", combined>>20, s.memoryLimit>>20))
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.status.State = "building"
+	s.mu.Unlock()
+	report := s.ai.reindex(embeddings, func(done, total int) {
+		s.mu.Lock()
+		s.status.Done, s.status.Total = done, total
+		if done > 0 {
+			eta := time.Since(started).Seconds() / float64(done) * float64(total-done)
+			s.status.ETASeconds = &eta
+		}
+		s.mu.Unlock()
+	})
+
+	s.mu.Lock()
+	s.status.State = "done"
+	s.status.Done, s.status.Total = report.Entries, report.Entries
+	s.status.ETASeconds = nil
+	s.mu.Unlock()
+	log.Printf("Embeddings swapped to %s in %s", path, time.Since(started).Round(time.Millisecond))
+}
+
+func (s *EmbeddingSwap) Status() SwapStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+type SwapRequest struct {
+	Path string `json:"path" schema:"required"`
+	// Format is the format of the file, as for -embeddings-format; auto
+	// by default.
+	Format string `json:"format"`
+	// Limit is the number of words read, as for -embeddings-limit; 0
+	// reads them all.
+	Limit int `json:"limit"`
+}
+
+func handleEmbeddingSwap(s *EmbeddingSwap) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var req SwapRequest
+			if err := decodeRequest(r, &req); err != nil {
+				writeError(w, err)
+				return
+			}
+			if err := checkEmbeddingsFormat(req.Format); err != nil {
+				writeError(w, newError(ErrInvalidInput, "%v", err))
+				return
+			}
+			if req.Limit < 0 {
+				writeError(w, newError(ErrInvalidInput, "limit must not be negative"))
+				return
+			}
+			if err := s.Start(req.Path, req.Format, req.Limit); err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(s.Status())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Status())
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kill switches. Every switch is on unless turned off globally or for the
+// tenant the caller's X-API-Key belongs to; see TenantKeys.
+const (
+	SwitchLearning    = "learning_enabled"
+	SwitchLLMFallback = "llm_fallback_enabled"
+	SwitchWebhooks    = "webhooks_enabled"
+)
+
+var knownSwitches = map[string]bool{
+	SwitchLearning:    true,
+	SwitchLLMFallback: true,
+	SwitchWebhooks:    true,
+}
+
+// maxSwitchAudit bounds the audit trail kept with the switches.
+const maxSwitchAudit = 200
+
+// SwitchChange is one audited toggle.
+type SwitchChange struct {
+	Switch  string    `json:"switch"`
+	Tenant  string    `json:"tenant,omitempty"`
+	Enabled bool      `json:"enabled"`
+	Actor   string    `json:"actor"`
+	At      time.Time `json:"at"`
+}
+
+type switchState struct {
+	Global  map[string]bool            `json:"global"`
+	Tenants map[string]map[string]bool `json:"tenants"`
+	Audit   []SwitchChange             `json:"audit"`
+}
+
+// Switches holds runtime kill switches, persisted to a file so restarts
+// keep them. A nil *Switches has every switch on.
+type Switches struct {
+	path  string
+	mu    sync.RWMutex
+	state switchState
+}
+
+// LoadSwitches reads the switches file; a missing file means every switch
+// is on.
+func LoadSwitches(path string) (*Switches, error) {
+	s := &Switches{path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.state); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if s.state.Global == nil {
+		s.state.Global = make(map[string]bool)
+	}
+	if s.state.Tenants == nil {
+		s.state.Tenants = make(map[string]map[string]bool)
+	}
+	return s, nil
+}
+
+// Enabled reports whether a switch is on for the tenant ("" checks only
+// the global setting).
+func (s *Switches) Enabled(name, tenant string) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if on, ok := s.state.Global[name]; ok && !on {
+		return false
+	}
+	if on, ok := s.state.Tenants[tenant][name]; ok && !on {
+		return false
+	}
+	return true
+}
+
+// Set turns a switch on or off, globally or for one tenant, records who did
+// it and persists the result.
+func (s *Switches) Set(name, tenant string, enabled bool, actor string) error {
+	if !knownSwitches[name] {
+		return newError(ErrInvalidInput, "unknown switch %q", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings := s.state.Global
+	if tenant != "" {
+		if s.state.Tenants[tenant] == nil {
+			s.state.Tenants[tenant] = make(map[string]bool)
+		}
+		settings = s.state.Tenants[tenant]
+	}
+	settings[name] = enabled
+	change := SwitchChange{Switch: name, Tenant: tenant, Enabled: enabled, Actor: actor, At: time.Now()}
+	s.state.Audit = append(s.state.Audit, change)
+	if len(s.state.Audit) > maxSwitchAudit {
+		s.state.Audit = s.state.Audit[len(s.state.Audit)-maxSwitchAudit:]
+	}
+	log.Printf("Switch %s set to %v (tenant %q) by %s", name, enabled, tenant, actor)
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	if err != nil {
+		return newError(ErrStoreUnavailable, "switch changed but not persisted: %v", err)
+	}
+	return nil
+}
+
+// rekeyTenants renames tenants stored by their API key, as switches files
+// written before tenant keys were, to the tenant the key belongs to and
+// persists the result, so the file no longer holds the keys. Tenants of
+// keys not in tenants are left as they are.
+func (s *Switches) rekeyTenants(tenants TenantKeys) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	renamed := false
+	for name, settings := range s.state.Tenants {
+		tenant := tenants.tenant(name)
+		if tenant == "" {
+			continue
+		}
+		delete(s.state.Tenants, name)
+		if s.state.Tenants[tenant] == nil {
+			s.state.Tenants[tenant] = make(map[string]bool)
+		}
+		for sw, on := range settings {
+			s.state.Tenants[tenant][sw] = on
+		}
+		renamed = true
+	}
+	for i, change := range s.state.Audit {
+		if tenant := tenants.tenant(change.Tenant); tenant != "" {
+			s.state.Audit[i].Tenant = tenant
+			renamed = true
+		}
+	}
+	if !renamed {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	return err
+}
+
+// Off lists the switches turned off globally.
+func (s *Switches) Off() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var off []string
+	for name := range knownSwitches {
+		if on, ok := s.state.Global[name]; ok && !on {
+			off = append(off, name)
+		}
+	}
+	return off
+}
+
+// SwitchSettings is every switch as set globally, and the switches set
+// for each tenant.
+type SwitchSettings struct {
+	Global  map[string]bool            `json:"global"`
+	Tenants map[string]map[string]bool `json:"tenants"`
+}
+
+// Settings returns the switches as they are now.
+func (s *Switches) Settings() SwitchSettings {
+	settings := SwitchSettings{Global: make(map[string]bool, len(knownSwitches)), Tenants: make(map[string]map[string]bool)}
+	for name := range knownSwitches {
+		settings.Global[name] = s.Enabled(name, "")
+	}
+	if s == nil {
+		return settings
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for tenant, switches := range s.state.Tenants {
+		settings.Tenants[tenant] = make(map[string]bool, len(switches))
+		for name, on := range switches {
+			settings.Tenants[tenant][name] = on
+		}
+	}
+	return settings
+}
+
+// refuseIfOff answers 503 when the switch is off for the caller and
+// reports whether it did.
+func refuseIfOff(w http.ResponseWriter, r *http.Request, s *Switches, name string) bool {
+	if s.Enabled(name, requestTenant(r)) {
+		return false
+	}
+	status, _ := errorResponse(ErrDisabled)
+	writeErrorBody(w, status, name+"_off", "disabled by an operator kill switch", nil)
+	return true
+}
+
+// SwitchRequest toggles one switch; an empty tenant sets it globally.
+// Tenant is a tenant name from the tenant key file, not an API key.
+type SwitchRequest struct {
+	Switch  string `json:"switch" schema:"required"`
+	Tenant  string `json:"tenant"`
+	Enabled bool   `json:"enabled"`
+}
+
+// switchActor identifies who toggled a switch for the audit trail: the
+// admin key the request was authenticated with, never anything the
+// caller merely claims.
+func switchActor(r *http.Request) string {
+	if id := adminIdentity(r); id != "" {
+		return "admin " + id + " from " + clientIP(r)
+	}
+	return "unauthenticated admin from " + clientIP(r)
+}
+
+// handleSwitches serves /admin/switches: GET returns the switches and their
+// audit trail, POST toggles one.
+func handleSwitches(s *Switches) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var req SwitchRequest
+			if err := decodeRequest(r, &req); err != nil {
+				writeError(w, err)
+				return
+			}
+			if err := s.Set(req.Switch, req.Tenant, req.Enabled, switchActor(r)); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		s.mu.RLock()
+		data, err := json.Marshal(s.state)
+		s.mu.RUnlock()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// maxTaughtAnswer bounds, in characters, an answer taught through
+// /ai/teach.
+const maxTaughtAnswer = 2000
+
+// TaughtAnswer is an answer a user supplied for a question the bot could
+// not answer, waiting for moderation.
+type TaughtAnswer struct {
+	ID        string    `json:"id"`
+	Question  string    `json:"question"`
+	Answer    string    `json:"answer"`
+	TaughtAt  time.Time `json:"taught_at"`
+	AskedAt   time.Time `json:"asked_at"`
+	LearnedID string    `json:"learned_id,omitempty"`
+}
+
+// TeachStats counts how unknown questions move through the teach flow.
+type TeachStats struct {
+	// Offered is the number of teach tokens handed out.
+	Offered int `json:"offered"`
+	// Taught is the number of tokens redeemed with an answer.
+	Taught int `json:"taught"`
+	// Converted is the number of taught answers that became learned
+	// entries.
+	Converted int `json:"converted"`
+	Rejected  int `json:"rejected"`
+}
+
+type teachOffer struct {
+	question string
+	askedAt  time.Time
+	expires  time.Time
+}
+
+// TeachStore issues single-use tokens inviting the user to answer a
+// question the bot didn't know, and holds the answers awaiting moderation.
+// Without moderation taught answers are learned straight away.
+type TeachStore struct {
+	ttl       time.Duration
+	moderated bool
+
+	mu      sync.Mutex
+	offers  map[string]teachOffer
+	pending []TaughtAnswer
+	stats   TeachStats
+}
+
+func NewTeachStore(ttl time.Duration, moderated bool) *TeachStore {
+	return &TeachStore{ttl: ttl, moderated: moderated, offers: make(map[string]teachOffer)}
+}
+
+// Offer returns a token the client can redeem at /ai/teach with an answer
+// to question.
+func (s *TeachStore) Offer(question string) string {
+	now := time.Now()
+	token := newToken()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t, offer := range s.offers {
+		if now.After(offer.expires) {
+			delete(s.offers, t)
+		}
+	}
+	s.offers[token] = teachOffer{question: question, askedAt: now, expires: now.Add(s.ttl)}
+	s.stats.Offered++
+	return token
+}
+
+// Redeem consumes a token and records the answer. Unknown, used or expired
+// tokens give ErrGone.
+func (s *TeachStore) Redeem(token, answer string) (TaughtAnswer, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offer, ok := s.offers[token]
+	delete(s.offers, token)
+	if !ok || now.After(offer.expires) {
+		return TaughtAnswer{}, newError(ErrGone, "teach token is invalid, used or expired")
+	}
+	s.stats.Taught++
+	return TaughtAnswer{ID: newToken()[:12], Question: offer.question, Answer: answer, TaughtAt: now, AskedAt: offer.askedAt}, nil
+}
+
+func (s *TeachStore) queue(taught TaughtAnswer) {
+	s.mu.Lock()
+	s.pending = append(s.pending, taught)
+	s.mu.Unlock()
+}
+
+// take removes a pending answer.
+func (s *TeachStore) take(id string) (TaughtAnswer, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, taught := range s.pending {
+		if taught.ID == id {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			return taught, true
+		}
+	}
+	return TaughtAnswer{}, false
+}
+
+func (s *TeachStore) count(converted bool) {
+	s.mu.Lock()
+	if converted {
+		s.stats.Converted++
+	} else {
+		s.stats.Rejected++
+	}
+	s.mu.Unlock()
+}
+
+// Pending lists the answers awaiting moderation, oldest first.
+func (s *TeachStore) Pending() ([]TaughtAnswer, TeachStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]TaughtAnswer{}, s.pending...), s.stats
+}
+
+// learnTaught turns a taught answer into a learned entry. It never
+// replaces an answer learned in the meantime.
+func (s *TeachStore) learnTaught(ai *AIEngine, taught *TaughtAnswer) error {
+	entry, err := ai.KB.learnChecked(LearnedEntry{Question: taught.Question, Answer: taught.Answer}, false)
+	if err != nil {
+		s.count(false)
+		return err
+	}
+	taught.LearnedID = entry.ID
+	s.count(true)
+	return nil
+}
+
+type TeachRequest struct {
+	Token  string `json:"token" schema:"required"`
+	Answer string `json:"answer" schema:"required"`
+}
+
+// handleTeach serves POST /ai/teach. The answer is queued for moderation,
+// or learned at once when moderation is off.
+func handleTeach(ai *AIEngine, s *TeachStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if refuseIfOff(w, r, ai.Switches, SwitchLearning) {
+			return
+		}
+		var req TeachRequest
+		if err := decodeRequest(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		answer := strings.TrimSpace(req.Answer)
+		if answer == "" {
+			writeError(w, newError(ErrInvalidInput, "answer is empty"))
+			return
+		}
+		if utf8.RuneCountInString(answer) > maxTaughtAnswer {
+			writeError(w, newError(ErrInvalidInput, "answer is longer than %d characters", maxTaughtAnswer))
+			return
+		}
+		taught, err := s.Redeem(req.Token, answer)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if s.moderated {
+			s.queue(taught)
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": taught.ID, "status": "pending"})
+			return
+		}
+		if err := s.learnTaught(ai, &taught); err != nil {
+			writeError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": taught.ID, "status": "learned"})
+	}
+}
+
+type TeachModerationRequest struct {
+	ID     string `json:"id" schema:"required"`
+	Action string `json:"action" schema:"required"`
+}
+
+// handleTeachModeration serves /admin/teach: GET lists the pending answers
+// and the teach flow's stats, POST approves or rejects one.
+func handleTeachModeration(ai *AIEngine, s *TeachStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			pending, stats := s.Pending()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"moderated": s.moderated,
+				"pending":   pending,
+				"stats":     stats,
+			})
+		case http.MethodPost:
+			var req TeachModerationRequest
+			if err := decodeRequest(r, &req); err != nil {
+				writeError(w, err)
+				return
+			}
+			if req.Action != "approve" && req.Action != "reject" {
+				writeError(w, newError(ErrInvalidInput, `action must be "approve" or "reject"`))
+				return
+			}
+			if req.Action == "approve" && refuseIfOff(w, r, ai.Switches, SwitchLearning) {
+				return
+			}
+			taught, ok := s.take(req.ID)
+			if !ok {
+				writeError(w, newError(ErrNotFound, "no pending answer %s", req.ID))
+				return
+			}
+			if req.Action == "reject" {
+				s.count(false)
+			} else if err := s.learnTaught(ai, &taught); err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(taught)
+		}
+	}
+}
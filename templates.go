@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+)
+
+// requiredTemplates are the pages the server renders.
+var requiredTemplates = []string{"index.html", "qa.html"}
+
+// loadTemplates parses the templates in dir and checks that every required
+// page is present and non-empty. Errors name the absolute directory, since
+// the usual cause is starting the server from the wrong place.
+func loadTemplates(dir string) (*template.Template, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	tmpl, err := template.ParseGlob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, fmt.Errorf("no templates in %s: %v", abs, err)
+	}
+	for _, name := range requiredTemplates {
+		t := tmpl.Lookup(name)
+		if t == nil {
+			return nil, fmt.Errorf("%s is missing from %s", name, abs)
+		}
+		if t.Tree == nil || len(t.Tree.Root.Nodes) == 0 {
+			return nil, fmt.Errorf("%s in %s is empty", name, abs)
+		}
+	}
+	return tmpl, nil
+}
+
+// errorPage is served when a template fails to render. It is inline so it
+// works even when the templates are what broke.
+const errorPage = `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <title>Something went wrong</title>
+    <link href="/static/style.css" rel="stylesheet" type="text/css" />
+</head>
+<body>
+    <div class="container">
+        <h1>Something went wrong</h1>
+        <p>This page could not be displayed. Please try again later.</p>
+    </div>
+</body>
+</html>
+`
+
+// renderTemplate renders a page into a buffer first, so a failing template
+// produces the error page instead of a half-written body.
+func renderTemplate(w http.ResponseWriter, tmpl *template.Template, name string, data interface{}) {
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		log.Printf("Error rendering %s: %v", name, err)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(errorPage))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestBogusTemplateDirsFailStartup loads templates from broken
+// directories and checks the error names the absolute path looked in.
+func TestBogusTemplateDirsFailStartup(t *testing.T) {
+	tests := map[string]struct {
+		files map[string]string
+		// dir is looked in instead of the temp dir when set.
+		dir  string
+		want string
+	}{
+		"missing dir":   {dir: "no-such-templates", want: "no templates in"},
+		"empty dir":     {files: map[string]string{}, want: "no templates in"},
+		"missing page":  {files: map[string]string{"index.html": "<p>hi</p>"}, want: "qa.html is missing from"},
+		"empty page":    {files: map[string]string{"index.html": "", "qa.html": "<p>hi</p>"}, want: "index.html in"},
+		"template only": {files: map[string]string{"index.html": `{{define "x"}}{{end}}`, "qa.html": "<p>hi</p>"}, want: "index.html in"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "askgo-templates")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for file, content := range tt.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.dir != "" {
+				dir = filepath.Join(dir, tt.dir)
+			}
+			_, err = loadTemplates(dir)
+			if err == nil {
+				t.Fatal("loaded")
+			}
+			abs, _ := filepath.Abs(dir)
+			if !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), abs) {
+				t.Errorf("error %q, want %q and the path %s", err, tt.want, abs)
+			}
+		})
+	}
+	// A relative directory is reported resolved.
+	_, err := loadTemplates("no-such-templates")
+	cwd, _ := os.Getwd()
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(cwd, "no-such-templates")) {
+		t.Errorf("error %v doesn't name the absolute path", err)
+	}
+}
+
+// TestFailingTemplateServesErrorPage serves a page whose template fails
+// to execute, and gets the styled 500 page rather than a partial body.
+func TestFailingTemplateServesErrorPage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "askgo-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"index.html": "<h1>{{.Title}}</h1>{{.Missing}}",
+		"qa.html":    "<p>hi</p>",
+	}
+	for file, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	templates, err := loadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handleTemplates(templates))
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", resp.StatusCode)
+	}
+	if string(body) != errorPage {
+		t.Errorf("body %q, want the error page", body)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("content type %q", ct)
+	}
+}
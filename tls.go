@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// certReloader holds the server's certificate pair and swaps it when the
+// files are reloaded, so renewed certificates are served without a
+// restart. Handshakes read it through GetCertificate.
+type certReloader struct {
+	certFile, keyFile string
+	cert              atomic.Value // *tls.Certificate
+}
+
+// newCertReloader loads the pair in certFile and keyFile. Errors name the
+// files.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload reads the pair again. On error the previous pair stays in use.
+func (c *certReloader) reload() error {
+	certPEM, err := ioutil.ReadFile(c.certFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := ioutil.ReadFile(c.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("%s, %s: %v", c.certFile, c.keyFile, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("%s: %v", c.certFile, err)
+	}
+	cert.Leaf = leaf
+	c.cert.Store(&cert)
+	return nil
+}
+
+// describe names the certificate in use, for logs.
+func (c *certReloader) describe() string {
+	leaf := c.cert.Load().(*tls.Certificate).Leaf
+	return fmt.Sprintf("%s (%s, expires %s)", c.certFile, leaf.Subject.CommonName, leaf.NotAfter.Format("2006-01-02"))
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load().(*tls.Certificate), nil
+}
+
+// httpsRedirect redirects every request to the same host and path over
+// HTTPS on the port of tlsAddr.
+func httpsRedirect(tlsAddr string) http.HandlerFunc {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}
+}
+
+// serveTLS configures server to take its certificate from certs and
+// returns the function that serves it.
+func serveTLS(server *http.Server, certs *certReloader) func() error {
+	server.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+	return func() error {
+		return server.ListenAndServeTLS("", "")
+	}
+}
+
+// reloadCerts reloads certs, logging the outcome. It is called on SIGHUP.
+func reloadCerts(certs *certReloader) {
+	if err := certs.reload(); err != nil {
+		log.Printf("Error reloading TLS certificate, keeping %s: %v", certs.describe(), err)
+		return
+	}
+	log.Printf("Reloaded TLS certificate %s", certs.describe())
+}
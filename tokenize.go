@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxCJKWordLen bounds the dictionary lookahead used when segmenting CJK
+// runs, in runes.
+const maxCJKWordLen = 4
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+// tokenize splits a sentence into lowercase words. Latin text is split on
+// whitespace as before; runs of Han or Kana, which carry no spaces, are
+// segmented against the embeddings vocabulary with a bigram fallback.
+func tokenize(sentence string, embeddings *EmbeddingStore) []string {
+	var words []string
+	for _, field := range strings.Fields(strings.ToLower(sentence)) {
+		if strings.IndexFunc(field, isCJK) < 0 {
+			words = append(words, field)
+			continue
+		}
+		words = append(words, splitCJKField(field, embeddings)...)
+	}
+	return words
+}
+
+func splitCJKField(field string, embeddings *EmbeddingStore) []string {
+	var words []string
+	var run []rune
+	var other strings.Builder
+	flushOther := func() {
+		if word := strings.TrimFunc(other.String(), unicode.IsPunct); word != "" {
+			words = append(words, word)
+		}
+		other.Reset()
+	}
+	for _, r := range field {
+		if isCJK(r) {
+			flushOther()
+			run = append(run, r)
+			continue
+		}
+		if len(run) > 0 {
+			words = append(words, segmentCJK(run, embeddings)...)
+			run = run[:0]
+		}
+		other.WriteRune(r)
+	}
+	flushOther()
+	if len(run) > 0 {
+		words = append(words, segmentCJK(run, embeddings)...)
+	}
+	return words
+}
+
+// segmentCJK uses forward maximum matching against the embeddings vocabulary
+// and falls back to overlapping bigrams where no dictionary word starts.
+func segmentCJK(run []rune, embeddings *EmbeddingStore) []string {
+	if len(run) == 1 {
+		return []string{string(run)}
+	}
+	var words []string
+	for i := 0; i < len(run); {
+		matched := false
+		for l := min(maxCJKWordLen, len(run)-i); l >= 2; l-- {
+			if embeddings.Has(string(run[i : i+l])) {
+				words = append(words, string(run[i:i+l]))
+				i += l
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		if i+1 < len(run) {
+			words = append(words, string(run[i:i+2]))
+		} else if embeddings.Has(string(run[i])) {
+			words = append(words, string(run[i]))
+		}
+		i++
+	}
+	return words
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenizeCJK(t *testing.T) {
+	store := newEmbeddingStore(2)
+	store.add("通道", []float32{1, 0})
+	store.add("协程", []float32{0, 1})
+	tests := []struct {
+		sentence   string
+		vocabulary *EmbeddingStore
+		want       []string
+	}{
+		{"How do channels work?", store, []string{"how", "do", "channels", "work?"}},
+		// Dictionary words are taken whole; elsewhere overlapping
+		// bigrams stand in for them.
+		{"Go语言的通道是什么？", store, []string{"go", "语言", "言的", "的通", "通道", "是什", "什么"}},
+		{"Go语言的通道是什么？", nil, []string{"go", "语言", "言的", "的通", "通道", "道是", "是什", "什么"}},
+		{"什么是协程", store, []string{"什么", "么是", "是协", "协程"}},
+		{"チャネルとは", store, []string{"チャ", "ャネ", "ネル", "ルと", "とは"}},
+		{"go 通道", store, []string{"go", "通道"}},
+		{"字", store, []string{"字"}},
+	}
+	for _, tt := range tests {
+		if got := tokenize(tt.sentence, tt.vocabulary); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.sentence, got, tt.want)
+		}
+	}
+}
+
+// TestCJKQuestionsMatchCJKEntries asks Chinese questions of Chinese
+// entries. Split on spaces alone, each question was one word with no
+// vector, and scored exactly zero against every entry.
+func TestCJKQuestionsMatchCJKEntries(t *testing.T) {
+	entries := []KnowledgeEntry{
+		{ID: "channels", Question: "通道是什么？", Answer: "通道在协程之间传递值。"},
+		{ID: "goroutines", Question: "协程是什么？", Answer: "协程是由运行时管理的轻量级线程。"},
+	}
+	ai := newTestEngine(t, withEntries(entries...))
+	tests := []struct {
+		question string
+		entryID  string
+	}{
+		{"通道是什么？", "channels"},
+		{"什么是通道", "channels"},
+		{"Go的协程是什么", "goroutines"},
+	}
+	for _, tt := range tests {
+		if fields := strings.Fields(tt.question); len(fields) != 1 || ai.Embeddings.Has(fields[0]) {
+			t.Fatalf("%q is not one unknown word when split on spaces", tt.question)
+		}
+		best := ai.KB.FindBestMatch(tt.question, ai.Embeddings)
+		if best.Entry.ID != tt.entryID || best.Score <= 0 {
+			t.Errorf("%q matched %s with %.2f, want %s above 0", tt.question, best.Entry.ID, best.Score, tt.entryID)
+		}
+	}
+	assertAnswerSource(t, ask(t, ai, "Go的协程是什么", AskOptions{}), SourceKnowledgeBase)
+}
+
+// TestLoadEmbeddingsPerLanguage merges embeddings.zh.json into the main
+// file's vocabulary, without overriding its words, and skips a language
+// file whose vectors have another dimension.
+func TestLoadEmbeddingsPerLanguage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "askgo-embeddings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range map[string]string{
+		"embeddings.json":    `{"go": [1, 0], "channel": [0, 1]}`,
+		"embeddings.zh.json": `{"通道": [0, 1], "go": [0, 1]}`,
+		"embeddings.ja.json": `{"チャネル": [1, 0, 0]}`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	embeddings, err := loadEmbeddings(filepath.Join(dir, "embeddings.json"), formatAuto, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !embeddings.Has("通道") {
+		t.Error("the Chinese vocabulary was not merged in")
+	}
+	if embeddings.Has("チャネル") {
+		t.Error("a language file of another dimension was merged in")
+	}
+	if got := embeddings.Lookup("go"); !reflect.DeepEqual(got, []float32{1, 0}) {
+		t.Errorf("go = %v, want the main file's [1 0]", got)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxUnanswered bounds the unanswered-question log; oldest go first.
+	maxUnanswered = 1000
+	// clusterThreshold is the cosine similarity to a cluster's centroid at
+	// which a question joins it.
+	clusterThreshold = 0.8
+)
+
+type unansweredQuestion struct {
+	Question string
+	Vector   []float32
+	AskedAt  time.Time
+}
+
+// UnansweredLog records questions that only got a fallback answer.
+type UnansweredLog struct {
+	mu        sync.Mutex
+	questions []unansweredQuestion
+	revision  int
+	clusters  []UnansweredCluster
+	clustered int // revision the cached clusters were built from
+}
+
+// UnansweredCluster groups phrasings of what is likely the same question.
+type UnansweredCluster struct {
+	ID             string   `json:"id"`
+	Representative string   `json:"representative"`
+	Count          int      `json:"count"`
+	Phrasings      []string `json:"phrasings"`
+}
+
+func NewUnansweredLog() *UnansweredLog {
+	return &UnansweredLog{clustered: -1}
+}
+
+func (l *UnansweredLog) Record(question string, vector []float32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.questions = append(l.questions, unansweredQuestion{Question: question, Vector: vector, AskedAt: time.Now()})
+	if len(l.questions) > maxUnanswered {
+		l.questions = l.questions[len(l.questions)-maxUnanswered:]
+	}
+	l.revision++
+}
+
+// Clusters groups the log by vector similarity. The result is cached until
+// the next recorded question.
+func (l *UnansweredLog) Clusters() (int, []UnansweredCluster) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.clustered != l.revision {
+		l.clusters = clusterQuestions(l.questions, l.revision)
+		l.clustered = l.revision
+	}
+	return l.revision, l.clusters
+}
+
+func clusterQuestions(questions []unansweredQuestion, revision int) []UnansweredCluster {
+	type group struct {
+		centroid []float32
+		counts   map[string]int
+		order    []string
+		total    int
+	}
+	var groups []*group
+	for _, q := range questions {
+		var target *group
+		for _, g := range groups {
+			if cosineSimilarity(q.Vector, g.centroid) >= clusterThreshold {
+				target = g
+				break
+			}
+		}
+		if target == nil {
+			target = &group{counts: make(map[string]int)}
+			groups = append(groups, target)
+		}
+		if _, seen := target.counts[q.Question]; !seen {
+			target.order = append(target.order, q.Question)
+		}
+		target.counts[q.Question]++
+		target.total++
+		target.centroid = runningMean(target.centroid, q.Vector, target.total)
+	}
+
+	clusters := make([]UnansweredCluster, len(groups))
+	for i, g := range groups {
+		representative := g.order[0]
+		for _, phrasing := range g.order {
+			if g.counts[phrasing] > g.counts[representative] {
+				representative = phrasing
+			}
+		}
+		clusters[i] = UnansweredCluster{
+			Representative: representative,
+			Count:          g.total,
+			Phrasings:      g.order,
+		}
+	}
+	sort.SliceStable(clusters, func(i, j int) bool { return clusters[i].Count > clusters[j].Count })
+	for i := range clusters {
+		clusters[i].ID = fmt.Sprintf("%d-%d", revision, i)
+	}
+	return clusters
+}
+
+func runningMean(mean, vec []float32, n int) []float32 {
+	if len(mean) == 0 {
+		return append([]float32{}, vec...)
+	}
+	for i := 0; i < len(mean) && i < len(vec); i++ {
+		mean[i] += (vec[i] - mean[i]) / float32(n)
+	}
+	return mean
+}
+
+func handleUnanswered(unanswered *UnansweredLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		revision, clusters := unanswered.Clusters()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"revision": revision,
+			"clusters": clusters,
+		})
+	}
+}
+
+type ClusterLearnRequest struct {
+	ClusterID string `json:"cluster_id" schema:"required"`
+	Question  string `json:"question"`
+	Answer    string `json:"answer" schema:"required"`
+}
+
+// handleClusterLearn creates a learned entry from a cluster: the
+// representative (or an edited question) plus every other phrasing are all
+// taught the same answer.
+func handleClusterLearn(ai *AIEngine, unanswered *UnansweredLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if refuseIfOff(w, r, ai.Switches, SwitchLearning) {
+			return
+		}
+		var req ClusterLearnRequest
+		if err := decodeRequest(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		_, clusters := unanswered.Clusters()
+		var cluster *UnansweredCluster
+		for i := range clusters {
+			if clusters[i].ID == req.ClusterID {
+				cluster = &clusters[i]
+			}
+		}
+		if cluster == nil {
+			writeError(w, newError(ErrConflict, "cluster not found; the report has changed since it was fetched"))
+			return
+		}
+		question := req.Question
+		if question == "" {
+			question = cluster.Representative
+		}
+		learned := []string{question}
+		ai.KB.learn(LearnedEntry{Question: question, Answer: req.Answer})
+		for _, phrasing := range cluster.Phrasings {
+			if normalizeQuestion(phrasing) != normalizeQuestion(question) {
+				ai.KB.learn(LearnedEntry{Question: phrasing, Answer: req.Answer})
+				learned = append(learned, phrasing)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"learned": learned})
+	}
+}
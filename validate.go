@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ValidationReport lists the entry IDs affected by each kind of problem.
+type ValidationReport struct {
+	Entries        int      `json:"entries"`
+	EmptyAnswers   []string `json:"empty_answers"`
+	ZeroVectors    []string `json:"zero_vectors"`
+	WrongDimension []string `json:"wrong_dimension"`
+	Repaired       []string `json:"repaired,omitempty"`
+	Quarantined    []string `json:"quarantined,omitempty"`
+}
+
+func (r *ValidationReport) problems() int {
+	return len(r.EmptyAnswers) + len(r.ZeroVectors) + len(r.WrongDimension)
+}
+
+func (r *ValidationReport) summary() string {
+	return fmt.Sprintf("%d entries, %d empty answers, %d zero vectors, %d wrong dimension",
+		r.Entries, len(r.EmptyAnswers), len(r.ZeroVectors), len(r.WrongDimension))
+}
+
+func isZeroVector(vec []float32) bool {
+	for _, x := range vec {
+		if x != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validateBatch is how many repairs Validate applies per write lock.
+const validateBatch = 256
+
+// entryRepair is a change Validate makes to one entry.
+type entryRepair struct {
+	id         string
+	vector     []float32
+	quarantine bool
+}
+
+// Validate scans the knowledge base for entries that can never be served
+// correctly. With repair set, vectors are rebuilt where the current
+// embeddings allow it and everything else is quarantined so it stops
+// matching. The scan and the vector rebuilds work on a copy; repairs are
+// applied in small batches so /ai is never blocked for long.
+func (kb *KnowledgeBase) Validate(embeddings *EmbeddingStore, repair bool) *ValidationReport {
+	dim := embeddingVectorDimension(embeddings)
+
+	entries := kb.snapshotEntries()
+	report := &ValidationReport{
+		Entries:        len(entries),
+		EmptyAnswers:   []string{},
+		ZeroVectors:    []string{},
+		WrongDimension: []string{},
+	}
+	var repairs []entryRepair
+	for _, entry := range entries {
+		broken := false
+		if strings.TrimSpace(entry.Answer) == "" {
+			report.EmptyAnswers = append(report.EmptyAnswers, entry.ID)
+			broken = true
+		}
+		badVector := false
+		if isZeroVector(entry.Vector) {
+			report.ZeroVectors = append(report.ZeroVectors, entry.ID)
+			badVector = true
+		} else if dim > 0 && len(entry.Vector) != dim {
+			report.WrongDimension = append(report.WrongDimension, entry.ID)
+			badVector = true
+		}
+		if !repair || (!broken && !badVector) {
+			continue
+		}
+		fix := entryRepair{id: entry.ID}
+		if badVector {
+			vec := getSentenceVector(entry.Question, embeddings)
+			if !isZeroVector(vec) && len(vec) == dim {
+				fix.vector = vec
+				report.Repaired = append(report.Repaired, entry.ID)
+			} else {
+				broken = true
+			}
+		}
+		if broken && !entry.Quarantined {
+			fix.quarantine = true
+			report.Quarantined = append(report.Quarantined, entry.ID)
+		}
+		repairs = append(repairs, fix)
+	}
+	for start := 0; start < len(repairs); start += validateBatch {
+		kb.applyRepairs(repairs[start:min(start+validateBatch, len(repairs))])
+	}
+	return report
+}
+
+func (kb *KnowledgeBase) applyRepairs(repairs []entryRepair) {
+	byID := make(map[string]entryRepair, len(repairs))
+	for _, fix := range repairs {
+		byID[fix.id] = fix
+	}
+	kb.updateEntries("Validate", func(entries []KnowledgeEntry) []KnowledgeEntry {
+		for i := range entries {
+			fix, ok := byID[entries[i].ID]
+			if !ok {
+				continue
+			}
+			if fix.vector != nil {
+				entries[i].Vector = fix.vector
+			}
+			if fix.quarantine {
+				entries[i].Quarantined = true
+			}
+		}
+		return entries
+	})
+}
+
+// ValidateKB runs the validator and records the result for /healthz.
+func (ai *AIEngine) ValidateKB(repair bool) *ValidationReport {
+	report := ai.KB.Validate(ai.embeddings(), repair)
+	ai.mu.Lock()
+	ai.lastValidation = report
+	ai.mu.Unlock()
+	return report
+}
+
+type ValidateRequest struct {
+	Repair bool `json:"repair"`
+}
+
+func handleValidate(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ValidateRequest
+		if r.ContentLength != 0 {
+			if err := decodeRequest(r, &req); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		report := ai.ValidateKB(req.Repair)
+		log.Printf("KB validation: %s", report.summary())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+func handleHealthz(ai *AIEngine, outbound *Outbound) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ai.mu.RLock()
+		validation := ai.lastValidation
+		mismatch := ai.embeddingMismatch
+		ai.mu.RUnlock()
+
+		health := map[string]interface{}{"status": "ok"}
+		warnings := []string{}
+		if validation != nil && validation.problems() > 0 {
+			warnings = append(warnings, "knowledge base: "+validation.summary())
+		}
+		if mismatch != "" {
+			warnings = append(warnings, "embeddings: "+mismatch)
+			health["embedding_mismatch"] = mismatch
+		}
+		for _, problem := range ai.unreadyList() {
+			warnings = append(warnings, "not ready: "+problem)
+		}
+		if isToyEmbeddings(ai.embeddings()) {
+			health["toy_embeddings"] = true
+			warnings = append(warnings, "embeddings: toy vectors written by askgo init; not for production")
+		}
+		if warning := ai.analyzerWarning(); warning != "" {
+			warnings = append(warnings, "analyzer: "+warning)
+		}
+		if off := ai.Switches.Off(); len(off) > 0 {
+			sort.Strings(off)
+			health["switches_off"] = off
+			warnings = append(warnings, "kill switches off: "+strings.Join(off, ", "))
+		}
+		if ai.Snapshots != nil {
+			last, err := ai.Snapshots.Last()
+			if !last.IsZero() {
+				health["last_snapshot"] = last
+			}
+			if err != nil {
+				warnings = append(warnings, "snapshot: "+err.Error())
+			}
+			if refused := ai.Snapshots.Refused(); refused > 0 {
+				health["snapshots_refused"] = refused
+				warnings = append(warnings, fmt.Sprintf("snapshot: %d snapshots skipped for an incompatible format", refused))
+			}
+		}
+		if destinations := outbound.Health(); len(destinations) > 0 {
+			names := make([]string, 0, len(destinations))
+			for destination := range destinations {
+				names = append(names, destination)
+			}
+			sort.Strings(names)
+			for _, destination := range names {
+				if h := destinations[destination]; h.Failing {
+					warnings = append(warnings, "outbound "+destination+": "+h.LastError)
+				}
+			}
+			health["outbound"] = destinations
+		}
+		health["warnings"] = warnings
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(health)
+	}
+}
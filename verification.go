@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultVerificationAge = 365 * 24 * time.Hour
+
+// VerificationConfig is the "verification" section of prompt.json.
+type VerificationConfig struct {
+	MaxAge     string `json:"max_age"`
+	Disclaimer string `json:"disclaimer"`
+}
+
+// VerificationPolicy decides when an entry is old enough to need review.
+type VerificationPolicy struct {
+	MaxAge     time.Duration
+	Disclaimer string
+}
+
+func (c VerificationConfig) policy() (VerificationPolicy, error) {
+	policy := VerificationPolicy{MaxAge: defaultVerificationAge, Disclaimer: c.Disclaimer}
+	if c.MaxAge != "" {
+		age, err := parseAge(c.MaxAge)
+		if err != nil {
+			return policy, fmt.Errorf("verification.max_age: %v", err)
+		}
+		policy.MaxAge = age
+	}
+	return policy, nil
+}
+
+func (p VerificationPolicy) isStale(entry *KnowledgeEntry, now time.Time) bool {
+	return now.Sub(entry.VerifiedAt) > p.MaxAge
+}
+
+// parseAge accepts Go durations plus a whole-day suffix, e.g. "365d".
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return 0, newError(ErrInvalidInput, "invalid age %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	age, err := time.ParseDuration(s)
+	if err != nil || age < 0 {
+		return 0, newError(ErrInvalidInput, "invalid age %q", s)
+	}
+	return age, nil
+}
+
+// entryID derives a stable identifier from the question for entries that
+// don't declare one.
+func entryID(question string) string {
+	sum := sha1.Sum([]byte(strings.ToLower(strings.TrimSpace(question))))
+	return "kb-" + hex.EncodeToString(sum[:])[:10]
+}
+
+// learnedID derives the identifier of a learned entry from its key.
+func learnedID(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return "learned-" + hex.EncodeToString(sum[:])[:10]
+}
+
+// ExpiringEntries returns the entries last verified before cutoff.
+func (kb *KnowledgeBase) ExpiringEntries(cutoff time.Time) []KnowledgeEntry {
+	var expiring []KnowledgeEntry
+	for _, entry := range kb.view().entries {
+		if entry.VerifiedAt.Before(cutoff) {
+			expiring = append(expiring, entry)
+		}
+	}
+	return expiring
+}
+
+// ReviewEntry marks an entry as verified (resetting its clock) or flags it
+// for rewrite. It returns ErrInvalidInput for other actions and ErrNotFound
+// if no entry has the given ID.
+func (kb *KnowledgeBase) ReviewEntry(id, action string, now time.Time) (KnowledgeEntry, error) {
+	if action != "verify" && action != "flag" {
+		return KnowledgeEntry{}, newError(ErrInvalidInput, `action must be "verify" or "flag"`)
+	}
+	var reviewed *KnowledgeEntry
+	kb.updateEntries("ReviewEntry", func(entries []KnowledgeEntry) []KnowledgeEntry {
+		for i := range entries {
+			if entries[i].ID != id {
+				continue
+			}
+			switch action {
+			case "verify":
+				entries[i].VerifiedAt = now
+				entries[i].NeedsRewrite = false
+			case "flag":
+				entries[i].NeedsRewrite = true
+			}
+			entry := entries[i]
+			reviewed = &entry
+			break
+		}
+		return entries
+	})
+	if reviewed == nil {
+		return KnowledgeEntry{}, newError(ErrNotFound, "entry %s not found", id)
+	}
+	return *reviewed, nil
+}
+
+type entryStatus struct {
+	ID           string    `json:"id"`
+	Question     string    `json:"question"`
+	SourceURL    string    `json:"source_url,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	VerifiedAt   time.Time `json:"verified_at"`
+	NeedsRewrite bool      `json:"needs_rewrite"`
+}
+
+func newEntryStatus(entry KnowledgeEntry) entryStatus {
+	return entryStatus{
+		ID:           entry.ID,
+		Question:     entry.Question,
+		SourceURL:    entry.SourceURL,
+		CreatedAt:    entry.CreatedAt,
+		VerifiedAt:   entry.VerifiedAt,
+		NeedsRewrite: entry.NeedsRewrite,
+	}
+}
+
+func handleExpiring(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		age := ai.Verification.MaxAge
+		if param := r.URL.Query().Get("age"); param != "" {
+			parsed, err := parseAge(param)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			age = parsed
+		}
+		entries := ai.KB.ExpiringEntries(time.Now().Add(-age))
+		statuses := make([]entryStatus, len(entries))
+		for i, entry := range entries {
+			statuses[i] = newEntryStatus(entry)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+	}
+}
+
+type ReviewRequest struct {
+	ID     string `json:"id" schema:"required"`
+	Action string `json:"action" schema:"required"`
+}
+
+func handleReview(ai *AIEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ReviewRequest
+		if err := decodeRequest(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		entry, err := ai.KB.ReviewEntry(req.ID, req.Action, time.Now())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newEntryStatus(entry))
+	}
+}
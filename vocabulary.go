@@ -0,0 +1,192 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Bounds of subword matching. Words are cut into character n-grams of
+// minNgram to maxNgram runes, with "<" and ">" marking their ends as in
+// fastText.
+const (
+	minNgram = 3
+	maxNgram = 5
+	// maxNgramWordLen bounds the length, in runes, of vocabulary words
+	// indexed by n-gram; longer ones are rarely what a typo was aiming at.
+	maxNgramWordLen = 30
+	// subwordNeighbors is the number of vocabulary words averaged into the
+	// vector of an out-of-vocabulary word.
+	subwordNeighbors = 3
+	// minSubwordOverlap is the Dice coefficient of shared n-grams a
+	// vocabulary word needs to lend its vector.
+	minSubwordOverlap = 0.4
+)
+
+// Ways lookup finds an embedding, from best to worst.
+const (
+	vocabExact = iota
+	vocabStem
+	vocabSubword
+	vocabMissing
+)
+
+// vocabIndex finds embeddings for words missing from the vocabulary:
+// through a vocabulary word with the same stem, else by averaging the
+// vocabulary words that share the most character n-grams with it, so
+// "gorutine" borrows from "goroutine". It is built once per embeddings and
+// read-only afterwards.
+type vocabIndex struct {
+	// stems maps each stem to the vocabulary word embedding it.
+	stems map[string]string
+	// words are the vocabulary words indexed by n-gram, and sizes their
+	// numbers of distinct n-grams; ngrams maps each n-gram to the
+	// positions in words of the words containing it.
+	words  []string
+	sizes  []int
+	ngrams map[uint64][]int32
+}
+
+// buildVocabIndex indexes the vocabulary of embeddings. Of the words
+// sharing a stem, the shortest, then the first alphabetically, is kept.
+func buildVocabIndex(embeddings *EmbeddingStore) *vocabIndex {
+	x := &vocabIndex{stems: make(map[string]string, embeddings.Len()), ngrams: make(map[uint64][]int32)}
+	for _, word := range embeddings.Words() {
+		stem := stemWord(word)
+		if kept, ok := x.stems[stem]; !ok || len(word) < len(kept) || (len(word) == len(kept) && word < kept) {
+			x.stems[stem] = word
+		}
+		if word == toyEmbeddingsMarker || utf8.RuneCountInString(word) > maxNgramWordLen {
+			continue
+		}
+		pos := int32(len(x.words))
+		grams := ngrams(word)
+		x.words = append(x.words, word)
+		x.sizes = append(x.sizes, len(grams))
+		for _, gram := range grams {
+			x.ngrams[gram] = append(x.ngrams[gram], pos)
+		}
+	}
+	return x
+}
+
+// ngrams returns the distinct character n-grams of word, hashed with
+// FNV-1a: collisions are rare enough not to matter for ranking neighbors,
+// and the index stays free of millions of small strings.
+func ngrams(word string) []uint64 {
+	runes := make([]rune, 0, len(word)+2)
+	runes = append(append(append(runes, '<'), []rune(word)...), '>')
+	var grams []uint64
+	for n := minNgram; n <= maxNgram; n++ {
+		for i := 0; i+n <= len(runes); i++ {
+			h := uint64(14695981039346656037)
+			for _, r := range runes[i : i+n] {
+				h = (h ^ uint64(r)) * 1099511628211
+			}
+			grams = append(grams, h)
+		}
+	}
+	sort.Slice(grams, func(i, j int) bool { return grams[i] < grams[j] })
+	distinct := grams[:0]
+	for i, h := range grams {
+		if i == 0 || h != grams[i-1] {
+			distinct = append(distinct, h)
+		}
+	}
+	return distinct
+}
+
+// lookup returns the embedding of word and how it was found: the word's
+// own, else that of its stem or of a vocabulary word with the same stem,
+// else one synthesized from subword neighbors. A nil index only tries the
+// word and its stem.
+func (x *vocabIndex) lookup(word string, embeddings *EmbeddingStore) ([]float32, int) {
+	if v := embeddings.Lookup(word); v != nil {
+		return v, vocabExact
+	}
+	stem := stemWord(word)
+	if v := embeddings.Lookup(stem); v != nil {
+		return v, vocabStem
+	}
+	if x == nil {
+		return nil, vocabMissing
+	}
+	if w, ok := x.stems[stem]; ok {
+		return embeddings.Lookup(w), vocabStem
+	}
+	if v := x.synthesize(strings.ToLower(strings.TrimFunc(word, unicode.IsPunct)), embeddings); v != nil {
+		return v, vocabSubword
+	}
+	return nil, vocabMissing
+}
+
+// synthesize averages the vectors of the subwordNeighbors vocabulary words
+// sharing the most n-grams with word, weighted by their overlap. It
+// returns nil when no word overlaps by minSubwordOverlap.
+func (x *vocabIndex) synthesize(word string, embeddings *EmbeddingStore) []float32 {
+	grams := ngrams(word)
+	shared := make(map[int32]int)
+	for _, gram := range grams {
+		for _, pos := range x.ngrams[gram] {
+			shared[pos]++
+		}
+	}
+	type neighbor struct {
+		word    string
+		overlap float64
+	}
+	var best []neighbor
+	for pos, n := range shared {
+		// Dice coefficient of the two n-gram sets.
+		if overlap := 2 * float64(n) / float64(len(grams)+x.sizes[pos]); overlap >= minSubwordOverlap {
+			best = append(best, neighbor{x.words[pos], overlap})
+		}
+	}
+	if len(best) == 0 {
+		return nil
+	}
+	sort.Slice(best, func(i, j int) bool {
+		if best[i].overlap != best[j].overlap {
+			return best[i].overlap > best[j].overlap
+		}
+		return best[i].word < best[j].word
+	})
+	best = best[:min(subwordNeighbors, len(best))]
+	var vec []float32
+	var total float64
+	for _, n := range best {
+		v := embeddings.Lookup(n.word)
+		if vec == nil {
+			vec = make([]float32, len(v))
+		}
+		for i := 0; i < len(vec) && i < len(v); i++ {
+			vec[i] += float32(n.overlap) * v[i]
+		}
+		total += n.overlap
+	}
+	for i := range vec {
+		vec[i] /= float32(total)
+	}
+	return vec
+}
+
+// VocabularyCoverage counts how the tokens of a question were found in the
+// embeddings.
+type VocabularyCoverage struct {
+	Tokens int `json:"tokens"`
+	// OOV tokens are out of the vocabulary, even by stem.
+	OOV int `json:"oov"`
+	// Synthesized OOV tokens got a vector from their subword neighbors;
+	// the others contribute nothing.
+	Synthesized int `json:"synthesized"`
+}
+
+// Share is the share of tokens in the vocabulary; a question with no
+// tokens is fully covered.
+func (c VocabularyCoverage) Share() float64 {
+	if c.Tokens == 0 {
+		return 1
+	}
+	return float64(c.Tokens-c.OOV) / float64(c.Tokens)
+}
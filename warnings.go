@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Warning codes are stable identifiers clients can switch on. Every code
+// the server emits is listed here.
+const (
+	WarnAnalysisFailed    = "analysis_failed"
+	WarnAnalysisTruncated = "analysis_truncated"
+	WarnLowCoverage       = "low_vocabulary_coverage"
+	WarnLexicalOnly       = "lexical_only"
+	WarnLowConfidence     = "low_confidence"
+	WarnStaleEntry        = "stale_entry"
+	WarnOutputProcessor   = "output_processor_failed"
+	WarnRateLimit         = "rate_limit_near"
+	WarnDeprecatedField   = "deprecated_field"
+)
+
+// warningCodes documents every code; Add refuses unregistered ones so the
+// registry can't drift from what is emitted.
+var warningCodes = map[string]string{
+	WarnAnalysisFailed:    "the question could not be analyzed; the answer is a generic fallback",
+	WarnAnalysisTruncated: "only part of the question was analyzed",
+	WarnLowCoverage:       "many words of the question are unknown to the embeddings",
+	WarnLexicalOnly:       "no embeddings are loaded; the answer was matched by shared words, not meaning",
+	WarnLowConfidence:     "no entry matched well enough; the answer is a fallback",
+	WarnStaleEntry:        "the answer comes from an entry overdue for re-verification",
+	WarnOutputProcessor:   "an output processor failed and was skipped",
+	WarnRateLimit:         "the client's rate limit budget is nearly exhausted",
+	WarnDeprecatedField:   "the request used a deprecated field",
+}
+
+// minCoverage is the share of question tokens that must have embeddings
+// before WarnLowCoverage is raised.
+const minCoverage = 0.5
+
+// Warning is a soft problem met while answering.
+type Warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Warnings collects the warnings of one request. It is safe for concurrent
+// use, and a nil *Warnings discards everything.
+type Warnings struct {
+	mu   sync.Mutex
+	list []Warning
+}
+
+// Add records a warning with a registered code.
+func (w *Warnings) Add(code, message string) {
+	if w == nil {
+		return
+	}
+	if _, ok := warningCodes[code]; !ok {
+		panic(fmt.Sprintf("unregistered warning code %q", code))
+	}
+	w.mu.Lock()
+	w.list = append(w.list, Warning{Code: code, Message: message})
+	w.mu.Unlock()
+}
+
+// List returns the warnings recorded so far.
+func (w *Warnings) List() []Warning {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]Warning(nil), w.list...)
+}
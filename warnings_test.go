@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestEveryWarningCanBeProduced raises each registered warning code
+// through /ai, and fails for a code no case raises.
+func TestEveryWarningCanBeProduced(t *testing.T) {
+	tests := map[string]struct {
+		setup func(t *testing.T, ai *AIEngine)
+		body  string
+		// rateWarning is the soft-limit warning the limiter attached.
+		rateWarning string
+	}{
+		WarnAnalysisFailed: {
+			setup: func(t *testing.T, ai *AIEngine) {
+				// prose rarely fails; a cached failure stands in for it.
+				ai.QueryCache = newQueryCache(4)
+				key := queryCacheKey("How do channels work?")
+				ai.QueryCache.items[key] = ai.QueryCache.order.PushFront(&cachedQuery{key: key, err: errors.New("tagger failed")})
+			},
+			body: `{"text": "How do channels work?", "verbose": true}`,
+		},
+		WarnAnalysisTruncated: {body: `{"text": "` + strings.Repeat("channels ", defaultAnalysisLimits.MaxTokens+1) + `", "verbose": true}`},
+		WarnLowCoverage:       {body: `{"text": "channels zebra quantum marmalade", "verbose": true}`},
+		WarnLexicalOnly: {
+			setup: func(t *testing.T, ai *AIEngine) { ai.setEmbeddings(newEmbeddingStore(0)) },
+			body:  `{"text": "How do channels work?", "verbose": true}`,
+		},
+		WarnLowConfidence: {body: `{"text": "zebra quantum marmalade", "verbose": true}`},
+		WarnStaleEntry: {
+			setup: func(t *testing.T, ai *AIEngine) { ai.Verification = VerificationPolicy{MaxAge: time.Nanosecond} },
+			body:  `{"text": "How do channels work?", "verbose": true}`,
+		},
+		WarnOutputProcessor: {
+			setup: func(t *testing.T, ai *AIEngine) {
+				processors, err := buildOutputProcessors([]OutputProcessorConfig{{Type: "template-wrap", Template: "{{.Missing}}"}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				ai.OutputProcessors = processors
+			},
+			body: `{"text": "How do channels work?", "verbose": true}`,
+		},
+		WarnRateLimit:       {body: `{"text": "How do channels work?", "verbose": true}`, rateWarning: "2 of 60 requests left this minute"},
+		WarnDeprecatedField: {body: `{"question": "How do channels work?", "verbose": true}`},
+	}
+	for code := range warningCodes {
+		if _, ok := tests[code]; !ok {
+			t.Errorf("no case raises %s", code)
+		}
+	}
+	for code, tt := range tests {
+		t.Run(code, func(t *testing.T) {
+			ai := newTestEngine(t)
+			if tt.setup != nil {
+				tt.setup(t, ai)
+			}
+			req := httptest.NewRequest("POST", "/ai", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.rateWarning != "" {
+				req = req.WithContext(context.WithValue(req.Context(), rateWarningKey{}, tt.rateWarning))
+			}
+			rec := httptest.NewRecorder()
+			handleAI(ai, NewEscalationStore(time.Hour, "", nil, ai.Switches), nil, nil, true, 0, Pacing{})(rec, req)
+			var response struct {
+				Warnings []Warning `json:"warnings"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("status %d: %v: %s", rec.Code, err, rec.Body)
+			}
+			for _, w := range response.Warnings {
+				if w.Code == code {
+					if w.Message == "" {
+						t.Errorf("%s has no message", code)
+					}
+					return
+				}
+			}
+			t.Errorf("warnings %+v lack %s", response.Warnings, code)
+		})
+	}
+}
+
+// TestWarningsOnlyWhenVerbose leaves warnings out of responses that
+// didn't ask for the breakdown.
+func TestWarningsOnlyWhenVerbose(t *testing.T) {
+	ai := newTestEngine(t)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/ai", strings.NewReader(`{"text": "zebra quantum marmalade"}`))
+	req.Header.Set("Content-Type", "application/json")
+	handleAI(ai, NewEscalationStore(time.Hour, "", nil, ai.Switches), nil, nil, false, 0, Pacing{})(rec, req)
+	if strings.Contains(rec.Body.String(), `"warnings"`) {
+		t.Errorf("terse response carries warnings: %s", rec.Body)
+	}
+}
+
+// TestWarningsConcurrentAdd adds warnings from many goroutines at once.
+// Run it with -race.
+func TestWarningsConcurrentAdd(t *testing.T) {
+	var w Warnings
+	const goroutines, each = 8, 100
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				w.Add(WarnLowConfidence, "best match scored 0.10")
+				w.List()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(w.List()); n != goroutines*each {
+		t.Errorf("%d warnings, want %d", n, goroutines*each)
+	}
+}
+
+func TestUnregisteredWarningPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("an unregistered code was accepted")
+		}
+	}()
+	(&Warnings{}).Add("made_up", "")
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// This is the server side of the WebSocket protocol (RFC 6455), as much
+// of it as /ws needs: text messages, fragmented or not, pings, pongs and
+// closing handshakes. No extensions or subprotocols are negotiated.
+
+// websocketGUID is appended to the client's key to prove the server
+// speaks WebSocket.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Frame opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// Close codes.
+const (
+	wsCloseNormal      = 1000
+	wsCloseGoingAway   = 1001
+	wsCloseProtocol    = 1002
+	wsCloseUnsupported = 1003
+	wsCloseInvalidData = 1007
+	wsCloseTooBig      = 1009
+)
+
+// Keepalive timing: the server pings every wsPingPeriod and gives up on a
+// connection that has sent nothing, pongs included, for wsPongWait.
+const (
+	wsPingPeriod = 30 * time.Second
+	wsPongWait   = 2 * wsPingPeriod
+	wsWriteWait  = 10 * time.Second
+)
+
+// wsCloseError ends a connection with a close code, sent to the client
+// when the connection is closed.
+type wsCloseError struct {
+	code   int
+	reason string
+}
+
+func (e *wsCloseError) Error() string {
+	return fmt.Sprintf("websocket closed (%d %s)", e.code, e.reason)
+}
+
+// wsConn is an upgraded connection. Messages are read by one goroutine;
+// frames may be written by several.
+type wsConn struct {
+	conn       net.Conn
+	r          *bufio.Reader
+	maxMessage int64
+
+	writeMu sync.Mutex
+}
+
+// headerHasToken reports whether the comma-separated header name contains
+// token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket completes the opening handshake of r and takes over
+// its connection. Messages longer than maxMessage bytes, if positive, end
+// the connection. Headers already set on w, such as the request ID, are
+// sent with the handshake. Requests that aren't WebSocket handshakes get
+// an ErrInvalidInput, for the caller to answer.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, maxMessage int64) (*wsConn, error) {
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		return nil, newError(ErrInvalidInput, "expected a WebSocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, newError(ErrInvalidInput, "only WebSocket version 13 is supported")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		return nil, newError(ErrInvalidInput, "Sec-WebSocket-Key must be 16 bytes in base64")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("connection can't be taken over for WebSocket")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// The server's read and write timeouts were set for one request.
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	header := w.Header().Clone()
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(sum[:]))
+	conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	header.Write(rw)
+	rw.WriteString("\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader, maxMessage: maxMessage}, nil
+}
+
+// readFrame reads one frame and unmasks its payload.
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode = head[0]&0x80 != 0, head[0]&0x0f
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, &wsCloseError{wsCloseProtocol, "reserved bits set"}
+	}
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, &wsCloseError{wsCloseProtocol, "client frames must be masked"}
+	}
+	size := uint64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= wsClose && (!fin || size > 125) {
+		return false, 0, nil, &wsCloseError{wsCloseProtocol, "control frames must be whole and short"}
+	}
+	if c.maxMessage > 0 && size > uint64(c.maxMessage) {
+		return false, 0, nil, &wsCloseError{wsCloseTooBig, "message too large"}
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// readMessage returns the next text message, answering pings and
+// reassembling fragments on the way. Every frame received extends the
+// read deadline by wsPongWait. A close from the client is acknowledged
+// and returned as a wsCloseError.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	fragmented := false
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			code := wsCloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			return nil, &wsCloseError{code, "closed by client"}
+		case wsText, wsBinary:
+			if fragmented {
+				return nil, &wsCloseError{wsCloseProtocol, "new message inside a fragmented one"}
+			}
+			if opcode == wsBinary {
+				return nil, &wsCloseError{wsCloseUnsupported, "only text messages are accepted"}
+			}
+			message, fragmented = payload, true
+		case wsContinuation:
+			if !fragmented {
+				return nil, &wsCloseError{wsCloseProtocol, "continuation without a message"}
+			}
+			if c.maxMessage > 0 && int64(len(message)+len(payload)) > c.maxMessage {
+				return nil, &wsCloseError{wsCloseTooBig, "message too large"}
+			}
+			message = append(message, payload...)
+		default:
+			return nil, &wsCloseError{wsCloseProtocol, fmt.Sprintf("unknown opcode %d", opcode)}
+		}
+		if fin {
+			if !utf8.Valid(message) {
+				return nil, &wsCloseError{wsCloseInvalidData, "text message is not UTF-8"}
+			}
+			return message, nil
+		}
+	}
+}
+
+// writeFrame writes one unfragmented frame.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode, 0}
+	switch size := len(payload); {
+	case size < 126:
+		header[1] = byte(size)
+	case size <= 0xffff:
+		header[1] = 126
+		header = append(header, byte(size>>8), byte(size))
+	default:
+		header[1] = 127
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(size))
+		header = append(header, ext[:]...)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// writeJSON sends v as a text message.
+func (c *wsConn) writeJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsText, data)
+}
+
+// keepalive pings the client every wsPingPeriod until done is closed.
+func (c *wsConn) keepalive(done <-chan struct{}) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.writeFrame(wsPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// close ends the connection after a close frame saying why: the code of a
+// wsCloseError, going away for anything else. A close the client started
+// is echoed back with its own code.
+func (c *wsConn) close(err error) {
+	code, reason := wsCloseGoingAway, ""
+	var closeErr *wsCloseError
+	if errors.As(err, &closeErr) {
+		code = closeErr.code
+		if code != wsCloseNormal && closeErr.reason != "closed by client" {
+			reason = closeErr.reason
+		}
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	c.writeFrame(wsClose, append(payload, reason...))
+	c.conn.Close()
+}
@@ -0,0 +1,39 @@
+package main
+
+import "math"
+
+// defaultMaxWeightBoost is how far an entry's weight may raise its score
+// above the cosine similarity by default.
+const defaultMaxWeightBoost = 0.1
+
+// weight returns the entry's weight, 1 when unset.
+func (e KnowledgeEntry) weight() float64 {
+	if e.Weight <= 0 {
+		return 1
+	}
+	return e.Weight
+}
+
+// weightedScore is the score of an entry of the given weight whose cosine
+// similarity is raw. Weights below 1 scale it down freely, but those above
+// 1 raise it by at most maxBoost, and never past 1, so a heavy weight
+// favours an entry among close matches without promoting a poor one over
+// the threshold.
+func weightedScore(raw, weight, maxBoost float64) float64 {
+	score := raw * weight
+	if weight <= 1 || raw <= 0 {
+		return score
+	}
+	return math.Min(score, math.Min(raw+maxBoost, 1))
+}
+
+// setMaxWeightBoost sets how far weights may raise scores. The entries and
+// their vectors are unchanged, so the current state is republished as it
+// is, like setScan does.
+func (kb *KnowledgeBase) setMaxWeightBoost(boost float64) {
+	defer kb.writeLock("setMaxWeightBoost")()
+	kb.maxWeightBoost = boost
+	next := *kb.view()
+	next.maxWeightBoost = boost
+	kb.state.Store(&next)
+}